@@ -251,6 +251,8 @@ export const ModelResponseChunkSchema = z.object({
   custom: z.unknown().optional(),
   /** If true, the chunk includes all data from previous chunks. Otherwise, considered to be incremental. */
   aggregated: z.boolean().optional(),
+  /** The position of this chunk in the stream of the response, starting at 0. */
+  index: z.number().optional(),
 });
 export type ModelResponseChunkData = z.infer<typeof ModelResponseChunkSchema>;
 
@@ -0,0 +1,58 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"fmt"
+	"sync"
+)
+
+// modelRef identifies a model by provider and name.
+type modelRef struct {
+	provider, name string
+}
+
+var aliases struct {
+	mu     sync.Mutex
+	models map[string]modelRef
+}
+
+// RegisterAlias makes alias a logical name for the model with the given
+// provider and name, so that code and prompts can refer to a model like
+// "fast" and the model it denotes can be changed in one place.
+// Look up an alias by calling [LookupModel] with an empty provider.
+// Registering an alias again repoints it.
+// The model need not be defined when the alias is registered.
+func RegisterAlias(alias, provider, model string) {
+	aliases.mu.Lock()
+	defer aliases.mu.Unlock()
+	if aliases.models == nil {
+		aliases.models = map[string]modelRef{}
+	}
+	aliases.models[alias] = modelRef{provider, model}
+}
+
+// ResolveAlias returns the provider and name of the model that alias
+// refers to. It returns an error if alias was not registered with
+// [RegisterAlias].
+func ResolveAlias(alias string) (provider, model string, err error) {
+	aliases.mu.Lock()
+	defer aliases.mu.Unlock()
+	ref, ok := aliases.models[alias]
+	if !ok {
+		return "", "", fmt.Errorf("unknown model alias %q", alias)
+	}
+	return ref.provider, ref.name, nil
+}
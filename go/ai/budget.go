@@ -0,0 +1,88 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// latencyBudget is the argument of [WithLatencyBudget].
+type latencyBudget struct {
+	budget          time.Duration
+	tokensPerSecond float64
+}
+
+// WithLatencyBudget caps the length of the response so that generating it
+// takes no longer than budget, for a model that generates tokensPerSecond
+// output tokens per second. If the context has a deadline that is sooner,
+// the time remaining until the deadline is the budget instead.
+//
+// The cap is the MaxOutputTokens of the request's [GenerationCommonConfig];
+// a smaller MaxOutputTokens in the config is kept. A response that reaches
+// the cap is cut short, with [FinishReasonLength], rather than failing.
+// The request's config must be nil or a GenerationCommonConfig.
+func WithLatencyBudget(budget time.Duration, tokensPerSecond float64) GenerateOption {
+	return func(req *generateParams) error {
+		if req.LatencyBudget != nil {
+			return errors.New("cannot set latency budget (WithLatencyBudget) more than once")
+		}
+		if budget <= 0 || tokensPerSecond <= 0 {
+			return fmt.Errorf("WithLatencyBudget: budget and tokens per second must be positive, got %v and %g", budget, tokensPerSecond)
+		}
+		req.LatencyBudget = &latencyBudget{budget, tokensPerSecond}
+		return nil
+	}
+}
+
+// maxTokens returns the number of tokens that can be generated within
+// the budget, or an error if the deadline of ctx has passed.
+func (b *latencyBudget) maxTokens(ctx context.Context) (int, error) {
+	budget := b.budget
+	if deadline, ok := ctx.Deadline(); ok {
+		budget = min(budget, time.Until(deadline))
+	}
+	if budget <= 0 {
+		return 0, context.DeadlineExceeded
+	}
+	return max(1, int(budget.Seconds()*b.tokensPerSecond)), nil
+}
+
+// apply returns a copy of config with its MaxOutputTokens capped
+// to fit the budget.
+func (b *latencyBudget) apply(ctx context.Context, config any) (*GenerationCommonConfig, error) {
+	var c GenerationCommonConfig
+	switch config := config.(type) {
+	case nil:
+	case *GenerationCommonConfig:
+		if config != nil {
+			c = *config
+		}
+	case GenerationCommonConfig:
+		c = config
+	default:
+		return nil, fmt.Errorf("WithLatencyBudget: config must be a GenerationCommonConfig, got %T", config)
+	}
+	n, err := b.maxTokens(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.MaxOutputTokens == 0 || c.MaxOutputTokens > n {
+		c.MaxOutputTokens = n
+	}
+	return &c, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLatencyBudget(t *testing.T) {
+	var got *GenerationCommonConfig
+	m := DefineModel("test", "budget", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		got, _ = req.Config.(*GenerationCommonConfig)
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("ok")}, nil
+	})
+	generate := func(ctx context.Context, opts ...GenerateOption) error {
+		got = nil
+		_, err := Generate(ctx, m, append([]GenerateOption{WithTextPrompt("hi")}, opts...)...)
+		return err
+	}
+	ctx := context.Background()
+
+	t.Run("budget", func(t *testing.T) {
+		if err := generate(ctx, WithLatencyBudget(2*time.Second, 50)); err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.MaxOutputTokens != 100 {
+			t.Errorf("got config %+v, want MaxOutputTokens 100", got)
+		}
+	})
+	t.Run("smaller limit kept", func(t *testing.T) {
+		config := &GenerationCommonConfig{MaxOutputTokens: 20, Temperature: 0.5}
+		if err := generate(ctx, WithConfig(config), WithLatencyBudget(2*time.Second, 50)); err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.MaxOutputTokens != 20 || got.Temperature != 0.5 {
+			t.Errorf("got config %+v, want MaxOutputTokens 20 and Temperature 0.5", got)
+		}
+	})
+	t.Run("larger limit capped", func(t *testing.T) {
+		config := &GenerationCommonConfig{MaxOutputTokens: 1000}
+		if err := generate(ctx, WithConfig(config), WithLatencyBudget(2*time.Second, 50)); err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.MaxOutputTokens != 100 {
+			t.Errorf("got config %+v, want MaxOutputTokens 100", got)
+		}
+		if config.MaxOutputTokens != 1000 {
+			t.Errorf("caller's config was modified")
+		}
+	})
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := generate(ctx, WithLatencyBudget(time.Minute, 50)); err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.MaxOutputTokens > 50 || got.MaxOutputTokens < 40 {
+			t.Errorf("got config %+v, want MaxOutputTokens about 50", got)
+		}
+	})
+	t.Run("other config", func(t *testing.T) {
+		err := generate(ctx, WithConfig(map[string]any{"maxOutputTokens": 10}), WithLatencyBudget(time.Second, 50))
+		errorContains(t, err, "config must be a GenerationCommonConfig")
+	})
+}
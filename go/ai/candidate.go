@@ -0,0 +1,108 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import "encoding/json"
+
+// A Candidate is one of the alternative responses generated by a model.
+// A [ModelResponse] holds the first candidate in its Message and
+// FinishReason fields; [ModelResponse.Candidates] describes all of them.
+type Candidate struct {
+	Index         int             `json:"index"`
+	Message       *Message        `json:"message,omitempty"`
+	FinishReason  FinishReason    `json:"finishReason,omitempty"`
+	FinishMessage string          `json:"finishMessage,omitempty"`
+	SafetyRatings []*SafetyRating `json:"safetyRatings,omitempty"`
+}
+
+// A SafetyRating is a model's assessment of one category of harm
+// in a candidate.
+type SafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	// Blocked reports whether the candidate was blocked
+	// because of this rating.
+	Blocked bool `json:"blocked,omitempty"`
+}
+
+// candidatesKey is the key in [ModelResponse.Custom] under which
+// the candidates are stored.
+const candidatesKey = "candidates"
+
+// Candidates returns the candidates recorded by the model plugin with
+// [ModelResponse.SetCandidates], or nil if the plugin recorded none.
+func (gr *ModelResponse) Candidates() []*Candidate {
+	return customValue[[]*Candidate](gr, candidatesKey)
+}
+
+// SetCandidates records the candidates of the response in its Custom field.
+// (Only genkit plugins should need to use this method.)
+func (gr *ModelResponse) SetCandidates(cs []*Candidate) {
+	gr.SetCustomValue(candidatesKey, cs)
+}
+
+// customValue returns the value of type T stored under key in the
+// Custom map of gr, or the zero value if there is none.
+func customValue[T any](gr *ModelResponse, key string) T {
+	var zero T
+	custom, ok := gr.Custom.(map[string]any)
+	if !ok {
+		return zero
+	}
+	switch v := custom[key].(type) {
+	case T:
+		return v
+	case nil:
+		return zero
+	default:
+		// The response was unmarshaled from JSON.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return zero
+		}
+		var t T
+		if err := json.Unmarshal(b, &t); err != nil {
+			return zero
+		}
+		return t
+	}
+}
+
+// SetCustomValue stores v under key in the Custom field of gr,
+// for plugins and libraries that record information on a response.
+// If Custom holds something other than a map[string]any, such as a struct
+// set by a model plugin, it is first converted to a map through JSON so
+// that its fields are kept; if it isn't a JSON object, it is kept in the
+// map under the key "custom".
+func (gr *ModelResponse) SetCustomValue(key string, v any) {
+	custom, ok := gr.Custom.(map[string]any)
+	if !ok {
+		custom = customMap(gr.Custom)
+		gr.Custom = custom
+	}
+	custom[key] = v
+}
+
+// customMap converts a Custom value that isn't a map[string]any to one.
+func customMap(v any) map[string]any {
+	if v == nil {
+		return map[string]any{}
+	}
+	var m map[string]any
+	if b, err := json.Marshal(v); err == nil && json.Unmarshal(b, &m) == nil && m != nil {
+		return m
+	}
+	return map[string]any{"custom": v}
+}
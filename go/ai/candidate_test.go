@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestCandidates(t *testing.T) {
+	want := []*Candidate{
+		{Index: 0, Message: NewModelTextMessage("done"), FinishReason: FinishReasonStop},
+		{
+			Index:         1,
+			FinishReason:  FinishReasonBlocked,
+			SafetyRatings: []*SafetyRating{{Category: "hate", Probability: "high", Blocked: true}},
+		},
+	}
+	resp := &ModelResponse{Message: want[0].Message, FinishReason: FinishReasonStop}
+	if got := resp.Candidates(); got != nil {
+		t.Errorf("got candidates %v before any were set", got)
+	}
+	resp.SetCandidates(want)
+	if diff := cmp.Diff(want, resp.Candidates()); diff != "" {
+		t.Errorf("candidates mismatch (-want +got):\n%s", diff)
+	}
+
+	// Candidates survive a JSON round trip, as when a response is traced.
+	b, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ModelResponse
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	// A text part's content type is not marshaled.
+	if diff := cmp.Diff(want, got.Candidates(), cmpopts.IgnoreFields(Part{}, "ContentType")); diff != "" {
+		t.Errorf("candidates after JSON mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetCustomValue(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		custom any
+		want   map[string]any
+	}{
+		{"nil", nil, map[string]any{"k": 1}},
+		{"map", map[string]any{"a": "b"}, map[string]any{"a": "b", "k": 1}},
+		{"struct", struct {
+			Region string `json:"region"`
+		}{"us"}, map[string]any{"region": "us", "k": 1}},
+		{"not an object", "raw", map[string]any{"custom": "raw", "k": 1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp := &ModelResponse{Custom: test.custom}
+			resp.SetCustomValue("k", 1)
+			if diff := cmp.Diff(test.want, resp.Custom); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
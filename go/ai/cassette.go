@@ -0,0 +1,197 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/firebase/genkit/go/internal/base"
+)
+
+// WithCassette records the model's responses in the file at path and
+// replays them on later calls, so that tests of code that calls real
+// models are deterministic and, once recorded, make no network requests.
+//
+// Each request to the model is recorded separately, keyed by a hash of
+// the model's name and the request. This includes each turn of a
+// conversation in which the model calls tools. A request whose response
+// is in the cassette is answered from it without calling the model;
+// otherwise the model is called and its response is added to the
+// cassette. Tools are run as usual on replay, and since their results
+// are part of the next request, a change to a tool's result is a request
+// that is not in the cassette. A replayed response is streamed, if
+// requested, as a single chunk.
+//
+// The file is read once per process, when the cassette is first used.
+// Delete the file to record again.
+func WithCassette(path string) GenerateOption {
+	return func(req *generateParams) error {
+		if req.Cassette != "" {
+			return errors.New("cannot set cassette (WithCassette) more than once")
+		}
+		req.Cassette = path
+		return nil
+	}
+}
+
+// cassetteFile is the contents of a cassette file.
+type cassetteFile struct {
+	Interactions []*cassetteInteraction `json:"interactions"`
+}
+
+type cassetteInteraction struct {
+	Key      string         `json:"key"`
+	Model    string         `json:"model"`
+	Request  *ModelRequest  `json:"request"`
+	Response *ModelResponse `json:"response"`
+}
+
+// A cassette is a cassette file loaded into memory.
+type cassette struct {
+	path string
+
+	mu    sync.Mutex
+	file  cassetteFile
+	byKey map[string]*cassetteInteraction
+}
+
+var (
+	cassettesMu sync.Mutex
+	cassettes   = map[string]*cassette{} // by cleaned path
+)
+
+// cassetteContextKey holds the cassette that model requests are
+// replayed from and recorded in.
+var cassetteContextKey = base.NewContextKey[*cassette]()
+
+// loadCassette returns the cassette at path, reading the file
+// the first time it is called for path.
+func loadCassette(path string) (*cassette, error) {
+	path = filepath.Clean(path)
+	cassettesMu.Lock()
+	defer cassettesMu.Unlock()
+	if c, ok := cassettes[path]; ok {
+		return c, nil
+	}
+	c := &cassette{path: path, byKey: map[string]*cassetteInteraction{}}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("cassette: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &c.file); err != nil {
+			return nil, fmt.Errorf("cassette %s: %w", path, err)
+		}
+	}
+	for _, in := range c.file.Interactions {
+		c.byKey[in.Key] = in
+	}
+	cassettes[path] = c
+	return c, nil
+}
+
+// run replays the response of the named model to req from the cassette,
+// or calls the model with call and records its response.
+func (c *cassette) run(ctx context.Context, model string, req *ModelRequest, cb ModelStreamingCallback, call ModelFunc) (*ModelResponse, error) {
+	key, err := cassetteKey(model, req)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	in := c.byKey[key]
+	c.mu.Unlock()
+	if in != nil {
+		// Copy the response, so that callers can't modify the recording.
+		resp, err := cloneResponse(in.Response)
+		if err != nil {
+			return nil, err
+		}
+		resp.Request = req
+		if cb != nil && resp.Message != nil {
+			if err := cb(ctx, &ModelResponseChunk{Content: resp.Message.Content}); err != nil {
+				return nil, err
+			}
+		}
+		return resp, nil
+	}
+	resp, err := call(ctx, req, cb)
+	if err != nil {
+		return nil, err
+	}
+	// The request is stored separately, for readability.
+	recorded := *resp
+	recorded.Request = nil
+	rec, err := cloneResponse(&recorded)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	in = &cassetteInteraction{Key: key, Model: model, Request: req, Response: rec}
+	c.file.Interactions = append(c.file.Interactions, in)
+	c.byKey[key] = in
+	if err := c.write(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// cloneResponse returns a deep copy of resp.
+func cloneResponse(resp *ModelResponse) (*ModelResponse, error) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("cassette: %w", err)
+	}
+	var c ModelResponse
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cassette: %w", err)
+	}
+	return &c, nil
+}
+
+// cassetteKey returns the key of a request to the named model.
+func cassetteKey(model string, req *ModelRequest) (string, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("cassette: %w", err)
+	}
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// write writes the cassette to its file.
+// The caller must hold c.mu.
+func (c *cassette) write() error {
+	data, err := json.MarshalIndent(&c.file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cassette: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0o644); err != nil {
+		return fmt.Errorf("cassette: %w", err)
+	}
+	return nil
+}
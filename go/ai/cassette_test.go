@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCassette(t *testing.T) {
+	calls := 0
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.WriteString(w, "recorded reply")
+	}))
+	m := DefineModel("test", "cassette", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		res, err := http.Get(provider.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &ModelResponse{Request: req, Message: NewModelTextMessage(string(body))}, nil
+	})
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	generate := func(prompt string) (string, error) {
+		return GenerateText(ctx, m, WithTextPrompt(prompt), WithCassette(path))
+	}
+	got, err := generate("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "recorded reply" || calls != 1 {
+		t.Fatalf("recording: got %q with %d calls, want %q with 1 call", got, calls, "recorded reply")
+	}
+
+	// Replaying must not touch the network, or read the file again.
+	provider.Close()
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = generate("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "recorded reply" || calls != 1 {
+		t.Errorf("replay: got %q with %d calls, want %q with 1 call", got, calls, "recorded reply")
+	}
+
+	// A different request is not in the cassette.
+	if _, err := generate("goodbye"); err == nil {
+		t.Error("got no error for an unrecorded request with the provider down")
+	}
+}
+
+func TestCassetteTools(t *testing.T) {
+	toolCalls := 0
+	suffix := "a"
+	tool := DefineTool("cassetteSuffix", "returns a suffix",
+		func(ctx context.Context, _ struct{ N int }) (string, error) {
+			toolCalls++
+			return suffix, nil
+		},
+	)
+	modelCalls := 0
+	offline := false
+	m := DefineModel("test", "cassetteTools", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		if offline {
+			return nil, errors.New("offline")
+		}
+		modelCalls++
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == RoleTool {
+			return &ModelResponse{Request: req, Message: NewModelTextMessage(fmt.Sprint("got ", last.Content[0].ToolResponse.Output["response"]))}, nil
+		}
+		return &ModelResponse{
+			Request: req,
+			Message: &Message{
+				Role:    RoleModel,
+				Content: []*Part{NewToolRequestPart(&ToolRequest{Name: "cassetteSuffix", Input: map[string]any{"N": 1}})},
+			},
+		}, nil
+	})
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	generate := func() (string, error) {
+		return GenerateText(ctx, m, WithTextPrompt("call the tool"), WithTools(tool), WithCassette(path))
+	}
+	got, err := generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "got a" || modelCalls != 2 || toolCalls != 1 {
+		t.Fatalf("recording: got %q with %d model calls and %d tool calls, want %q with 2 and 1", got, modelCalls, toolCalls, "got a")
+	}
+
+	// On replay, each model turn is answered from the cassette,
+	// but the tool is run.
+	offline = true
+	got, err = generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "got a" || toolCalls != 2 {
+		t.Errorf("replay: got %q with %d tool calls, want %q with 2", got, toolCalls, "got a")
+	}
+
+	// A different tool result makes a request that is not in the cassette.
+	suffix = "b"
+	if _, err := generate(); err == nil {
+		t.Error("got no error for a changed tool result with the model offline")
+	}
+}
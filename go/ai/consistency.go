@@ -0,0 +1,98 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// selfConsistencyTemperature is the temperature at which
+// [SelfConsistency] samples if the request has no config.
+const selfConsistencyTemperature = 1.0
+
+// SelfConsistency generates n responses to the same request, built from
+// opts, and returns the answer that reducer chooses from their texts.
+// Sampling several answers and keeping the most common one makes
+// answers to reasoning questions more accurate. If reducer is nil,
+// it is [MajorityVote].
+//
+// The responses are generated concurrently. So that they vary, if opts
+// doesn't set a config, they are sampled with a temperature of 1.
+// If any response fails, SelfConsistency returns the errors.
+func SelfConsistency(ctx context.Context, m Model, n int, reducer func([]string) string, opts ...GenerateOption) (string, error) {
+	if n < 1 {
+		return "", fmt.Errorf("SelfConsistency: n must be positive, got %d", n)
+	}
+	if reducer == nil {
+		reducer = MajorityVote
+	}
+	// Apply the options to see whether they set a config.
+	params := &generateParams{Request: &ModelRequest{}}
+	for _, with := range opts {
+		if err := with(params); err != nil {
+			return "", err
+		}
+	}
+	if params.Config == nil && params.Request.Config == nil {
+		opts = append(opts, WithConfig(&GenerationCommonConfig{Temperature: selfConsistencyTemperature}))
+	}
+
+	answers := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := Generate(ctx, m, opts...)
+			if err != nil {
+				errs[i] = fmt.Errorf("sample %d: %w", i, err)
+				return
+			}
+			answers[i] = resp.Text()
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return "", err
+	}
+	return reducer(answers), nil
+}
+
+// MajorityVote returns the most common of the answers, ignoring
+// leading and trailing white space. Ties go to the answer that
+// appears first. It returns "" if there are no answers.
+func MajorityVote(answers []string) string {
+	counts := map[string]int{}
+	var order []string // distinct answers in order of first appearance
+	for _, a := range answers {
+		a = strings.TrimSpace(a)
+		if counts[a] == 0 {
+			order = append(order, a)
+		}
+		counts[a]++
+	}
+	best, bestCount := "", 0
+	for _, a := range order {
+		if counts[a] > bestCount {
+			best, bestCount = a, counts[a]
+		}
+	}
+	return best
+}
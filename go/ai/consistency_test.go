@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSelfConsistency(t *testing.T) {
+	// The model gives the right answer most of the time.
+	answers := []string{"42", "41", "42 ", "17", "42"}
+	var (
+		mu           sync.Mutex
+		calls        int
+		temperatures []float64
+	)
+	m := DefineModel("test", "sampler", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		a := answers[calls%len(answers)]
+		calls++
+		if c, ok := req.Config.(*GenerationCommonConfig); ok {
+			temperatures = append(temperatures, c.Temperature)
+		}
+		return &ModelResponse{Request: req, Message: NewModelTextMessage(a)}, nil
+	})
+
+	got, err := SelfConsistency(context.Background(), m, len(answers), MajorityVote, WithTextPrompt("6 * 7?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "42" {
+		t.Errorf("got %q, want %q", got, "42")
+	}
+	if calls != len(answers) {
+		t.Errorf("model called %d times, want %d", calls, len(answers))
+	}
+	for _, temp := range temperatures {
+		if temp != selfConsistencyTemperature {
+			t.Errorf("sampled at temperature %g, want %g", temp, selfConsistencyTemperature)
+		}
+	}
+	if len(temperatures) != len(answers) {
+		t.Errorf("%d samples had a config, want %d", len(temperatures), len(answers))
+	}
+
+	t.Run("custom reducer", func(t *testing.T) {
+		longest := func(as []string) string {
+			best := ""
+			for _, a := range as {
+				if len(strings.TrimSpace(a)) > len(best) {
+					best = strings.TrimSpace(a)
+				}
+			}
+			return best
+		}
+		calls = 0
+		got, err := SelfConsistency(context.Background(), m, 2, longest, WithTextPrompt("6 * 7?"),
+			WithConfig(&GenerationCommonConfig{Temperature: 0.5}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "42" && got != "41" {
+			t.Errorf("got %q, want one of the first two answers", got)
+		}
+		if last := temperatures[len(temperatures)-1]; last != 0.5 {
+			t.Errorf("sampled at temperature %g, want the configured 0.5", last)
+		}
+	})
+	t.Run("bad n", func(t *testing.T) {
+		_, err := SelfConsistency(context.Background(), m, 0, nil, WithTextPrompt("6 * 7?"))
+		errorContains(t, err, "n must be positive")
+	})
+}
+
+func TestMajorityVote(t *testing.T) {
+	for _, test := range []struct {
+		answers []string
+		want    string
+	}{
+		{nil, ""},
+		{[]string{"a"}, "a"},
+		{[]string{"a", "b", "b"}, "b"},
+		{[]string{" b", "a", "b\n", "a"}, "b"},
+		{[]string{"x", ""}, "x"},
+	} {
+		if got := MajorityVote(test.answers); got != test.want {
+			t.Errorf("MajorityVote(%q) = %q, want %q", test.answers, got, test.want)
+		}
+	}
+}
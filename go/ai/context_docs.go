@@ -0,0 +1,137 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// WithContextDocuments passes documents, such as those returned by a
+// retriever, to the model as context for its answer. The documents are
+// added to the request's Context, and their text is appended to the last
+// user message, each labeled with its ID so that the model can cite it.
+// A document without an ID is labeled with its [Document.ContentID].
+// Use [ModelResponse.CitedDocuments] to find the documents cited by the
+// response.
+func WithContextDocuments(docs ...*Document) GenerateOption {
+	return func(req *generateParams) error {
+		for _, d := range docs {
+			if d.ID == "" {
+				// Copy the document rather than modifying it.
+				dc := *d
+				dc.ID = d.ContentID()
+				d = &dc
+			}
+			req.ContextDocuments = append(req.ContextDocuments, d)
+			req.Request.Context = append(req.Request.Context, d)
+		}
+		return nil
+	}
+}
+
+// WithCitationsRequired requires the response to cite at least one of the
+// documents passed with [WithContextDocuments], with a citation part.
+// If it cites none, the model is asked once more to answer, citing the
+// documents it uses. If that response cites none either, [Generate]
+// returns a [*MissingCitationsError].
+func WithCitationsRequired() GenerateOption {
+	return func(req *generateParams) error {
+		if req.CitationsRequired {
+			return errors.New("cannot set citations required (WithCitationsRequired) more than once")
+		}
+		req.CitationsRequired = true
+		return nil
+	}
+}
+
+// A MissingCitationsError is returned by [Generate] when the response
+// set by [WithCitationsRequired] does not cite any context document.
+type MissingCitationsError struct {
+	// Response is the last response, which has no citations.
+	Response *ModelResponse
+}
+
+func (e *MissingCitationsError) Error() string {
+	return "response does not cite any of the context documents"
+}
+
+// citesContext reports whether resp cites any of docs.
+func citesContext(resp *ModelResponse, docs []*Document) bool {
+	for _, c := range resp.Citations() {
+		if slices.ContainsFunc(docs, func(d *Document) bool { return d.ID == c.DocumentID }) {
+			return true
+		}
+	}
+	return false
+}
+
+// citationRepairRequest returns a request that follows req and its
+// response resp, which cites none of docs, with a demand for citations.
+func citationRepairRequest(req *ModelRequest, resp *ModelResponse, docs []*Document) *ModelRequest {
+	rreq := *req
+	rreq.Messages = slices.Clip(rreq.Messages)
+	if resp.Message != nil {
+		rreq.Messages = append(rreq.Messages, resp.Message)
+	}
+	ids := make([]string, len(docs))
+	for i, d := range docs {
+		ids[i] = d.ID
+	}
+	rreq.Messages = append(rreq.Messages, NewUserTextMessage(fmt.Sprintf(
+		"Your previous response did not cite its sources. Answer again, citing by ID the documents you use, from: %s.",
+		strings.Join(ids, ", "))))
+	return &rreq
+}
+
+// contextDocumentsPreamble introduces the documents in the prompt.
+const contextDocumentsPreamble = "Use the following information to complete your task. " +
+	"Each item is labeled with the ID of its document; cite the documents you use by ID."
+
+// augmentWithContext appends the text of docs to the last user message
+// in msgs, or adds a user message holding it if there is none.
+// The messages are not modified.
+func augmentWithContext(msgs []*Message, docs []*Document) []*Message {
+	var sb strings.Builder
+	sb.WriteString(contextDocumentsPreamble)
+	sb.WriteString("\n\n")
+	for _, d := range docs {
+		fmt.Fprintf(&sb, "- [%s]: %s\n", d.ID, documentText(d))
+	}
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role != RoleUser {
+			continue
+		}
+		m := *msgs[i]
+		m.Content = append(slices.Clip(m.Content), NewTextPart("\n\n"+sb.String()))
+		msgs = slices.Clone(msgs)
+		msgs[i] = &m
+		return msgs
+	}
+	return append(slices.Clip(msgs), NewUserTextMessage(sb.String()))
+}
+
+// documentText returns the text parts of d, concatenated.
+func documentText(d *Document) string {
+	var sb strings.Builder
+	for _, p := range d.Content {
+		if p.IsText() {
+			sb.WriteString(p.Text)
+		}
+	}
+	return sb.String()
+}
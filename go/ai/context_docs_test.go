@@ -0,0 +1,129 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWithContextDocuments(t *testing.T) {
+	pizza := &Document{ID: "pizza", Content: []*Part{NewTextPart("Pizza $10")}}
+	soup := DocumentFromText("Soup $5", nil)
+	soup.ID = ""
+
+	// The model cites the document that mentions soup.
+	var prompt string
+	m := DefineModel("test", "citer", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		prompt = req.Messages[len(req.Messages)-1].Text()
+		resp := &ModelResponse{Request: req, Message: NewModelTextMessage("Soup costs $5.")}
+		for _, c := range req.Context {
+			if d := c.(*Document); strings.Contains(d.Content[0].Text, "Soup") {
+				resp.AddCitations(d.ID)
+			}
+		}
+		return resp, nil
+	})
+	prompt0 := NewUserTextMessage("How much is the soup?")
+	resp, err := Generate(context.Background(), m,
+		WithMessages(prompt0), WithContextDocuments(pizza, soup))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Request.Context) != 2 {
+		t.Fatalf("request has %d context items, want 2", len(resp.Request.Context))
+	}
+	soupID := soup.ContentID()
+	want := "How much is the soup?\n\n" + contextDocumentsPreamble + "\n\n" +
+		"- [pizza]: Pizza $10\n" +
+		"- [" + soupID + "]: Soup $5\n"
+	if diff := cmp.Diff(want, prompt); diff != "" {
+		t.Errorf("prompt mismatch (-want, +got):\n%s", diff)
+	}
+	if len(prompt0.Content) != 1 {
+		t.Error("Generate modified the caller's message")
+	}
+	if soup.ID != "" {
+		t.Error("WithContextDocuments modified the caller's document")
+	}
+
+	cited := resp.CitedDocuments()
+	if len(cited) != 1 || cited[0].ID != soupID || cited[0].Content[0].Text != "Soup $5" {
+		t.Errorf("got cited documents %v, want the soup document", cited)
+	}
+}
+
+func TestWithCitationsRequired(t *testing.T) {
+	doc := &Document{ID: "policy", Content: []*Part{NewTextPart("Refunds within 30 days.")}}
+
+	// The model omits citations until it is asked for them.
+	var requests []*ModelRequest
+	m := DefineModel("test", "forgetfulCiter", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		requests = append(requests, req)
+		resp := &ModelResponse{Request: req, Message: NewModelTextMessage("Within 30 days.")}
+		if strings.Contains(req.Messages[len(req.Messages)-1].Text(), "did not cite") {
+			resp.AddCitations("policy")
+		}
+		return resp, nil
+	})
+	resp, err := Generate(context.Background(), m,
+		WithTextPrompt("When can I get a refund?"), WithContextDocuments(doc), WithCitationsRequired())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("model called %d times, want 2", len(requests))
+	}
+	repair := requests[1].Messages
+	if got, want := len(repair), len(requests[0].Messages)+2; got != want {
+		t.Fatalf("repair request has %d messages, want %d", got, want)
+	}
+	if repair[len(repair)-2].Role != RoleModel {
+		t.Error("repair request does not include the uncited response")
+	}
+	if got := repair[len(repair)-1].Text(); !strings.Contains(got, "policy") {
+		t.Errorf("repair message %q does not list the document IDs", got)
+	}
+	if cited := resp.CitedDocuments(); len(cited) != 1 || cited[0].ID != "policy" {
+		t.Errorf("got cited documents %v, want the policy document", cited)
+	}
+
+	t.Run("never cites", func(t *testing.T) {
+		calls := 0
+		m := DefineModel("test", "nonCiter", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+			calls++
+			return &ModelResponse{Request: req, Message: NewModelTextMessage("Within 30 days.")}, nil
+		})
+		_, err := Generate(context.Background(), m,
+			WithTextPrompt("When can I get a refund?"), WithContextDocuments(doc), WithCitationsRequired())
+		var cerr *MissingCitationsError
+		if !errors.As(err, &cerr) {
+			t.Fatalf("got error %v, want a *MissingCitationsError", err)
+		}
+		if calls != 2 {
+			t.Errorf("model called %d times, want 2", calls)
+		}
+	})
+
+	t.Run("no documents", func(t *testing.T) {
+		_, err := Generate(context.Background(), m, WithTextPrompt("Hi"), WithCitationsRequired())
+		errorContains(t, err, "no context documents")
+	})
+}
@@ -0,0 +1,37 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+
+	"github.com/firebase/genkit/go/internal/base"
+)
+
+var apiKeyKey = base.NewContextKey[string]()
+
+// WithAPIKey returns a context that carries a provider API key.
+// Plugins that support it use this key instead of the one they were
+// initialized with for any model call made with the returned context.
+// This lets a single process serve requests on behalf of many tenants.
+func WithAPIKey(ctx context.Context, key string) context.Context {
+	return apiKeyKey.NewContext(ctx, key)
+}
+
+// APIKeyFromContext returns the API key set with [WithAPIKey],
+// or the empty string if there is none.
+func APIKeyFromContext(ctx context.Context) string {
+	return apiKeyKey.FromContext(ctx)
+}
@@ -15,13 +15,26 @@
 package ai
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
 )
 
 // A Document is a piece of data that can be embedded, indexed, or retrieved.
 // It includes metadata. It can contain multiple parts.
 type Document struct {
+	// ID identifies the document, for deduplication, deletion and
+	// citation. Indexers use it, if set, as the ID of the stored
+	// document, and retrievers return it. If it is empty, indexers
+	// derive an ID from the document as the TypeScript implementation
+	// does, so that stores indexed by either agree. Set it to
+	// [Document.ContentID] for an ID that is stable across processes
+	// and doesn't depend on the indexer.
+	ID string `json:"id,omitempty"`
 	// The data that is part of this document.
 	Content []*Part `json:"content,omitempty"`
 	// The metadata for this document.
@@ -36,6 +49,49 @@ type Part struct {
 	Text         string        `json:"text,omitempty"`        // valid for kind∈{text,blob}
 	ToolRequest  *ToolRequest  `json:"toolreq,omitempty"`     // valid for kind==partToolRequest
 	ToolResponse *ToolResponse `json:"toolresp,omitempty"`    // valid for kind==partToolResponse
+	Citation     *Citation     `json:"citation,omitempty"`    // valid for kind==partCitation
+	MediaReader  io.Reader     `json:"-"`                     // valid for kind==blob; see NewMediaPartReader
+}
+
+// mediaReader reads the contents of a media part from an io.Reader
+// the first time they are needed, and keeps them for later use.
+type mediaReader struct {
+	once sync.Once
+	r    io.Reader
+	data []byte
+	err  error
+
+	mu  sync.Mutex
+	off int // offset of the next Read
+}
+
+func (m *mediaReader) contents() ([]byte, error) {
+	m.once.Do(func() {
+		m.data, m.err = io.ReadAll(m.r)
+		if c, ok := m.r.(io.Closer); ok {
+			if err := c.Close(); m.err == nil {
+				m.err = err
+			}
+		}
+		m.r = nil
+	})
+	return m.data, m.err
+}
+
+// Read reads from the contents, independently of [Part.ReadMedia].
+func (m *mediaReader) Read(p []byte) (int, error) {
+	data, err := m.contents()
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.off >= len(data) {
+		return 0, io.EOF
+	}
+	n := copy(p, data[m.off:])
+	m.off += n
+	return n, nil
 }
 
 type PartKind int8
@@ -46,8 +102,18 @@ const (
 	PartData
 	PartToolRequest
 	PartToolResponse
+	PartCitation
 )
 
+// A Citation identifies a source document that informed a model's answer,
+// such as a document returned by a retriever in a RAG flow.
+type Citation struct {
+	// The ID of the cited document.
+	DocumentID string `json:"documentId"`
+	// Optional metadata about the citation, such as the cited passage.
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
 // NewTextPart returns a Part containing text.
 func NewTextPart(text string) *Part {
 	return &Part{Kind: PartText, ContentType: "plain/text", Text: text}
@@ -64,6 +130,18 @@ func NewMediaPart(mimeType, contents string) *Part {
 	return &Part{Kind: PartMedia, ContentType: mimeType, Text: contents}
 }
 
+// NewMediaPartReader returns a Part containing media of the given
+// content type whose contents are read from r, instead of being held
+// in memory as a "data:" URL. The contents are not read until a model
+// request containing the part is sent; they are then read once and kept,
+// so that the part may be sent again, as in the turns of a tool loop.
+// If r is an [io.Closer], it is closed after reading.
+//
+// The contents are not included when the part is marshaled to JSON.
+func NewMediaPartReader(contentType string, r io.Reader) *Part {
+	return &Part{Kind: PartMedia, ContentType: contentType, MediaReader: &mediaReader{r: r}}
+}
+
 // NewDataPart returns a Part containing raw string data.
 func NewDataPart(contents string) *Part {
 	return &Part{Kind: PartData, Text: contents}
@@ -82,6 +160,11 @@ func NewToolResponsePart(r *ToolResponse) *Part {
 	return &Part{Kind: PartToolResponse, ToolResponse: r}
 }
 
+// NewCitationPart returns a Part citing the document with the given ID.
+func NewCitationPart(c *Citation) *Part {
+	return &Part{Kind: PartCitation, Citation: c}
+}
+
 // IsText reports whether the [Part] contains plain text.
 func (p *Part) IsText() bool {
 	return p.Kind == PartText
@@ -107,6 +190,25 @@ func (p *Part) IsToolResponse() bool {
 	return p.Kind == PartToolResponse
 }
 
+// ReadMedia returns the contents of the [Part]'s MediaReader.
+// For a part made by [NewMediaPartReader], they are read on the first
+// call and returned again by later calls.
+// (Only genkit plugins should need to use this method.)
+func (p *Part) ReadMedia() ([]byte, error) {
+	if p.MediaReader == nil {
+		return nil, errors.New("ReadMedia: part has no media reader")
+	}
+	if m, ok := p.MediaReader.(*mediaReader); ok {
+		return m.contents()
+	}
+	return io.ReadAll(p.MediaReader)
+}
+
+// IsCitation reports whether the [Part] contains a citation of a source document.
+func (p *Part) IsCitation() bool {
+	return p.Kind == PartCitation
+}
+
 // MarshalJSON is called by the JSON marshaler to write out a Part.
 func (p *Part) MarshalJSON() ([]byte, error) {
 	// This is not handled by the schema generator because
@@ -148,6 +250,13 @@ func (p *Part) MarshalJSON() ([]byte, error) {
 			ToolResp: p.ToolResponse,
 		}
 		return json.Marshal(v)
+	case PartCitation:
+		v := struct {
+			Citation *Citation `json:"citation,omitempty"`
+		}{
+			Citation: p.Citation,
+		}
+		return json.Marshal(v)
 	default:
 		return nil, fmt.Errorf("invalid part kind %v", p.Kind)
 	}
@@ -159,6 +268,7 @@ type partSchema struct {
 	Data     string          `json:"data,omitempty"`
 	ToolReq  *ToolRequest    `json:"toolreq,omitempty"`
 	ToolResp *ToolResponse   `json:"toolresp,omitempty"`
+	Citation *Citation       `json:"citation,omitempty"`
 }
 
 // UnmarshalJSON is called by the JSON unmarshaler to read a Part.
@@ -182,6 +292,9 @@ func (p *Part) UnmarshalJSON(b []byte) error {
 	case s.ToolResp != nil:
 		p.Kind = PartToolResponse
 		p.ToolResponse = s.ToolResp
+	case s.Citation != nil:
+		p.Kind = PartCitation
+		p.Citation = s.Citation
 	default:
 		p.Kind = PartText
 		p.Text = s.Text
@@ -204,6 +317,27 @@ func (Part) JSONSchemaAlias() any {
 	return partSchema{}
 }
 
+// DecodeMetadata stores the metadata value under key in the value pointed
+// to by v. The value is converted through JSON, so this works both when
+// the metadata holds the original Go value and when a document store has
+// round-tripped it through JSON, leaving a map[string]any.
+// It returns an error if there is no value under key or if the value
+// cannot be decoded into v.
+func (d *Document) DecodeMetadata(key string, v any) error {
+	val, ok := d.Metadata[key]
+	if !ok {
+		return fmt.Errorf("document has no metadata %q", key)
+	}
+	b, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Errorf("metadata %q: %w", key, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("metadata %q: %w", key, err)
+	}
+	return nil
+}
+
 // DocumentFromText returns a [Document] containing a single plain text part.
 // This takes ownership of the metadata map.
 func DocumentFromText(text string, metadata map[string]any) *Document {
@@ -217,3 +351,22 @@ func DocumentFromText(text string, metadata map[string]any) *Document {
 		Metadata: metadata,
 	}
 }
+
+// ContentID returns an ID derived from the content and metadata of the
+// document, ignoring its ID field. Documents with the same content and
+// metadata have the same ContentID.
+func (d *Document) ContentID() string {
+	// Map keys are marshaled in sorted order, so the encoding is deterministic.
+	b, err := json.Marshal(struct {
+		Content  []*Part        `json:"content,omitempty"`
+		Metadata map[string]any `json:"metadata,omitempty"`
+	}{d.Content, d.Metadata})
+	if err != nil {
+		// The metadata holds a value that can't be marshaled to JSON.
+		// fmt also prints maps in sorted key order.
+		content, _ := json.Marshal(d.Content)
+		b = append(content, fmt.Sprint(d.Metadata)...)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
@@ -37,6 +37,40 @@ func TestDocumentFromText(t *testing.T) {
 	}
 }
 
+func TestDocumentID(t *testing.T) {
+	d1 := DocumentFromText("robot overlord", map[string]any{"source": "a", "page": 1})
+	d2 := DocumentFromText("robot overlord", map[string]any{"page": 1, "source": "a"})
+	if d1.ID != "" {
+		t.Errorf("DocumentFromText set the ID %q, want none", d1.ID)
+	}
+	id := d1.ContentID()
+	if id != d2.ContentID() {
+		t.Errorf("documents with the same content and metadata have IDs %q and %q", id, d2.ContentID())
+	}
+	for _, d := range []*Document{
+		DocumentFromText("robot underling", map[string]any{"source": "a", "page": 1}),
+		DocumentFromText("robot overlord", map[string]any{"source": "b", "page": 1}),
+		DocumentFromText("robot overlord", nil),
+	} {
+		if d.ContentID() == id {
+			t.Errorf("document %v has the same ContentID as %v", d, d1)
+		}
+	}
+	// The ID survives a JSON round trip, and doesn't affect the ContentID.
+	d1.ID = id
+	b, err := json.Marshal(d1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Document
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != id || got.ContentID() != id {
+		t.Errorf("after a JSON round trip, got ID %q and ContentID %q, want %q", got.ID, got.ContentID(), id)
+	}
+}
+
 // TODO: verify that this works with the data that genkit passes.
 func TestDocumentJSON(t *testing.T) {
 	d := Document{
@@ -68,6 +102,13 @@ func TestDocumentJSON(t *testing.T) {
 					Output: map[string]any{"res1": 4.4, "res2": "bar"},
 				},
 			},
+			&Part{
+				Kind: PartCitation,
+				Citation: &Citation{
+					DocumentID: "doc1",
+					Metadata:   map[string]any{"page": 2.0},
+				},
+			},
 		},
 	}
 
@@ -97,6 +138,8 @@ func TestDocumentJSON(t *testing.T) {
 			return reflect.DeepEqual(a.ToolRequest, b.ToolRequest)
 		case PartToolResponse:
 			return reflect.DeepEqual(a.ToolResponse, b.ToolResponse)
+		case PartCitation:
+			return reflect.DeepEqual(a.Citation, b.Citation)
 		default:
 			t.Fatalf("bad part kind %v", a.Kind)
 			return false
@@ -108,3 +151,20 @@ func TestDocumentJSON(t *testing.T) {
 		t.Errorf("mismatch (-want, +got)\n%s", diff)
 	}
 }
+
+func TestResponseCitations(t *testing.T) {
+	resp := &ModelResponse{Message: NewModelTextMessage("The soup is vegan.")}
+	resp.AddCitations("menu/soup", "menu/allergens")
+
+	var got []string
+	for _, c := range resp.Citations() {
+		got = append(got, c.DocumentID)
+	}
+	want := []string{"menu/soup", "menu/allergens"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got)\n%s", diff)
+	}
+	if g, w := resp.Text(), "The soup is vegan."; g != w {
+		t.Errorf("Text() == %q, want %q", g, w)
+	}
+}
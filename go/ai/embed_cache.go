@@ -0,0 +1,148 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// An EmbedCache stores embeddings so that identical content
+// does not have to be embedded more than once.
+// Callers may modify the embeddings they pass to Put or get from Get,
+// so implementations that keep them in memory must store copies.
+// Keys are opaque strings derived from the embedder name,
+// the embedder options and the document content.
+type EmbedCache interface {
+	// Get returns the embedding stored under key, if any.
+	Get(ctx context.Context, key string) ([]float32, bool)
+	// Put stores an embedding under key.
+	Put(ctx context.Context, key string, embedding []float32)
+}
+
+// NewMemoryEmbedCache returns an in-memory [EmbedCache].
+// Entries expire after ttl. If ttl is zero, entries never expire.
+func NewMemoryEmbedCache(ttl time.Duration) EmbedCache {
+	return &memoryEmbedCache{
+		ttl:     ttl,
+		entries: map[string]memoryEmbedCacheEntry{},
+	}
+}
+
+type memoryEmbedCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]memoryEmbedCacheEntry
+}
+
+type memoryEmbedCacheEntry struct {
+	embedding []float32
+	expires   time.Time // zero if the entry never expires
+}
+
+func (c *memoryEmbedCache) Get(_ context.Context, key string) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return slices.Clone(e.embedding), true
+}
+
+func (c *memoryEmbedCache) Put(_ context.Context, key string, embedding []float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := memoryEmbedCacheEntry{embedding: slices.Clone(embedding)}
+	if c.ttl > 0 {
+		e.expires = time.Now().Add(c.ttl)
+	}
+	c.entries[key] = e
+}
+
+// embedCacheKey returns the cache key for embedding doc with the named
+// embedder and the options and task type of req.
+func embedCacheKey(name string, req *EmbedRequest, doc *Document) (string, error) {
+	opts, err := json.Marshal(req.Options)
+	if err != nil {
+		return "", fmt.Errorf("embed cache: marshaling options: %w", err)
+	}
+	content, err := json.Marshal(doc.Content)
+	if err != nil {
+		return "", fmt.Errorf("embed cache: marshaling document: %w", err)
+	}
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(opts)
+	h.Write([]byte{0})
+	if req.TaskType != "" {
+		h.Write([]byte(req.TaskType))
+		h.Write([]byte{0})
+	}
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// embedCached runs req on e, using cache to avoid embedding
+// documents whose embeddings are already known.
+func embedCached(ctx context.Context, e Embedder, req *EmbedRequest, cache EmbedCache) (*EmbedResponse, error) {
+	resp := &EmbedResponse{Embeddings: make([]*DocumentEmbedding, len(req.Documents))}
+	keys := make([]string, len(req.Documents))
+	var misses []int // indexes of documents not in the cache
+	for i, doc := range req.Documents {
+		key, err := embedCacheKey(e.Name(), req, doc)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+		if emb, ok := cache.Get(ctx, key); ok {
+			resp.Embeddings[i] = &DocumentEmbedding{Embedding: emb}
+		} else {
+			misses = append(misses, i)
+		}
+	}
+	if len(misses) == 0 {
+		return resp, nil
+	}
+
+	missReq := &EmbedRequest{Options: req.Options, TaskType: req.TaskType}
+	for _, i := range misses {
+		missReq.Documents = append(missReq.Documents, req.Documents[i])
+	}
+	missResp, err := e.Embed(ctx, missReq)
+	if err != nil {
+		return nil, err
+	}
+	if len(missResp.Embeddings) != len(misses) {
+		return nil, fmt.Errorf("embedder %s returned %d embeddings for %d documents", e.Name(), len(missResp.Embeddings), len(misses))
+	}
+	for j, i := range misses {
+		emb := missResp.Embeddings[j]
+		resp.Embeddings[i] = emb
+		cache.Put(ctx, keys[i], emb.Embedding)
+	}
+	return resp, nil
+}
@@ -41,8 +41,26 @@ type embedderAction = core.Action[*EmbedRequest, *EmbedResponse, struct{}]
 type EmbedRequest struct {
 	Documents []*Document `json:"input"`
 	Options   any         `json:"options,omitempty"`
+	// TaskType describes how the embeddings will be used, for embedders
+	// that produce different embeddings for different tasks.
+	// Embedders that don't support task types ignore it.
+	TaskType EmbedTaskType `json:"taskType,omitempty"`
+
+	cache EmbedCache // set by [WithEmbedCache]; not sent to the embedder
 }
 
+// EmbedTaskType is the intended use of embeddings.
+type EmbedTaskType string
+
+const (
+	// The documents are queries to be compared with embedded documents.
+	EmbedTaskTypeRetrievalQuery EmbedTaskType = "RETRIEVAL_QUERY"
+	// The documents are to be indexed and retrieved by query.
+	EmbedTaskTypeRetrievalDocument EmbedTaskType = "RETRIEVAL_DOCUMENT"
+	// The documents are to be classified.
+	EmbedTaskTypeClassification EmbedTaskType = "CLASSIFICATION"
+)
+
 type EmbedResponse struct {
 	// One embedding for each Document in the request, in the same order.
 	Embeddings []*DocumentEmbedding `json:"embeddings"`
@@ -99,6 +117,17 @@ func WithEmbedOptions(opts any) EmbedOption {
 	}
 }
 
+// WithEmbedTaskType sets the task type of the [EmbedRequest].
+func WithEmbedTaskType(t EmbedTaskType) EmbedOption {
+	return func(req *EmbedRequest) error {
+		if req.TaskType != "" {
+			return errors.New("cannot set task type (WithEmbedTaskType) more than once")
+		}
+		req.TaskType = t
+		return nil
+	}
+}
+
 // WithEmbedText adds simple text documents to [EmbedRequest]
 func WithEmbedText(text ...string) EmbedOption {
 	return func(req *EmbedRequest) error {
@@ -119,6 +148,19 @@ func WithEmbedDocs(docs ...*Document) EmbedOption {
 	}
 }
 
+// WithEmbedCache looks up embeddings in cache before calling the embedder,
+// and stores new embeddings in it. Only documents that miss the cache are
+// sent to the embedder.
+func WithEmbedCache(cache EmbedCache) EmbedOption {
+	return func(req *EmbedRequest) error {
+		if req.cache != nil {
+			return errors.New("cannot set embed cache (WithEmbedCache) more than once")
+		}
+		req.cache = cache
+		return nil
+	}
+}
+
 // Embed invokes the embedder with provided options.
 func Embed(ctx context.Context, e Embedder, opts ...EmbedOption) (*EmbedResponse, error) {
 	req := &EmbedRequest{}
@@ -128,5 +170,8 @@ func Embed(ctx context.Context, e Embedder, opts ...EmbedOption) (*EmbedResponse
 			return nil, err
 		}
 	}
+	if req.cache != nil {
+		return embedCached(ctx, e, req, req.cache)
+	}
 	return e.Embed(ctx, req)
 }
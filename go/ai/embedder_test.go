@@ -0,0 +1,136 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEmbedCache(t *testing.T) {
+	calls := 0
+	embedded := 0
+	emb := DefineEmbedder("test", "counting", func(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+		calls++
+		resp := &EmbedResponse{}
+		for _, doc := range req.Documents {
+			embedded++
+			resp.Embeddings = append(resp.Embeddings, &DocumentEmbedding{
+				Embedding: []float32{float32(len(doc.Content[0].Text))},
+			})
+		}
+		return resp, nil
+	})
+
+	ctx := context.Background()
+	cache := NewMemoryEmbedCache(time.Hour)
+	embed := func(text ...string) [][]float32 {
+		t.Helper()
+		resp, err := Embed(ctx, emb, WithEmbedText(text...), WithEmbedCache(cache))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][]float32
+		for _, e := range resp.Embeddings {
+			got = append(got, e.Embedding)
+		}
+		return got
+	}
+
+	want := [][]float32{{5}}
+	if got := embed("hello"); !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := embed("hello"); !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if calls != 1 {
+		t.Errorf("embedder called %d times, want 1", calls)
+	}
+
+	// Only the new document should be sent to the embedder.
+	want = [][]float32{{2}, {5}}
+	if got := embed("hi", "hello"); !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if calls != 2 || embedded != 2 {
+		t.Errorf("got %d calls embedding %d documents, want 2 calls embedding 2 documents", calls, embedded)
+	}
+
+	// Modifying a returned embedding must not change the cached one.
+	embed("hello")[0][0] = 99
+	if got := embed("hello"); !cmp.Equal(got, [][]float32{{5}}) {
+		t.Errorf("after modifying a result: got %v, want [[5]]", got)
+	}
+
+	// Options written against EmbedRequest work with the cache.
+	var withOne EmbedOption = func(req *EmbedRequest) error {
+		req.Documents = append(req.Documents, DocumentFromText("one", nil))
+		return nil
+	}
+	resp, err := Embed(ctx, emb, withOne, WithEmbedCache(cache))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Embeddings[0].Embedding; !cmp.Equal(got, []float32{3}) {
+		t.Errorf("got %v, want [3]", got)
+	}
+}
+
+func TestMemoryEmbedCacheTTL(t *testing.T) {
+	ctx := context.Background()
+	cache := NewMemoryEmbedCache(time.Millisecond)
+	emb := []float32{1}
+	cache.Put(ctx, "k", emb)
+	emb[0] = 2
+	if got, _ := cache.Get(ctx, "k"); !cmp.Equal(got, []float32{1}) {
+		t.Errorf("after modifying the stored slice: got %v, want [1]", got)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get(ctx, "k"); ok {
+		t.Error("got expired entry")
+	}
+}
+
+func TestEmbedTaskType(t *testing.T) {
+	var got []EmbedTaskType
+	emb := DefineEmbedder("test", "taskType", func(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+		got = append(got, req.TaskType)
+		resp := &EmbedResponse{}
+		for range req.Documents {
+			resp.Embeddings = append(resp.Embeddings, &DocumentEmbedding{Embedding: []float32{1}})
+		}
+		return resp, nil
+	})
+
+	ctx := context.Background()
+	cache := NewMemoryEmbedCache(time.Hour)
+	for _, tt := range []EmbedTaskType{EmbedTaskTypeRetrievalQuery, EmbedTaskTypeRetrievalDocument, ""} {
+		// The cache must not serve an embedding computed for another task type.
+		if _, err := Embed(ctx, emb, WithEmbedText("hello"), WithEmbedTaskType(tt), WithEmbedCache(cache)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []EmbedTaskType{EmbedTaskTypeRetrievalQuery, EmbedTaskTypeRetrievalDocument, ""}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	_, err := Embed(ctx, emb, WithEmbedTaskType(EmbedTaskTypeClassification), WithEmbedTaskType(EmbedTaskTypeRetrievalQuery))
+	errorContains(t, err, "more than once")
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// A Format is a custom output format, such as YAML or CSV.
+// Register one with [RegisterFormat], then request it with
+// [WithOutputFormat]. The model's response is parsed into a value,
+// validated against the output schema if there is one, and stored as
+// JSON, so [ModelResponse.UnmarshalOutput] and [GenerateData] work as they
+// do for [OutputFormatJSON].
+type Format struct {
+	// Instructions, if non-nil, returns text that is added to the
+	// last message of the request to tell the model how to format
+	// its output. The schema is the output schema, or nil.
+	Instructions func(schema map[string]any) string
+	// Parse parses the text of the model's response. The result must
+	// be marshalable to JSON. An error causes the response to be
+	// treated as invalid output, which may be repaired; see [WithSchema].
+	Parse func(text string) (any, error)
+}
+
+var formats struct {
+	mu      sync.Mutex
+	formats map[OutputFormat]*Format
+}
+
+// RegisterFormat registers a custom output format under name.
+// Registering a name again replaces its format.
+// It panics if name is one of the built-in formats or f has no Parse function.
+func RegisterFormat(name OutputFormat, f *Format) {
+	switch name {
+	case OutputFormatJSON, OutputFormatText, OutputFormatMedia:
+		log.Panicf("RegisterFormat: cannot replace built-in format %q", name)
+	}
+	if f == nil || f.Parse == nil {
+		log.Panicf("RegisterFormat: format %q has no Parse function", name)
+	}
+	formats.mu.Lock()
+	defer formats.mu.Unlock()
+	if formats.formats == nil {
+		formats.formats = map[OutputFormat]*Format{}
+	}
+	formats.formats[name] = f
+}
+
+// lookupFormat returns the custom format registered under name, or nil.
+func lookupFormat(name OutputFormat) *Format {
+	formats.mu.Lock()
+	defer formats.mu.Unlock()
+	return formats.formats[name]
+}
+
+// parseFormatted parses text in format f and returns it as JSON,
+// validated against schema if it is non-nil.
+func parseFormatted(f *Format, text string, schema map[string]any) (string, error) {
+	v, err := f.Parse(text)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("parsed output cannot be represented as JSON: %w", err)
+	}
+	return RepairJSON(string(data), schema)
+}
@@ -0,0 +1,61 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v3"
+)
+
+func TestCustomFormat(t *testing.T) {
+	const formatYAML OutputFormat = "yaml"
+	RegisterFormat(formatYAML, &Format{
+		Instructions: func(map[string]any) string { return "Output should be in YAML format." },
+		Parse: func(text string) (any, error) {
+			var v any
+			err := yaml.Unmarshal([]byte(text), &v)
+			return v, err
+		},
+	})
+
+	var instructions string
+	m := DefineModel("test", "yaml", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		instructions = req.Messages[len(req.Messages)-1].Text()
+		return &ModelResponse{
+			Request: req,
+			Message: NewModelTextMessage("name: Ada\nlanguages:\n  - Go\n  - YAML\n"),
+		}, nil
+	})
+
+	type person struct {
+		Name      string   `json:"name"`
+		Languages []string `json:"languages"`
+	}
+	var got person
+	if _, err := GenerateData(context.Background(), m, &got, WithTextPrompt("Describe Ada."), WithOutputFormat(formatYAML)); err != nil {
+		t.Fatal(err)
+	}
+	want := person{Name: "Ada", Languages: []string{"Go", "YAML"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if !strings.Contains(instructions, "YAML format") {
+		t.Errorf("request %q does not contain the format instructions", instructions)
+	}
+}
@@ -124,6 +124,10 @@ type ModelResponseChunk struct {
 	Aggregated bool    `json:"aggregated,omitempty"`
 	Content    []*Part `json:"content,omitempty"`
 	Custom     any     `json:"custom,omitempty"`
+	// Index is the position of the chunk in the stream of the response,
+	// starting at 0. Plugins set it so that [MergeChunks] can order chunks
+	// that arrive out of order.
+	Index int `json:"index,omitempty"`
 }
 
 type FinishReason string
@@ -174,6 +178,10 @@ type ToolRequest struct {
 	// An example might be map[string]any{"country":"USA", "president":3}.
 	Input map[string]any `json:"input,omitempty"`
 	Name  string         `json:"name,omitempty"`
+	// Ref identifies this call of the tool among the tool requests of a response.
+	// The [ToolResponse] to it must have the same Ref. Ref may be empty
+	// if the model does not identify its tool calls.
+	Ref string `json:"ref,omitempty"`
 }
 
 // A ToolResponse is a message from the client to the model containing
@@ -184,4 +192,6 @@ type ToolResponse struct {
 	// Output is a JSON object describing the results of running the tool.
 	// An example might be map[string]any{"name":"Thomas Jefferson", "born":1743}.
 	Output map[string]any `json:"output,omitempty"`
+	// Ref is the Ref of the [ToolRequest] that this responds to.
+	Ref string `json:"ref,omitempty"`
 }
@@ -15,18 +15,24 @@
 package ai
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/firebase/genkit/go/core"
 	"github.com/firebase/genkit/go/core/logger"
 	"github.com/firebase/genkit/go/internal/atype"
 	"github.com/firebase/genkit/go/internal/base"
+	"github.com/firebase/genkit/go/internal/registry"
 )
 
 // Model represents a model that can perform content generation tasks.
@@ -50,17 +56,34 @@ type ModelCapabilities struct {
 	Media      bool // the model supports media as well as text input
 	Tools      bool // the model supports tools
 	SystemRole bool // the model supports a system prompt or role
+	Prefill    bool // the model continues a partial response in a final model message
+	LogProbs   bool // the model can return the log probabilities of generated tokens
+	// The model requires user and model messages to alternate.
+	// Generate merges consecutive messages with the same role.
+	AlternatingRoles bool
 }
 
 // ModelMetadata is the metadata of the model, specifying things like nice user-visible label, capabilities, etc.
 type ModelMetadata struct {
 	Label    string
 	Supports ModelCapabilities
+	// The maximum number of input tokens; zero if unknown.
+	ContextWindow int
+	// The maximum number of output tokens; zero if unknown.
+	MaxOutputTokens int
+	// The config of requests that don't set some of its fields.
+	// See [WithConfig].
+	DefaultConfig *GenerationCommonConfig
 }
 
 // DefineModel registers the given generate function as an action, and returns a
 // [Model] that runs it.
 func DefineModel(provider, name string, metadata *ModelMetadata, generate func(context.Context, *ModelRequest, ModelStreamingCallback) (*ModelResponse, error)) Model {
+	return DefineModelInRegistry(registry.Global, provider, name, metadata, generate)
+}
+
+// DefineModelInRegistry is like [DefineModel], but registers the model in r.
+func DefineModelInRegistry(r *registry.Registry, provider, name string, metadata *ModelMetadata, generate func(context.Context, *ModelRequest, ModelStreamingCallback) (*ModelResponse, error)) Model {
 	metadataMap := map[string]any{}
 	if metadata == nil {
 		// Always make sure there's at least minimal metadata.
@@ -76,12 +99,26 @@ func DefineModel(provider, name string, metadata *ModelMetadata, generate func(c
 		"multiturn":  metadata.Supports.Multiturn,
 		"systemRole": metadata.Supports.SystemRole,
 		"tools":      metadata.Supports.Tools,
+		"prefill":    metadata.Supports.Prefill,
+		"logProbs":   metadata.Supports.LogProbs,
+
+		"alternatingRoles": metadata.Supports.AlternatingRoles,
 	}
 	metadataMap["supports"] = supports
+	if metadata.ContextWindow > 0 {
+		metadataMap["contextWindow"] = metadata.ContextWindow
+	}
+	if metadata.MaxOutputTokens > 0 {
+		metadataMap["maxOutputTokens"] = metadata.MaxOutputTokens
+	}
+	if metadata.DefaultConfig != nil {
+		c := *metadata.DefaultConfig
+		metadataMap["defaultConfig"] = &c
+	}
 
-	return (*modelActionDef)(core.DefineStreamingAction(provider, name, atype.Model, map[string]any{
+	return (*modelActionDef)(core.DefineActionInRegistry(r, provider, name, atype.Model, map[string]any{
 		"model": metadataMap,
-	}, generate))
+	}, nil, generate))
 }
 
 // IsDefinedModel reports whether a model is defined.
@@ -90,9 +127,25 @@ func IsDefinedModel(provider, name string) bool {
 }
 
 // LookupModel looks up a [Model] registered by [DefineModel].
-// It returns nil if the model was not defined.
+// If provider is empty, name is an alias registered with [RegisterAlias].
+// It returns nil if the model was not defined or the alias is unknown.
 func LookupModel(provider, name string) Model {
-	action := core.LookupActionFor[*ModelRequest, *ModelResponse, *ModelResponseChunk](atype.Model, provider, name)
+	return LookupModelInRegistry(registry.Global, provider, name)
+}
+
+// LookupModelInRegistry is like [LookupModel], but looks the model up
+// in r, and then, if r doesn't have it, in the global registry.
+func LookupModelInRegistry(r *registry.Registry, provider, name string) Model {
+	if provider == "" {
+		var err error
+		if provider, name, err = ResolveAlias(name); err != nil {
+			return nil
+		}
+	}
+	action := core.LookupActionInRegistry[*ModelRequest, *ModelResponse, *ModelResponseChunk](r, atype.Model, provider, name)
+	if action == nil && r != registry.Global {
+		action = core.LookupActionFor[*ModelRequest, *ModelResponse, *ModelResponseChunk](atype.Model, provider, name)
+	}
 	if action == nil {
 		return nil
 	}
@@ -105,6 +158,24 @@ type generateParams struct {
 	Stream       ModelStreamingCallback
 	History      []*Message
 	SystemPrompt *Message
+	Moderator    Moderator
+	MaxRepairs   int
+	// Maximum size in bytes of a tool result passed back to the model;
+	// zero for no limit.
+	MaxToolResultSize int
+	Middleware        []ModelMiddleware
+	ToolInterrupt     bool
+	ToolLoopLimit     int           // number of repeats of a tool call that stops the tool loop; zero for no limit
+	Timeout           time.Duration // bound on the whole Generate call; zero for none
+	SemanticCache     *semanticCache
+	AssistantPrefix   string
+	LogProbs          *int           // number of alternative tokens; nil if log probabilities weren't requested
+	Cassette          string         // path of the cassette file; empty if there is none
+	LatencyBudget     *latencyBudget // nil if there is no budget
+	ContextDocuments  []*Document    // documents to add to the prompt, with IDs
+	CitationsRequired bool           // whether the response must cite ContextDocuments
+	OutputParsers     []OutputParser // run in order on the response text
+	Config            any            // set by WithConfig; merged into Request.Config
 }
 
 // GenerateOption configures params of the Generate call.
@@ -130,6 +201,21 @@ func WithSystemPrompt(prompt string) GenerateOption {
 	}
 }
 
+// WithAssistantPrefix primes the model by starting its reply with prefix.
+// If the model supports prefill, prefix is sent as a final model message
+// that the model continues, and the response holds only the continuation.
+// Otherwise, the model is instructed in a final user message to begin
+// its reply with prefix.
+func WithAssistantPrefix(prefix string) GenerateOption {
+	return func(req *generateParams) error {
+		if req.AssistantPrefix != "" {
+			return errors.New("cannot set assistant prefix (WithAssistantPrefix) more than once")
+		}
+		req.AssistantPrefix = prefix
+		return nil
+	}
+}
+
 // WithMessages adds provided messages to ModelRequest.
 func WithMessages(messages ...*Message) GenerateOption {
 	return func(req *generateParams) error {
@@ -152,13 +238,24 @@ func WithHistory(history ...*Message) GenerateOption {
 	}
 }
 
-// WithConfig adds provided config to ModelRequest.
+// WithConfig sets the config of the request.
+//
+// If config is a [GenerationCommonConfig] or a pointer to one, it is
+// merged with any config the request already has, such as that of a
+// request being resumed by [ResumeWithToolResults], and with the
+// DefaultConfig of the model's [ModelMetadata]. Each field of the
+// config sent to the model is taken from the first of these that sets
+// it (has a non-zero value for it): config, then the request's config,
+// then the model's default. So setting only MaxOutputTokens keeps the
+// Temperature of the model's default.
+// Other types of config replace the request's config, and are not
+// merged with the model's default.
 func WithConfig(config any) GenerateOption {
 	return func(req *generateParams) error {
-		if req.Request.Config != nil {
+		if req.Config != nil {
 			return errors.New("cannot set Request.Config (WithConfig) more than once")
 		}
-		req.Request.Config = config
+		req.Config = config
 		return nil
 	}
 }
@@ -198,7 +295,25 @@ func WithOutputSchema(schema any) GenerateOption {
 	}
 }
 
+// WithSchema sets the output schema of the request like [WithOutputSchema].
+// In addition, if the model's response cannot be parsed or does not match
+// the schema, the model is shown the validation error and asked to correct
+// its output, up to maxRepairs times. Repair turns are not streamed.
+func WithSchema(schema any, maxRepairs int) GenerateOption {
+	return func(req *generateParams) error {
+		if maxRepairs < 0 {
+			return fmt.Errorf("WithSchema: negative number of repairs %d", maxRepairs)
+		}
+		if err := WithOutputSchema(schema)(req); err != nil {
+			return err
+		}
+		req.MaxRepairs = maxRepairs
+		return nil
+	}
+}
+
 // WithOutputFormat adds provided output format to ModelRequest.
+// The format may be a custom one registered with [RegisterFormat].
 func WithOutputFormat(format OutputFormat) GenerateOption {
 	return func(req *generateParams) error {
 		if req.Request.Output == nil {
@@ -220,6 +335,99 @@ func WithStreaming(cb ModelStreamingCallback) GenerateOption {
 	}
 }
 
+// WithModeration runs the moderator on the request messages before calling
+// the model, and on the response text afterwards. If the moderator blocks
+// either, Generate returns a [*ModerationError].
+// Note that streamed chunks are delivered before the response is moderated.
+func WithModeration(m Moderator) GenerateOption {
+	return func(req *generateParams) error {
+		if req.Moderator != nil {
+			return errors.New("cannot set moderator (WithModeration) more than once")
+		}
+		req.Moderator = m
+		return nil
+	}
+}
+
+// WithMaxToolResultSize limits each tool result that is passed back to the
+// model during the tool loop to maxBytes bytes of JSON. A larger result is
+// cut to maxBytes, followed by a marker saying how many bytes were removed,
+// and passed to the model as a string instead of structured output.
+func WithMaxToolResultSize(maxBytes int) GenerateOption {
+	return func(req *generateParams) error {
+		if req.MaxToolResultSize != 0 {
+			return errors.New("cannot set max tool result size (WithMaxToolResultSize) more than once")
+		}
+		if maxBytes <= 0 {
+			return fmt.Errorf("WithMaxToolResultSize: size must be positive, got %d", maxBytes)
+		}
+		req.MaxToolResultSize = maxBytes
+		return nil
+	}
+}
+
+// WithToolInterrupt stops generation when the model requests a tool,
+// instead of running the tool. Generate returns the model's response,
+// whose [ModelResponse.ToolRequests] are the pending tool calls.
+// Use [ResumeWithToolResults] to supply their results and continue.
+func WithToolInterrupt() GenerateOption {
+	return func(req *generateParams) error {
+		if req.ToolInterrupt {
+			return errors.New("cannot set tool interrupt (WithToolInterrupt) more than once")
+		}
+		req.ToolInterrupt = true
+		return nil
+	}
+}
+
+// WithTimeout bounds the time that the Generate call may take, including
+// any tool calls and repairs, to d, by running it with a context that
+// has a timeout. When d passes, the call is cancelled and Generate returns
+// an error that matches [context.DeadlineExceeded]. A sooner deadline of
+// the context passed to Generate still applies.
+// This bounds a single call more finely than any timeout set for all
+// requests by the model's plugin, and plugins that see a deadline may
+// use it in place of their own timeout.
+func WithTimeout(d time.Duration) GenerateOption {
+	return func(req *generateParams) error {
+		if req.Timeout != 0 {
+			return errors.New("cannot set timeout (WithTimeout) more than once")
+		}
+		if d <= 0 {
+			return fmt.Errorf("WithTimeout: timeout must be positive, got %v", d)
+		}
+		req.Timeout = d
+		return nil
+	}
+}
+
+// A ModelFunc generates a response to a request, like [Model.Generate].
+type ModelFunc = func(context.Context, *ModelRequest, ModelStreamingCallback) (*ModelResponse, error)
+
+// A ModelMiddleware wraps a [ModelFunc], typically to rewrite the request
+// before calling next or the response after it returns.
+type ModelMiddleware func(next ModelFunc) ModelFunc
+
+// WithModelMiddleware wraps the call to the model in the given middleware.
+// Middleware is applied in order: the first one sees the request first
+// and the response last. Middleware sees the request before any tool
+// calls and the final response after them, and wraps repair turns
+// requested by [WithSchema] as well.
+func WithModelMiddleware(mw ...ModelMiddleware) GenerateOption {
+	return func(req *generateParams) error {
+		req.Middleware = append(req.Middleware, mw...)
+		return nil
+	}
+}
+
+// maxToolResultSizeKey holds the limit set by [WithMaxToolResultSize]
+// while the model's tool loop runs.
+var maxToolResultSizeKey = base.NewContextKey[int]()
+
+// toolInterruptKey is set by [WithToolInterrupt] while the model's
+// tool loop runs.
+var toolInterruptKey = base.NewContextKey[bool]()
+
 // Generate run generate request for this model. Returns ModelResponse struct.
 func Generate(ctx context.Context, m Model, opts ...GenerateOption) (*ModelResponse, error) {
 	req := &generateParams{
@@ -231,6 +439,15 @@ func Generate(ctx context.Context, m Model, opts ...GenerateOption) (*ModelRespo
 			return nil, err
 		}
 	}
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
+	}
+	req.Request.Config = mergeConfig(req.Request.Config, req.Config)
+	if def := DescribeModel(m).DefaultConfig; def != nil {
+		req.Request.Config = mergeConfig(def, req.Request.Config)
+	}
 	if req.History != nil {
 		prev := req.Request.Messages
 		req.Request.Messages = req.History
@@ -241,8 +458,458 @@ func Generate(ctx context.Context, m Model, opts ...GenerateOption) (*ModelRespo
 		req.Request.Messages = []*Message{req.SystemPrompt}
 		req.Request.Messages = append(req.Request.Messages, prev...)
 	}
+	if req.CitationsRequired && len(req.ContextDocuments) == 0 {
+		return nil, errors.New("WithCitationsRequired: no context documents (WithContextDocuments) to cite")
+	}
+	if len(req.ContextDocuments) > 0 {
+		req.Request.Messages = augmentWithContext(req.Request.Messages, req.ContextDocuments)
+	}
+	if req.AssistantPrefix != "" {
+		var msg *Message
+		if modelSupports(m, "prefill") {
+			msg = NewModelTextMessage(req.AssistantPrefix)
+		} else {
+			msg = NewUserTextMessage(fmt.Sprintf("Begin your response with exactly the following text, then continue it:\n%s", req.AssistantPrefix))
+		}
+		req.Request.Messages = append(req.Request.Messages, msg)
+	}
+	req.Request.Messages = mergeSystemMessages(req.Request.Messages)
+	if modelSupports(m, "alternatingRoles") {
+		req.Request.Messages = mergeConsecutiveMessages(req.Request.Messages)
+	}
+
+	if req.LatencyBudget != nil {
+		config, err := req.LatencyBudget.apply(ctx, req.Request.Config)
+		if err != nil {
+			return nil, err
+		}
+		req.Request.Config = config
+	}
+
+	if err := validateConfig(req.Request.Config); err != nil {
+		return nil, err
+	}
+	if req.Moderator != nil {
+		if err := moderateRequest(ctx, req.Moderator, req.Request); err != nil {
+			return nil, err
+		}
+	}
+	if req.MaxToolResultSize > 0 {
+		ctx = maxToolResultSizeKey.NewContext(ctx, req.MaxToolResultSize)
+	}
+	if req.ToolInterrupt {
+		ctx = toolInterruptKey.NewContext(ctx, true)
+	}
+	if req.ToolLoopLimit > 0 {
+		ctx = toolLoopLimitKey.NewContext(ctx, req.ToolLoopLimit)
+	}
+	if req.Cassette != "" {
+		c, err := loadCassette(req.Cassette)
+		if err != nil {
+			return nil, err
+		}
+		ctx = cassetteContextKey.NewContext(ctx, c)
+	}
+	if req.LogProbs != nil {
+		if !modelSupports(m, "logProbs") {
+			return nil, fmt.Errorf("model %s does not support log probabilities (WithLogProbs)", m.Name())
+		}
+		ctx = logProbsKey.NewContext(ctx, req.LogProbs)
+	}
+	var cacheScope string
+	var cacheEmbedding []float32
+	if req.SemanticCache != nil {
+		cached, scope, embedding, err := req.SemanticCache.lookup(ctx, m.Name(), req.Request)
+		if err != nil {
+			return nil, err
+		}
+		if cached != nil {
+			if req.Stream != nil && cached.Message != nil {
+				chunk := &ModelResponseChunk{Content: cached.Message.Content}
+				if err := req.Stream(ctx, chunk); err != nil {
+					return nil, err
+				}
+			}
+			if len(req.OutputParsers) > 0 {
+				return parseOutput(cached, req.OutputParsers)
+			}
+			return cached, nil
+		}
+		cacheScope, cacheEmbedding = scope, embedding
+	}
+	generate := m.Generate
+	for i := len(req.Middleware) - 1; i >= 0; i-- {
+		generate = req.Middleware[i](generate)
+	}
+	resp, err := generate(ctx, req.Request, req.Stream)
+	for i := 0; i < req.MaxRepairs; i++ {
+		var oerr *invalidOutputError
+		if !errors.As(err, &oerr) {
+			break
+		}
+		if cerr := ctx.Err(); cerr != nil {
+			err = cerr
+			break
+		}
+		resp, err = generate(ctx, repairRequest(oerr), nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.CitationsRequired && !citesContext(resp, req.ContextDocuments) {
+		resp, err = generate(ctx, citationRepairRequest(req.Request, resp, req.ContextDocuments), nil)
+		if err != nil {
+			return nil, err
+		}
+		if !citesContext(resp, req.ContextDocuments) {
+			return nil, &MissingCitationsError{Response: resp}
+		}
+	}
+	if req.Moderator != nil {
+		if err := moderateResponse(ctx, req.Moderator, resp); err != nil {
+			return nil, err
+		}
+	}
+	if req.SemanticCache != nil {
+		req.SemanticCache.store.Put(ctx, cacheScope, cacheEmbedding, resp)
+	}
+	if len(req.OutputParsers) > 0 {
+		return parseOutput(resp, req.OutputParsers)
+	}
+	return resp, nil
+}
+
+// mergeSystemMessages merges the system messages of msgs into one,
+// so that models that accept a single system instruction see all of them.
+// The merged message takes the place of the first system message, and its
+// content is the content of each system message in order, with a blank line
+// between consecutive text parts from different messages.
+// The system prompt of [WithSystemPrompt] is always first, followed by
+// any system messages from [WithMessages] or [WithHistory], such as those
+// of a rendered prompt.
+func mergeSystemMessages(msgs []*Message) []*Message {
+	first := -1
+	count := 0
+	for i, m := range msgs {
+		if m.Role == RoleSystem {
+			if first < 0 {
+				first = i
+			}
+			count++
+		}
+	}
+	if count < 2 {
+		return msgs
+	}
+	merged := &Message{Role: RoleSystem, Metadata: msgs[first].Metadata}
+	var out []*Message
+	for i, m := range msgs {
+		if m.Role != RoleSystem {
+			out = append(out, m)
+			continue
+		}
+		if i == first {
+			out = append(out, merged)
+		}
+		merged.Content = appendMessageContent(merged.Content, m.Content)
+	}
+	return out
+}
+
+// mergeConsecutiveMessages merges each run of consecutive messages with
+// the same role into one message, so that the roles of msgs alternate,
+// as some providers require. This happens, for example, when a prompt is
+// appended to a history that ends with a user message. The content of
+// the merged message is joined as by [mergeSystemMessages], and its
+// metadata is that of the first message of the run.
+func mergeConsecutiveMessages(msgs []*Message) []*Message {
+	var out []*Message
+	merging := false // whether the last message of out is a copy being merged into
+	for _, m := range msgs {
+		n := len(out)
+		if n == 0 || out[n-1].Role != m.Role {
+			out = append(out, m)
+			merging = false
+			continue
+		}
+		if !merging {
+			// Copy the message before changing it, leaving msgs unchanged.
+			merged := *out[n-1]
+			merged.Content = slices.Clone(merged.Content)
+			out[n-1] = &merged
+			merging = true
+		}
+		out[n-1].Content = appendMessageContent(out[n-1].Content, m.Content)
+	}
+	return out
+}
+
+// appendMessageContent appends the content of a message to content.
+// A text part that follows a text part is joined to it with a blank line.
+func appendMessageContent(content, parts []*Part) []*Part {
+	for j, p := range parts {
+		if j == 0 && len(content) > 0 {
+			if last := content[len(content)-1]; last.IsText() && p.IsText() {
+				content[len(content)-1] = NewTextPart(last.Text + "\n\n" + p.Text)
+				continue
+			}
+		}
+		content = append(content, p)
+	}
+	return content
+}
+
+// modelSupports reports whether m is a model defined with [DefineModel]
+// whose metadata declares the named capability.
+func modelSupports(m Model, capability string) bool {
+	supports, _ := modelInfo(m)["supports"].(map[string]bool)
+	return supports[capability]
+}
+
+// modelInfo returns the model metadata recorded by [DefineModel] for m,
+// or nil if m was not defined by DefineModel.
+func modelInfo(m Model) map[string]any {
+	a, ok := m.(*modelActionDef)
+	if !ok {
+		return nil
+	}
+	info, _ := (*modelAction)(a).Desc().Metadata["model"].(map[string]any)
+	return info
+}
+
+// DescribeModel returns the metadata of m, a model defined with
+// [DefineModel], as provided by the plugin that defined it. Callers can
+// use it to fit requests to the model, for example by trimming history
+// to the model's context window. Fields the plugin did not provide are
+// zero, and for other models all fields are zero.
+func DescribeModel(m Model) ModelMetadata {
+	info := modelInfo(m)
+	label, _ := info["label"].(string)
+	contextWindow, _ := info["contextWindow"].(int)
+	maxOutputTokens, _ := info["maxOutputTokens"].(int)
+	defaultConfig, _ := info["defaultConfig"].(*GenerationCommonConfig)
+	return ModelMetadata{
+		Label:           label,
+		Supports:        SupportedCapabilities(m),
+		ContextWindow:   contextWindow,
+		MaxOutputTokens: maxOutputTokens,
+		DefaultConfig:   defaultConfig,
+	}
+}
+
+// SupportedCapabilities returns the capabilities declared in the metadata of m,
+// a model defined with [DefineModel]. For other models it returns no capabilities.
+func SupportedCapabilities(m Model) ModelCapabilities {
+	return ModelCapabilities{
+		Multiturn:  modelSupports(m, "multiturn"),
+		Media:      modelSupports(m, "media"),
+		Tools:      modelSupports(m, "tools"),
+		SystemRole: modelSupports(m, "systemRole"),
+		Prefill:    modelSupports(m, "prefill"),
+		LogProbs:   modelSupports(m, "logProbs"),
+
+		AlternatingRoles: modelSupports(m, "alternatingRoles"),
+	}
+}
+
+// validateConfig reports an error if config is a [GenerationCommonConfig]
+// with out-of-range values. Other config types are left to the model.
+func validateConfig(config any) error {
+	var c *GenerationCommonConfig
+	switch config := config.(type) {
+	case *GenerationCommonConfig:
+		c = config
+	case GenerationCommonConfig:
+		c = &config
+	}
+	if c == nil {
+		return nil
+	}
+	switch {
+	case c.Temperature < 0:
+		return fmt.Errorf("invalid generation config: Temperature must not be negative, got %g", c.Temperature)
+	case c.TopP < 0 || c.TopP > 1:
+		return fmt.Errorf("invalid generation config: TopP must be between 0 and 1, got %g", c.TopP)
+	case c.TopK < 0:
+		return fmt.Errorf("invalid generation config: TopK must not be negative, got %d", c.TopK)
+	case c.MaxOutputTokens < 0:
+		return fmt.Errorf("invalid generation config: MaxOutputTokens must not be negative, got %d", c.MaxOutputTokens)
+	}
+	return nil
+}
+
+// mergeConfig returns the config whose fields are those set in override,
+// and the rest those of base, if both are a [GenerationCommonConfig] or
+// a pointer to one. Otherwise it returns override, or base if override
+// is nil. Neither config is modified.
+func mergeConfig(base, override any) any {
+	if override == nil {
+		return base
+	}
+	if base == nil {
+		return override
+	}
+	b, ok := commonConfig(base)
+	if !ok {
+		return override
+	}
+	o, ok := commonConfig(override)
+	if !ok {
+		return override
+	}
+	return MergeConfig(&b, &o)
+}
+
+// MergeConfig returns a config whose fields are those set (non-zero) in
+// override, and the rest those of base, as [WithConfig] merges configs.
+// Either may be nil; MergeConfig returns nil if both are.
+// Neither config is modified.
+func MergeConfig(base, override *GenerationCommonConfig) *GenerationCommonConfig {
+	if base == nil && override == nil {
+		return nil
+	}
+	var c GenerationCommonConfig
+	if base != nil {
+		c = *base
+	}
+	if override == nil {
+		return &c
+	}
+	if override.MaxOutputTokens != 0 {
+		c.MaxOutputTokens = override.MaxOutputTokens
+	}
+	if override.StopSequences != nil {
+		c.StopSequences = override.StopSequences
+	}
+	if override.Temperature != 0 {
+		c.Temperature = override.Temperature
+	}
+	if override.TopK != 0 {
+		c.TopK = override.TopK
+	}
+	if override.TopP != 0 {
+		c.TopP = override.TopP
+	}
+	if override.Version != "" {
+		c.Version = override.Version
+	}
+	return &c
+}
+
+// commonConfig returns a copy of config if it is a [GenerationCommonConfig]
+// or a non-nil pointer to one.
+func commonConfig(config any) (GenerationCommonConfig, bool) {
+	switch c := config.(type) {
+	case GenerationCommonConfig:
+		return c, true
+	case *GenerationCommonConfig:
+		if c != nil {
+			return *c, true
+		}
+	}
+	return GenerationCommonConfig{}, false
+}
 
-	return m.Generate(ctx, req.Request, req.Stream)
+// ResumeWithToolResults continues a generation that was stopped by
+// [WithToolInterrupt]. resp is the interrupted response, and results
+// holds the output of its tool requests, each with the Ref of the
+// [ToolRequest] it answers. The request of resp, followed by
+// its message and a tool message with the results, is sent to the model.
+// The request's config, tools and output settings are kept, so opts
+// should only add options such as [WithStreaming] or [WithToolInterrupt],
+// or [WithConfig] to override some fields of the config.
+func ResumeWithToolResults(ctx context.Context, m Model, resp *ModelResponse, results []*ToolResponse, opts ...GenerateOption) (*ModelResponse, error) {
+	if resp == nil || resp.Request == nil || resp.Message == nil {
+		return nil, errors.New("ResumeWithToolResults: response has no request or message")
+	}
+	if len(results) == 0 {
+		return nil, errors.New("ResumeWithToolResults: no tool results")
+	}
+	toolMsg := &Message{Role: RoleTool}
+	for _, r := range results {
+		toolMsg.Content = append(toolMsg.Content, NewToolResponsePart(r))
+	}
+	// Copy the ModelRequest rather than modifying it.
+	rreq := *resp.Request
+	rreq.Messages = append(slices.Clip(rreq.Messages), resp.Message, toolMsg)
+	resume := func(req *generateParams) error {
+		req.Request = &rreq
+		return nil
+	}
+	return Generate(ctx, m, append([]GenerateOption{resume}, opts...)...)
+}
+
+// GenerateAcross sends the same request, built from opts, to each of models
+// concurrently. It returns the responses in the same order as models.
+// If some models fail, their responses are nil and the returned error
+// joins their errors, each prefixed by the model's name; the responses
+// of the other models are still returned.
+// A callback set by [WithStreaming] may be called concurrently.
+func GenerateAcross(ctx context.Context, models []Model, opts ...GenerateOption) ([]*ModelResponse, error) {
+	resps := make([]*ModelResponse, len(models))
+	errs := make([]error, len(models))
+	var wg sync.WaitGroup
+	for i, m := range models {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := Generate(ctx, m, opts...)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", m.Name(), err)
+				return
+			}
+			resps[i] = resp
+		}()
+	}
+	wg.Wait()
+	return resps, errors.Join(errs...)
+}
+
+// GenerateStream runs [Generate] in the background, delivering the streamed
+// chunks of the response on the returned channel, which is closed when
+// generation finishes. The returned function waits for generation to
+// finish and returns its result; it may be called more than once.
+// The caller must receive from the channel until it is closed, or cancel ctx.
+// opts should not include [WithStreaming].
+func GenerateStream(ctx context.Context, m Model, opts ...GenerateOption) (<-chan *ModelResponseChunk, func() (*ModelResponse, error)) {
+	ch := make(chan *ModelResponseChunk)
+	done := make(chan struct{})
+	var resp *ModelResponse
+	var err error
+	stream := WithStreaming(func(ctx context.Context, chunk *ModelResponseChunk) error {
+		select {
+		case ch <- chunk:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	go func() {
+		defer close(done)
+		defer close(ch)
+		resp, err = Generate(ctx, m, append([]GenerateOption{stream}, opts...)...)
+	}()
+	return ch, func() (*ModelResponse, error) {
+		<-done
+		return resp, err
+	}
+}
+
+// GenerateToWriter runs [Generate], writing the text of each streamed
+// chunk of the response to w as it arrives, and returns the complete
+// response. It is convenient for command-line tools that print the
+// response as it is generated:
+//
+//	resp, err := ai.GenerateToWriter(ctx, m, os.Stdout, ai.WithTextPrompt(prompt))
+//
+// Generation stops with an error if writing to w fails.
+// opts should not include [WithStreaming].
+func GenerateToWriter(ctx context.Context, m Model, w io.Writer, opts ...GenerateOption) (*ModelResponse, error) {
+	stream := WithStreaming(func(_ context.Context, chunk *ModelResponseChunk) error {
+		_, err := io.WriteString(w, chunk.Text())
+		return err
+	})
+	return Generate(ctx, m, append([]GenerateOption{stream}, opts...)...)
 }
 
 // GenerateText run generate request for this model. Returns generated text only.
@@ -256,9 +923,14 @@ func GenerateText(ctx context.Context, m Model, opts ...GenerateOption) (string,
 }
 
 // Generate run generate request for this model. Returns ModelResponse struct.
+// The model's output is repaired with [RepairJSON] before being validated
+// against the schema of value. If that fails, the model is asked once to
+// correct its output.
+// If a custom output format is requested with [WithOutputFormat], the output
+// is parsed with the format's parser instead.
 // TODO: Stream GenerateData with partial JSON
 func GenerateData(ctx context.Context, m Model, value any, opts ...GenerateOption) (*ModelResponse, error) {
-	opts = append(opts, WithOutputSchema(value))
+	opts = append(opts, WithSchema(value, 1))
 	resp, err := Generate(ctx, m, opts...)
 	if err != nil {
 		return nil, err
@@ -275,28 +947,59 @@ func (m *modelActionDef) Generate(ctx context.Context, req *ModelRequest, cb Mod
 	if m == nil {
 		return nil, errors.New("Generate called on a nil Model; check that all models are defined")
 	}
-	if err := conformOutput(req); err != nil {
+	if err := conformOutput(req, modelSupports(m, "prefill")); err != nil {
 		return nil, err
 	}
+	if base.DryRunKey.FromContext(ctx) {
+		return dryRunResponse(req)
+	}
 
 	a := (*core.Action[*ModelRequest, *ModelResponse, *ModelResponseChunk])(m)
+	loops := newToolLoopDetector(toolLoopLimitKey.FromContext(ctx))
 	for {
-		resp, err := a.Run(ctx, req, cb)
+		// Stop if the context was cancelled while a tool was running,
+		// rather than making another request to the model.
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var resp *ModelResponse
+		var err error
+		if c := cassetteContextKey.FromContext(ctx); c != nil {
+			resp, err = c.run(ctx, m.Name(), req, cb, a.Run)
+		} else {
+			resp, err = a.Run(ctx, req, cb)
+		}
 		if err != nil {
 			return nil, err
 		}
+		if isEmptyResponse(resp) {
+			return nil, &EmptyResponseError{
+				FinishReason:  resp.FinishReason,
+				FinishMessage: resp.FinishMessage,
+				Response:      resp,
+			}
+		}
 
 		msg, err := validResponse(ctx, resp)
 		if err != nil {
-			return nil, err
+			return nil, &invalidOutputError{request: req, response: resp, err: err}
 		}
 		resp.Message = msg
 
-		newReq, err := handleToolRequest(ctx, req, resp)
+		if toolInterruptKey.FromContext(ctx) && len(resp.ToolRequests()) > 0 {
+			recordCost(m.Name(), resp)
+			return resp, nil
+		}
+
+		if err := loops.observe(resp); err != nil {
+			return nil, err
+		}
+		newReq, err := handleToolRequest(ctx, req, resp, cb)
 		if err != nil {
 			return nil, err
 		}
 		if newReq == nil {
+			recordCost(m.Name(), resp)
 			return resp, nil
 		}
 
@@ -306,21 +1009,142 @@ func (m *modelActionDef) Generate(ctx context.Context, req *ModelRequest, cb Mod
 
 func (i *modelActionDef) Name() string { return (*modelAction)(i).Name() }
 
+// dryRunResponse returns a synthetic response describing req,
+// for use in place of calling the model in dry-run mode.
+func dryRunResponse(req *ModelRequest) (*ModelResponse, error) {
+	desc := struct {
+		Messages []*Message        `json:"messages"`
+		Config   any               `json:"config,omitempty"`
+		Tools    []*ToolDefinition `json:"tools,omitempty"`
+	}{req.Messages, req.Config, req.Tools}
+	bytes, err := json.MarshalIndent(desc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("dry run: %w", err)
+	}
+	return &ModelResponse{
+		Message:      NewModelTextMessage(string(bytes)),
+		FinishReason: FinishReasonStop,
+		Request:      req,
+		Custom:       map[string]any{"dryRun": true},
+	}, nil
+}
+
 // conformOutput appends a message to the request indicating conformance to the expected schema.
-func conformOutput(req *ModelRequest) error {
-	if req.Output != nil && req.Output.Format == OutputFormatJSON && len(req.Messages) > 0 {
+// If prefill is true, a final model message is a prefix for the model to
+// continue (see [WithAssistantPrefix]), so the instructions are added to
+// the last user message instead.
+func conformOutput(req *ModelRequest, prefill bool) error {
+	if req.Output == nil || len(req.Messages) == 0 {
+		return nil
+	}
+	if f := lookupFormat(req.Output.Format); f != nil {
+		if f.Instructions != nil {
+			appendInstructions(req, NewTextPart(f.Instructions(req.Output.Schema)), prefill)
+		}
+		return nil
+	}
+	if req.Output.Format == OutputFormatJSON {
 		jsonBytes, err := json.Marshal(req.Output.Schema)
 		if err != nil {
 			return fmt.Errorf("expected schema is not valid: %w", err)
 		}
 
 		escapedJSON := strconv.Quote(string(jsonBytes))
-		part := NewTextPart(fmt.Sprintf("Output should be in JSON format and conform to the following schema:\n\n```%s```", escapedJSON))
-		req.Messages[len(req.Messages)-1].Content = append(req.Messages[len(req.Messages)-1].Content, part)
+		appendInstructions(req, NewTextPart(fmt.Sprintf("Output should be in JSON format and conform to the following schema:\n\n```%s```", escapedJSON)), prefill)
 	}
 	return nil
 }
 
+// appendInstructions appends part to the content of the last message of req,
+// or, if prefill is true and the last message is a model message, to the
+// last user message before it.
+func appendInstructions(req *ModelRequest, part *Part, prefill bool) {
+	i := len(req.Messages) - 1
+	if prefill && req.Messages[i].Role == RoleModel {
+		for j := i - 1; j >= 0; j-- {
+			if req.Messages[j].Role == RoleUser {
+				i = j
+				break
+			}
+		}
+	}
+	// Copy the message rather than modifying it,
+	// as it may be shared with other requests.
+	msg := *req.Messages[i]
+	msg.Content = append(slices.Clip(msg.Content), part)
+	req.Messages = slices.Clone(req.Messages)
+	req.Messages[i] = &msg
+}
+
+// ErrEmptyResponse matches, with [errors.Is], the error returned when a
+// model's response has no content.
+var ErrEmptyResponse = errors.New("model returned an empty response")
+
+// An EmptyResponseError is returned by a model's Generate method, and so by
+// [Generate], when the response has no message or its message has no content,
+// for example because all of it was filtered by the provider.
+// It matches [ErrEmptyResponse].
+type EmptyResponseError struct {
+	FinishReason  FinishReason
+	FinishMessage string
+	Response      *ModelResponse // the empty response
+}
+
+func (e *EmptyResponseError) Error() string {
+	msg := fmt.Sprintf("%v (finish reason %q)", ErrEmptyResponse, e.FinishReason)
+	if e.FinishMessage != "" {
+		msg += ": " + e.FinishMessage
+	}
+	return msg
+}
+
+func (e *EmptyResponseError) Is(target error) bool { return target == ErrEmptyResponse }
+
+// isEmptyResponse reports whether resp has no message content,
+// counting empty text parts as no content.
+func isEmptyResponse(resp *ModelResponse) bool {
+	if resp.Message == nil {
+		return true
+	}
+	for _, p := range resp.Message.Content {
+		if !p.IsText() || p.Text != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidOutputError is returned by a model's Generate method
+// when the response does not match the expected schema.
+type invalidOutputError struct {
+	request  *ModelRequest  // the request that produced the response
+	response *ModelResponse // the invalid response
+	err      error          // why the response is invalid
+}
+
+func (e *invalidOutputError) Error() string {
+	return "generation did not result in a message matching expected schema"
+}
+
+func (e *invalidOutputError) Unwrap() error { return e.err }
+
+// repairRequest returns a request that asks the model to correct
+// the invalid output described by oerr.
+func repairRequest(oerr *invalidOutputError) *ModelRequest {
+	rreq := *oerr.request
+	rreq.Messages = slices.Clip(rreq.Messages)
+	if oerr.response.Message != nil {
+		rreq.Messages = append(rreq.Messages, oerr.response.Message)
+	}
+	format := "JSON"
+	if o := rreq.Output; o != nil && lookupFormat(o.Format) != nil {
+		format = string(o.Format)
+	}
+	rreq.Messages = append(rreq.Messages, NewUserTextMessage(fmt.Sprintf(
+		"Your previous response was not valid: %v\nRespond again with only the corrected %s.", oerr.err, format)))
+	return &rreq
+}
+
 // validResponse check the message matches the expected schema.
 // It will strip JSON markdown delimiters from the response.
 func validResponse(ctx context.Context, resp *ModelResponse) (*Message, error) {
@@ -329,14 +1153,20 @@ func validResponse(ctx context.Context, resp *ModelResponse) (*Message, error) {
 		return msg, nil
 	} else {
 		logger.FromContext(ctx).Debug("message did not match expected schema", "error", err.Error())
-		return nil, errors.New("generation did not result in a message matching expected schema")
+		return nil, err
 	}
 }
 
 // validMessage will validate the message against the expected schema.
+// Output in a custom [Format] is parsed, validated and converted to JSON.
 // It will return an error if it does not match, otherwise it will return a message with JSON content and type.
+// Common formatting mistakes in the JSON are repaired with [RepairJSON].
 func validMessage(m *Message, output *ModelRequestOutput) (*Message, error) {
-	if output != nil && output.Format == OutputFormatJSON {
+	if output == nil {
+		return m, nil
+	}
+	f := lookupFormat(output.Format)
+	if f != nil || output.Format == OutputFormatJSON {
 		if m == nil {
 			return nil, errors.New("message is empty")
 		}
@@ -344,13 +1174,14 @@ func validMessage(m *Message, output *ModelRequestOutput) (*Message, error) {
 			return nil, errors.New("message has no content")
 		}
 
-		text := base.ExtractJSONFromMarkdown(m.Text())
-		var schemaBytes []byte
-		schemaBytes, err := json.Marshal(output.Schema)
-		if err != nil {
-			return nil, fmt.Errorf("expected schema is not valid: %w", err)
+		var text string
+		var err error
+		if f != nil {
+			text, err = parseFormatted(f, m.Text(), output.Schema)
+		} else {
+			text, err = RepairJSON(m.Text(), output.Schema)
 		}
-		if err = base.ValidateRaw([]byte(text), schemaBytes); err != nil {
+		if err != nil {
 			return nil, err
 		}
 		// TODO: Verify that it okay to replace all content with JSON.
@@ -359,46 +1190,93 @@ func validMessage(m *Message, output *ModelRequestOutput) (*Message, error) {
 	return m, nil
 }
 
-// handleToolRequest checks if a tool was requested by a model.
-// If a tool was requested, this runs the tool and returns an
-// updated ModelRequest. If no tool was requested this returns nil.
-func handleToolRequest(ctx context.Context, req *ModelRequest, resp *ModelResponse) (*ModelRequest, error) {
-	msg := resp.Message
-	if msg == nil || len(msg.Content) == 0 {
+// handleToolRequest checks if tools were requested by a model.
+// If so, this runs each requested tool and returns an updated
+// ModelRequest, whose last message holds a [ToolResponse] for each
+// [ToolRequest] with the same Ref. If no tool was requested this returns nil.
+// If cb is non-nil, partial results streamed by the tools are passed
+// to it as chunks holding a [*ToolChunk].
+func handleToolRequest(ctx context.Context, req *ModelRequest, resp *ModelResponse, cb ModelStreamingCallback) (*ModelRequest, error) {
+	toolReqs := resp.ToolRequests()
+	if len(toolReqs) == 0 {
 		return nil, nil
 	}
-	part := msg.Content[0]
-	if !part.IsToolRequest() {
-		return nil, nil
+
+	toolResp := &Message{Role: RoleTool}
+	for _, toolReq := range toolReqs {
+		tr, err := runToolRequest(ctx, toolReq, cb)
+		if err != nil {
+			return nil, err
+		}
+		toolResp.Content = append(toolResp.Content, NewToolResponsePart(tr))
 	}
 
-	toolReq := part.ToolRequest
-	tool := LookupTool(toolReq.Name)
+	// Copy the ModelRequest rather than modifying it.
+	rreq := *req
+	rreq.Messages = append(slices.Clip(rreq.Messages), resp.Message, toolResp)
+
+	return &rreq, nil
+}
+
+// runToolRequest runs the tool requested by toolReq and returns its response.
+func runToolRequest(ctx context.Context, toolReq *ToolRequest, cb ModelStreamingCallback) (*ToolResponse, error) {
+	tool := lookupTool(ctx, toolReq.Name)
 	if tool == nil {
 		return nil, fmt.Errorf("tool %v not found", toolReq.Name)
 	}
-	to, err := tool.RunRaw(ctx, toolReq.Input)
+	mo := &modelOutput{}
+	var toolCB func(context.Context, json.RawMessage) error
+	if cb != nil {
+		toolCB = func(ctx context.Context, partial json.RawMessage) error {
+			var v any
+			if err := json.Unmarshal(partial, &v); err != nil {
+				return err
+			}
+			return cb(ctx, &ModelResponseChunk{Custom: &ToolChunk{Name: toolReq.Name, Output: v}})
+		}
+	}
+	to, err := runAction(modelOutputKey.NewContext(ctx, mo), tool, toolReq.Input, toolCB)
 	if err != nil {
 		return nil, err
 	}
-
-	toolResp := &Message{
-		Content: []*Part{
-			NewToolResponsePart(&ToolResponse{
-				Name: toolReq.Name,
-				Output: map[string]any{
-					"response": to,
-				},
-			}),
-		},
-		Role: RoleTool,
+	if mo.data != nil {
+		var v any
+		if err := json.Unmarshal(mo.data, &v); err != nil {
+			return nil, fmt.Errorf("tool %v: MarshalForModel returned invalid JSON: %w", toolReq.Name, err)
+		}
+		to = v
 	}
+	if max := maxToolResultSizeKey.FromContext(ctx); max > 0 {
+		if to, err = truncateToolResult(to, max); err != nil {
+			return nil, fmt.Errorf("tool %v: %w", toolReq.Name, err)
+		}
+	}
+	return &ToolResponse{
+		Name: toolReq.Name,
+		Ref:  toolReq.Ref,
+		Output: map[string]any{
+			"response": to,
+		},
+	}, nil
+}
 
-	// Copy the ModelRequest rather than modifying it.
-	rreq := *req
-	rreq.Messages = append(slices.Clip(rreq.Messages), msg, toolResp)
-
-	return &rreq, nil
+// truncateToolResult returns the tool result v unchanged if its JSON
+// encoding is at most max bytes long. Otherwise it returns the first
+// max bytes of the encoding followed by a truncation marker.
+func truncateToolResult(v any, max int) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) <= max {
+		return v, nil
+	}
+	// Don't split a UTF-8 sequence.
+	n := max
+	for n > 0 && !utf8.RuneStart(b[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s...[truncated %d bytes]", b[:n], len(b)-n), nil
 }
 
 // Text returns the contents of the first candidate in a
@@ -420,6 +1298,69 @@ func (gr *ModelResponse) History() []*Message {
 	return append(gr.Request.Messages, gr.Message)
 }
 
+// ToolRequests returns the tool requests in the response message,
+// in the order they appear.
+func (gr *ModelResponse) ToolRequests() []*ToolRequest {
+	if gr.Message == nil {
+		return nil
+	}
+	var trs []*ToolRequest
+	for _, p := range gr.Message.Content {
+		if p.IsToolRequest() {
+			trs = append(trs, p.ToolRequest)
+		}
+	}
+	return trs
+}
+
+// Citations returns the citations in the response message,
+// in the order they appear.
+func (gr *ModelResponse) Citations() []*Citation {
+	if gr.Message == nil {
+		return nil
+	}
+	var cs []*Citation
+	for _, p := range gr.Message.Content {
+		if p.IsCitation() {
+			cs = append(cs, p.Citation)
+		}
+	}
+	return cs
+}
+
+// AddCitations appends a citation part to the response message
+// for each of the given document IDs.
+func (gr *ModelResponse) AddCitations(docIDs ...string) {
+	if gr.Message == nil {
+		gr.Message = &Message{Role: RoleModel}
+	}
+	for _, id := range docIDs {
+		gr.Message.Content = append(gr.Message.Content, NewCitationPart(&Citation{DocumentID: id}))
+	}
+}
+
+// CitedDocuments returns the documents in the request context, such as
+// those passed with [WithContextDocuments], that are cited by the
+// response, in the order they are cited.
+func (gr *ModelResponse) CitedDocuments() []*Document {
+	if gr.Request == nil {
+		return nil
+	}
+	byID := map[string]*Document{}
+	for _, c := range gr.Request.Context {
+		if d, ok := c.(*Document); ok && d.ID != "" {
+			byID[d.ID] = d
+		}
+	}
+	var docs []*Document
+	for _, c := range gr.Citations() {
+		if d := byID[c.DocumentID]; d != nil && !slices.Contains(docs, d) {
+			docs = append(docs, d)
+		}
+	}
+	return docs
+}
+
 // UnmarshalOutput unmarshals structured JSON output into the provided
 // struct pointer.
 func (gr *ModelResponse) UnmarshalOutput(v any) error {
@@ -448,6 +1389,43 @@ func (c *ModelResponseChunk) Text() string {
 	return sb.String()
 }
 
+// MergeChunks returns the model message formed by the content of chunks,
+// such as those passed to a streaming callback. The chunks are merged in
+// order of their Index, and a chunk with the same Index as another is
+// taken to be a duplicate and skipped. If no chunk has an Index, the
+// chunks are merged in the order given. A chunk that is Aggregated
+// replaces the content of the chunks before it. Consecutive text parts
+// with the same content type are joined into one.
+// The chunks are not modified.
+func MergeChunks(chunks []*ModelResponseChunk) *Message {
+	sorted := slices.Clone(chunks)
+	slices.SortStableFunc(sorted, func(a, b *ModelResponseChunk) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+	indexed := slices.ContainsFunc(sorted, func(c *ModelResponseChunk) bool { return c.Index != 0 })
+	msg := &Message{Role: RoleModel}
+	for i, c := range sorted {
+		if indexed && i > 0 && c.Index == sorted[i-1].Index {
+			continue
+		}
+		if c.Aggregated {
+			msg.Content = nil
+		}
+		for _, p := range c.Content {
+			n := len(msg.Content)
+			if p.IsText() && n > 0 && msg.Content[n-1].IsText() && msg.Content[n-1].ContentType == p.ContentType {
+				// Copy the part rather than modifying the chunk's.
+				last := *msg.Content[n-1]
+				last.Text += p.Text
+				msg.Content[n-1] = &last
+				continue
+			}
+			msg.Content = append(msg.Content, p)
+		}
+	}
+	return msg
+}
+
 // Text returns the contents of a [Message] as a string. It
 // returns an empty string if the message has no content.
 func (m *Message) Text() string {
@@ -15,10 +15,13 @@
 package ai
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"math"
 	"strings"
 	"testing"
+	"time"
 
 	test_utils "github.com/firebase/genkit/go/tests/utils"
 	"github.com/google/go-cmp/cmp"
@@ -326,6 +329,725 @@ func TestGenerate(t *testing.T) {
 	})
 }
 
+func TestGenerateModeration(t *testing.T) {
+	banned := ModeratorFunc(func(_ context.Context, text string) error {
+		if strings.Contains(text, "banana") {
+			return errors.New("bananas are not allowed")
+		}
+		return nil
+	})
+	bananaModel := DefineModel("test", "banana", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("have a banana")}, nil
+	})
+
+	t.Run("blocks response", func(t *testing.T) {
+		_, err := Generate(context.Background(), bananaModel,
+			WithTextPrompt("what fruit should I eat?"),
+			WithModeration(banned))
+		var merr *ModerationError
+		if !errors.As(err, &merr) {
+			t.Fatalf("got error %v, want *ModerationError", err)
+		}
+		if merr.Stage != "response" {
+			t.Errorf("got stage %q, want %q", merr.Stage, "response")
+		}
+	})
+	t.Run("blocks request", func(t *testing.T) {
+		_, err := Generate(context.Background(), echoModel,
+			WithTextPrompt("banana"),
+			WithModeration(banned))
+		var merr *ModerationError
+		if !errors.As(err, &merr) {
+			t.Fatalf("got error %v, want *ModerationError", err)
+		}
+		if merr.Stage != "request" {
+			t.Errorf("got stage %q, want %q", merr.Stage, "request")
+		}
+	})
+	t.Run("allows", func(t *testing.T) {
+		res, err := Generate(context.Background(), echoModel,
+			WithTextPrompt("apple"),
+			WithModeration(banned))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Text(), "apple"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestGenerateModelMiddleware(t *testing.T) {
+	var order []string
+	redact := func(next ModelFunc) ModelFunc {
+		return func(ctx context.Context, req *ModelRequest, cb ModelStreamingCallback) (*ModelResponse, error) {
+			order = append(order, "redact")
+			for _, m := range req.Messages {
+				for _, p := range m.Content {
+					p.Text = strings.ReplaceAll(p.Text, "555-1234", "[redacted]")
+				}
+			}
+			return next(ctx, req, cb)
+		}
+	}
+	disclaim := func(next ModelFunc) ModelFunc {
+		return func(ctx context.Context, req *ModelRequest, cb ModelStreamingCallback) (*ModelResponse, error) {
+			order = append(order, "disclaim")
+			resp, err := next(ctx, req, cb)
+			if err != nil {
+				return nil, err
+			}
+			resp.Message.Content = append(resp.Message.Content, NewTextPart(" (AI-generated)"))
+			return resp, nil
+		}
+	}
+
+	resp, err := Generate(context.Background(), echoModel,
+		WithTextPrompt("call me at 555-1234"),
+		WithModelMiddleware(redact, disclaim))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, w := resp.Text(), "call me at [redacted] (AI-generated)"; g != w {
+		t.Errorf("got %q, want %q", g, w)
+	}
+	if diff := cmp.Diff([]string{"redact", "disclaim"}, order); diff != "" {
+		t.Errorf("middleware order mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestGenerateMaxToolResultSize(t *testing.T) {
+	bigTool := DefineTool("bigResult", "returns a large result",
+		func(ctx context.Context, input struct{ N int }) (string, error) {
+			return strings.Repeat("x", input.N), nil
+		},
+	)
+	var toolOutput any
+	m := DefineModel("test", "bigToolCaller", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == RoleTool {
+			toolOutput = last.Content[0].ToolResponse.Output["response"]
+			return &ModelResponse{Request: req, Message: NewModelTextMessage("done")}, nil
+		}
+		return &ModelResponse{
+			Request: req,
+			Message: &Message{
+				Role: RoleModel,
+				Content: []*Part{NewToolRequestPart(&ToolRequest{
+					Name:  "bigResult",
+					Input: map[string]any{"N": 1000},
+				})},
+			},
+		}, nil
+	})
+
+	_, err := Generate(context.Background(), m,
+		WithTextPrompt("call the tool"),
+		WithTools(bigTool),
+		WithMaxToolResultSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := toolOutput.(string)
+	if !ok {
+		t.Fatalf("tool output is %T, want string", toolOutput)
+	}
+	// The JSON encoding of the result is 1002 bytes: 1000 x's and two quotes.
+	want := `"` + strings.Repeat("x", 99) + "...[truncated 902 bytes]"
+	if got != want {
+		t.Errorf("got tool output\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGenerateToolInterrupt(t *testing.T) {
+	toolRan := false
+	approvalTool := DefineTool("needsApproval", "does something that needs approval",
+		func(ctx context.Context, input struct{ N int }) (int, error) {
+			toolRan = true
+			return input.N * 2, nil
+		},
+	)
+	var lastReq *ModelRequest
+	m := DefineModel("test", "interruptCaller", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		lastReq = req
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == RoleTool {
+			return &ModelResponse{Request: req, Message: NewModelTextMessage("done")}, nil
+		}
+		return &ModelResponse{
+			Request: req,
+			Message: &Message{
+				Role: RoleModel,
+				Content: []*Part{NewToolRequestPart(&ToolRequest{
+					Name:  "needsApproval",
+					Input: map[string]any{"N": 21},
+				})},
+			},
+		}, nil
+	})
+
+	resp, err := Generate(context.Background(), m,
+		WithTextPrompt("call the tool"),
+		WithTools(approvalTool),
+		WithToolInterrupt())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toolRan {
+		t.Error("tool ran despite WithToolInterrupt")
+	}
+	wantReqs := []*ToolRequest{{Name: "needsApproval", Input: map[string]any{"N": 21}}}
+	if diff := cmp.Diff(wantReqs, resp.ToolRequests()); diff != "" {
+		t.Fatalf("tool requests mismatch (-want +got):\n%s", diff)
+	}
+
+	results := []*ToolResponse{{Name: "needsApproval", Output: map[string]any{"response": 42}}}
+	resp, err = ResumeWithToolResults(context.Background(), m, resp, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if toolRan {
+		t.Error("tool ran on resume")
+	}
+	if got := resp.Text(); got != "done" {
+		t.Errorf("got text %q, want %q", got, "done")
+	}
+	wantRoles := []Role{RoleUser, RoleModel, RoleTool}
+	var gotRoles []Role
+	for _, msg := range lastReq.Messages {
+		gotRoles = append(gotRoles, msg.Role)
+	}
+	if diff := cmp.Diff(wantRoles, gotRoles); diff != "" {
+		t.Errorf("resumed request roles mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(results[0], lastReq.Messages[2].Content[0].ToolResponse); diff != "" {
+		t.Errorf("tool response mismatch (-want +got):\n%s", diff)
+	}
+	if len(lastReq.Tools) != 1 {
+		t.Errorf("resumed request has %d tools, want 1", len(lastReq.Tools))
+	}
+}
+
+func TestGenerateParallelToolCalls(t *testing.T) {
+	square := DefineTool("square", "squares a number",
+		func(ctx context.Context, input struct{ N int }) (int, error) {
+			return input.N * input.N, nil
+		},
+	)
+	negate := DefineTool("negate", "negates a number",
+		func(ctx context.Context, input struct{ N int }) (int, error) {
+			return -input.N, nil
+		},
+	)
+	var toolMsg *Message
+	m := DefineModel("test", "parallelCaller", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == RoleTool {
+			toolMsg = last
+			return &ModelResponse{Request: req, Message: NewModelTextMessage("done")}, nil
+		}
+		// Call the same tool twice and another tool once, in one turn.
+		return &ModelResponse{
+			Request: req,
+			Message: &Message{
+				Role: RoleModel,
+				Content: []*Part{
+					NewToolRequestPart(&ToolRequest{Ref: "call_1", Name: "square", Input: map[string]any{"N": 3}}),
+					NewToolRequestPart(&ToolRequest{Ref: "call_2", Name: "negate", Input: map[string]any{"N": 3}}),
+					NewToolRequestPart(&ToolRequest{Ref: "call_3", Name: "square", Input: map[string]any{"N": 4}}),
+				},
+			},
+		}, nil
+	})
+
+	if _, err := Generate(context.Background(), m, WithTextPrompt("go"), WithTools(square, negate)); err != nil {
+		t.Fatal(err)
+	}
+	if toolMsg == nil {
+		t.Fatal("model was not sent the tool results")
+	}
+	got := map[string]*ToolResponse{}
+	for _, p := range toolMsg.Content {
+		got[p.ToolResponse.Ref] = p.ToolResponse
+	}
+	want := map[string]*ToolResponse{
+		"call_1": {Ref: "call_1", Name: "square", Output: map[string]any{"response": 9.0}},
+		"call_2": {Ref: "call_2", Name: "negate", Output: map[string]any{"response": -3.0}},
+		"call_3": {Ref: "call_3", Name: "square", Output: map[string]any{"response": 16.0}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("tool responses mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestGenerateConfigValidation(t *testing.T) {
+	calls := 0
+	m := DefineModel("test", "configValidation", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		calls++
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("ok")}, nil
+	})
+	tests := []struct {
+		name   string
+		config any
+		want   string
+	}{
+		{"negative temperature", &GenerationCommonConfig{Temperature: -0.5}, "Temperature must not be negative, got -0.5"},
+		{"TopP above 1", &GenerationCommonConfig{TopP: 1.5}, "TopP must be between 0 and 1, got 1.5"},
+		{"negative TopK", GenerationCommonConfig{TopK: -1}, "TopK must not be negative, got -1"},
+		{"negative MaxOutputTokens", &GenerationCommonConfig{MaxOutputTokens: -3}, "MaxOutputTokens must not be negative, got -3"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Generate(context.Background(), m, WithTextPrompt("hi"), WithConfig(test.config))
+			errorContains(t, err, test.want)
+		})
+	}
+	if calls != 0 {
+		t.Errorf("model called %d times with invalid config, want 0", calls)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		_, err := Generate(context.Background(), m, WithTextPrompt("hi"),
+			WithConfig(&GenerationCommonConfig{Temperature: 1.5, TopP: 1, TopK: 40, MaxOutputTokens: 3}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestGenerateConfigMerge(t *testing.T) {
+	var got any
+	capture := func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		got = req.Config
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("ok")}, nil
+	}
+	m := DefineModel("test", "defaultConfig", &ModelMetadata{
+		DefaultConfig: &GenerationCommonConfig{Temperature: 0.2, TopK: 10},
+	}, capture)
+
+	for _, test := range []struct {
+		name   string
+		config any
+		want   any
+	}{
+		{"none", nil, &GenerationCommonConfig{Temperature: 0.2, TopK: 10}},
+		{
+			"max tokens only",
+			&GenerationCommonConfig{MaxOutputTokens: 100},
+			&GenerationCommonConfig{Temperature: 0.2, TopK: 10, MaxOutputTokens: 100},
+		},
+		{
+			"override temperature",
+			GenerationCommonConfig{Temperature: 0.9},
+			&GenerationCommonConfig{Temperature: 0.9, TopK: 10},
+		},
+		{"other type", map[string]any{"seed": 1}, map[string]any{"seed": 1}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opts := []GenerateOption{WithTextPrompt("hi")}
+			if test.config != nil {
+				opts = append(opts, WithConfig(test.config))
+			}
+			if _, err := Generate(context.Background(), m, opts...); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("config mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("resumed request", func(t *testing.T) {
+		prev := &ModelResponse{
+			Request: &ModelRequest{
+				Messages: []*Message{NewUserTextMessage("hi")},
+				Config:   &GenerationCommonConfig{Temperature: 0.5, TopP: 0.8},
+			},
+			Message: &Message{Role: RoleModel, Content: []*Part{NewToolRequestPart(&ToolRequest{Name: "t"})}},
+		}
+		results := []*ToolResponse{{Name: "t", Output: map[string]any{"response": 1}}}
+		if _, err := ResumeWithToolResults(context.Background(), m, prev, results,
+			WithConfig(&GenerationCommonConfig{MaxOutputTokens: 50})); err != nil {
+			t.Fatal(err)
+		}
+		want := &GenerationCommonConfig{Temperature: 0.5, TopK: 10, TopP: 0.8, MaxOutputTokens: 50}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("config mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("twice", func(t *testing.T) {
+		_, err := Generate(context.Background(), m, WithConfig(&GenerationCommonConfig{}), WithConfig(&GenerationCommonConfig{}))
+		errorContains(t, err, "more than once")
+	})
+}
+
+func TestGenerateAssistantPrefix(t *testing.T) {
+	echo := func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("ok")}, nil
+	}
+	t.Run("prefill", func(t *testing.T) {
+		m := DefineModel("test", "prefill", &ModelMetadata{Supports: ModelCapabilities{Prefill: true}}, echo)
+		resp, err := Generate(context.Background(), m, WithTextPrompt("Name a color."), WithAssistantPrefix("My favorite color is"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []*Message{
+			NewUserTextMessage("Name a color."),
+			NewModelTextMessage("My favorite color is"),
+		}
+		if diff := cmp.Diff(want, resp.Request.Messages); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+	t.Run("instruction", func(t *testing.T) {
+		m := DefineModel("test", "noPrefill", nil, echo)
+		resp, err := Generate(context.Background(), m, WithTextPrompt("Name a color."), WithAssistantPrefix("My favorite color is"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs := resp.Request.Messages
+		last := msgs[len(msgs)-1]
+		if last.Role != RoleUser || !strings.Contains(last.Text(), "My favorite color is") {
+			t.Errorf("got final message %+v, want user instruction containing the prefix", last)
+		}
+	})
+	t.Run("prefill with output schema", func(t *testing.T) {
+		m := DefineModel("test", "prefillSchema", &ModelMetadata{Supports: ModelCapabilities{Prefill: true}},
+			func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+				return &ModelResponse{Request: req, Message: NewModelTextMessage(`{"color": "blue"}`)}, nil
+			})
+		resp, err := Generate(context.Background(), m,
+			WithTextPrompt("Name a color."),
+			WithAssistantPrefix(`{"color": "`),
+			WithOutputSchema(struct {
+				Color string `json:"color"`
+			}{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs := resp.Request.Messages
+		if len(msgs) != 2 {
+			t.Fatalf("got %d messages, want 2", len(msgs))
+		}
+		// The schema instructions belong to the user's turn, and the
+		// prefill message must be exactly the prefix.
+		if user := msgs[0]; user.Role != RoleUser || !strings.Contains(user.Text(), "schema") {
+			t.Errorf("got first message %+v, want user message with the schema instructions", user)
+		}
+		if diff := cmp.Diff(NewModelTextMessage(`{"color": "`), msgs[1]); diff != "" {
+			t.Errorf("prefill mismatch (-want, +got):\n%s", diff)
+		}
+	})
+	t.Run("twice", func(t *testing.T) {
+		m := DefineModel("test", "prefillTwice", nil, echo)
+		_, err := Generate(context.Background(), m, WithAssistantPrefix("a"), WithAssistantPrefix("b"))
+		errorContains(t, err, "more than once")
+	})
+}
+
+func TestGenerateMergesSystemMessages(t *testing.T) {
+	m := DefineModel("test", "systemEcho", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("ok")}, nil
+	})
+	resp, err := Generate(context.Background(), m,
+		WithSystemPrompt("You are a caller-configured assistant."),
+		WithMessages(
+			NewSystemTextMessage("You are a prompt-configured assistant."),
+			NewUserTextMessage("Hello."),
+		))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Message{
+		NewSystemTextMessage("You are a caller-configured assistant.\n\nYou are a prompt-configured assistant."),
+		NewUserTextMessage("Hello."),
+	}
+	if diff := cmp.Diff(want, resp.Request.Messages); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestGenerateAlternatingRoles(t *testing.T) {
+	echo := func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("ok")}, nil
+	}
+	history := []*Message{
+		NewUserTextMessage("Hi."),
+		NewModelTextMessage("Hello!"),
+		NewUserTextMessage("Are you there?"),
+	}
+	opts := []GenerateOption{WithHistory(history...), WithTextPrompt("What is 2+2?")}
+
+	t.Run("merged", func(t *testing.T) {
+		m := DefineModel("test", "alternating", &ModelMetadata{Supports: ModelCapabilities{AlternatingRoles: true}}, echo)
+		resp, err := Generate(context.Background(), m, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []*Message{
+			NewUserTextMessage("Hi."),
+			NewModelTextMessage("Hello!"),
+			NewUserTextMessage("Are you there?\n\nWhat is 2+2?"),
+		}
+		if diff := cmp.Diff(want, resp.Request.Messages); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+		if got := history[2].Text(); got != "Are you there?" {
+			t.Errorf("history message changed to %q", got)
+		}
+	})
+	t.Run("unchanged", func(t *testing.T) {
+		m := DefineModel("test", "notAlternating", nil, echo)
+		resp, err := Generate(context.Background(), m, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(resp.Request.Messages); got != 4 {
+			t.Errorf("got %d messages, want 4", got)
+		}
+	})
+}
+
+func TestGenerateStream(t *testing.T) {
+	m := DefineModel("test", "chunker", nil, func(ctx context.Context, req *ModelRequest, cb ModelStreamingCallback) (*ModelResponse, error) {
+		for _, s := range []string{"a", "b", "c"} {
+			if err := cb(ctx, &ModelResponseChunk{Content: []*Part{NewTextPart(s)}}); err != nil {
+				return nil, err
+			}
+		}
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("abc")}, nil
+	})
+	chunks, wait := GenerateStream(context.Background(), m, WithTextPrompt("hi"))
+	var got []string
+	for c := range chunks {
+		got = append(got, c.Text())
+	}
+	if diff := cmp.Diff([]string{"a", "b", "c"}, got); diff != "" {
+		t.Errorf("chunks mismatch (-want, +got):\n%s", diff)
+	}
+	resp, err := wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Text(), "abc"; got != want {
+		t.Errorf("got response %q, want %q", got, want)
+	}
+}
+
+func TestMergeChunks(t *testing.T) {
+	text := func(index int, s string) *ModelResponseChunk {
+		return &ModelResponseChunk{Index: index, Content: []*Part{NewTextPart(s)}}
+	}
+	for _, test := range []struct {
+		desc   string
+		chunks []*ModelResponseChunk
+		want   string
+	}{
+		{"in order", []*ModelResponseChunk{text(0, "a"), text(1, "b"), text(2, "c")}, "abc"},
+		{"out of order", []*ModelResponseChunk{text(2, "c"), text(0, "a"), text(1, "b")}, "abc"},
+		{"duplicate", []*ModelResponseChunk{text(0, "a"), text(1, "b"), text(1, "b"), text(2, "c")}, "abc"},
+		{"unindexed", []*ModelResponseChunk{text(0, "x"), text(0, "y"), text(0, "z")}, "xyz"},
+		{"aggregated", []*ModelResponseChunk{text(0, "a"), {Index: 1, Aggregated: true, Content: []*Part{NewTextPart("AB")}}, text(2, "c")}, "ABc"},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			msg := MergeChunks(test.chunks)
+			if len(msg.Content) != 1 {
+				t.Errorf("got %d parts, want consecutive text joined into 1", len(msg.Content))
+			}
+			if got := msg.Text(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGenerateToWriter(t *testing.T) {
+	m := DefineModel("test", "writer", nil, func(ctx context.Context, req *ModelRequest, cb ModelStreamingCallback) (*ModelResponse, error) {
+		for _, s := range []string{"Hello", ", ", "world"} {
+			if err := cb(ctx, &ModelResponseChunk{Content: []*Part{NewTextPart(s)}}); err != nil {
+				return nil, err
+			}
+		}
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("Hello, world")}, nil
+	})
+	var buf bytes.Buffer
+	resp, err := GenerateToWriter(context.Background(), m, &buf, WithTextPrompt("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), resp.Text(); got != want {
+		t.Errorf("wrote %q, want response text %q", got, want)
+	}
+}
+
+func TestGenerateEmptyResponse(t *testing.T) {
+	m := DefineModel("test", "filtered", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{
+			Request:       req,
+			Message:       &Message{Role: RoleModel},
+			FinishReason:  FinishReasonBlocked,
+			FinishMessage: "SAFETY",
+		}, nil
+	})
+	_, err := Generate(context.Background(), m, WithTextPrompt("hi"))
+	if !errors.Is(err, ErrEmptyResponse) {
+		t.Fatalf("got error %v, want ErrEmptyResponse", err)
+	}
+	var eerr *EmptyResponseError
+	if !errors.As(err, &eerr) {
+		t.Fatalf("got error of type %T, want *EmptyResponseError", err)
+	}
+	if eerr.FinishReason != FinishReasonBlocked || eerr.FinishMessage != "SAFETY" {
+		t.Errorf("got finish reason %q, message %q; want %q, %q", eerr.FinishReason, eerr.FinishMessage, FinishReasonBlocked, "SAFETY")
+	}
+}
+
+func TestGenerateAcross(t *testing.T) {
+	fakeModel := func(name string) Model {
+		return DefineModel("test", name, nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+			return &ModelResponse{Request: req, Message: NewModelTextMessage(name + ": " + req.Messages[0].Text())}, nil
+		})
+	}
+	failing := DefineModel("test", "acrossFailing", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return nil, errors.New("unavailable")
+	})
+	a, b := fakeModel("acrossA"), fakeModel("acrossB")
+
+	t.Run("all succeed", func(t *testing.T) {
+		resps, err := GenerateAcross(context.Background(), []Model{a, b}, WithTextPrompt("hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range resps {
+			got = append(got, r.Text())
+		}
+		want := []string{"acrossA: hi", "acrossB: hi"}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("responses mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("one fails", func(t *testing.T) {
+		resps, err := GenerateAcross(context.Background(), []Model{failing, b}, WithTextPrompt("hi"))
+		errorContains(t, err, "test/acrossFailing: unavailable")
+		if len(resps) != 2 || resps[0] != nil || resps[1] == nil {
+			t.Fatalf("got responses %v, want [nil, response]", resps)
+		}
+		if got, want := resps[1].Text(), "acrossB: hi"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestGenerateCancel(t *testing.T) {
+	t.Run("during model call", func(t *testing.T) {
+		m := DefineModel("test", "blocking", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		start := time.Now()
+		_, err := Generate(ctx, m, WithTextPrompt("hi"))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want context.Canceled", err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Generate took %v to return after cancellation", d)
+		}
+	})
+	t.Run("during tool call", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancellingTool := DefineTool("cancelling", "cancels the generation",
+			func(context.Context, struct{}) (string, error) {
+				cancel()
+				return "ok", nil
+			},
+		)
+		calls := 0
+		m := DefineModel("test", "cancelledToolCaller", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+			calls++
+			return &ModelResponse{
+				Request: req,
+				Message: &Message{
+					Role:    RoleModel,
+					Content: []*Part{NewToolRequestPart(&ToolRequest{Name: "cancelling", Input: map[string]any{}})},
+				},
+			}, nil
+		})
+		_, err := Generate(ctx, m, WithTextPrompt("hi"), WithTools(cancellingTool))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v, want context.Canceled", err)
+		}
+		if calls != 1 {
+			t.Errorf("model called %d times, want 1", calls)
+		}
+	})
+}
+
+func TestGenerateTimeout(t *testing.T) {
+	// slow is a provider that takes a second to answer.
+	m := DefineModel("test", "slow", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return &ModelResponse{Request: req, Message: NewModelTextMessage("done")}, nil
+		}
+	})
+	start := time.Now()
+	_, err := Generate(context.Background(), m, WithTextPrompt("hi"), WithTimeout(10*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Generate took %v with a 10ms timeout", d)
+	}
+
+	_, err = Generate(context.Background(), m, WithTimeout(0))
+	errorContains(t, err, "must be positive")
+	_, err = Generate(context.Background(), m, WithTimeout(time.Second), WithTimeout(time.Second))
+	errorContains(t, err, "more than once")
+}
+
+func TestTruncateToolResult(t *testing.T) {
+	got, err := truncateToolResult(map[string]any{"a": 1}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]any{"a": 1}, got); diff != "" {
+		t.Errorf("small result changed (-want, +got):\n%s", diff)
+	}
+	// "é" is two bytes; the cut must not split it.
+	got, err = truncateToolResult("ééé", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"é...[truncated 5 bytes]`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDescribeModel(t *testing.T) {
+	meta := ModelMetadata{
+		Label:           "Big",
+		Supports:        ModelCapabilities{Multiturn: true, Media: true},
+		ContextWindow:   128000,
+		MaxOutputTokens: 4096,
+	}
+	DefineModel("test", "describe", &meta, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("ok")}, nil
+	})
+	m := LookupModel("test", "describe")
+	if diff := cmp.Diff(meta, DescribeModel(m)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if got := DescribeModel(echoModel); got.ContextWindow != 0 || got.MaxOutputTokens != 0 {
+		t.Errorf("got limits %d, %d for a model without them, want zero", got.ContextWindow, got.MaxOutputTokens)
+	}
+}
+
 func TestIsDefinedModel(t *testing.T) {
 	t.Run("should return true", func(t *testing.T) {
 		if IsDefinedModel("test", "echo") != true {
@@ -350,6 +1072,23 @@ func TestLookupModel(t *testing.T) {
 			t.Errorf("LookupModel did not return nil")
 		}
 	})
+	t.Run("should resolve alias", func(t *testing.T) {
+		RegisterAlias("fast", "test", "echo")
+		if got, want := LookupModel("", "fast"), LookupModel("test", "echo"); got != want {
+			t.Errorf("LookupModel(\"\", \"fast\") = %v, want %v", got, want)
+		}
+		RegisterAlias("fast", "foo", "bar")
+		if LookupModel("", "fast") != nil {
+			t.Errorf("LookupModel did not return nil for an alias to an undefined model")
+		}
+	})
+	t.Run("should fail on unknown alias", func(t *testing.T) {
+		if LookupModel("", "slow") != nil {
+			t.Errorf("LookupModel did not return nil")
+		}
+		_, _, err := ResolveAlias("slow")
+		errorContains(t, err, `unknown model alias "slow"`)
+	})
 }
 
 func JSONMarkdown(text string) string {
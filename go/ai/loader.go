@@ -0,0 +1,258 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// A DocumentLoader reads a file in some format and returns its text as
+// documents, ready to be split into chunks and indexed. source describes
+// where the file came from, such as its path, and is recorded in the
+// "source" metadata of each document.
+type DocumentLoader func(r io.Reader, source string) ([]*Document, error)
+
+// documentLoaders maps file extensions to the loaders for them.
+var documentLoaders = map[string]DocumentLoader{
+	".txt":      LoadText,
+	".text":     LoadText,
+	".md":       LoadMarkdown,
+	".markdown": LoadMarkdown,
+	".html":     LoadHTML,
+	".htm":      LoadHTML,
+	".pdf":      LoadPDF,
+}
+
+// LoadDocuments loads the files at paths, choosing a [DocumentLoader]
+// for each by its extension: [LoadText] for .txt, [LoadMarkdown] for .md,
+// [LoadHTML] for .html and [LoadPDF] for .pdf. Each document's "source"
+// metadata is the path of its file.
+func LoadDocuments(paths ...string) ([]*Document, error) {
+	var docs []*Document
+	for _, path := range paths {
+		load, ok := documentLoaders[strings.ToLower(filepath.Ext(path))]
+		if !ok {
+			return nil, fmt.Errorf("LoadDocuments: no loader for %q", path)
+		}
+		ds, err := loadFile(path, load)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, ds...)
+	}
+	return docs, nil
+}
+
+func loadFile(path string, load DocumentLoader) ([]*Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	docs, err := load(f, path)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", path, err)
+	}
+	return docs, nil
+}
+
+// newLoadedDocument returns a document holding text, with the given
+// metadata and the source and format.
+func newLoadedDocument(text, source, format string, metadata map[string]any) *Document {
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	metadata["source"] = source
+	metadata["format"] = format
+	return DocumentFromText(text, metadata)
+}
+
+// LoadText loads a plain text file as a single document.
+func LoadText(r io.Reader, source string) ([]*Document, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return []*Document{newLoadedDocument(string(b), source, "text", nil)}, nil
+}
+
+var (
+	mdImage      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink       = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	mdEmphasis   = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	mdInlineCode = regexp.MustCompile("`([^`]*)`")
+	mdHeading    = regexp.MustCompile(`^#{1,6}\s+`)
+)
+
+// LoadMarkdown loads a Markdown file as a single document, whose text
+// is the file with Markdown syntax removed: heading markers, link and
+// image targets, bold markers, backquotes and code fences.
+// The keys of a YAML front matter block of simple "key: value" lines
+// become metadata, and the metadata "title" is the front matter's title
+// or, if there is none, the text of the first top-level heading.
+func LoadMarkdown(r io.Reader, source string) ([]*Document, error) {
+	metadata := map[string]any{}
+	var sb strings.Builder
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	first := true
+	inFrontMatter := false
+	for sc.Scan() {
+		line := sc.Text()
+		if first && strings.TrimSpace(line) == "---" {
+			first = false
+			inFrontMatter = true
+			continue
+		}
+		first = false
+		if inFrontMatter {
+			if strings.TrimSpace(line) == "---" {
+				inFrontMatter = false
+			} else if k, v, ok := strings.Cut(line, ":"); ok {
+				metadata[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			continue
+		}
+		if strings.HasPrefix(line, "# ") {
+			if _, ok := metadata["title"]; !ok {
+				metadata["title"] = strings.TrimSpace(line[2:])
+			}
+		}
+		line = mdHeading.ReplaceAllString(line, "")
+		line = mdImage.ReplaceAllString(line, "$1")
+		line = mdLink.ReplaceAllString(line, "$1")
+		line = mdEmphasis.ReplaceAllString(line, "$2")
+		line = mdInlineCode.ReplaceAllString(line, "$1")
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(sb.String())
+	return []*Document{newLoadedDocument(text, source, "markdown", metadata)}, nil
+}
+
+// LoadHTML loads an HTML file as a single document, whose text is the
+// visible text of the page. Block elements such as paragraphs and list
+// items start new lines, and the contents of scripts and styles are
+// dropped. The metadata "title" is the page's title, and "description"
+// its description meta tag, if it has them.
+func LoadHTML(r io.Reader, source string) ([]*Document, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	metadata := map[string]any{}
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			if text := strings.Join(strings.Fields(n.Data), " "); text != "" {
+				if s := sb.String(); s != "" && !strings.HasSuffix(s, "\n") && !strings.HasSuffix(s, " ") && startsWithSpace(n.Data) {
+					sb.WriteByte(' ')
+				}
+				sb.WriteString(text)
+				if endsWithSpace(n.Data) {
+					sb.WriteByte(' ')
+				}
+			}
+			return
+		case html.ElementNode:
+			switch n.DataAtom {
+			case atom.Script, atom.Style, atom.Noscript, atom.Template:
+				return
+			case atom.Title:
+				if n.FirstChild != nil {
+					metadata["title"] = strings.TrimSpace(n.FirstChild.Data)
+				}
+				return
+			case atom.Meta:
+				if htmlAttr(n, "name") == "description" {
+					metadata["description"] = htmlAttr(n, "content")
+				}
+				return
+			case atom.Br:
+				endLine(&sb)
+				return
+			}
+		}
+		block := n.Type == html.ElementNode && htmlBlocks[n.DataAtom]
+		if block {
+			endLine(&sb)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			endLine(&sb)
+		}
+	}
+	walk(doc)
+	lines := strings.Split(sb.String(), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+	text := strings.TrimSpace(strings.Join(lines, "\n"))
+	return []*Document{newLoadedDocument(text, source, "html", metadata)}, nil
+}
+
+// htmlBlocks holds the elements whose contents LoadHTML puts on their own lines.
+var htmlBlocks = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Li: true, atom.Ul: true, atom.Ol: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Table: true, atom.Tr: true, atom.Pre: true, atom.Blockquote: true,
+	atom.Section: true, atom.Article: true, atom.Header: true, atom.Footer: true,
+	atom.Nav: true, atom.Main: true, atom.Aside: true, atom.Hr: true,
+	atom.Dl: true, atom.Dt: true, atom.Dd: true, atom.Figure: true, atom.Figcaption: true,
+}
+
+// endLine ends the current line of sb, unless it is empty.
+func endLine(sb *strings.Builder) {
+	if s := sb.String(); s != "" && !strings.HasSuffix(s, "\n") {
+		sb.WriteByte('\n')
+	}
+}
+
+func startsWithSpace(s string) bool {
+	return s != "" && strings.TrimLeft(s, " \t\r\n") != s
+}
+
+func endsWithSpace(s string) bool {
+	return s != "" && strings.TrimRight(s, " \t\r\n") != s
+}
+
+// htmlAttr returns the value of the named attribute of n, or "".
+func htmlAttr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
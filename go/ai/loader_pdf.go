@@ -0,0 +1,360 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LoadPDF loads a PDF file as a single document holding the text of its
+// pages, which it finds by reading the text-showing operators of the
+// file's content streams. This handles most PDFs produced from text
+// documents, but not scanned pages, encrypted files, or text in fonts
+// whose characters are not encoded as single bytes, whose text comes out
+// garbled. Convert such files to text with a dedicated tool instead.
+// LoadPDF returns an error if the file's compressed streams decompress
+// to more than 64 MiB each, or 256 MiB in all.
+func LoadPDF(r io.Reader, source string) ([]*Document, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil, errors.New("not a PDF file")
+	}
+	streams, err := pdfContentStreams(data)
+	if err != nil {
+		return nil, err
+	}
+	var sb strings.Builder
+	for _, content := range streams {
+		pdfText(&sb, content)
+	}
+	text := strings.TrimSpace(sb.String())
+	return []*Document{newLoadedDocument(text, source, "pdf", nil)}, nil
+}
+
+var (
+	pdfStream    = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	pdfEndStream = []byte("endstream")
+	pdfImage     = regexp.MustCompile(`/Subtype\s*/Image`)
+)
+
+// Limits on the size of decompressed streams, which keep a small PDF
+// crafted to inflate to gigabytes from exhausting memory.
+// They are variables so that tests can lower them.
+var (
+	pdfMaxStreamSize int64 = 64 << 20  // bytes of one decoded stream
+	pdfMaxTotalSize  int64 = 256 << 20 // bytes of all decoded streams of a file
+)
+
+// pdfContentStreams returns the decoded streams of data that hold text.
+// Images, and streams with filters other than FlateDecode, are skipped.
+// It returns an error if the decoded streams exceed the size limits.
+func pdfContentStreams(data []byte) ([][]byte, error) {
+	var streams [][]byte
+	var total int64
+	for _, loc := range pdfStream.FindAllSubmatchIndex(data, -1) {
+		dict := string(data[loc[2]:loc[3]])
+		start := loc[1]
+		end := bytes.Index(data[start:], pdfEndStream)
+		if end < 0 {
+			break
+		}
+		body := data[start : start+end]
+		if pdfImage.MatchString(dict) {
+			continue
+		}
+		if strings.Contains(dict, "/Filter") {
+			if !strings.Contains(dict, "/FlateDecode") {
+				continue
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				continue
+			}
+			limit := min(pdfMaxStreamSize, pdfMaxTotalSize-total)
+			// A truncated stream still yields the text before the error.
+			body, _ = io.ReadAll(io.LimitReader(zr, limit+1))
+			if int64(len(body)) > limit {
+				if limit < pdfMaxStreamSize {
+					return nil, fmt.Errorf("decompressed streams exceed %d bytes", pdfMaxTotalSize)
+				}
+				return nil, fmt.Errorf("decompressed stream exceeds %d bytes", pdfMaxStreamSize)
+			}
+			total += int64(len(body))
+		}
+		if bytes.Contains(body, []byte("BT")) && bytes.Contains(body, []byte("ET")) {
+			streams = append(streams, body)
+		}
+	}
+	return streams, nil
+}
+
+// pdfText writes to sb the text shown by the operators in a content stream.
+func pdfText(sb *strings.Builder, content []byte) {
+	var operands []any // strings, numbers and arrays before the next operator
+	lex := &pdfLexer{data: content}
+	for {
+		tok, ok := lex.next()
+		if !ok {
+			break
+		}
+		op, isOp := tok.(pdfOperator)
+		if !isOp {
+			operands = append(operands, tok)
+			continue
+		}
+		switch op {
+		case "Tj":
+			pdfShow(sb, operands)
+		case "'", `"`:
+			sb.WriteByte('\n')
+			pdfShow(sb, operands)
+		case "TJ":
+			if len(operands) > 0 {
+				if arr, ok := operands[len(operands)-1].([]any); ok {
+					for _, e := range arr {
+						switch e := e.(type) {
+						case string:
+							sb.WriteString(e)
+						case float64:
+							// A large negative adjustment separates words.
+							if e < -200 {
+								sb.WriteByte(' ')
+							}
+						}
+					}
+				}
+			}
+		case "T*", "ET":
+			sb.WriteByte('\n')
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if ty, ok := operands[len(operands)-1].(float64); ok && ty != 0 {
+					sb.WriteByte('\n')
+				} else {
+					sb.WriteByte(' ')
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// pdfShow writes the last operand, a string, to sb.
+func pdfShow(sb *strings.Builder, operands []any) {
+	if len(operands) > 0 {
+		if s, ok := operands[len(operands)-1].(string); ok {
+			sb.WriteString(s)
+		}
+	}
+}
+
+// A pdfOperator is an operator in a content stream, like "Tj".
+type pdfOperator string
+
+// pdfLexer splits a content stream into tokens: strings, numbers,
+// arrays ([]any), names (ignored, as nil) and operators.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func (l *pdfLexer) next() (any, bool) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, false
+	}
+	c := l.data[l.pos]
+	switch {
+	case c == '(':
+		return l.literalString(), true
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.pos += 2
+		return nil, true
+	case c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+		l.pos += 2
+		return nil, true
+	case c == '<':
+		return l.hexString(), true
+	case c == '[':
+		l.pos++
+		var arr []any
+		for {
+			l.skipSpace()
+			if l.pos >= len(l.data) {
+				return arr, true
+			}
+			if l.data[l.pos] == ']' {
+				l.pos++
+				return arr, true
+			}
+			tok, ok := l.next()
+			if !ok {
+				return arr, true
+			}
+			arr = append(arr, tok)
+		}
+	case c == ']' || c == '{' || c == '}' || c == ')' || c == '>':
+		l.pos++
+		return nil, true
+	case c == '/':
+		l.pos++
+		l.word()
+		return nil, true
+	}
+	w := l.word()
+	if w == "" {
+		l.pos++
+		return nil, true
+	}
+	if f, err := strconv.ParseFloat(w, 64); err == nil {
+		return f, true
+	}
+	return pdfOperator(w), true
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		switch c := l.data[l.pos]; {
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case pdfIsSpace(c):
+			l.pos++
+		default:
+			return
+		}
+	}
+}
+
+// word returns the regular characters at the current position.
+func (l *pdfLexer) word() string {
+	start := l.pos
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if pdfIsSpace(c) || strings.IndexByte("()<>[]{}/%", c) >= 0 {
+			break
+		}
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// literalString returns the string in parentheses at the current position.
+func (l *pdfLexer) literalString() string {
+	l.pos++ // skip '('
+	var b []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfDecode(b)
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				continue
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// A line continuation.
+				if e == '\r' && l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						n = n*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(n)
+				} else {
+					c = e
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return pdfDecode(b)
+}
+
+// hexString returns the string in angle brackets at the current position.
+func (l *pdfLexer) hexString() string {
+	l.pos++ // skip '<'
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; !pdfIsSpace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++ // skip '>'
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, 0, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		n, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			return ""
+		}
+		b = append(b, byte(n))
+	}
+	return pdfDecode(b)
+}
+
+// pdfDecode converts the bytes of a string in a single-byte encoding to
+// UTF-8, treating them as Latin-1, which agrees with the common PDF
+// encodings for letters, digits and punctuation.
+func pdfDecode(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+func pdfIsSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == 0
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadMarkdown(t *testing.T) {
+	const md = "---\n" +
+		"author: Ada\n" +
+		"tags: \"notes\"\n" +
+		"---\n" +
+		"# Getting started\n" +
+		"\n" +
+		"Read the **guide** at [the docs](https://example.com/docs).\n" +
+		"![a diagram](diagram.png)\n" +
+		"\n" +
+		"## Install\n" +
+		"```sh\n" +
+		"go get `genkit`\n" +
+		"```\n"
+	docs, err := LoadMarkdown(strings.NewReader(md), "guide.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("got %d documents, want 1", len(docs))
+	}
+	wantText := "Getting started\n\nRead the guide at the docs.\na diagram\n\nInstall\ngo get genkit"
+	if diff := cmp.Diff(wantText, docs[0].Content[0].Text); diff != "" {
+		t.Errorf("text mismatch (-want, +got):\n%s", diff)
+	}
+	wantMetadata := map[string]any{
+		"author": "Ada",
+		"tags":   "notes",
+		"title":  "Getting started",
+		"source": "guide.md",
+		"format": "markdown",
+	}
+	if diff := cmp.Diff(wantMetadata, docs[0].Metadata); diff != "" {
+		t.Errorf("metadata mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestLoadHTML(t *testing.T) {
+	const page = `<!DOCTYPE html>
+<html>
+<head>
+  <title> Release notes </title>
+  <meta name="description" content="What changed.">
+  <style>body { color: red; }</style>
+  <script>alert("hi")</script>
+</head>
+<body>
+  <h1>Release notes</h1>
+  <p>This release adds <b>loaders</b> and
+     fixes bugs.</p>
+  <ul><li>PDF</li><li>HTML</li></ul>
+  <p>Line one<br>line two</p>
+</body>
+</html>`
+	docs, err := LoadHTML(strings.NewReader(page), "notes.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("got %d documents, want 1", len(docs))
+	}
+	wantText := "Release notes\nThis release adds loaders and fixes bugs.\nPDF\nHTML\nLine one\nline two"
+	if diff := cmp.Diff(wantText, docs[0].Content[0].Text); diff != "" {
+		t.Errorf("text mismatch (-want, +got):\n%s", diff)
+	}
+	wantMetadata := map[string]any{
+		"title":       "Release notes",
+		"description": "What changed.",
+		"source":      "notes.html",
+		"format":      "html",
+	}
+	if diff := cmp.Diff(wantMetadata, docs[0].Metadata); diff != "" {
+		t.Errorf("metadata mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+// testPDF returns a minimal PDF file whose page shows the given content
+// stream, compressed if compress is true.
+func testPDF(content string, compress bool) []byte {
+	var stream, filter string
+	if compress {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write([]byte(content))
+		zw.Close()
+		stream, filter = buf.String(), " /Filter /FlateDecode"
+	} else {
+		stream = content
+	}
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	b.WriteString("1 0 obj << /Type /Catalog /Pages 2 0 R >> endobj\n")
+	b.WriteString("2 0 obj << /Type /Pages /Kids [3 0 R] /Count 1 >> endobj\n")
+	b.WriteString("3 0 obj << /Type /Page /Parent 2 0 R /Contents 4 0 R >> endobj\n")
+	fmt.Fprintf(&b, "4 0 obj << /Length %d%s >>\nstream\n%s\nendstream\nendobj\n", len(stream), filter, stream)
+	b.WriteString("trailer << /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func TestLoadPDF(t *testing.T) {
+	const content = "BT /F1 12 Tf 72 720 Td (Hello, \\(PDF\\) world!) Tj 0 -14 Td " +
+		"[(Sec) 20 (ond) -250 (line)] TJ T* <416263> Tj ET"
+	const want = "Hello, (PDF) world!\nSecond line\nAbc"
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%t", compress), func(t *testing.T) {
+			docs, err := LoadPDF(bytes.NewReader(testPDF(content, compress)), "doc.pdf")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, docs[0].Content[0].Text); diff != "" {
+				t.Errorf("text mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+	t.Run("decompression bomb", func(t *testing.T) {
+		defer func(stream, total int64) { pdfMaxStreamSize, pdfMaxTotalSize = stream, total }(pdfMaxStreamSize, pdfMaxTotalSize)
+		pdfMaxStreamSize, pdfMaxTotalSize = 1<<20, 3<<20
+		bomb := "BT (" + strings.Repeat("A", 2<<20) + ") Tj ET"
+		_, err := LoadPDF(bytes.NewReader(testPDF(bomb, true)), "bomb.pdf")
+		errorContains(t, err, "decompressed stream exceeds")
+
+		// Streams under the per-stream limit count toward the total.
+		var b bytes.Buffer
+		b.WriteString("%PDF-1.4\n")
+		for range 4 {
+			b.Write(testPDF("BT ("+strings.Repeat("A", 900<<10)+") Tj ET", true)[len("%PDF-1.4\n"):])
+		}
+		_, err = LoadPDF(&b, "bombs.pdf")
+		errorContains(t, err, "decompressed streams exceed")
+	})
+	t.Run("not a PDF", func(t *testing.T) {
+		_, err := LoadPDF(strings.NewReader("hello"), "doc.pdf")
+		errorContains(t, err, "not a PDF")
+	})
+}
+
+func TestLoadDocuments(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	txt := write("a.txt", "plain")
+	md := write("b.MD", "# Title\nbody")
+
+	docs, err := LoadDocuments(txt, md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range docs {
+		got = append(got, fmt.Sprintf("%s %s %q", d.Metadata["source"], d.Metadata["format"], d.Content[0].Text))
+	}
+	want := []string{
+		fmt.Sprintf("%s text %q", txt, "plain"),
+		fmt.Sprintf("%s markdown %q", md, "Title\nbody"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	_, err = LoadDocuments(write("c.docx", ""))
+	errorContains(t, err, "no loader")
+	_, err = LoadDocuments(filepath.Join(dir, "missing.txt"))
+	if err == nil {
+		t.Error("got nil error for a missing file")
+	}
+}
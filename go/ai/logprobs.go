@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/firebase/genkit/go/internal/base"
+)
+
+// A TokenLogProb is the log probability of a generated token.
+type TokenLogProb struct {
+	Token   string  `json:"token"`
+	LogProb float64 `json:"logProb"`
+	// TopLogProbs holds the most likely tokens at this position,
+	// most likely first, if they were requested.
+	TopLogProbs []*TokenLogProb `json:"topLogProbs,omitempty"`
+}
+
+// WithLogProbs requests the log probability of each generated token,
+// along with those of the n most likely tokens at each position.
+// They are returned by [ModelResponse.LogProbs].
+// The model must declare support for log probabilities in its
+// [ModelCapabilities]; otherwise [Generate] returns an error.
+func WithLogProbs(n int) GenerateOption {
+	return func(req *generateParams) error {
+		if req.LogProbs != nil {
+			return errors.New("cannot set log probabilities (WithLogProbs) more than once")
+		}
+		if n < 0 {
+			return fmt.Errorf("WithLogProbs: n must not be negative, got %d", n)
+		}
+		req.LogProbs = &n
+		return nil
+	}
+}
+
+// logProbsKey holds the argument of [WithLogProbs].
+var logProbsKey = base.NewContextKey[*int]()
+
+// RequestedLogProbs reports whether log probabilities were requested with
+// [WithLogProbs] for the generation running in ctx, and if so, how many
+// alternative tokens were requested at each position.
+// (Only genkit plugins should need to use this function.)
+func RequestedLogProbs(ctx context.Context) (n int, ok bool) {
+	if p := logProbsKey.FromContext(ctx); p != nil {
+		return *p, true
+	}
+	return 0, false
+}
+
+// logProbsKeyName is the key in [ModelResponse.Custom] under which
+// the log probabilities are stored.
+const logProbsKeyName = "logProbs"
+
+// LogProbs returns the log probabilities of the generated tokens recorded
+// by the model plugin with [ModelResponse.SetLogProbs], or nil if there
+// are none.
+func (gr *ModelResponse) LogProbs() []*TokenLogProb {
+	return customValue[[]*TokenLogProb](gr, logProbsKeyName)
+}
+
+// SetLogProbs records the log probabilities of the generated tokens in the
+// response's Custom field.
+// (Only genkit plugins should need to use this method.)
+func (gr *ModelResponse) SetLogProbs(lps []*TokenLogProb) {
+	gr.SetCustomValue(logProbsKeyName, lps)
+}
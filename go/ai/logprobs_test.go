@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLogProbs(t *testing.T) {
+	// The fake plugin reports a log probability for each word of its reply,
+	// with n alternatives at each position.
+	m := DefineModel("test", "logProbs", &ModelMetadata{Supports: ModelCapabilities{LogProbs: true}},
+		func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+			resp := &ModelResponse{Request: req, Message: NewModelTextMessage("hello world")}
+			n, ok := RequestedLogProbs(ctx)
+			if !ok {
+				return resp, nil
+			}
+			var lps []*TokenLogProb
+			for _, tok := range []string{"hello", " world"} {
+				lp := &TokenLogProb{Token: tok, LogProb: -0.5}
+				for range n {
+					lp.TopLogProbs = append(lp.TopLogProbs, &TokenLogProb{Token: tok + "?", LogProb: -2})
+				}
+				lps = append(lps, lp)
+			}
+			resp.SetLogProbs(lps)
+			return resp, nil
+		})
+
+	resp, err := Generate(context.Background(), m, WithTextPrompt("hi"), WithLogProbs(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*TokenLogProb{
+		{Token: "hello", LogProb: -0.5, TopLogProbs: []*TokenLogProb{{Token: "hello?", LogProb: -2}}},
+		{Token: " world", LogProb: -0.5, TopLogProbs: []*TokenLogProb{{Token: " world?", LogProb: -2}}},
+	}
+	if diff := cmp.Diff(want, resp.LogProbs()); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Log probabilities survive a JSON round trip, as through the dev UI.
+	b, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ModelResponse
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, decoded.LogProbs()); diff != "" {
+		t.Errorf("after JSON round trip: mismatch (-want, +got):\n%s", diff)
+	}
+
+	resp, err = Generate(context.Background(), m, WithTextPrompt("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lps := resp.LogProbs(); lps != nil {
+		t.Errorf("got log probabilities %v without WithLogProbs", lps)
+	}
+
+	unsupported := DefineModel("test", "noLogProbs", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("ok")}, nil
+	})
+	_, err = Generate(context.Background(), unsupported, WithTextPrompt("hi"), WithLogProbs(0))
+	errorContains(t, err, "does not support log probabilities")
+}
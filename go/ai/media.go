@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// MediaPartFromFile returns a media Part holding the contents of the file
+// as a "data:" URL. The content type is determined from the file's extension,
+// or from its contents if the extension is not recognized.
+func MediaPartFromFile(path string) (*Part, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return dataURLPart(contentType, data), nil
+}
+
+// MediaPartFromURL fetches url and returns a media Part holding the
+// response body as a "data:" URL, so that it can be sent to models that
+// do not fetch URLs themselves. The content type is taken from the
+// response's Content-Type header, or determined from the body if the
+// header is missing.
+func MediaPartFromURL(ctx context.Context, url string) (*Part, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("MediaPartFromURL: fetching %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("MediaPartFromURL: reading %s: %w", url, err)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return dataURLPart(contentType, data), nil
+}
+
+// dataURLPart returns a media Part holding data as a base64 "data:" URL.
+// Parameters of contentType, such as the charset, are dropped.
+func dataURLPart(contentType string, data []byte) *Part {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		contentType = mediaType
+	}
+	url := "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	return NewMediaPart(contentType, url)
+}
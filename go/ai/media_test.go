@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pngHeader is the start of a PNG file, enough for content sniffing.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestMediaPartFromFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"menu.png", pngHeader, "image/png"},
+		{"notes.txt", []byte("soup"), "text/plain"},
+		// No extension: the content type is sniffed.
+		{"image", pngHeader, "image/png"},
+	} {
+		path := filepath.Join(dir, test.name)
+		if err := os.WriteFile(path, test.data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := MediaPartFromFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !p.IsMedia() || p.ContentType != test.want {
+			t.Errorf("%s: got media %t, content type %q; want true, %q", test.name, p.IsMedia(), p.ContentType, test.want)
+		}
+		wantURL := "data:" + test.want + ";base64," + base64.StdEncoding.EncodeToString(test.data)
+		if p.Text != wantURL {
+			t.Errorf("%s: got URL %q, want %q", test.name, p.Text, wantURL)
+		}
+	}
+
+	if _, err := MediaPartFromFile(filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("got nil error for missing file")
+	}
+}
+
+func TestMediaPartFromURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("jpeg bytes"))
+		case "/sniffed":
+			w.Header()["Content-Type"] = nil // suppress the server's own sniffing
+			w.Write(pngHeader)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for path, want := range map[string]string{"/photo": "image/jpeg", "/sniffed": "image/png"} {
+		p, err := MediaPartFromURL(context.Background(), srv.URL+path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.ContentType != want {
+			t.Errorf("%s: got content type %q, want %q", path, p.ContentType, want)
+		}
+	}
+
+	_, err := MediaPartFromURL(context.Background(), srv.URL+"/missing")
+	errorContains(t, err, "404")
+}
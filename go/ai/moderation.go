@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"fmt"
+)
+
+// A Moderator inspects text sent to or received from a model.
+type Moderator interface {
+	// Moderate returns a non-nil error if text should be blocked.
+	Moderate(ctx context.Context, text string) error
+}
+
+// ModeratorFunc adapts an ordinary function to a [Moderator].
+type ModeratorFunc func(ctx context.Context, text string) error
+
+// Moderate calls f(ctx, text).
+func (f ModeratorFunc) Moderate(ctx context.Context, text string) error {
+	return f(ctx, text)
+}
+
+// A ModerationError is returned by [Generate] when a [Moderator]
+// blocks the request or the response.
+type ModerationError struct {
+	// Stage is "request" if the prompt was blocked,
+	// or "response" if the model output was blocked.
+	Stage string
+	Err   error // the error returned by the Moderator
+}
+
+func (e *ModerationError) Error() string {
+	return fmt.Sprintf("moderation blocked %s: %v", e.Stage, e.Err)
+}
+
+func (e *ModerationError) Unwrap() error { return e.Err }
+
+// moderateRequest runs the moderator on the text of each message in req.
+func moderateRequest(ctx context.Context, m Moderator, req *ModelRequest) error {
+	for _, msg := range req.Messages {
+		text := msg.Text()
+		if text == "" {
+			continue
+		}
+		if err := m.Moderate(ctx, text); err != nil {
+			return &ModerationError{Stage: "request", Err: err}
+		}
+	}
+	return nil
+}
+
+// moderateResponse runs the moderator on the text of resp.
+func moderateResponse(ctx context.Context, m Moderator, resp *ModelResponse) error {
+	text := resp.Text()
+	if text == "" {
+		return nil
+	}
+	if err := m.Moderate(ctx, text); err != nil {
+		return &ModerationError{Stage: "response", Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"fmt"
+	"maps"
+)
+
+// An OutputParser parses or cleans up the text of a model response.
+type OutputParser func(text string) (any, error)
+
+// WithOutputParser runs parser on the text of the response after
+// generation, and records its result in the response, where
+// [ModelResponse.ParsedOutput] returns it. If parser fails,
+// Generate returns its error.
+//
+// The option can be given more than once to chain parsers. The first
+// parser is passed the response text, and each later one is passed the
+// result of the parser before it, which must be a string; for example,
+// a parser that strips Markdown code fences can feed a JSON parser.
+func WithOutputParser(parser OutputParser) GenerateOption {
+	return func(req *generateParams) error {
+		req.OutputParsers = append(req.OutputParsers, parser)
+		return nil
+	}
+}
+
+// parsedOutputKey is the key in [ModelResponse.Custom] under which
+// the result of the output parsers is stored.
+const parsedOutputKey = "parsedOutput"
+
+// ParsedOutput returns the result of the parsers given with
+// [WithOutputParser], or nil if there were none.
+func (gr *ModelResponse) ParsedOutput() any {
+	return customValue[any](gr, parsedOutputKey)
+}
+
+// parseOutput runs the parsers on the text of resp, and returns a copy
+// of resp that records their result. resp itself is not modified,
+// since it may be shared, as by a semantic cache.
+func parseOutput(resp *ModelResponse, parsers []OutputParser) (*ModelResponse, error) {
+	var v any = resp.Text()
+	for i, parse := range parsers {
+		text, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("output parser %d returned %T, but parser %d needs a string", i-1, v, i)
+		}
+		var err error
+		if v, err = parse(text); err != nil {
+			return nil, fmt.Errorf("output parser %d: %w", i, err)
+		}
+	}
+	r := *resp
+	if custom, ok := resp.Custom.(map[string]any); ok {
+		r.Custom = maps.Clone(custom)
+	}
+	r.SetCustomValue(parsedOutputKey, v)
+	return &r, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWithOutputParser(t *testing.T) {
+	m := DefineModel("test", "fenced", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("```json\n{\"name\": \"Ada\", \"age\": 36}\n```\n")}, nil
+	})
+	stripFences := func(text string) (any, error) {
+		text = strings.TrimSpace(text)
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimSuffix(text, "```")
+		return strings.TrimSpace(text), nil
+	}
+	parseJSON := func(text string) (any, error) {
+		var v map[string]any
+		err := json.Unmarshal([]byte(text), &v)
+		return v, err
+	}
+
+	t.Run("chain", func(t *testing.T) {
+		resp, err := Generate(context.Background(), m,
+			WithTextPrompt("Who?"), WithOutputParser(stripFences), WithOutputParser(parseJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]any{"name": "Ada", "age": 36.0}
+		if diff := cmp.Diff(want, resp.ParsedOutput()); diff != "" {
+			t.Errorf("mismatch (-want, +got):\n%s", diff)
+		}
+	})
+	t.Run("parser error", func(t *testing.T) {
+		_, err := Generate(context.Background(), m,
+			WithTextPrompt("Who?"), WithOutputParser(parseJSON))
+		errorContains(t, err, "output parser 0")
+	})
+	t.Run("non-string input", func(t *testing.T) {
+		_, err := Generate(context.Background(), m,
+			WithTextPrompt("Who?"), WithOutputParser(stripFences), WithOutputParser(parseJSON), WithOutputParser(stripFences))
+		errorContains(t, err, "parser 2 needs a string")
+	})
+	t.Run("no parser", func(t *testing.T) {
+		resp, err := Generate(context.Background(), m, WithTextPrompt("Who?"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.ParsedOutput(); got != nil {
+			t.Errorf("got %v, want nil", got)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import "sync"
+
+// estimatedCostKey is the key in [GenerationUsage.Custom]
+// under which the estimated cost of a response is recorded.
+const estimatedCostKey = "estimatedCost"
+
+// modelPricing is the price of a model, in dollars per 1000 tokens.
+type modelPricing struct {
+	inputPer1k, outputPer1k float64
+}
+
+var pricing struct {
+	mu     sync.Mutex
+	models map[string]modelPricing // keyed by "provider/model"
+}
+
+// RegisterPricing sets the price of a model, in dollars per 1000 input
+// and output tokens. Responses from the model that report token usage
+// then carry a cost estimate; see [ModelResponse.EstimatedCost].
+// Registering a model again replaces its price.
+func RegisterPricing(provider, model string, inputPer1k, outputPer1k float64) {
+	pricing.mu.Lock()
+	defer pricing.mu.Unlock()
+	if pricing.models == nil {
+		pricing.models = map[string]modelPricing{}
+	}
+	pricing.models[provider+"/"+model] = modelPricing{inputPer1k, outputPer1k}
+}
+
+// recordCost adds the estimated cost of resp to its usage,
+// if the named model has a registered price and resp reports usage.
+func recordCost(name string, resp *ModelResponse) {
+	if resp.Usage == nil {
+		return
+	}
+	pricing.mu.Lock()
+	p, ok := pricing.models[name]
+	pricing.mu.Unlock()
+	if !ok {
+		return
+	}
+	cost := (float64(resp.Usage.InputTokens)*p.inputPer1k + float64(resp.Usage.OutputTokens)*p.outputPer1k) / 1000
+	if resp.Usage.Custom == nil {
+		resp.Usage.Custom = map[string]float64{}
+	}
+	resp.Usage.Custom[estimatedCostKey] = cost
+}
+
+// EstimatedCost returns the estimated cost of the response in dollars,
+// computed from its token usage and the price registered for the model
+// with [RegisterPricing]. It reports false if there is no estimate,
+// because the model has no registered price or did not report usage.
+func (gr *ModelResponse) EstimatedCost() (float64, bool) {
+	if gr.Usage == nil {
+		return 0, false
+	}
+	cost, ok := gr.Usage.Custom[estimatedCostKey]
+	return cost, ok
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestEstimatedCost(t *testing.T) {
+	usage := func() *GenerationUsage {
+		return &GenerationUsage{InputTokens: 1500, OutputTokens: 400}
+	}
+	priced := DefineModel("test", "priced", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("ok"), Usage: usage()}, nil
+	})
+	unpriced := DefineModel("test", "unpriced", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("ok"), Usage: usage()}, nil
+	})
+	RegisterPricing("test", "priced", 0.5, 1.5)
+
+	resp, err := Generate(context.Background(), priced, WithTextPrompt("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := resp.EstimatedCost()
+	if !ok {
+		t.Fatal("no cost estimate for a priced model")
+	}
+	// 1.5k input tokens at $0.5 plus 0.4k output tokens at $1.5.
+	if want := 1.35; math.Abs(got-want) > 1e-9 {
+		t.Errorf("got cost %g, want %g", got, want)
+	}
+
+	resp, err = Generate(context.Background(), unpriced, WithTextPrompt("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, ok := resp.EstimatedCost(); ok {
+		t.Errorf("got cost %g for a model with no price, want none", cost)
+	}
+}
@@ -17,7 +17,9 @@ package ai
 import (
 	"context"
 	"errors"
+	"fmt"
 	"maps"
+	"strings"
 
 	"github.com/firebase/genkit/go/core"
 	"github.com/firebase/genkit/go/internal/atype"
@@ -60,3 +62,60 @@ func (p *Prompt) Render(ctx context.Context, input any) (*ModelRequest, error) {
 	}
 	return (*core.Action[any, *ModelRequest, struct{}])(p).Run(ctx, input, nil)
 }
+
+// A PromptCase is a test case for a [Prompt], checked by [TestPrompt].
+type PromptCase struct {
+	// Name identifies the case in errors. If empty, the case is
+	// identified by its index.
+	Name string
+	// Input is the input with which the prompt is rendered.
+	Input any
+	// Want is the expected text of the rendered messages, each message's
+	// text separated from the next by a blank line. It is ignored if
+	// Match is set.
+	Want string
+	// Match, if non-nil, checks the rendered request, returning an error
+	// that describes how it differs from what was expected.
+	Match func(*ModelRequest) error
+}
+
+// TestPrompt renders p with the input of each case, and checks the result
+// against the case, so that prompts can be tested without calling a model.
+// It returns an error describing every case that failed, or nil if all
+// cases passed. In a Go test, use it like this:
+//
+//	if err := ai.TestPrompt(ctx, p, cases); err != nil {
+//		t.Error(err)
+//	}
+func TestPrompt(ctx context.Context, p *Prompt, cases []PromptCase) error {
+	var errs []error
+	for i, c := range cases {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		if err := c.check(ctx, p); err != nil {
+			errs = append(errs, fmt.Errorf("prompt case %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// check renders p with the case's input and checks the result.
+func (c *PromptCase) check(ctx context.Context, p *Prompt) error {
+	req, err := p.Render(ctx, c.Input)
+	if err != nil {
+		return err
+	}
+	if c.Match != nil {
+		return c.Match(req)
+	}
+	texts := make([]string, len(req.Messages))
+	for i, m := range req.Messages {
+		texts[i] = m.Text()
+	}
+	if got := strings.Join(texts, "\n\n"); got != c.Want {
+		return fmt.Errorf("rendered\n%s\nwant\n%s", got, c.Want)
+	}
+	return nil
+}
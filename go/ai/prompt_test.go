@@ -0,0 +1,81 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/invopop/jsonschema"
+)
+
+func TestTestPrompt(t *testing.T) {
+	p := DefinePrompt("test", "greeting", nil, &jsonschema.Schema{Type: "object"}, func(_ context.Context, input any) (*ModelRequest, error) {
+		name := input.(map[string]any)["name"]
+		return &ModelRequest{Messages: []*Message{
+			NewSystemTextMessage("Be brief."),
+			NewUserTextMessage(fmt.Sprintf("Say hello to %s.", name)),
+		}}, nil
+	})
+	ctx := context.Background()
+
+	t.Run("pass", func(t *testing.T) {
+		err := TestPrompt(ctx, p, []PromptCase{
+			{
+				Name:  "want",
+				Input: map[string]any{"name": "Ada"},
+				Want:  "Be brief.\n\nSay hello to Ada.",
+			},
+			{
+				Name:  "match",
+				Input: map[string]any{"name": "Grace"},
+				Match: func(req *ModelRequest) error {
+					if got := req.Messages[1].Text(); !strings.Contains(got, "Grace") {
+						return fmt.Errorf("user message %q doesn't mention Grace", got)
+					}
+					return nil
+				},
+			},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("fail", func(t *testing.T) {
+		err := TestPrompt(ctx, p, []PromptCase{
+			{
+				Input: map[string]any{"name": "Ada"},
+				Want:  "Be brief.\n\nSay hello to Ada.",
+			},
+			{
+				Input: map[string]any{"name": "Ada"},
+				Want:  "Say hi to Ada.",
+			},
+			{
+				Name:  "matcher",
+				Input: map[string]any{"name": "Ada"},
+				Match: func(*ModelRequest) error { return errors.New("no greeting") },
+			},
+		})
+		errorContains(t, err, "prompt case 1: rendered\nBe brief.\n\nSay hello to Ada.\nwant\nSay hi to Ada.")
+		errorContains(t, err, "prompt case matcher: no greeting")
+		if strings.Contains(err.Error(), "prompt case 0") {
+			t.Errorf("error %q reports the passing case", err)
+		}
+	})
+}
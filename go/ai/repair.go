@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/internal/base"
+)
+
+// RepairJSON extracts a JSON value from raw model output, fixing common
+// mistakes: Markdown code fences, prose before or after the value,
+// and trailing commas in objects and arrays.
+// If schema is non-nil, the result is validated against it.
+// RepairJSON returns the repaired JSON text, or an error if no valid
+// value could be recovered.
+func RepairJSON(raw string, schema map[string]any) (string, error) {
+	text := strings.TrimSpace(base.ExtractJSONFromMarkdown(raw))
+	if !json.Valid([]byte(text)) {
+		if v, ok := extractJSONValue(text); ok {
+			text = v
+		}
+	}
+	if !json.Valid([]byte(text)) {
+		text = removeTrailingCommas(text)
+	}
+	if schema == nil {
+		var v any
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			return "", fmt.Errorf("data is not valid JSON: %w", err)
+		}
+		return text, nil
+	}
+	schemaBytes, err := json.Marshal(schema)
+	if err != nil {
+		return "", fmt.Errorf("expected schema is not valid: %w", err)
+	}
+	if err := base.ValidateRaw([]byte(text), schemaBytes); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// extractJSONValue returns the first JSON object or array in s,
+// ignoring any text around it.
+// It reports false if s contains no '{' or '['.
+// If the value is not closed, the rest of s is returned.
+func extractJSONValue(s string) (string, bool) {
+	start := strings.IndexAny(s, "{[")
+	if start < 0 {
+		return "", false
+	}
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return s[start : i+1], true
+			}
+		}
+	}
+	return s[start:], true
+}
+
+// removeTrailingCommas removes commas that directly precede
+// (ignoring whitespace) a closing '}' or ']' outside of strings.
+func removeTrailingCommas(s string) string {
+	var sb strings.Builder
+	inString := false
+	escaped := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			sb.WriteByte(c)
+			continue
+		}
+		if c == '"' {
+			inString = true
+		}
+		if c == ',' {
+			rest := strings.TrimLeft(s[i+1:], " \t\r\n")
+			if strings.HasPrefix(rest, "}") || strings.HasPrefix(rest, "]") {
+				continue
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRepairJSON(t *testing.T) {
+	schema := map[string]any{
+		"type":     "object",
+		"required": []any{"name"},
+		"properties": map[string]any{
+			"name": map[string]any{"type": "string"},
+			"tags": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+	}
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "valid",
+			raw:  `{"name": "a"}`,
+			want: `{"name": "a"}`,
+		},
+		{
+			name: "prose-wrapped",
+			raw:  `Sure! Here is the JSON: {"name": "a {b}"} Let me know if you need more.`,
+			want: `{"name": "a {b}"}`,
+		},
+		{
+			name: "code-fenced",
+			raw:  "Here you go:\n```json\n{\"name\": \"a\"}\n```\n",
+			want: `{"name": "a"}`,
+		},
+		{
+			name: "trailing commas",
+			raw:  `{"name": "a, }", "tags": ["x", "y",],}`,
+			want: `{"name": "a, }", "tags": ["x", "y"]}`,
+		},
+		{
+			name: "all of the above",
+			raw:  "Result:\n```\n{\"name\": \"a\",\n}\n```",
+			want: "{\"name\": \"a\"\n}",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := RepairJSON(test.raw, schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	t.Run("schema mismatch", func(t *testing.T) {
+		_, err := RepairJSON(`{"tags": []}`, schema)
+		errorContains(t, err, "data did not match expected schema")
+	})
+	t.Run("no JSON", func(t *testing.T) {
+		_, err := RepairJSON("I don't know.", nil)
+		errorContains(t, err, "data is not valid JSON")
+	})
+}
+
+func TestGenerateDataRepairTurn(t *testing.T) {
+	calls := 0
+	m := DefineModel("test", "repair", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		calls++
+		text := "I can't produce that."
+		if calls > 1 {
+			text = `{"Name": "foo", "Backstory": "bar"}`
+		}
+		return &ModelResponse{Request: req, Message: NewModelTextMessage(text)}, nil
+	})
+
+	var char GameCharacter
+	_, err := GenerateData(context.Background(), m, &char, WithTextPrompt("make a character"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("model called %d times, want 2", calls)
+	}
+	if char.Name != "foo" || char.Backstory != "bar" {
+		t.Errorf("got %+v", char)
+	}
+}
+
+func TestGenerateDataNoRepairTurn(t *testing.T) {
+	calls := 0
+	m := DefineModel("test", "prose", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		calls++
+		return &ModelResponse{Request: req, Message: NewModelTextMessage(`Here: {"Name": "foo", "Backstory": "bar",}`)}, nil
+	})
+
+	var char GameCharacter
+	if _, err := GenerateData(context.Background(), m, &char, WithTextPrompt("make a character")); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("model called %d times, want 1", calls)
+	}
+	if char.Name != "foo" {
+		t.Errorf("got %+v", char)
+	}
+}
+
+func TestGenerateWithSchema(t *testing.T) {
+	t.Run("one repair turn", func(t *testing.T) {
+		var reqs []*ModelRequest
+		m := DefineModel("test", "schemaRepair", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+			reqs = append(reqs, req)
+			text := `{"Name": "foo"`
+			if len(reqs) > 1 {
+				text = `{"Name": "foo", "Backstory": "bar"}`
+			}
+			return &ModelResponse{Request: req, Message: NewModelTextMessage(text)}, nil
+		})
+
+		resp, err := Generate(context.Background(), m,
+			WithTextPrompt("make a character"),
+			WithSchema(GameCharacter{}, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reqs) != 2 {
+			t.Fatalf("model called %d times, want 2", len(reqs))
+		}
+		last := reqs[1].Messages[len(reqs[1].Messages)-1]
+		if !strings.Contains(last.Text(), "Your previous response was not valid") {
+			t.Errorf("repair turn does not report the validation error:\n%s", last.Text())
+		}
+		var char GameCharacter
+		if err := resp.UnmarshalOutput(&char); err != nil {
+			t.Fatal(err)
+		}
+		if char.Name != "foo" || char.Backstory != "bar" {
+			t.Errorf("got %+v", char)
+		}
+	})
+	t.Run("repairs exhausted", func(t *testing.T) {
+		calls := 0
+		m := DefineModel("test", "schemaNoRepair", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+			calls++
+			return &ModelResponse{Request: req, Message: NewModelTextMessage("no")}, nil
+		})
+
+		_, err := Generate(context.Background(), m,
+			WithTextPrompt("make a character"),
+			WithSchema(GameCharacter{}, 2))
+		errorContains(t, err, "matching expected schema")
+		if calls != 3 {
+			t.Errorf("model called %d times, want 3", calls)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Rerank orders docs by their relevance to query, as judged by m,
+// and returns the topK most relevant. If topK is not positive or
+// exceeds the number of docs, all docs are returned.
+// It is typically used to refine the results of a [Retriever].
+//
+// Each document is scored separately: m is asked for the relevance of the
+// document to the query as a number between 0 and 1, and must reply with
+// just that number. The documents are scored concurrently.
+// Documents with equal scores keep their original order.
+func Rerank(ctx context.Context, m Model, query string, docs []*Document, topK int) ([]*Document, error) {
+	scores := make([]float64, len(docs))
+	errs := make([]error, len(docs))
+	var wg sync.WaitGroup
+	for i, doc := range docs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scores[i], errs[i] = relevanceScore(ctx, m, query, doc)
+		}()
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("Rerank: document %d: %w", i, err)
+		}
+	}
+
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(scores[b], scores[a]) // descending
+	})
+	if topK <= 0 || topK > len(order) {
+		topK = len(order)
+	}
+	ranked := make([]*Document, topK)
+	for i := range ranked {
+		ranked[i] = docs[order[i]]
+	}
+	return ranked, nil
+}
+
+// relevanceScore asks m for the relevance of doc to query.
+func relevanceScore(ctx context.Context, m Model, query string, doc *Document) (float64, error) {
+	var text strings.Builder
+	for _, p := range doc.Content {
+		if p.IsText() {
+			text.WriteString(p.Text)
+		}
+	}
+	prompt := fmt.Sprintf(`Rate how relevant the document is to the query, as a number between 0 and 1,
+where 0 is irrelevant and 1 is highly relevant. Reply with only the number.
+
+Query: %s
+
+Document: %s`, query, text.String())
+	resp, err := Generate(ctx, m, WithTextPrompt(prompt))
+	if err != nil {
+		return 0, err
+	}
+	reply := strings.TrimSpace(resp.Text())
+	score, err := strconv.ParseFloat(reply, 64)
+	if err != nil {
+		return 0, fmt.Errorf("model %s replied %q, not a relevance score", m.Name(), reply)
+	}
+	return score, nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRerank(t *testing.T) {
+	// The fake model scores a document by the number of times
+	// it mentions "pizza".
+	scores := map[int]string{0: "0.1", 1: "0.5", 2: "0.9"}
+	m := DefineModel("test", "reranker", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		_, doc, _ := strings.Cut(req.Messages[0].Text(), "Document: ")
+		score := scores[min(strings.Count(doc, "pizza"), 2)]
+		return &ModelResponse{Request: req, Message: NewModelTextMessage(score)}, nil
+	})
+	docs := []*Document{
+		DocumentFromText("soup of the day", nil),
+		DocumentFromText("pizza and pizza", nil),
+		DocumentFromText("salad", nil),
+		DocumentFromText("pizza", nil),
+	}
+	text := func(ds []*Document) []string {
+		var ts []string
+		for _, d := range ds {
+			ts = append(ts, d.Content[0].Text)
+		}
+		return ts
+	}
+
+	got, err := Rerank(context.Background(), m, "pizza", docs, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"pizza and pizza", "pizza", "soup of the day"}
+	if diff := cmp.Diff(want, text(got)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	got, err = Rerank(context.Background(), m, "pizza", docs, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"pizza and pizza", "pizza", "soup of the day", "salad"}
+	if diff := cmp.Diff(want, text(got)); diff != "" {
+		t.Errorf("topK 0: mismatch (-want, +got):\n%s", diff)
+	}
+
+	bad := DefineModel("test", "badReranker", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("very relevant")}, nil
+	})
+	_, err = Rerank(context.Background(), bad, "pizza", docs, 1)
+	errorContains(t, err, `replied "very relevant", not a relevance score`)
+}
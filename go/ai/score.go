@@ -0,0 +1,124 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A Scorer compares generated output with a reference answer,
+// for evaluating prompts and models.
+type Scorer interface {
+	// Score returns how well output matches reference, from 0 (not at all)
+	// to 1 (perfectly).
+	Score(ctx context.Context, output, reference string) (float64, error)
+}
+
+// ScorerFunc adapts a function to a [Scorer].
+type ScorerFunc func(ctx context.Context, output, reference string) (float64, error)
+
+// Score calls f(ctx, output, reference).
+func (f ScorerFunc) Score(ctx context.Context, output, reference string) (float64, error) {
+	return f(ctx, output, reference)
+}
+
+// Score scores output against reference with scorer.
+// It is useful in tests that guard against regressions in prompts:
+//
+//	s, err := ai.Score(ctx, resp.Text(), "Paris", ai.TokenF1Scorer())
+//	if err != nil { ... }
+//	if s < 0.8 { t.Errorf(...) }
+func Score(ctx context.Context, output, reference string, scorer Scorer) (float64, error) {
+	s, err := scorer.Score(ctx, output, reference)
+	if err != nil {
+		return 0, fmt.Errorf("Score: %w", err)
+	}
+	return s, nil
+}
+
+// ExactMatchScorer returns a [Scorer] that scores 1 if the output equals
+// the reference, ignoring leading and trailing white space, and 0 otherwise.
+func ExactMatchScorer() Scorer {
+	return ScorerFunc(func(_ context.Context, output, reference string) (float64, error) {
+		if strings.TrimSpace(output) == strings.TrimSpace(reference) {
+			return 1, nil
+		}
+		return 0, nil
+	})
+}
+
+// TokenF1Scorer returns a [Scorer] that scores the F1 measure of the words
+// of the output and the reference: the harmonic mean of the fraction of
+// output words that are in the reference and the fraction of reference
+// words that are in the output. Words are compared case-insensitively,
+// and punctuation is ignored.
+func TokenF1Scorer() Scorer {
+	return ScorerFunc(func(_ context.Context, output, reference string) (float64, error) {
+		return tokenF1(scoreTokens(output), scoreTokens(reference)), nil
+	})
+}
+
+// scoreTokens splits s into lower-case words.
+func scoreTokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func tokenF1(output, reference []string) float64 {
+	if len(output) == 0 || len(reference) == 0 {
+		if len(output) == len(reference) {
+			return 1
+		}
+		return 0
+	}
+	counts := map[string]int{}
+	for _, w := range reference {
+		counts[w]++
+	}
+	common := 0
+	for _, w := range output {
+		if counts[w] > 0 {
+			counts[w]--
+			common++
+		}
+	}
+	if common == 0 {
+		return 0
+	}
+	precision := float64(common) / float64(len(output))
+	recall := float64(common) / float64(len(reference))
+	return 2 * precision * recall / (precision + recall)
+}
+
+// EmbeddingScorer returns a [Scorer] that scores the cosine similarity of
+// the embeddings of the output and the reference, computed by e.
+// Unlike the other scorers, it credits answers that are worded
+// differently from the reference but mean the same thing.
+func EmbeddingScorer(e Embedder) Scorer {
+	return ScorerFunc(func(ctx context.Context, output, reference string) (float64, error) {
+		resp, err := Embed(ctx, e, WithEmbedText(output, reference))
+		if err != nil {
+			return 0, err
+		}
+		if len(resp.Embeddings) != 2 {
+			return 0, fmt.Errorf("embedder %s returned %d embeddings, want 2", e.Name(), len(resp.Embeddings))
+		}
+		return cosineSimilarity(resp.Embeddings[0].Embedding, resp.Embeddings[1].Embedding), nil
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	vectors := map[string][]float32{
+		"Paris":                 {1, 0},
+		"The capital is Paris.": {1, 1},
+		"Berlin":                {0, 1},
+	}
+	emb := DefineEmbedder("test", "scorer", func(_ context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+		resp := &EmbedResponse{}
+		for _, doc := range req.Documents {
+			v, ok := vectors[doc.Content[0].Text]
+			if !ok {
+				return nil, fmt.Errorf("no vector for %q", doc.Content[0].Text)
+			}
+			resp.Embeddings = append(resp.Embeddings, &DocumentEmbedding{Embedding: v})
+		}
+		return resp, nil
+	})
+
+	for _, test := range []struct {
+		desc              string
+		scorer            Scorer
+		output, reference string
+		want              float64
+	}{
+		{"exact match", ExactMatchScorer(), " Paris\n", "Paris", 1},
+		{"exact mismatch", ExactMatchScorer(), "paris", "Paris", 0},
+		{"token F1", TokenF1Scorer(), "The capital is Paris.", "paris", 0.4},
+		{"token F1 disjoint", TokenF1Scorer(), "Berlin", "Paris", 0},
+		{"embedding same", EmbeddingScorer(emb), "Paris", "Paris", 1},
+		{"embedding similar", EmbeddingScorer(emb), "The capital is Paris.", "Paris", 1 / math.Sqrt2},
+		{"embedding orthogonal", EmbeddingScorer(emb), "Berlin", "Paris", 0},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := Score(context.Background(), test.output, test.reference, test.scorer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(got-test.want) > 1e-6 {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	_, err := Score(context.Background(), "Rome", "Paris", EmbeddingScorer(emb))
+	errorContains(t, err, `no vector for "Rome"`)
+}
@@ -0,0 +1,195 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// A SemanticCacheStore stores model responses together with the
+// embedding of the prompt that produced them, for use by [WithSemanticCache].
+// Responses are grouped by scope, an opaque string derived from the
+// model name, the request's config, tools and output settings, the roles
+// and non-text parts of its messages, and its context documents;
+// only responses in the same scope are compared.
+type SemanticCacheStore interface {
+	// Search returns the response stored under scope whose embedding is
+	// most similar to embedding, and its cosine similarity.
+	// It reports false if there is no response stored under scope.
+	Search(ctx context.Context, scope string, embedding []float32) (_ *ModelResponse, similarity float64, ok bool)
+	// Put stores a response and the embedding of its prompt under scope.
+	Put(ctx context.Context, scope string, embedding []float32, resp *ModelResponse)
+}
+
+// NewMemorySemanticCacheStore returns an in-memory [SemanticCacheStore].
+// Search compares the embedding with every stored one, so it is suited
+// to small caches.
+func NewMemorySemanticCacheStore() SemanticCacheStore {
+	return &memorySemanticCacheStore{entries: map[string][]semanticCacheEntry{}}
+}
+
+type memorySemanticCacheStore struct {
+	mu      sync.Mutex
+	entries map[string][]semanticCacheEntry
+}
+
+type semanticCacheEntry struct {
+	embedding []float32
+	resp      *ModelResponse
+}
+
+func (s *memorySemanticCacheStore) Search(_ context.Context, scope string, embedding []float32) (*ModelResponse, float64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var best *ModelResponse
+	bestSim := math.Inf(-1)
+	for _, e := range s.entries[scope] {
+		if sim := cosineSimilarity(embedding, e.embedding); sim > bestSim {
+			best, bestSim = e.resp, sim
+		}
+	}
+	if best == nil {
+		return nil, 0, false
+	}
+	return best, bestSim, true
+}
+
+func (s *memorySemanticCacheStore) Put(_ context.Context, scope string, embedding []float32, resp *ModelResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[scope] = append(s.entries[scope], semanticCacheEntry{embedding, resp})
+}
+
+// cosineSimilarity returns the cosine similarity of a and b,
+// or 0 if they differ in length or either is zero.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// semanticCache holds the arguments of [WithSemanticCache].
+type semanticCache struct {
+	embedder  Embedder
+	store     SemanticCacheStore
+	threshold float64
+}
+
+// WithSemanticCache returns a stored response instead of calling the model
+// when a previous request's prompt is similar enough to this one.
+// The text of the request's messages is embedded with embedder, and the most
+// similar stored prompt for the same model and settings is found in store.
+// Only the text is compared by similarity: prompts with different media,
+// context documents or message roles never share a response.
+// If its cosine similarity is at least threshold, its response is returned;
+// otherwise the model is called and the response is stored.
+// A streaming callback receives a cached response as a single chunk.
+func WithSemanticCache(embedder Embedder, store SemanticCacheStore, threshold float64) GenerateOption {
+	return func(req *generateParams) error {
+		if req.SemanticCache != nil {
+			return errors.New("cannot set semantic cache (WithSemanticCache) more than once")
+		}
+		if threshold <= 0 || threshold > 1 {
+			return fmt.Errorf("WithSemanticCache: threshold must be in (0, 1], got %g", threshold)
+		}
+		req.SemanticCache = &semanticCache{embedder, store, threshold}
+		return nil
+	}
+}
+
+// lookup returns the cached response for req, if any, along with the
+// scope and embedding under which a new response should be stored.
+func (c *semanticCache) lookup(ctx context.Context, modelName string, req *ModelRequest) (_ *ModelResponse, scope string, embedding []float32, _ error) {
+	scope, err := semanticCacheScope(modelName, req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	eresp, err := c.embedder.Embed(ctx, &EmbedRequest{
+		Documents: []*Document{DocumentFromText(promptText(req), nil)},
+	})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("semantic cache: %w", err)
+	}
+	if len(eresp.Embeddings) != 1 {
+		return nil, "", nil, fmt.Errorf("semantic cache: embedder %s returned %d embeddings for 1 document", c.embedder.Name(), len(eresp.Embeddings))
+	}
+	embedding = eresp.Embeddings[0].Embedding
+	if cached, sim, ok := c.store.Search(ctx, scope, embedding); ok && sim >= c.threshold {
+		// Copy the cached response rather than modifying it.
+		resp := *cached
+		resp.Request = req
+		return &resp, scope, embedding, nil
+	}
+	return nil, scope, embedding, nil
+}
+
+// promptText returns the text of the messages of req, one per line.
+func promptText(req *ModelRequest) string {
+	var texts []string
+	for _, m := range req.Messages {
+		texts = append(texts, m.Text())
+	}
+	return strings.Join(texts, "\n")
+}
+
+// semanticCacheScope returns the scope under which responses to req
+// from the named model are cached. It covers everything in req except
+// the text of its messages, which is compared by embedding.
+func semanticCacheScope(modelName string, req *ModelRequest) (string, error) {
+	var roles []Role
+	var parts []*Part // non-text parts, such as media
+	for _, m := range req.Messages {
+		roles = append(roles, m.Role)
+		for _, p := range m.Content {
+			if !p.IsText() {
+				parts = append(parts, p)
+			}
+		}
+	}
+	settings, err := json.Marshal(struct {
+		Config  any                 `json:"config,omitempty"`
+		Tools   []*ToolDefinition   `json:"tools,omitempty"`
+		Output  *ModelRequestOutput `json:"output,omitempty"`
+		Roles   []Role              `json:"roles,omitempty"`
+		Parts   []*Part             `json:"parts,omitempty"`
+		Context []any               `json:"context,omitempty"`
+	}{req.Config, req.Tools, req.Output, roles, parts, req.Context})
+	if err != nil {
+		return "", fmt.Errorf("semantic cache: marshaling request settings: %w", err)
+	}
+	h := sha256.New()
+	h.Write([]byte(modelName))
+	h.Write([]byte{0})
+	h.Write(settings)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
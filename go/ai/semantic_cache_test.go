@@ -0,0 +1,130 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSemanticCache(t *testing.T) {
+	// The fake embedder maps a prompt to a vector of counts of topic words,
+	// so paraphrases about the same topic get similar embeddings.
+	topics := [][]string{{"weather", "forecast", "rain"}, {"pizza", "order", "cheese"}}
+	emb := DefineEmbedder("test", "topics", func(ctx context.Context, req *EmbedRequest) (*EmbedResponse, error) {
+		resp := &EmbedResponse{}
+		for _, doc := range req.Documents {
+			text := strings.ToLower(doc.Content[0].Text)
+			var v []float32
+			for _, words := range topics {
+				n := 0
+				for _, w := range words {
+					n += strings.Count(text, w)
+				}
+				v = append(v, float32(n))
+			}
+			resp.Embeddings = append(resp.Embeddings, &DocumentEmbedding{Embedding: v})
+		}
+		return resp, nil
+	})
+	calls := 0
+	m := DefineModel("test", "semanticCached", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		calls++
+		return &ModelResponse{Request: req, Message: NewModelTextMessage("answer to: " + req.Messages[0].Text())}, nil
+	})
+
+	store := NewMemorySemanticCacheStore()
+	generate := func(prompt string) string {
+		t.Helper()
+		resp, err := Generate(context.Background(), m,
+			WithTextPrompt(prompt),
+			WithSemanticCache(emb, store, 0.9))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Request.Messages[0].Text(); got != prompt {
+			t.Errorf("response request has prompt %q, want %q", got, prompt)
+		}
+		return resp.Text()
+	}
+
+	first := generate("What is the weather forecast?")
+	if calls != 1 {
+		t.Fatalf("model called %d times, want 1", calls)
+	}
+	t.Run("similar prompt hits", func(t *testing.T) {
+		if got := generate("Tell me the weather forecast, will it rain?"); got != first {
+			t.Errorf("got %q, want cached %q", got, first)
+		}
+		if calls != 1 {
+			t.Errorf("model called %d times, want 1", calls)
+		}
+	})
+	t.Run("dissimilar prompt misses", func(t *testing.T) {
+		want := "answer to: I want to order a pizza"
+		if got := generate("I want to order a pizza"); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if calls != 2 {
+			t.Errorf("model called %d times, want 2", calls)
+		}
+	})
+	t.Run("different config misses", func(t *testing.T) {
+		_, err := Generate(context.Background(), m,
+			WithTextPrompt("What is the weather forecast?"),
+			WithConfig(&GenerationCommonConfig{Temperature: 0.1}),
+			WithSemanticCache(emb, store, 0.9))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if calls != 3 {
+			t.Errorf("model called %d times, want 3", calls)
+		}
+	})
+	t.Run("different media misses", func(t *testing.T) {
+		for i, url := range []string{"https://example.com/a.png", "https://example.com/b.png", "https://example.com/b.png"} {
+			_, err := Generate(context.Background(), m,
+				WithMessages(NewUserMessage(NewTextPart("What is the weather forecast in this photo?"), NewMediaPart("image/png", url))),
+				WithSemanticCache(emb, store, 0.9))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The third request repeats the image of the second.
+			if want := 4 + min(i, 1); calls != want {
+				t.Errorf("request %d: model called %d times, want %d", i, calls, want)
+			}
+		}
+	})
+	t.Run("different context documents miss", func(t *testing.T) {
+		before := calls
+		for _, doc := range []string{"Rain all week.", "Sunny all week."} {
+			_, err := Generate(context.Background(), m,
+				WithTextPrompt("What is the weather forecast?"),
+				WithContextDocuments(DocumentFromText(doc, nil)),
+				WithSemanticCache(emb, store, 0.9))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := calls - before; got != 2 {
+			t.Errorf("model called %d times, want 2", got)
+		}
+	})
+	t.Run("bad threshold", func(t *testing.T) {
+		_, err := Generate(context.Background(), m, WithTextPrompt("hi"), WithSemanticCache(emb, store, 1.5))
+		errorContains(t, err, "threshold must be in (0, 1]")
+	})
+}
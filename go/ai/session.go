@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// A SessionStore persists the message history of chat sessions,
+// keyed by session ID. Implementations backed by a database such as
+// Redis or Firestore let a multi-turn conversation span many requests
+// and processes.
+type SessionStore interface {
+	// Load returns the messages of the session with the given ID,
+	// or nil if there is no such session.
+	Load(ctx context.Context, id string) ([]*Message, error)
+	// Save replaces the messages of the session with the given ID.
+	Save(ctx context.Context, id string, messages []*Message) error
+}
+
+// NewMemorySessionStore returns a [SessionStore] that keeps sessions
+// in memory. Sessions are lost when the process exits.
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{sessions: map[string][]*Message{}}
+}
+
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string][]*Message
+}
+
+func (s *memorySessionStore) Load(_ context.Context, id string) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.sessions[id]), nil
+}
+
+func (s *memorySessionStore) Save(_ context.Context, id string, messages []*Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = slices.Clone(messages)
+	return nil
+}
+
+// A Session is the message history of a conversation, persisted in a
+// [SessionStore]. Pass its messages to [WithHistory] when generating
+// the next turn, then append the new messages:
+//
+//	s, err := ai.LoadSession(ctx, store, sessionID)
+//	...
+//	resp, err := ai.Generate(ctx, model, ai.WithHistory(s.Messages()...), ai.WithTextPrompt(question))
+//	...
+//	err = s.Append(ctx, ai.NewUserTextMessage(question), resp.Message)
+type Session struct {
+	id    string
+	store SessionStore
+
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// LoadSession returns the session with the given ID from store.
+// If there is no such session, it returns an empty one.
+func LoadSession(ctx context.Context, store SessionStore, id string) (*Session, error) {
+	msgs, err := store.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{id: id, store: store, messages: msgs}, nil
+}
+
+// ID returns the ID of the session.
+func (s *Session) ID() string { return s.id }
+
+// Messages returns the messages of the session, oldest first.
+func (s *Session) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.messages)
+}
+
+// Append adds messages to the end of the session and saves it to the store.
+// If saving fails, the session is left unchanged.
+func (s *Session) Append(ctx context.Context, messages ...*Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msgs := append(slices.Clip(s.messages), messages...)
+	if err := s.store.Save(ctx, s.id, msgs); err != nil {
+		return err
+	}
+	s.messages = msgs
+	return nil
+}
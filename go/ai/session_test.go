@@ -0,0 +1,65 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSession(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemorySessionStore()
+
+	// handle simulates one request of a chat flow: it loads the session,
+	// generates a reply using the history, and records the new turn.
+	handle := func(sessionID, question string) []*Message {
+		s, err := LoadSession(ctx, store, sessionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := Generate(ctx, echoModel, WithHistory(s.Messages()...), WithTextPrompt(question))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Append(ctx, NewUserTextMessage(question), NewModelTextMessage(resp.Text())); err != nil {
+			t.Fatal(err)
+		}
+		return s.Messages()
+	}
+
+	handle("s1", "hello")
+	got := handle("s1", "again")
+	want := []*Message{
+		NewUserTextMessage("hello"),
+		NewModelTextMessage("hello"),
+		NewUserTextMessage("again"),
+		// The echo model repeats all user messages, including the history.
+		NewModelTextMessage("helloagain"),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	other, err := LoadSession(ctx, store, "s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(other.Messages()); n != 0 {
+		t.Errorf("new session has %d messages, want 0", n)
+	}
+}
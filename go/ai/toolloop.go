@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/internal/base"
+)
+
+// WithToolLoopLimit stops the tool loop with a [*ToolLoopError] when the
+// model has requested the same tool call, that is, the same tool with the
+// same input, n times. A model that keeps repeating a call is usually
+// stuck, and stopping it early saves tokens. n must be at least 2.
+func WithToolLoopLimit(n int) GenerateOption {
+	return func(req *generateParams) error {
+		if req.ToolLoopLimit != 0 {
+			return errors.New("cannot set tool loop limit (WithToolLoopLimit) more than once")
+		}
+		if n < 2 {
+			return fmt.Errorf("WithToolLoopLimit: limit must be at least 2, got %d", n)
+		}
+		req.ToolLoopLimit = n
+		return nil
+	}
+}
+
+// toolLoopLimitKey is set by [WithToolLoopLimit] while the model's
+// tool loop runs.
+var toolLoopLimitKey = base.NewContextKey[int]()
+
+// A ToolLoopError is returned by [Generate] when the model repeats a tool
+// call as many times as the limit set by [WithToolLoopLimit].
+type ToolLoopError struct {
+	// Requests are the tool calls that reached the limit.
+	Requests []*ToolRequest
+	// Count is the number of times each was requested.
+	Count int
+	// Response is the response that made the last request.
+	Response *ModelResponse
+}
+
+func (e *ToolLoopError) Error() string {
+	calls := make([]string, len(e.Requests))
+	for i, tr := range e.Requests {
+		input, _ := json.Marshal(tr.Input)
+		calls[i] = fmt.Sprintf("%s(%s)", tr.Name, input)
+	}
+	return fmt.Sprintf("tool loop detected: model requested the same tool call %d times: %s", e.Count, strings.Join(calls, ", "))
+}
+
+// toolLoopDetector counts the tool calls requested during a tool loop.
+// A nil *toolLoopDetector detects nothing.
+type toolLoopDetector struct {
+	limit  int
+	counts map[string]int // keyed by tool name and JSON input
+}
+
+// newToolLoopDetector returns a detector for the given limit,
+// or nil if limit is zero.
+func newToolLoopDetector(limit int) *toolLoopDetector {
+	if limit == 0 {
+		return nil
+	}
+	return &toolLoopDetector{limit: limit, counts: map[string]int{}}
+}
+
+// observe counts the tool calls that resp requests, returning a
+// *ToolLoopError if any has reached the limit.
+func (d *toolLoopDetector) observe(resp *ModelResponse) error {
+	if d == nil {
+		return nil
+	}
+	var repeated []*ToolRequest
+	for _, tr := range resp.ToolRequests() {
+		// Inputs are compared by their JSON encoding,
+		// which has the keys of maps in sorted order.
+		input, err := json.Marshal(tr.Input)
+		if err != nil {
+			continue
+		}
+		key := tr.Name + "\x00" + string(input)
+		d.counts[key]++
+		if d.counts[key] == d.limit {
+			repeated = append(repeated, tr)
+		}
+	}
+	if len(repeated) > 0 {
+		return &ToolLoopError{Requests: repeated, Count: d.limit, Response: resp}
+	}
+	return nil
+}
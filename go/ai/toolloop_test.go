@@ -0,0 +1,95 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestGenerateToolLoopLimit(t *testing.T) {
+	toolRuns := 0
+	lookup := DefineTool("loopLookup", "looks up a city",
+		func(ctx context.Context, input struct{ City string }) (string, error) {
+			toolRuns++
+			return "unknown", nil
+		},
+	)
+	// The model asks for the same city until it has made maxCalls calls,
+	// or for a different city each time if vary is true.
+	var modelCalls, maxCalls int
+	var vary bool
+	m := DefineModel("test", "looper", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		modelCalls++
+		if modelCalls > maxCalls {
+			return &ModelResponse{Request: req, Message: NewModelTextMessage("gave up")}, nil
+		}
+		city := "Paris"
+		if vary {
+			city += string(rune('A' + modelCalls))
+		}
+		return &ModelResponse{
+			Request: req,
+			Message: &Message{
+				Role: RoleModel,
+				Content: []*Part{NewToolRequestPart(&ToolRequest{
+					Name:  "loopLookup",
+					Input: map[string]any{"City": city},
+				})},
+			},
+		}, nil
+	})
+	run := func(calls int, varied bool, opts ...GenerateOption) (*ModelResponse, error) {
+		toolRuns, modelCalls, maxCalls, vary = 0, 0, calls, varied
+		opts = append(opts, WithTextPrompt("Where is it?"), WithTools(lookup))
+		return Generate(context.Background(), m, opts...)
+	}
+
+	t.Run("loop", func(t *testing.T) {
+		_, err := run(10, false, WithToolLoopLimit(3))
+		var lerr *ToolLoopError
+		if !errors.As(err, &lerr) {
+			t.Fatalf("got error %v, want a *ToolLoopError", err)
+		}
+		errorContains(t, err, `requested the same tool call 3 times: loopLookup({"City":"Paris"})`)
+		if modelCalls != 3 || toolRuns != 2 {
+			t.Errorf("got %d model calls and %d tool runs, want 3 and 2", modelCalls, toolRuns)
+		}
+	})
+	t.Run("different inputs", func(t *testing.T) {
+		resp, err := run(5, true, WithToolLoopLimit(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Text(); got != "gave up" {
+			t.Errorf("got text %q, want %q", got, "gave up")
+		}
+	})
+	t.Run("no limit", func(t *testing.T) {
+		if _, err := run(5, false); err != nil {
+			t.Fatal(err)
+		}
+		if toolRuns != 5 {
+			t.Errorf("got %d tool runs, want 5", toolRuns)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		_, err := run(0, false, WithToolLoopLimit(1))
+		errorContains(t, err, "at least 2")
+		_, err = run(0, false, WithToolLoopLimit(3), WithToolLoopLimit(4))
+		errorContains(t, err, "more than once")
+	})
+}
@@ -18,6 +18,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
 
 	"github.com/firebase/genkit/go/core"
 	"github.com/firebase/genkit/go/internal/action"
@@ -30,7 +34,7 @@ const provider = "local"
 
 // A ToolDef is an implementation of a single tool.
 type ToolDef[In, Out any] struct {
-	action *core.Action[In, Out, struct{}]
+	action action.Action
 }
 
 // toolAction is genericless version of ToolDef. It's required to make
@@ -52,18 +56,157 @@ type Tool interface {
 
 // DefineTool defines a tool function.
 func DefineTool[In, Out any](name, description string, fn func(ctx context.Context, input In) (Out, error)) *ToolDef[In, Out] {
+	return DefineToolInRegistry(registry.Global, name, description, fn)
+}
+
+// DefineToolInRegistry is like [DefineTool], but registers the tool in r.
+// [Generate] finds it when a model requests it during a flow of r.
+func DefineToolInRegistry[In, Out any](r *registry.Registry, name, description string, fn func(ctx context.Context, input In) (Out, error)) *ToolDef[In, Out] {
+	metadata := make(map[string]any)
+	metadata["type"] = "tool"
+	metadata["name"] = name
+	metadata["description"] = description
+
+	f := recordModelOutput(fn)
+	toolAction := core.DefineActionInRegistry(r, provider, name, atype.Tool, metadata, nil,
+		func(ctx context.Context, input In, _ func(context.Context, struct{}) error) (Out, error) {
+			return f(ctx, input)
+		})
+
+	return &ToolDef[In, Out]{
+		action: toolAction,
+	}
+}
+
+// DefineStreamingTool defines a tool function that streams partial
+// results while it runs, such as the matches of a long search.
+// When the tool is run by [Generate] with a streaming callback, each
+// value passed to cb reaches that callback as a [ModelResponseChunk]
+// whose Custom field is a [*ToolChunk], before the tool returns and
+// the model continues. The model sees only the tool's final output.
+// The cb passed to fn is never nil; when no one is listening, it
+// discards the values.
+func DefineStreamingTool[In, Out, Stream any](name, description string, fn func(ctx context.Context, input In, cb func(context.Context, Stream) error) (Out, error)) *ToolDef[In, Out] {
 	metadata := make(map[string]any)
 	metadata["type"] = "tool"
 	metadata["name"] = name
 	metadata["description"] = description
 
-	toolAction := core.DefineAction(provider, name, atype.Tool, metadata, fn)
+	toolAction := core.DefineStreamingAction(provider, name, atype.Tool, metadata,
+		func(ctx context.Context, input In, cb func(context.Context, Stream) error) (Out, error) {
+			if cb == nil {
+				cb = func(context.Context, Stream) error { return nil }
+			}
+			return recordModelOutput(func(ctx context.Context, input In) (Out, error) {
+				return fn(ctx, input, cb)
+			})(ctx, input)
+		})
 
 	return &ToolDef[In, Out]{
 		action: toolAction,
 	}
 }
 
+// A ToolChunk is a partial result streamed by a tool defined with
+// [DefineStreamingTool].
+type ToolChunk struct {
+	// Name is the name of the tool.
+	Name string `json:"name"`
+	// Output is the partial result, decoded from JSON.
+	Output any `json:"output"`
+}
+
+// DefineToolFromFunc defines a tool from a named function or method value,
+// such as weather.Forecast or svc.Forecast, deriving what [DefineTool] is
+// otherwise told:
+//   - The tool's name is the name of the function or method, without its
+//     package or receiver.
+//   - The input schema is reflected from In with all definitions inlined,
+//     which more models accept than a schema with references. Fields are
+//     described with struct tags, like `jsonschema:"description=The city"`.
+//
+// DefineToolFromFunc panics if fn is a function literal, which has no name.
+func DefineToolFromFunc[In, Out any](description string, fn func(ctx context.Context, input In) (Out, error)) *ToolDef[In, Out] {
+	name := funcName(fn)
+	metadata := map[string]any{
+		"type":        "tool",
+		"name":        name,
+		"description": description,
+	}
+	var in In
+	inputSchema := base.InferJSONSchemaNonReferencing(in)
+	inputSchema.ID = ""
+	toolAction := core.DefineActionInRegistry(registry.Global, provider, name, atype.Tool, metadata, inputSchema,
+		func(ctx context.Context, input In, _ func(context.Context, struct{}) error) (Out, error) {
+			return recordModelOutput(fn)(ctx, input)
+		})
+	return &ToolDef[In, Out]{
+		action: toolAction,
+	}
+}
+
+// A ModelMarshaler is a tool output with its own encoding for models.
+// When a tool called by [Generate] returns a ModelMarshaler, the model
+// sees the result of MarshalForModel rather than the output's usual
+// JSON encoding. This is useful for outputs with values, such as times
+// or decimals, that are better presented to a model in another form.
+// The tool's output schema and its callers other than the model are
+// unaffected.
+type ModelMarshaler interface {
+	// MarshalForModel returns the JSON encoding of the value
+	// to pass to the model.
+	MarshalForModel() ([]byte, error)
+}
+
+// modelOutputKey holds where a tool run by the tool loop of [Generate]
+// records the result of MarshalForModel, if its output is a [ModelMarshaler].
+var modelOutputKey = base.NewContextKey[*modelOutput]()
+
+type modelOutput struct {
+	data []byte // JSON encoding for the model; nil if the output is not a ModelMarshaler
+}
+
+// recordModelOutput wraps fn, the function of a tool, to record the
+// encoding for the model of its output.
+func recordModelOutput[In, Out any](fn func(context.Context, In) (Out, error)) func(context.Context, In) (Out, error) {
+	return func(ctx context.Context, input In) (Out, error) {
+		out, err := fn(ctx, input)
+		if err != nil {
+			return out, err
+		}
+		mo := modelOutputKey.FromContext(ctx)
+		if mo == nil {
+			return out, nil
+		}
+		// Overwrite anything recorded by tools that fn ran itself.
+		mo.data = nil
+		if mm, ok := any(out).(ModelMarshaler); ok {
+			data, err := mm.MarshalForModel()
+			if err != nil {
+				return base.Zero[Out](), fmt.Errorf("MarshalForModel: %w", err)
+			}
+			mo.data = data
+		}
+		return out, nil
+	}
+}
+
+// literalFuncName matches the generated names of function literals.
+// Nested literals are numbered without the "func" prefix.
+var literalFuncName = regexp.MustCompile(`^(func)?\d+$`)
+
+// funcName returns the unqualified name of the function or method value fn.
+func funcName(fn any) string {
+	full := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	// Method values have names like "pkg.(*T).Method-fm".
+	full = strings.TrimSuffix(full, "-fm")
+	name := full[strings.LastIndex(full, ".")+1:]
+	if literalFuncName.MatchString(name) {
+		panic(fmt.Sprintf("DefineToolFromFunc: %s is a function literal; use DefineTool to name it", full))
+	}
+	return name
+}
+
 // Action returns the action instance that backs this tools.
 func (ta *ToolDef[In, Out]) Action() action.Action {
 	return ta.action
@@ -96,22 +239,23 @@ func definition(ta Tool) *ToolDefinition {
 // RunRaw runs this tool using the provided raw map format data (JSON parsed
 // as map[string]any).
 func (ta *toolAction) RunRaw(ctx context.Context, input map[string]any) (any, error) {
-	return runAction(ctx, ta, input)
-
+	return runAction(ctx, ta, input, nil)
 }
 
 // RunRaw runs this tool using the provided raw map format data (JSON parsed
 // as map[string]any).
 func (ta *ToolDef[In, Out]) RunRaw(ctx context.Context, input map[string]any) (any, error) {
-	return runAction(ctx, ta, input)
+	return runAction(ctx, ta, input, nil)
 }
 
-func runAction(ctx context.Context, action Tool, input map[string]any) (any, error) {
+// runAction runs the tool with the given input. If cb is non-nil,
+// partial results streamed by the tool are passed to it.
+func runAction(ctx context.Context, action Tool, input map[string]any, cb func(context.Context, json.RawMessage) error) (any, error) {
 	mi, err := json.Marshal(input)
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling tool input for %v: %v", action.Definition().Name, err)
 	}
-	output, err := action.Action().RunJSON(ctx, mi, nil)
+	output, err := action.Action().RunJSON(ctx, mi, cb)
 	if err != nil {
 		return nil, fmt.Errorf("error calling tool %v: %v", action.Definition().Name, err)
 	}
@@ -128,3 +272,28 @@ func runAction(ctx context.Context, action Tool, input map[string]any) (any, err
 func LookupTool(name string) Tool {
 	return &toolAction{action: registry.Global.LookupAction(fmt.Sprintf("/tool/local/%s", name))}
 }
+
+// LookupToolInRegistry looks up the named tool in r, and then, if r
+// doesn't have it, in the global registry. It returns nil if neither has it.
+func LookupToolInRegistry(r *registry.Registry, name string) Tool {
+	key := fmt.Sprintf("/%s/%s/%s", atype.Tool, provider, name)
+	a := r.LookupAction(key)
+	if a == nil && r != registry.Global {
+		a = registry.Global.LookupAction(key)
+	}
+	if a == nil {
+		return nil
+	}
+	return &toolAction{action: a}
+}
+
+// lookupTool returns the tool that a model requested by name: the tool
+// in the registry of the flow running in ctx, if any, or else the global
+// one. It returns nil if there is none.
+func lookupTool(ctx context.Context, name string) Tool {
+	r := registry.FromContext(ctx)
+	if r == nil {
+		r = registry.Global
+	}
+	return LookupToolInRegistry(r, name)
+}
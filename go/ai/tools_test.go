@@ -0,0 +1,183 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type forecastInput struct {
+	City  string        `json:"city" jsonschema:"description=The city to forecast"`
+	Days  int           `json:"days,omitempty" jsonschema:"description=Number of days,minimum=1"`
+	Where forecastPlace `json:"where,omitempty"`
+}
+
+type forecastPlace struct {
+	Country string `json:"country" jsonschema:"description=ISO country code"`
+}
+
+type forecaster struct{ unit string }
+
+func (f *forecaster) Forecast(_ context.Context, in forecastInput) (string, error) {
+	return fmt.Sprintf("%s: 20%s", in.City, f.unit), nil
+}
+
+func TestDefineToolFromFunc(t *testing.T) {
+	f := &forecaster{unit: "C"}
+	tool := DefineToolFromFunc("Forecasts the weather.", f.Forecast)
+
+	def := tool.Definition()
+	if def.Name != "Forecast" {
+		t.Errorf("got name %q, want %q", def.Name, "Forecast")
+	}
+	if def.Description != "Forecasts the weather." {
+		t.Errorf("got description %q", def.Description)
+	}
+	want := map[string]any{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []any{"city"},
+		"properties": map[string]any{
+			"city": map[string]any{"type": "string", "description": "The city to forecast"},
+			"days": map[string]any{"type": "integer", "description": "Number of days", "minimum": float64(1)},
+			"where": map[string]any{
+				"type":                 "object",
+				"additionalProperties": false,
+				"required":             []any{"country"},
+				"properties": map[string]any{
+					"country": map[string]any{"type": "string", "description": "ISO country code"},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(want, def.InputSchema); diff != "" {
+		t.Errorf("input schema mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err := tool.RunRaw(context.Background(), map[string]any{"city": "Paris"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Paris: 20C" {
+		t.Errorf("got %v, want %q", got, "Paris: 20C")
+	}
+
+	t.Run("function literal", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("no panic for a function literal")
+			}
+		}()
+		DefineToolFromFunc("", func(context.Context, struct{}) (string, error) { return "", nil })
+	})
+}
+
+type price struct {
+	Cents    int    `json:"cents"`
+	Currency string `json:"currency"`
+}
+
+func (p price) MarshalForModel() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%d.%02d %s", p.Cents/100, p.Cents%100, p.Currency))
+}
+
+func TestToolModelMarshaler(t *testing.T) {
+	tool := DefineTool("priceOf", "returns the price of an item",
+		func(_ context.Context, _ struct{}) (price, error) {
+			return price{Cents: 1250, Currency: "USD"}, nil
+		})
+
+	var seen any
+	m := DefineModel("test", "priceAsker", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == RoleTool {
+			seen = last.Content[0].ToolResponse.Output["response"]
+			return &ModelResponse{Request: req, Message: NewModelTextMessage("done")}, nil
+		}
+		return &ModelResponse{Request: req, Message: &Message{
+			Role:    RoleModel,
+			Content: []*Part{NewToolRequestPart(&ToolRequest{Name: "priceOf", Input: map[string]any{}})},
+		}}, nil
+	})
+	if _, err := Generate(context.Background(), m, WithTextPrompt("price?"), WithTools(tool)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "12.50 USD"; seen != want {
+		t.Errorf("model saw tool output %#v, want %q", seen, want)
+	}
+
+	// Other callers see the usual encoding.
+	out, err := tool.RunRaw(context.Background(), map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"cents": float64(1250), "currency": "USD"}
+	if diff := cmp.Diff(want, out); diff != "" {
+		t.Errorf("RunRaw mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestStreamingTool(t *testing.T) {
+	var events []string
+	tool := DefineStreamingTool("slowSearch", "searches slowly",
+		func(ctx context.Context, _ struct{}, cb func(context.Context, string) error) (int, error) {
+			for _, hit := range []string{"a", "b"} {
+				if err := cb(ctx, hit); err != nil {
+					return 0, err
+				}
+			}
+			return 2, nil
+		})
+
+	m := DefineModel("test", "searcher", nil, func(ctx context.Context, req *ModelRequest, _ ModelStreamingCallback) (*ModelResponse, error) {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == RoleTool {
+			events = append(events, fmt.Sprintf("model saw %v", last.Content[0].ToolResponse.Output["response"]))
+			return &ModelResponse{Request: req, Message: NewModelTextMessage("done")}, nil
+		}
+		return &ModelResponse{Request: req, Message: &Message{
+			Role:    RoleModel,
+			Content: []*Part{NewToolRequestPart(&ToolRequest{Name: "slowSearch", Input: map[string]any{}})},
+		}}, nil
+	})
+	_, err := Generate(context.Background(), m, WithTextPrompt("search"), WithTools(tool),
+		WithStreaming(func(_ context.Context, c *ModelResponseChunk) error {
+			if tc, ok := c.Custom.(*ToolChunk); ok {
+				events = append(events, fmt.Sprintf("%s streamed %v", tc.Name, tc.Output))
+			}
+			return nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"slowSearch streamed a", "slowSearch streamed b", "model saw 2"}
+	if diff := cmp.Diff(want, events); diff != "" {
+		t.Errorf("events mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Without a streaming callback, the tool just returns its output.
+	out, err := tool.RunRaw(context.Background(), map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != float64(2) {
+		t.Errorf("RunRaw: got %v, want 2", out)
+	}
+}
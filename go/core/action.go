@@ -186,7 +186,7 @@ func (a *Action[In, Out, Stream]) Run(ctx context.Context, input In, cb func(con
 			}
 			var output Out
 			if err == nil {
-				output, err = a.fn(ctx, input, cb)
+				output, err = callRecovering(ctx, a.fn, input, cb)
 				if err == nil {
 					if err = base.ValidateValue(output, a.outputSchema); err != nil {
 						err = fmt.Errorf("invalid output: %w", err)
@@ -257,8 +257,13 @@ func (a *Action[I, O, S]) Desc() action.Desc {
 // or nil if there is none.
 // It panics if the action is of the wrong type.
 func LookupActionFor[In, Out, Stream any](typ atype.ActionType, provider, name string) *Action[In, Out, Stream] {
+	return LookupActionInRegistry[In, Out, Stream](registry.Global, typ, provider, name)
+}
+
+// LookupActionInRegistry is like [LookupActionFor], but looks the action up in r.
+func LookupActionInRegistry[In, Out, Stream any](r *registry.Registry, typ atype.ActionType, provider, name string) *Action[In, Out, Stream] {
 	key := fmt.Sprintf("/%s/%s/%s", typ, provider, name)
-	a := registry.Global.LookupAction(key)
+	a := r.LookupAction(key)
 	if a == nil {
 		return nil
 	}
@@ -17,6 +17,7 @@ package core
 import (
 	"bytes"
 	"context"
+	"errors"
 	"slices"
 	"testing"
 
@@ -118,3 +119,32 @@ func TestActionTracing(t *testing.T) {
 	}
 	t.Fatalf("did not find trace named %q", actionName)
 }
+
+func TestActionPanic(t *testing.T) {
+	tc := tracing.NewTestOnlyTelemetryClient()
+	registry.Global.TracingState().WriteTelemetryImmediate(tc)
+	const actionName = "TestPanic-assert"
+	a := newAction(actionName, atype.Custom, nil, nil, func(_ context.Context, x int, _ noStream) (int, error) {
+		var xs []int
+		return xs[x], nil
+	})
+	_, err := a.Run(context.Background(), 3, nil)
+	var perr *PanicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("got error %v, want a *PanicError", err)
+	}
+	if len(perr.Stack) == 0 {
+		t.Error("PanicError has no stack")
+	}
+	for _, td := range tc.Traces {
+		if td.DisplayName == actionName {
+			for _, sd := range td.Spans {
+				if _, ok := sd.Attributes["genkit:metadata:panic:stack"]; !ok {
+					t.Error("span has no panic stack")
+				}
+			}
+			return
+		}
+	}
+	t.Fatalf("did not find trace named %q", actionName)
+}
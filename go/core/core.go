@@ -25,6 +25,7 @@
 package core
 
 import (
+	"github.com/firebase/genkit/go/core/tracing"
 	"github.com/firebase/genkit/go/internal/registry"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
@@ -33,3 +34,9 @@ import (
 func RegisterSpanProcessor(sp sdktrace.SpanProcessor) {
 	registry.Global.RegisterSpanProcessor(sp)
 }
+
+// SetTracePayloadLimits limits the size of the inputs and outputs
+// recorded in traces. See [tracing.PayloadLimits].
+func SetTracePayloadLimits(limits tracing.PayloadLimits) {
+	registry.Global.TracingState().SetPayloadLimits(limits)
+}
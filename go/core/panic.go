@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/firebase/genkit/go/core/tracing"
+)
+
+// A PanicError is the error returned when the function of an action or
+// flow panics. It has the status INTERNAL: the flow server serves it with
+// a 500 status, and the server keeps running.
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // the stack of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("INTERNAL: panic: %v", e.Value)
+}
+
+// RecoverPanic recovers from a panic and stores it in *errp as a [*PanicError].
+// It must be called directly by a deferred statement:
+//
+//	defer core.RecoverPanic(ctx, &err)
+//
+// The stack of the panic is recorded in the current span.
+func RecoverPanic(ctx context.Context, errp *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	perr := &PanicError{Value: v, Stack: debug.Stack()}
+	tracing.SetCustomMetadataAttr(ctx, "panic:stack", string(perr.Stack))
+	*errp = perr
+}
+
+// callRecovering calls fn, converting a panic into a [*PanicError].
+func callRecovering[In, Out, Stream any](ctx context.Context, fn Func[In, Out, Stream], input In, cb func(context.Context, Stream) error) (_ Out, err error) {
+	defer RecoverPanic(ctx, &err)
+	return fn(ctx, input, cb)
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/firebase/genkit/go/core/logger"
+)
+
+// PayloadLimits limits the size of the inputs and outputs recorded in
+// spans. Step outputs such as full model responses can be large, and
+// exporters and trace stores often limit the size of attributes.
+type PayloadLimits struct {
+	// MaxSize is the maximum number of bytes of the JSON encoding of a
+	// span's input or output that is recorded in the span. Longer
+	// payloads are truncated, and end with a marker giving the number
+	// of bytes removed. Zero means no limit.
+	MaxSize int
+	// Blobs, if non-nil, stores the whole of each truncated payload.
+	// The marker then includes the reference that Blobs returns, which
+	// is also recorded in the attribute "genkit:inputRef" or
+	// "genkit:outputRef".
+	Blobs BlobStore
+}
+
+// A BlobStore stores span payloads that are too large to be recorded
+// in the span itself.
+type BlobStore interface {
+	// Put stores data, the JSON encoding of the input or output of a
+	// span, and returns a reference to it, such as a URL. key is the
+	// path of the span followed by ":input" or ":output"; it is not
+	// unique across traces.
+	Put(ctx context.Context, key string, data []byte) (ref string, err error)
+}
+
+// SetPayloadLimits sets the limits on the inputs and outputs recorded
+// by spans that end after the call.
+func (ts *State) SetPayloadLimits(limits PayloadLimits) {
+	ts.limits.Store(&limits)
+}
+
+// payloadLimits returns the limits set by SetPayloadLimits, or nil.
+func (ts *State) payloadLimits() *PayloadLimits {
+	return ts.limits.Load()
+}
+
+// limit returns the value to record for payload, the redacted JSON
+// encoding of a span's input or output, and the reference to where it was
+// stored in full if it was too large. key is passed to the BlobStore.
+// A nil *PayloadLimits doesn't limit payloads.
+func (l *PayloadLimits) limit(ctx context.Context, key, payload string) (value, ref string) {
+	if l == nil || l.MaxSize <= 0 || len(payload) <= l.MaxSize {
+		return payload, ""
+	}
+	// Cut at a rune boundary, so the recorded value is valid UTF-8.
+	n := l.MaxSize
+	for n > 0 && !utf8.RuneStart(payload[n]) {
+		n--
+	}
+	removed := len(payload) - n
+	if l.Blobs != nil {
+		var err error
+		ref, err = l.Blobs.Put(ctx, key, []byte(payload))
+		if err != nil {
+			logger.FromContext(ctx).Warn("storing span payload", "key", key, "err", err)
+			ref = ""
+		}
+	}
+	if ref != "" {
+		return fmt.Sprintf("%s...[truncated %d bytes; stored at %s]", payload[:n], removed, ref), ref
+	}
+	return fmt.Sprintf("%s...[truncated %d bytes]", payload[:n], removed), ""
+}
@@ -17,7 +17,10 @@ package tracing
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/firebase/genkit/go/core/logger"
 	"github.com/firebase/genkit/go/internal/base"
@@ -31,6 +34,7 @@ import (
 type State struct {
 	tp     *sdktrace.TracerProvider // references Stores
 	tracer trace.Tracer             // returned from tp.Tracer(), cached
+	limits atomic.Pointer[PayloadLimits]
 }
 
 func NewState() *State {
@@ -101,6 +105,9 @@ func RunInNewSpan[I, O any](
 	var parentPath string
 	if parentSpanMeta != nil {
 		parentPath = parentSpanMeta.Path
+		sm.redactions = parentSpanMeta.redactions
+	} else {
+		sm.redactions = &redactions{}
 	}
 	sm.Path = parentPath + "/" + name
 	var opts []trace.SpanStartOption
@@ -110,7 +117,8 @@ func RunInNewSpan[I, O any](
 	ctx, span := tstate.tracer.Start(ctx, name, opts...)
 	defer span.End()
 	// At the end, copy some of the spanMetadata to the OpenTelemetry span.
-	defer func() { span.SetAttributes(sm.attributes()...) }()
+	limits := tstate.payloadLimits()
+	defer func() { span.SetAttributes(sm.attributes(ctx, limits)...) }()
 	// Add the spanMetadata to the context, so the function can access it.
 	ctx = spanMetaKey.NewContext(ctx, sm)
 	// Run the function.
@@ -118,8 +126,14 @@ func RunInNewSpan[I, O any](
 
 	if err != nil {
 		sm.State = spanStateError
-		span.SetStatus(codes.Error, err.Error())
-		span.RecordError(err)
+		msg := sm.redactions.apply(err.Error())
+		span.SetStatus(codes.Error, msg)
+		if msg != err.Error() {
+			// Don't record the unredacted message.
+			span.RecordError(errors.New(msg))
+		} else {
+			span.RecordError(err)
+		}
 		return base.Zero[O](), err
 	}
 	// TODO: the typescript code checks if sm.State == error here. Can that happen?
@@ -148,6 +162,8 @@ type spanMetadata struct {
 	Path   string // slash-separated list of names from the root span to the current one
 	mu     sync.Mutex
 	attrs  map[string]string // additional information, as key-value pairs
+	// values to remove from attributes, shared by all spans of a trace
+	redactions *redactions
 }
 
 // SetAttr sets an attribute, overwriting whatever is there.
@@ -164,26 +180,79 @@ func (sm *spanMetadata) SetAttr(k, v string) {
 }
 
 // attributes returns some information about the spanMetadata
-// as a slice of OpenTelemetry attributes.
-func (sm *spanMetadata) attributes() []attribute.KeyValue {
+// as a slice of OpenTelemetry attributes. The input and output
+// are subject to limits.
+func (sm *spanMetadata) attributes(ctx context.Context, limits *PayloadLimits) []attribute.KeyValue {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	redact := sm.redactions.apply
+	input, inputRef := limits.limit(ctx, sm.Path+":input", redact(base.JSONString(sm.Input)))
+	output, outputRef := limits.limit(ctx, sm.Path+":output", redact(base.JSONString(sm.Output)))
 	kvs := []attribute.KeyValue{
 		attribute.String("genkit:name", sm.Name),
 		attribute.String("genkit:state", string(sm.State)),
-		attribute.String("genkit:input", base.JSONString(sm.Input)),
+		attribute.String("genkit:input", input),
 		attribute.String("genkit:path", sm.Path),
-		attribute.String("genkit:output", base.JSONString(sm.Output)),
+		attribute.String("genkit:output", output),
+	}
+	if inputRef != "" {
+		kvs = append(kvs, attribute.String("genkit:inputRef", inputRef))
+	}
+	if outputRef != "" {
+		kvs = append(kvs, attribute.String("genkit:outputRef", outputRef))
 	}
 	if sm.IsRoot {
 		kvs = append(kvs, attribute.Bool("genkit:isRoot", sm.IsRoot))
 	}
 	for k, v := range sm.attrs {
-		kvs = append(kvs, attribute.String(attrPrefix+":metadata:"+k, v))
+		kvs = append(kvs, attribute.String(attrPrefix+":metadata:"+k, redact(v)))
 	}
 	return kvs
 }
 
+// Redacted replaces values passed to [Redact] in span attributes.
+const Redacted = "[REDACTED]"
+
+// Redact arranges for every occurrence of value in the attributes of the
+// current span, and of any span of the same trace that ends after this call,
+// to be replaced by [Redacted].
+// Use it for secrets, such as API keys, that may otherwise end up in a
+// span's input, output or error.
+func Redact(ctx context.Context, value string) {
+	if sm := spanMetaKey.FromContext(ctx); sm != nil {
+		sm.redactions.add(value)
+	}
+}
+
+// redactions is a set of values to redact from span attributes.
+// A nil *redactions redacts nothing.
+type redactions struct {
+	mu     sync.Mutex
+	values []string
+}
+
+func (r *redactions) add(value string) {
+	if r == nil || value == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values = append(r.values, value)
+}
+
+// apply returns s with all redacted values replaced by [Redacted].
+func (r *redactions) apply(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.values {
+		s = strings.ReplaceAll(s, v, Redacted)
+	}
+	return s
+}
+
 // spanMetaKey is for storing spanMetadatas in a context.
 var spanMetaKey = base.NewContextKey[*spanMetadata]()
 
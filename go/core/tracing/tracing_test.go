@@ -15,11 +15,15 @@
 package tracing
 
 import (
+	"context"
+	"errors"
 	"slices"
 	"strconv"
+	"strings"
 	"testing"
 
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // TODO: add tests that compare tracing data saved to disk with goldens.
@@ -38,7 +42,7 @@ func TestSpanMetadata(t *testing.T) {
 	}
 	sm.SetAttr("key", "value")
 
-	got := sm.attributes()
+	got := sm.attributes(context.Background(), nil)
 	want := []attribute.KeyValue{
 		attribute.String("genkit:name", "name"),
 		attribute.String("genkit:state", "success"),
@@ -51,3 +55,85 @@ func TestSpanMetadata(t *testing.T) {
 		t.Errorf("\ngot  %v\nwant %v", got, want)
 	}
 }
+
+// fakeBlobStore stores blobs in a map.
+type fakeBlobStore struct {
+	blobs map[string]string
+	err   error
+}
+
+func (s *fakeBlobStore) Put(_ context.Context, key string, data []byte) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	ref := "blob://" + key
+	s.blobs[ref] = string(data)
+	return ref, nil
+}
+
+func TestPayloadLimits(t *testing.T) {
+	// runStep runs a step whose output is large, and returns the
+	// attributes of its span.
+	runStep := func(limits *PayloadLimits) map[attribute.Key]string {
+		tstate := NewState()
+		rec := tracetest.NewSpanRecorder()
+		tstate.RegisterSpanProcessor(rec)
+		if limits != nil {
+			tstate.SetPayloadLimits(*limits)
+		}
+		_, err := RunInNewSpan(context.Background(), tstate, "step", "", true, "in", func(context.Context, string) (string, error) {
+			return strings.Repeat("x", 100), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs := map[attribute.Key]string{}
+		for _, kv := range rec.Ended()[0].Attributes() {
+			attrs[kv.Key] = kv.Value.Emit()
+		}
+		return attrs
+	}
+	output := `"` + strings.Repeat("x", 100) + `"` // the JSON encoding of the output
+
+	t.Run("no limit", func(t *testing.T) {
+		if got := runStep(nil)["genkit:output"]; got != output {
+			t.Errorf("got output %q, want %q", got, output)
+		}
+	})
+	t.Run("truncated", func(t *testing.T) {
+		attrs := runStep(&PayloadLimits{MaxSize: 10})
+		if got, want := attrs["genkit:output"], output[:10]+"...[truncated 92 bytes]"; got != want {
+			t.Errorf("got output %q, want %q", got, want)
+		}
+		if got, want := attrs["genkit:input"], `"in"`; got != want {
+			t.Errorf("got input %q, want %q", got, want)
+		}
+	})
+	t.Run("stored", func(t *testing.T) {
+		blobs := &fakeBlobStore{blobs: map[string]string{}}
+		attrs := runStep(&PayloadLimits{MaxSize: 10, Blobs: blobs})
+		const ref = "blob:///step:output"
+		if got, want := attrs["genkit:output"], output[:10]+"...[truncated 92 bytes; stored at "+ref+"]"; got != want {
+			t.Errorf("got output %q, want %q", got, want)
+		}
+		if got := attrs["genkit:outputRef"]; got != ref {
+			t.Errorf("got output ref %q, want %q", got, ref)
+		}
+		if got := blobs.blobs[ref]; got != output {
+			t.Errorf("got stored output %q, want %q", got, output)
+		}
+	})
+	t.Run("store fails", func(t *testing.T) {
+		attrs := runStep(&PayloadLimits{MaxSize: 10, Blobs: &fakeBlobStore{err: errors.New("full")}})
+		if got, want := attrs["genkit:output"], output[:10]+"...[truncated 92 bytes]"; got != want {
+			t.Errorf("got output %q, want %q", got, want)
+		}
+	})
+	t.Run("runes", func(t *testing.T) {
+		// The cut doesn't split the three-byte rune that spans the limit.
+		got, _ := (&PayloadLimits{MaxSize: 4}).limit(context.Background(), "", "ab€cd")
+		if want := "ab...[truncated 5 bytes]"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
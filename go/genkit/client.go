@@ -0,0 +1,128 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/firebase/genkit/go/internal/base"
+)
+
+// StreamFlow runs the flow served at url by a Genkit flow server, such as
+// one started by [Init], and streams its output.
+// It calls cb with each chunk that the flow streams, then returns the
+// flow's result. If cb returns an error, StreamFlow stops reading and
+// returns that error. If the flow fails, the error StreamFlow returns
+// wraps the [*FlowError] that the server reported.
+//
+// The type parameter Out cannot be inferred, so it must be given,
+// as in StreamFlow[Result](ctx, url, input, cb).
+//
+// The request is made with [http.DefaultClient]; use ctx to cancel it or
+// to set a deadline.
+func StreamFlow[Out, In, Stream any](ctx context.Context, url string, input In, cb func(context.Context, Stream) error) (Out, error) {
+	body, err := json.Marshal(struct {
+		Data In `json:"data"`
+	}{input})
+	if err != nil {
+		return base.Zero[Out](), err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url+"?stream=true", bytes.NewReader(body))
+	if err != nil {
+		return base.Zero[Out](), err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return base.Zero[Out](), err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(res.Body)
+		msg = bytes.TrimSpace(msg)
+		if ferr := decodeFlowError(msg); ferr != nil {
+			return base.Zero[Out](), fmt.Errorf("flow %s: %s: %w", url, res.Status, ferr)
+		}
+		return base.Zero[Out](), fmt.Errorf("flow %s: %s: %s", url, res.Status, msg)
+	}
+
+	// The server writes each chunk as a line of compact JSON, then the result
+	// as a line of the form `{"result": ...}`. The space after the colon
+	// distinguishes the result from a chunk that has a "result" field.
+	// If the flow fails after streaming has begun, the last line is the
+	// error, of the form `{"error": ...}`.
+	r := bufio.NewReader(res.Body)
+	for {
+		line, rerr := r.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if rerr == nil {
+				continue
+			}
+			if errors.Is(rerr, io.EOF) {
+				rerr = errors.New("stream ended without a result")
+			}
+			return base.Zero[Out](), fmt.Errorf("flow %s: %w", url, rerr)
+		}
+		if bytes.HasPrefix(line, []byte(`{"result": `)) {
+			var result struct {
+				Result Out `json:"result"`
+			}
+			// The server follows the result with a literal `\n`.
+			line = bytes.TrimSuffix(line, []byte(`\n`))
+			if err := json.Unmarshal(line, &result); err != nil {
+				return base.Zero[Out](), fmt.Errorf("flow %s: decoding result: %w", url, err)
+			}
+			return result.Result, nil
+		}
+		if ferr := decodeFlowError(line); ferr != nil {
+			return base.Zero[Out](), fmt.Errorf("flow %s: %w", url, ferr)
+		}
+		if !json.Valid(line) {
+			return base.Zero[Out](), fmt.Errorf("flow %s: %s", url, line)
+		}
+		var chunk Stream
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return base.Zero[Out](), fmt.Errorf("flow %s: decoding chunk: %w", url, err)
+		}
+		if cb != nil {
+			if err := cb(ctx, chunk); err != nil {
+				return base.Zero[Out](), err
+			}
+		}
+	}
+}
+
+// decodeFlowError returns the error in line, if it is a JSON error
+// envelope written by the flow server, or nil.
+func decodeFlowError(line []byte) *FlowError {
+	if !bytes.HasPrefix(line, []byte(`{"error": `)) {
+		return nil
+	}
+	var env struct {
+		Error *FlowError `json:"error"`
+	}
+	if err := json.Unmarshal(line, &env); err != nil {
+		return nil
+	}
+	return env.Error
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/firebase/genkit/go/internal/base"
+)
+
+// A Status classifies the failure of a flow. The statuses are those of
+// gRPC and Google APIs.
+type Status string
+
+const (
+	StatusCancelled          Status = "CANCELLED"
+	StatusUnknown            Status = "UNKNOWN"
+	StatusInvalidArgument    Status = "INVALID_ARGUMENT"
+	StatusDeadlineExceeded   Status = "DEADLINE_EXCEEDED"
+	StatusNotFound           Status = "NOT_FOUND"
+	StatusAlreadyExists      Status = "ALREADY_EXISTS"
+	StatusPermissionDenied   Status = "PERMISSION_DENIED"
+	StatusResourceExhausted  Status = "RESOURCE_EXHAUSTED"
+	StatusFailedPrecondition Status = "FAILED_PRECONDITION"
+	StatusAborted            Status = "ABORTED"
+	StatusOutOfRange         Status = "OUT_OF_RANGE"
+	StatusUnimplemented      Status = "UNIMPLEMENTED"
+	StatusInternal           Status = "INTERNAL"
+	StatusUnavailable        Status = "UNAVAILABLE"
+	StatusDataLoss           Status = "DATA_LOSS"
+	StatusUnauthenticated    Status = "UNAUTHENTICATED"
+)
+
+// httpStatuses maps each Status to the HTTP status code that the flow
+// server responds with.
+var httpStatuses = map[Status]int{
+	StatusCancelled:          499, // client closed request
+	StatusUnknown:            http.StatusInternalServerError,
+	StatusInvalidArgument:    http.StatusBadRequest,
+	StatusDeadlineExceeded:   http.StatusGatewayTimeout,
+	StatusNotFound:           http.StatusNotFound,
+	StatusAlreadyExists:      http.StatusConflict,
+	StatusPermissionDenied:   http.StatusForbidden,
+	StatusResourceExhausted:  http.StatusTooManyRequests,
+	StatusFailedPrecondition: http.StatusBadRequest,
+	StatusAborted:            http.StatusConflict,
+	StatusOutOfRange:         http.StatusBadRequest,
+	StatusUnimplemented:      http.StatusNotImplemented,
+	StatusInternal:           http.StatusInternalServerError,
+	StatusUnavailable:        http.StatusServiceUnavailable,
+	StatusDataLoss:           http.StatusInternalServerError,
+	StatusUnauthenticated:    http.StatusUnauthorized,
+}
+
+// HTTPStatus returns the HTTP status code that corresponds to s.
+// Unrecognized statuses correspond to 500 Internal Server Error.
+func (s Status) HTTPStatus() int {
+	if code, ok := httpStatuses[s]; ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+// A FlowError is an error that a flow returns to tell its clients why it
+// failed. The flow server responds to a request for a flow that returns a
+// FlowError, possibly wrapped, with the HTTP status of its Status and a
+// JSON body of the form
+//
+//	{"error": {"code": "NOT_FOUND", "message": "no such user", "details": {...}}}
+//
+// Other errors are reported in the same form, with the status INTERNAL,
+// except for errors the server itself detects, such as invalid input
+// (INVALID_ARGUMENT) or a failed auth policy (PERMISSION_DENIED).
+// Messages of such errors are not meant for end users.
+type FlowError struct {
+	Status  Status `json:"code"`
+	Message string `json:"message"`
+	// Details is additional information about the error, which must
+	// be encodable as JSON.
+	Details any `json:"details,omitempty"`
+}
+
+// NewFlowError returns a [FlowError] with the given status and a message
+// formatted as by [fmt.Sprintf].
+func NewFlowError(status Status, format string, args ...any) *FlowError {
+	return &FlowError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *FlowError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Status, e.Message)
+}
+
+// toFlowError returns the FlowError that describes err to clients,
+// and the HTTP status code of the response reporting it.
+func toFlowError(err error) (*FlowError, int) {
+	var ferr *FlowError
+	if errors.As(err, &ferr) {
+		return ferr, ferr.Status.HTTPStatus()
+	}
+	var herr *base.HTTPError
+	if errors.As(err, &herr) {
+		return &FlowError{Status: statusForHTTP(herr.Code), Message: herr.Err.Error()}, herr.Code
+	}
+	status := StatusInternal
+	switch {
+	case errors.Is(err, context.Canceled):
+		status = StatusCancelled
+	case errors.Is(err, context.DeadlineExceeded):
+		status = StatusDeadlineExceeded
+	}
+	return &FlowError{Status: status, Message: err.Error()}, status.HTTPStatus()
+}
+
+// statusForHTTP returns the Status that best describes an HTTP status code.
+func statusForHTTP(code int) Status {
+	switch code {
+	case http.StatusBadRequest:
+		return StatusInvalidArgument
+	case http.StatusUnauthorized:
+		return StatusUnauthenticated
+	case http.StatusForbidden:
+		return StatusPermissionDenied
+	case http.StatusNotFound:
+		return StatusNotFound
+	case http.StatusConflict:
+		return StatusAborted
+	case http.StatusUnprocessableEntity:
+		return StatusFailedPrecondition
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return StatusResourceExhausted
+	case http.StatusNotImplemented:
+		return StatusUnimplemented
+	case http.StatusServiceUnavailable:
+		return StatusUnavailable
+	case http.StatusGatewayTimeout:
+		return StatusDeadlineExceeded
+	}
+	if code >= 500 {
+		return StatusInternal
+	}
+	return StatusUnknown
+}
+
+// writeFlowError writes the JSON error envelope describing err.
+// Like the response holding a flow's result, the envelope is written
+// on a single line that begins `{"error": `, so that clients reading
+// a stream of chunks can tell it apart from a chunk.
+func writeFlowError(w http.ResponseWriter, err error) {
+	ferr, code := toFlowError(err)
+	body, merr := json.Marshal(ferr)
+	if merr != nil {
+		// The details can't be encoded; report the error without them.
+		body, _ = json.Marshal(&FlowError{Status: ferr.Status, Message: ferr.Message})
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "{\"error\": %s}\n", body)
+}
@@ -26,6 +26,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core"
 	"github.com/firebase/genkit/go/core/logger"
 	"github.com/firebase/genkit/go/core/tracing"
@@ -100,6 +101,16 @@ type Flow[In, Out, Stream any] struct {
 	inputSchema  *jsonschema.Schema         // Schema of the input to the flow
 	outputSchema *jsonschema.Schema         // Schema of the output out of the flow
 	auth         FlowAuth                   // Auth provider and policy checker for the flow.
+	version      string                     // Version of the flow, if set.
+	deprecated   string                     // Deprecation message; non-empty if the flow is deprecated.
+	logging      *flowLogging               // Input and output logging, if set.
+	hooks        *FlowHooks                 // Lifecycle hooks, if set.
+	maxInput     int64                      // Maximum size of an HTTP request body; zero for the server's limit.
+	examples     []json.RawMessage          // Example inputs for the dev UI, as JSON.
+	cache        *flowCache                 // Output cache, if set.
+	reg          *registry.Registry         // The registry the flow is defined in.
+	toolOnce     sync.Once                  // Guards tool.
+	tool         *ai.ToolDef[In, Out]       // The tool defined by FlowAsTool, if any.
 	// TODO: scheduler
 	// TODO: experimentalDurable
 	// TODO: middleware
@@ -108,11 +119,19 @@ type Flow[In, Out, Stream any] struct {
 // runOptions configures a single flow run.
 type runOptions struct {
 	authContext AuthContext // Auth context to pass to auth policy checker when calling a flow directly.
+	webhookURL  string      // URL to notify when the flow finishes, if any.
 }
 
 // flowOptions configures a flow.
 type flowOptions struct {
-	auth FlowAuth // Auth provider and policy checker for the flow.
+	auth       FlowAuth     // Auth provider and policy checker for the flow.
+	version    string       // Version of the flow.
+	deprecated string       // Deprecation message for the flow.
+	logging    *flowLogging // Input and output logging for the flow.
+	hooks      *FlowHooks   // Lifecycle hooks for the flow.
+	maxInput   int64        // Maximum size of an HTTP request body for the flow.
+	examples   []any        // Example inputs for the flow.
+	cache      *flowCache   // Output cache for the flow.
 }
 
 type noStream = func(context.Context, struct{}) error
@@ -156,6 +175,76 @@ func WithFlowAuth(auth FlowAuth) FlowOption {
 	}
 }
 
+// WithFlowVersion records a version for the flow in its action metadata.
+func WithFlowVersion(v string) FlowOption {
+	return func(f *flowOptions) {
+		if f.version != "" {
+			log.Panic("version already set in flow")
+		}
+		f.version = v
+	}
+}
+
+// WithFlowDeprecated marks the flow as deprecated. The message is recorded
+// in the flow's action metadata and returned to HTTP clients in the
+// X-Genkit-Deprecation header, along with a "Deprecation: true" header.
+func WithFlowDeprecated(msg string) FlowOption {
+	return func(f *flowOptions) {
+		if f.deprecated != "" {
+			log.Panic("deprecation already set in flow")
+		}
+		f.deprecated = msg
+	}
+}
+
+// FlowHooks are functions called at points in each run of a flow.
+// Any of them may be nil.
+type FlowHooks struct {
+	// OnStart is called with the input before the flow function runs.
+	OnStart func(ctx context.Context, input any)
+	// OnSuccess is called with the output when the flow succeeds.
+	OnSuccess func(ctx context.Context, output any)
+	// OnError is called with the error when the flow fails,
+	// including when its input or output is invalid.
+	OnError func(ctx context.Context, err error)
+}
+
+// WithFlowHooks sets hooks to be called when the flow starts, succeeds and fails.
+// The hooks are called synchronously, within the flow's span.
+func WithFlowHooks(hooks FlowHooks) FlowOption {
+	return func(f *flowOptions) {
+		if f.hooks != nil {
+			log.Panic("hooks already set in flow")
+		}
+		f.hooks = &hooks
+	}
+}
+
+// WithMaxInputSize limits the size of the body of an HTTP request to run
+// the flow to n bytes, overriding the server's limit set by
+// [WithMaxRequestBodySize]. The limit applies to the flow's route and to
+// the development server's /api/runAction and /api/runActions. Larger
+// requests are rejected with a 413 (Request Entity Too Large) status.
+func WithMaxInputSize(n int64) FlowOption {
+	return func(f *flowOptions) {
+		if f.maxInput != 0 {
+			log.Panic("max input size already set in flow")
+		}
+		f.maxInput = n
+	}
+}
+
+// WithFlowExample adds an example input for the flow. The examples are
+// recorded as JSON in the flow's action metadata under "examples", so
+// the developer UI can offer them when running the flow. It may be
+// given more than once.
+// Defining the flow panics if an example does not match its input schema.
+func WithFlowExample(input any) FlowOption {
+	return func(f *flowOptions) {
+		f.examples = append(f.examples, input)
+	}
+}
+
 // WithLocalAuth configures an option to run or stream a flow with a local auth value.
 func WithLocalAuth(authContext AuthContext) FlowRunOption {
 	return func(opts *runOptions) {
@@ -174,10 +263,14 @@ func DefineFlow[In, Out any](
 	fn func(ctx context.Context, input In) (Out, error),
 	opts ...FlowOption,
 ) *Flow[In, Out, struct{}] {
-	return defineFlow(registry.Global, name, core.Func[In, Out, struct{}](
-		func(ctx context.Context, input In, cb func(ctx context.Context, _ struct{}) error) (Out, error) {
-			return fn(ctx, input)
-		}), opts...)
+	return defineFlow(registry.Global, name, nonStreamingFunc(fn), opts...)
+}
+
+// nonStreamingFunc adapts the function of a non-streaming flow to a [core.Func].
+func nonStreamingFunc[In, Out any](fn func(ctx context.Context, input In) (Out, error)) core.Func[In, Out, struct{}] {
+	return func(ctx context.Context, input In, cb func(ctx context.Context, _ struct{}) error) (Out, error) {
+		return fn(ctx, input)
+	}
 }
 
 // DefineStreamingFlow creates a streaming Flow that runs fn, and registers it as an action.
@@ -203,6 +296,7 @@ func defineFlow[In, Out, Stream any](r *registry.Registry, name string, fn core.
 	f := &Flow[In, Out, Stream]{
 		name:         name,
 		fn:           fn,
+		reg:          r,
 		inputSchema:  base.InferJSONSchema(i),
 		outputSchema: base.InferJSONSchema(o),
 		// TODO: set stateStore?
@@ -212,9 +306,34 @@ func defineFlow[In, Out, Stream any](r *registry.Registry, name string, fn core.
 		opt(flowOpts)
 	}
 	f.auth = flowOpts.auth
+	f.version = flowOpts.version
+	f.deprecated = flowOpts.deprecated
+	f.logging = flowOpts.logging
+	f.hooks = flowOpts.hooks
+	f.maxInput = flowOpts.maxInput
+	f.cache = flowOpts.cache
+	for i, ex := range flowOpts.examples {
+		data, err := json.Marshal(ex)
+		if err != nil {
+			log.Panicf("flow %q: example %d: %v", name, i, err)
+		}
+		if err := base.ValidateJSON(data, f.inputSchema); err != nil {
+			log.Panicf("flow %q: example %d does not match the input schema: %v", name, i, err)
+		}
+		f.examples = append(f.examples, data)
+	}
 	metadata := map[string]any{
 		"requiresAuth": f.auth != nil,
 	}
+	if f.version != "" {
+		metadata["version"] = f.version
+	}
+	if f.deprecated != "" {
+		metadata["deprecated"] = f.deprecated
+	}
+	if len(f.examples) > 0 {
+		metadata["examples"] = f.examples
+	}
 	afunc := func(ctx context.Context, input In, cb func(context.Context, Stream) error) (*Out, error) {
 		tracing.SetCustomMetadataAttr(ctx, "flow:wrapperAction", "true")
 		runtimeContext := core.ActionContext(ctx)
@@ -331,13 +450,17 @@ type FlowResult[Out any] struct {
 // Name returns the name that the flow was defined with.
 func (f *Flow[In, Out, Stream]) Name() string { return f.name }
 
+func (f *Flow[In, Out, Stream]) deprecation() string { return f.deprecated }
+
+func (f *Flow[In, Out, Stream]) maxInputSize() int64 { return f.maxInput }
+
+func (f *Flow[In, Out, Stream]) schemas() (input, output *jsonschema.Schema) {
+	return f.inputSchema, f.outputSchema
+}
+
 func (f *Flow[In, Out, Stream]) runJSON(ctx context.Context, authHeader string, input json.RawMessage, cb streamingCallback[json.RawMessage]) (json.RawMessage, error) {
-	// Validate input before unmarshaling it because invalid or unknown fields will be discarded in the process.
-	if err := base.ValidateJSON(input, f.inputSchema); err != nil {
-		return nil, &base.HTTPError{Code: http.StatusBadRequest, Err: err}
-	}
-	var in In
-	if err := json.Unmarshal(input, &in); err != nil {
+	in, err := f.decodeInput(input)
+	if err != nil {
 		return nil, &base.HTTPError{Code: http.StatusBadRequest, Err: err}
 	}
 	newCtx, err := f.provideAuthContext(ctx, authHeader)
@@ -347,18 +470,19 @@ func (f *Flow[In, Out, Stream]) runJSON(ctx context.Context, authHeader string,
 	if err := f.checkAuthPolicy(newCtx, in); err != nil {
 		return nil, &base.HTTPError{Code: http.StatusForbidden, Err: err}
 	}
+	enc := jsonEncodingKey.FromContext(ctx)
 	// If there is a callback, wrap it to turn an S into a json.RawMessage.
 	var callback streamingCallback[Stream]
 	if cb != nil {
 		callback = func(ctx context.Context, s Stream) error {
-			bytes, err := json.Marshal(s)
+			bytes, err := enc.marshal(s)
 			if err != nil {
 				return err
 			}
 			return cb(ctx, json.RawMessage(bytes))
 		}
 	}
-	fstate, err := f.start(ctx, in, callback)
+	fstate, err := f.start(newCtx, in, callback)
 	if err != nil {
 		return nil, err
 	}
@@ -372,7 +496,43 @@ func (f *Flow[In, Out, Stream]) runJSON(ctx context.Context, authHeader string,
 	if res.err != nil {
 		return nil, res.err
 	}
-	return json.Marshal(res.Response)
+	return enc.marshal(res.Response)
+}
+
+// decodeInput validates the JSON input to the flow against its input schema
+// and unmarshals it.
+func (f *Flow[In, Out, Stream]) decodeInput(input json.RawMessage) (In, error) {
+	var in In
+	// Validate input before unmarshaling it because invalid or unknown fields will be discarded in the process.
+	if err := base.ValidateJSON(input, f.inputSchema); err != nil {
+		return in, err
+	}
+	if err := json.Unmarshal(input, &in); err != nil {
+		return in, err
+	}
+	return in, nil
+}
+
+// ValidateInput reports whether input is valid input to f, returning the
+// same error for invalid input that the flow's HTTP handler reports
+// with a 400 status. input may be JSON, as a [json.RawMessage] or []byte,
+// or a value that is marshaled to JSON.
+// ValidateInput does not check the flow's auth policy.
+func ValidateInput[In, Out, Stream any](f *Flow[In, Out, Stream], input any) error {
+	var data []byte
+	switch input := input.(type) {
+	case json.RawMessage:
+		data = input
+	case []byte:
+		data = input
+	default:
+		var err error
+		if data, err = json.Marshal(input); err != nil {
+			return err
+		}
+	}
+	_, err := f.decodeInput(data)
+	return err
 }
 
 // provideAuthContext provides auth context for the given auth header if flow auth is configured.
@@ -399,6 +559,10 @@ func (f *Flow[In, Out, Stream]) checkAuthPolicy(ctx context.Context, input any)
 
 // start starts executing the flow with the given input.
 func (f *Flow[In, Out, Stream]) start(ctx context.Context, input In, cb streamingCallback[Stream]) (_ *flowState[In, Out], err error) {
+	if f.reg != nil {
+		// Tools requested by models are looked up in the flow's registry.
+		ctx = registry.NewContext(ctx, f.reg)
+	}
 	flowID, err := generateFlowID()
 	if err != nil {
 		return nil, err
@@ -444,13 +608,25 @@ func (f *Flow[In, Out, Stream]) execute(ctx context.Context, state *flowState[In
 		// TODO: Save rootSpanContext in the state.
 		// TODO: If input is missing, get it from state.input and overwrite metadata.input.
 		start := time.Now()
+		if f.hooks != nil && f.hooks.OnStart != nil {
+			f.hooks.OnStart(ctx, input)
+		}
 		var err error
 		if err = base.ValidateValue(input, f.inputSchema); err != nil {
 			err = fmt.Errorf("invalid input: %w", err)
 		}
 		var output Out
 		if err == nil {
-			output, err = f.fn(ctx, input, cb)
+			output, err = func() (_ Out, err error) {
+				// Keep a panicking flow from crashing the server.
+				defer core.RecoverPanic(ctx, &err)
+				if f.cache != nil && cb == nil {
+					return runCached(ctx, f.cache, f.name, input, func(ctx context.Context) (Out, error) {
+						return f.fn(ctx, input, nil)
+					})
+				}
+				return f.fn(ctx, input, cb)
+			}()
 			if err == nil {
 				if err = base.ValidateValue(output, f.outputSchema); err != nil {
 					err = fmt.Errorf("invalid output: %w", err)
@@ -458,6 +634,16 @@ func (f *Flow[In, Out, Stream]) execute(ctx context.Context, state *flowState[In
 			}
 		}
 		latency := time.Since(start)
+		if f.logging != nil {
+			f.logging.log(ctx, f.name, input, output, err)
+		}
+		if f.hooks != nil {
+			if err != nil && f.hooks.OnError != nil {
+				f.hooks.OnError(ctx, err)
+			} else if err == nil && f.hooks.OnSuccess != nil {
+				f.hooks.OnSuccess(ctx, output)
+			}
+		}
 		if err != nil {
 			// TODO: handle InterruptError
 			logger.FromContext(ctx).Error("flow failed",
@@ -475,7 +661,6 @@ func (f *Flow[In, Out, Stream]) execute(ctx context.Context, state *flowState[In
 		// TODO: telemetry
 		return output, err
 	})
-	// TODO: perhaps this should be in a defer, to handle panics?
 	state.mu.Lock()
 	defer state.mu.Unlock()
 	state.Operation.Done = true
@@ -624,9 +809,81 @@ func (f *Flow[In, Out, Stream]) run(ctx context.Context, input In, cb func(conte
 	if err != nil {
 		return base.Zero[Out](), err
 	}
+	if runOpts.webhookURL != "" {
+		go deliverWebhook(context.WithoutCancel(ctx), runOpts.webhookURL, completionPayload(state))
+	}
 	return finishedOpResponse(state.Operation)
 }
 
+// FlowAsTool defines a tool that runs f, so that a model can call the flow
+// from the tool loop of [ai.Generate]. The tool has the flow's name, and its
+// input and output schemas are those of the flow. The flow is run with
+// [Flow.Run], so it does not stream.
+// The tool is defined in the registry of the flow, so a flow defined with
+// [DefineFlowInRegistry] gives a tool that only that registry's flows can
+// call. The tool is defined once; later calls return the same tool.
+// Like other tools, it must be defined before [Init] is called.
+func FlowAsTool[In, Out, Stream any](f *Flow[In, Out, Stream]) *ai.ToolDef[In, Out] {
+	f.toolOnce.Do(func() {
+		r := f.reg
+		if r == nil {
+			r = registry.Global
+		}
+		f.tool = ai.DefineToolInRegistry(r, f.name, fmt.Sprintf("Runs the %s flow.", f.name),
+			func(ctx context.Context, input In) (Out, error) {
+				return f.Run(ctx, input)
+			})
+	})
+	return f.tool
+}
+
+// Chain returns a function that runs first on its input and then second
+// on the output of first. The function can be passed to [DefineFlow] to define
+// a flow that pipes one flow into another; chaining that flow in turn
+// composes longer pipelines:
+//
+//	ab := genkit.DefineFlow("ab", genkit.Chain(a, b))
+//	abc := genkit.DefineFlow("abc", genkit.Chain(ab, c))
+func Chain[In, Mid, Out, S1, S2 any](first *Flow[In, Mid, S1], second *Flow[Mid, Out, S2]) func(context.Context, In) (Out, error) {
+	return func(ctx context.Context, input In) (Out, error) {
+		mid, err := first.Run(ctx, input)
+		if err != nil {
+			return base.Zero[Out](), err
+		}
+		return second.Run(ctx, mid)
+	}
+}
+
+// Parallel returns a function that runs each of flows concurrently on its
+// input and returns their outputs in the same order as flows.
+// If any flow fails, the function returns an error joining the errors of
+// all failed flows, each prefixed by the flow's name.
+// Like the result of [Chain], the function can be passed to [DefineFlow].
+func Parallel[In, Out, Stream any](flows ...*Flow[In, Out, Stream]) func(context.Context, In) ([]Out, error) {
+	return func(ctx context.Context, input In) ([]Out, error) {
+		outs := make([]Out, len(flows))
+		errs := make([]error, len(flows))
+		var wg sync.WaitGroup
+		for i, f := range flows {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				out, err := f.Run(ctx, input)
+				if err != nil {
+					errs[i] = fmt.Errorf("%s: %w", f.name, err)
+					return
+				}
+				outs[i] = out
+			}()
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+		return outs, nil
+	}
+}
+
 // StreamFlowValue is either a streamed value or a final output of a flow.
 type StreamFlowValue[Out, Stream any] struct {
 	Done   bool
@@ -0,0 +1,136 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/core/tracing"
+)
+
+// A FlowCacheStore stores the outputs of flows defined with [WithFlowCache].
+// Keys are opaque strings derived from the flow name and its input.
+type FlowCacheStore interface {
+	// Get returns the output stored under key, if any.
+	Get(ctx context.Context, key string) (json.RawMessage, bool)
+	// Put stores an output under key. If ttl is positive, the output
+	// should be discarded after ttl.
+	Put(ctx context.Context, key string, output json.RawMessage, ttl time.Duration)
+}
+
+// NewMemoryFlowCacheStore returns an in-memory [FlowCacheStore].
+func NewMemoryFlowCacheStore() FlowCacheStore {
+	return &memoryFlowCacheStore{entries: map[string]memoryFlowCacheEntry{}}
+}
+
+type memoryFlowCacheStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryFlowCacheEntry
+}
+
+type memoryFlowCacheEntry struct {
+	output  json.RawMessage
+	expires time.Time // zero if the entry never expires
+}
+
+func (s *memoryFlowCacheStore) Get(_ context.Context, key string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return e.output, true
+}
+
+func (s *memoryFlowCacheStore) Put(_ context.Context, key string, output json.RawMessage, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := memoryFlowCacheEntry{output: output}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	s.entries[key] = e
+}
+
+// flowCache holds the arguments of [WithFlowCache].
+type flowCache struct {
+	store FlowCacheStore
+	ttl   time.Duration
+}
+
+// WithFlowCache caches the outputs of the flow in store, keyed on a hash
+// of the flow's JSON-encoded input, so that running the flow again with
+// the same input within ttl returns the cached output without running
+// the flow function. If ttl is zero, outputs are cached until the store
+// discards them. Errors are not cached.
+// It is meant for expensive flows whose output depends only on their input.
+// Runs that stream are not cached, since the cache does not hold the
+// streamed values.
+func WithFlowCache(store FlowCacheStore, ttl time.Duration) FlowOption {
+	return func(f *flowOptions) {
+		if f.cache != nil {
+			log.Panic("cache already set in flow")
+		}
+		f.cache = &flowCache{store: store, ttl: ttl}
+	}
+}
+
+// runCached returns the cached output of the flow named name for input,
+// if there is one; otherwise it returns the result of fn, caching it if
+// it succeeds.
+func runCached[In, Out any](ctx context.Context, c *flowCache, name string, input In, fn func(context.Context) (Out, error)) (Out, error) {
+	key, err := flowCacheKey(name, input)
+	if err != nil {
+		// Inputs that can't be encoded aren't cached.
+		return fn(ctx)
+	}
+	if data, ok := c.store.Get(ctx, key); ok {
+		var out Out
+		if err := json.Unmarshal(data, &out); err == nil {
+			tracing.SetCustomMetadataAttr(ctx, "flow:cacheHit", "true")
+			return out, nil
+		}
+		// Ignore an entry that no longer matches the output type.
+	}
+	out, err := fn(ctx)
+	if err != nil {
+		return out, err
+	}
+	if data, err := json.Marshal(out); err == nil {
+		c.store.Put(ctx, key, data, c.ttl)
+	}
+	return out, nil
+}
+
+// flowCacheKey returns the cache key for running the flow named name on input.
+func flowCacheKey(name string, input any) (string, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return name + "/" + hex.EncodeToString(sum[:]), nil
+}
@@ -15,13 +15,23 @@
 package genkit
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/core/tracing"
+	"github.com/firebase/genkit/go/internal/base"
 	"github.com/firebase/genkit/go/internal/registry"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -147,3 +157,400 @@ func TestFlowState(t *testing.T) {
 		t.Errorf("mismatch (-want, +got):\n%s", diff)
 	}
 }
+
+func TestCompletionWebhook(t *testing.T) {
+	defer func(d time.Duration) { webhookRetryDelay = d }(webhookRetryDelay)
+	webhookRetryDelay = time.Millisecond
+
+	attempts := 0
+	got := make(chan CompletionWebhookPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			// Fail the first delivery to exercise retries.
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var p CompletionWebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		got <- p
+	}))
+	defer srv.Close()
+
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished := false
+	f := defineFlow(r, "webhooked", func(_ context.Context, i int, _ noStream) (int, error) {
+		finished = true
+		return i + 1, nil
+	})
+	if _, err := f.Run(context.Background(), 1, WithCompletionWebhook(srv.URL)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case p := <-got:
+		if !finished {
+			t.Error("webhook delivered before the flow finished")
+		}
+		if p.FlowID == "" || p.TraceID == "" {
+			t.Errorf("missing flow or trace ID in %+v", p)
+		}
+		want := CompletionWebhookPayload{FlowID: p.FlowID, FlowName: "webhooked", TraceID: p.TraceID, Status: "done", Result: float64(2)}
+		if diff := cmp.Diff(want, p); diff != "" {
+			t.Errorf("payload mismatch (-want, +got):\n%s", diff)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+	if attempts != 2 {
+		t.Errorf("got %d delivery attempts, want 2", attempts)
+	}
+}
+
+func TestFlowAsTool(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type doubleInput struct {
+		N int `json:"n"`
+	}
+	f := defineFlow(r, "double", func(_ context.Context, in doubleInput, _ noStream) (int, error) {
+		return in.N * 2, nil
+	})
+	tool := FlowAsTool(f)
+
+	def := tool.Definition()
+	if def.Name != "double" {
+		t.Errorf("got tool name %q, want %q", def.Name, "double")
+	}
+	in, out := f.schemas()
+	if diff := cmp.Diff(base.SchemaAsMap(in), def.InputSchema); diff != "" {
+		t.Errorf("input schema mismatch (-flow, +tool):\n%s", diff)
+	}
+	if diff := cmp.Diff(base.SchemaAsMap(out), def.OutputSchema); diff != "" {
+		t.Errorf("output schema mismatch (-flow, +tool):\n%s", diff)
+	}
+
+	m := ai.DefineModel("test", "flowToolCaller", nil, func(ctx context.Context, req *ai.ModelRequest, _ ai.ModelStreamingCallback) (*ai.ModelResponse, error) {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == ai.RoleTool {
+			out := last.Content[0].ToolResponse.Output["response"]
+			return &ai.ModelResponse{Request: req, Message: ai.NewModelTextMessage(fmt.Sprintf("the answer is %v", out))}, nil
+		}
+		return &ai.ModelResponse{
+			Request: req,
+			Message: &ai.Message{
+				Role: ai.RoleModel,
+				Content: []*ai.Part{ai.NewToolRequestPart(&ai.ToolRequest{
+					Name:  "double",
+					Input: map[string]any{"n": 21},
+				})},
+			},
+		}, nil
+	})
+	if again := FlowAsTool(f); again != tool {
+		t.Error("second FlowAsTool call returned a different tool")
+	}
+	// The tool is in the flow's registry, not the global one.
+	if ai.LookupToolInRegistry(registry.Global, "double") != nil {
+		t.Error("tool for a flow in a registry was defined globally")
+	}
+	ctx := registry.NewContext(context.Background(), r)
+	resp, err := ai.Generate(ctx, m, ai.WithTextPrompt("double 21"), ai.WithTools(tool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resp.Text(), "the answer is 42"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFlowLogging(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	type user struct {
+		Name string `json:"name"`
+		SSN  string `json:"ssn"`
+	}
+	f := defineFlow(r, "greet", func(_ context.Context, u user, _ noStream) (string, error) {
+		return "hello " + u.Name, nil
+	}, WithFlowLogging(nil, "ssn"))
+	if _, err := f.Run(context.Background(), user{Name: "Ada", SSN: "123-45-6789"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var record struct {
+		Msg    string `json:"msg"`
+		Flow   string `json:"flow"`
+		Input  string `json:"input"`
+		Output string `json:"output"`
+	}
+	for _, line := range bytes.Split(buf.Bytes(), []byte("\n")) {
+		if bytes.Contains(line, []byte(`"flow io"`)) {
+			if err := json.Unmarshal(line, &record); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if record.Msg == "" {
+		t.Fatalf("no flow io record in log output:\n%s", buf.String())
+	}
+	if want := `{"name":"Ada","ssn":"[REDACTED]"}`; record.Input != want {
+		t.Errorf("got input %s, want %s", record.Input, want)
+	}
+	if want := `"hello Ada"`; record.Output != want {
+		t.Errorf("got output %s, want %s", record.Output, want)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("123-45-6789")) {
+		t.Error("log output contains the sensitive field")
+	}
+}
+
+func TestChain(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc := defineFlow(r, "chainInc", incFlow)
+	str := defineFlow(r, "chainStr", func(_ context.Context, i int, _ noStream) (string, error) {
+		return fmt.Sprintf("<%d>", i), nil
+	})
+	incInc := defineFlow(r, "chainIncInc", func(ctx context.Context, i int, _ noStream) (int, error) {
+		return Chain(inc, inc)(ctx, i)
+	})
+	got, err := Chain(incInc, str)(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<3>"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	fail := defineFlow(r, "chainFail", func(_ context.Context, i int, _ noStream) (int, error) {
+		return 0, errors.New("boom")
+	})
+	if _, err := Chain(fail, str)(context.Background(), 1); err == nil {
+		t.Error("got nil error from chain with failing flow")
+	}
+}
+
+func TestParallel(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	double := defineFlow(r, "parallelDouble", func(_ context.Context, i int, _ noStream) (int, error) {
+		return i * 2, nil
+	})
+	square := defineFlow(r, "parallelSquare", func(_ context.Context, i int, _ noStream) (int, error) {
+		return i * i, nil
+	})
+	got, err := Parallel(double, square)(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int{10, 25}, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	fail := defineFlow(r, "parallelFail", func(_ context.Context, i int, _ noStream) (int, error) {
+		return 0, errors.New("boom")
+	})
+	_, err = Parallel(double, fail)(context.Background(), 5)
+	if err == nil || !strings.HasPrefix(err.Error(), "parallelFail: ") {
+		t.Errorf("got error %v, want one prefixed by the failing flow's name", err)
+	}
+}
+
+func TestFlowHooks(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	hooks := FlowHooks{
+		OnStart:   func(_ context.Context, input any) { events = append(events, fmt.Sprintf("start %v", input)) },
+		OnSuccess: func(_ context.Context, output any) { events = append(events, fmt.Sprintf("success %v", output)) },
+		OnError:   func(_ context.Context, err error) { events = append(events, fmt.Sprintf("error %v", err)) },
+	}
+	f := defineFlow(r, "hooked", func(_ context.Context, i int, _ noStream) (int, error) {
+		if i < 0 {
+			return 0, errors.New("negative")
+		}
+		return i + 1, nil
+	}, WithFlowHooks(hooks))
+
+	if _, err := f.Run(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Run(context.Background(), -1); err == nil {
+		t.Fatal("got nil error, want error")
+	}
+	want := []string{"start 1", "success 2", "start -1", "error negative"}
+	if diff := cmp.Diff(want, events); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestSecret(t *testing.T) {
+	const key = "sk-test-1234"
+	t.Setenv("GENKIT_TEST_API_KEY", key)
+
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := tracing.NewTestOnlyTelemetryClient()
+	r.TracingState().WriteTelemetryImmediate(tc)
+	f := defineFlow(r, "secret", func(ctx context.Context, _ string, _ noStream) (string, error) {
+		k, err := Secret(ctx, "GENKIT_TEST_API_KEY")
+		if err != nil {
+			return "", err
+		}
+		return "called with " + k, nil
+	})
+	got, err := f.Run(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "called with " + key; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var found bool
+	for _, td := range tc.Traces {
+		for _, sd := range td.Spans {
+			for k, v := range sd.Attributes {
+				if s, ok := v.(string); ok && strings.Contains(s, key) {
+					t.Errorf("span %s: attribute %s contains the secret: %q", sd.DisplayName, k, s)
+				}
+			}
+			if sd.Attributes["genkit:name"] == "secret" {
+				found = true
+				if g, w := sd.Attributes["genkit:output"], `"called with [REDACTED]"`; g != w {
+					t.Errorf("output: got %v, want %s", g, w)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Error("no span for the flow")
+	}
+
+	_, err = Secret(context.Background(), "GENKIT_TEST_MISSING_SECRET")
+	if !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("got error %v, want ErrSecretNotFound", err)
+	}
+}
+
+func TestFlowExamples(t *testing.T) {
+	type order struct {
+		Item     string `json:"item"`
+		Quantity int    `json:"quantity"`
+	}
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "order", func(_ context.Context, o order, _ noStream) (string, error) {
+		return o.Item, nil
+	}, WithFlowExample(order{Item: "pizza", Quantity: 2}), WithFlowExample(map[string]any{"item": "soup", "quantity": 1}))
+
+	// Check the descriptor as the dev UI sees it.
+	data, err := json.Marshal(r.LookupAction("/flow/order").Desc())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var desc struct {
+		InputSchema json.RawMessage `json:"inputSchema"`
+		Metadata    struct {
+			Examples []json.RawMessage `json:"examples"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &desc); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ex := range desc.Metadata.Examples {
+		if err := base.ValidateRaw(ex, desc.InputSchema); err != nil {
+			t.Errorf("example %s: %v", ex, err)
+		}
+		got = append(got, string(ex))
+	}
+	want := []string{`{"item":"pizza","quantity":2}`, `{"item":"soup","quantity":1}`}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("examples mismatch (-want, +got):\n%s", diff)
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("defining a flow with an invalid example did not panic")
+			}
+		}()
+		defineFlow(r, "badOrder", func(_ context.Context, o order, _ noStream) (string, error) {
+			return o.Item, nil
+		}, WithFlowExample("pizza"))
+	})
+}
+
+func TestFlowCache(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	f := defineFlow(r, "cached", func(_ context.Context, i int, cb func(context.Context, int) error) (int, error) {
+		calls++
+		if cb != nil {
+			if err := cb(context.Background(), i); err != nil {
+				return 0, err
+			}
+		}
+		return i * 10, nil
+	}, WithFlowCache(NewMemoryFlowCacheStore(), 50*time.Millisecond))
+
+	run := func(input, wantCalls int) {
+		t.Helper()
+		got, err := f.Run(context.Background(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != input*10 {
+			t.Errorf("Run(%d) = %d, want %d", input, got, input*10)
+		}
+		if calls != wantCalls {
+			t.Errorf("after Run(%d): flow body ran %d times, want %d", input, calls, wantCalls)
+		}
+	}
+	run(1, 1)
+	run(1, 1) // cached
+	run(2, 2)
+
+	// Streaming runs bypass the cache.
+	f.Stream(context.Background(), 1)(func(_ *StreamFlowValue[int, int], err error) bool {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return true
+	})
+	if calls != 3 {
+		t.Errorf("after streaming run: flow body ran %d times, want 3", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	run(1, 4) // expired
+}
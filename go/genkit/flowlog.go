@@ -0,0 +1,113 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/firebase/genkit/go/core/logger"
+	"github.com/firebase/genkit/go/core/tracing"
+)
+
+// A RedactFunc returns the value to log in place of value,
+// the value of the sensitive field with the given JSON name.
+type RedactFunc func(field string, value any) any
+
+// Redacted is the value logged for a sensitive field
+// when [WithFlowLogging] is given a nil [RedactFunc].
+// It is the same as [tracing.Redacted], which replaces secrets in traces.
+const Redacted = tracing.Redacted
+
+// WithFlowLogging logs the input and output of every run of the flow,
+// for example as an audit log. Each run produces a "flow io" record in
+// the context's logger, with the JSON input and either the JSON output
+// or the error.
+//
+// Any field of the input or output, at any depth, whose JSON name is one
+// of sensitiveFields is replaced by the result of calling redact on it.
+// If redact is nil, such fields are replaced by [Redacted].
+func WithFlowLogging(redact RedactFunc, sensitiveFields ...string) FlowOption {
+	return func(f *flowOptions) {
+		if f.logging != nil {
+			log.Panic("logging already set in flow")
+		}
+		if redact == nil {
+			redact = func(string, any) any { return Redacted }
+		}
+		fields := map[string]bool{}
+		for _, name := range sensitiveFields {
+			fields[name] = true
+		}
+		f.logging = &flowLogging{redact: redact, fields: fields}
+	}
+}
+
+// flowLogging holds the arguments of [WithFlowLogging].
+type flowLogging struct {
+	redact RedactFunc
+	fields map[string]bool
+}
+
+// log logs the input and the output or error of a flow run.
+func (l *flowLogging) log(ctx context.Context, flowName string, input, output any, err error) {
+	args := []any{"flow", flowName, "input", l.redactedJSON(input)}
+	if err != nil {
+		args = append(args, "err", err.Error())
+	} else {
+		args = append(args, "output", l.redactedJSON(output))
+	}
+	logger.FromContext(ctx).Info("flow io", args...)
+}
+
+// redactedJSON returns the JSON encoding of v with its sensitive fields redacted.
+func (l *flowLogging) redactedJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "<unmarshalable: " + err.Error() + ">"
+	}
+	if len(l.fields) == 0 {
+		return string(b)
+	}
+	var x any
+	if err := json.Unmarshal(b, &x); err != nil {
+		return "<unmarshalable: " + err.Error() + ">"
+	}
+	b, err = json.Marshal(l.redactValue(x))
+	if err != nil {
+		return "<unmarshalable: " + err.Error() + ">"
+	}
+	return string(b)
+}
+
+// redactValue redacts the sensitive fields of x, a decoded JSON value.
+func (l *flowLogging) redactValue(x any) any {
+	switch x := x.(type) {
+	case map[string]any:
+		for k, v := range x {
+			if l.fields[k] {
+				x[k] = l.redact(k, v)
+			} else {
+				x[k] = l.redactValue(v)
+			}
+		}
+	case []any:
+		for i, v := range x {
+			x[i] = l.redactValue(v)
+		}
+	}
+	return x
+}
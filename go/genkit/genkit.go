@@ -25,7 +25,9 @@ import (
 	"sync"
 	"syscall"
 
+	"github.com/firebase/genkit/go/internal/base"
 	"github.com/firebase/genkit/go/internal/registry"
+	"google.golang.org/grpc"
 )
 
 // Options are options to [Init].
@@ -42,20 +44,27 @@ type Options struct {
 // Init initializes Genkit.
 // After it is called, no further actions can be defined.
 //
-// Init starts servers depending on the value of the GENKIT_ENV
-// environment variable and the provided options.
+// Init starts servers depending on the environment and the provided options.
+// The environment is set by [WithEnv], or else by the GENKIT_ENV
+// environment variable, and defaults to "prod".
 //
-// If GENKIT_ENV = "dev", a development server is started
-// in a separate goroutine at the address in opts.DevAddr, or the default
-// of ":3100" if empty.
+// In the "dev" environment, a development server is started
+// in a separate goroutine on the port set by [WithDevPort], or else by the
+// GENKIT_REFLECTION_PORT environment variable, or the default of 3100.
 //
 // If opts.FlowAddr is a value other than "-", a flow server is started
 // and the call to Init waits for the server to shut down.
+// If opts.FlowAddr is empty, the flow server listens on the port set by
+// [WithProdPort], or else by the PORT environment variable, or the default of 3400.
 // If opts.FlowAddr == "-", no flow server is started and Init returns immediately.
 //
 // Thus Init(nil) will start a dev server in the "dev" environment, will always start
 // a flow server, and will pause execution until the flow server terminates.
-func Init(ctx context.Context, opts *Options) error {
+//
+// If [WithGRPC] is passed, flows are also served over gRPC.
+//
+// The ServerOptions configure the flow server.
+func Init(ctx context.Context, opts *Options, sopts ...ServerOption) error {
 	if opts == nil {
 		opts = &Options{}
 	}
@@ -64,24 +73,31 @@ func Init(ctx context.Context, opts *Options) error {
 	var mu sync.Mutex
 	var servers []*http.Server
 	var wg sync.WaitGroup
-	errCh := make(chan error, 2)
+	errCh := make(chan error, 3)
 
-	if registry.CurrentEnvironment() == registry.EnvironmentDev {
+	so := newServerOptions(sopts)
+	if so.environment() == registry.EnvironmentDev {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			s := startReflectionServer(ctx, errCh)
+			s := startReflectionServer(ctx, errCh, so.devPort, so.maxBodySize, so.httpMiddleware, so.httpServer)
 			mu.Lock()
 			servers = append(servers, s)
 			mu.Unlock()
 		}()
 	}
 
+	var grpcServer *grpc.Server
+	if so.grpcAddr != "" {
+		grpcServer = startGRPCServer(so.grpcAddr, opts.Flows, errCh)
+		defer grpcServer.GracefulStop()
+	}
+
 	if opts.FlowAddr != "-" {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			s := startFlowServer(opts.FlowAddr, opts.Flows, errCh)
+			s := startFlowServer(opts.FlowAddr, opts.Flows, errCh, sopts...)
 			mu.Lock()
 			servers = append(servers, s)
 			mu.Unlock()
@@ -120,3 +136,11 @@ func Init(ctx context.Context, opts *Options) error {
 
 	return shutdownServers(servers)
 }
+
+// WithDryRun returns a context in which model calls do not reach the provider.
+// Instead, a model's Generate method returns a synthetic response whose text
+// is a JSON description of the request: its messages, config and tools.
+// This lets flows be exercised in tests and CI without network access or cost.
+func WithDryRun(ctx context.Context) context.Context {
+	return base.DryRunKey.NewContext(ctx, true)
+}
@@ -16,7 +16,10 @@ package genkit
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
+
+	"github.com/firebase/genkit/go/ai"
 )
 
 func TestStreamFlow(t *testing.T) {
@@ -52,3 +55,32 @@ func count(ctx context.Context, n int, cb func(context.Context, int) error) (int
 	}
 	return n, nil
 }
+
+func TestDryRun(t *testing.T) {
+	model := ai.DefineModel("test", "dryRun", nil, func(context.Context, *ai.ModelRequest, ai.ModelStreamingCallback) (*ai.ModelResponse, error) {
+		t.Fatal("model called in dry-run mode")
+		return nil, nil
+	})
+	f := DefineFlow("dryRunFlow", func(ctx context.Context, subject string) (string, error) {
+		return ai.GenerateText(ctx, model,
+			ai.WithTextPrompt("Tell me a joke about "+subject),
+			ai.WithConfig(&ai.GenerationCommonConfig{Temperature: 0.5}))
+	})
+	got, err := f.Run(WithDryRun(context.Background()), "cats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var desc struct {
+		Messages []*ai.Message
+		Config   map[string]any
+	}
+	if err := json.Unmarshal([]byte(got), &desc); err != nil {
+		t.Fatalf("dry-run response is not JSON: %v\n%s", err, got)
+	}
+	if len(desc.Messages) != 1 || desc.Messages[0].Content[0].Text != "Tell me a joke about cats" {
+		t.Errorf("dry-run response does not capture the prompt:\n%s", got)
+	}
+	if g, w := desc.Config["temperature"], 0.5; g != w {
+		t.Errorf("temperature: got %v, want %v", g, w)
+	}
+}
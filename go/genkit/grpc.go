@@ -0,0 +1,164 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+
+	"github.com/firebase/genkit/go/internal/registry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// FlowServiceName is the name of the gRPC service registered by
+// [RegisterFlowService].
+const FlowServiceName = "genkit.Flows"
+
+// RegisterFlowService registers a gRPC service named [FlowServiceName] on s.
+// If flows is non-empty, each of the named flows is exposed as a method.
+// Otherwise, all defined flows are exposed.
+//
+// A flow named F has a unary method "/genkit.Flows/F" and a server-streaming
+// method "/genkit.Flows/FStream". Both take a google.protobuf.BytesValue
+// holding the JSON input to the flow. The unary method returns a BytesValue
+// holding the JSON output of the flow. The streaming method sends a
+// BytesValue for each JSON chunk the flow streams, followed by one
+// holding the JSON output.
+// The "authorization" metadata value is passed to the flow's auth policy,
+// like the Authorization header of an HTTP request.
+func RegisterFlowService(s grpc.ServiceRegistrar, flows []string) {
+	registerFlowService(registry.Global, s, flows)
+}
+
+func registerFlowService(r *registry.Registry, s grpc.ServiceRegistrar, flows []string) {
+	sd := &grpc.ServiceDesc{
+		ServiceName: FlowServiceName,
+		HandlerType: (*any)(nil),
+	}
+	m := map[string]bool{}
+	for _, f := range flows {
+		m[f] = true
+	}
+	for _, f := range r.ListFlows() {
+		f := f.(flow)
+		if len(flows) == 0 || m[f.Name()] {
+			sd.Methods = append(sd.Methods, grpc.MethodDesc{
+				MethodName: f.Name(),
+				Handler:    unaryFlowHandler(f),
+			})
+			sd.Streams = append(sd.Streams, grpc.StreamDesc{
+				StreamName:    f.Name() + "Stream",
+				Handler:       streamingFlowHandler(f),
+				ServerStreams: true,
+			})
+		}
+	}
+	s.RegisterService(sd, nil)
+}
+
+// unaryFlowHandler returns a gRPC method handler that runs f.
+func unaryFlowHandler(f flow) func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		in := new(wrapperspb.BytesValue)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		run := func(ctx context.Context, req any) (any, error) {
+			out, err := f.runJSON(ctx, grpcAuthHeader(ctx), req.(*wrapperspb.BytesValue).Value, nil)
+			if err != nil {
+				return nil, grpcError(err)
+			}
+			return wrapperspb.Bytes(out), nil
+		}
+		if interceptor == nil {
+			return run(ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: "/" + FlowServiceName + "/" + f.Name(),
+		}
+		return interceptor(ctx, in, info, run)
+	}
+}
+
+// streamingFlowHandler returns a gRPC stream handler that runs f,
+// sending each streamed chunk and then the result.
+func streamingFlowHandler(f flow) grpc.StreamHandler {
+	return func(_ any, stream grpc.ServerStream) error {
+		in := new(wrapperspb.BytesValue)
+		if err := stream.RecvMsg(in); err != nil {
+			return err
+		}
+		ctx := stream.Context()
+		callback := func(ctx context.Context, msg json.RawMessage) error {
+			return stream.SendMsg(wrapperspb.Bytes(msg))
+		}
+		out, err := f.runJSON(ctx, grpcAuthHeader(ctx), in.Value, callback)
+		if err != nil {
+			return grpcError(err)
+		}
+		return stream.SendMsg(wrapperspb.Bytes(out))
+	}
+}
+
+// grpcAuthHeader returns the "authorization" metadata value of ctx, if any.
+func grpcAuthHeader(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vs := md.Get("authorization"); len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// grpcError converts an error from running a flow into a gRPC status error.
+// The code is the gRPC code of the same name as the status of the
+// [FlowError] that an HTTP client would see, so that both kinds of
+// client see the same code.
+func grpcError(err error) error {
+	ferr, _ := toFlowError(err)
+	var code codes.Code
+	if code.UnmarshalJSON([]byte(strconv.Quote(string(ferr.Status)))) != nil {
+		code = codes.Unknown
+	}
+	return status.Error(code, ferr.Message)
+}
+
+// startGRPCServer starts a gRPC server listening at addr
+// that serves the flow service.
+func startGRPCServer(addr string, flows []string, errCh chan<- error) *grpc.Server {
+	slog.Debug("starting gRPC server")
+	server := grpc.NewServer()
+	RegisterFlowService(server, flows)
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		errCh <- fmt.Errorf("gRPC server error on %s: %w", addr, err)
+		return server
+	}
+	go func() {
+		slog.Debug("gRPC server listening", "addr", addr)
+		if err := server.Serve(lis); err != nil {
+			errCh <- fmt.Errorf("gRPC server error on %s: %w", addr, err)
+		}
+	}()
+	return server
+}
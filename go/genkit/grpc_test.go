@@ -0,0 +1,55 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/firebase/genkit/go/internal/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCError(t *testing.T) {
+	httpErr := func(code int) error {
+		return &base.HTTPError{Code: code, Err: errors.New("oops")}
+	}
+	for _, test := range []struct {
+		err  error
+		want codes.Code
+	}{
+		{NewFlowError(StatusNotFound, "no"), codes.NotFound},
+		{fmt.Errorf("wrapped: %w", NewFlowError(StatusAborted, "no")), codes.Aborted},
+		{httpErr(http.StatusBadRequest), codes.InvalidArgument},
+		{httpErr(http.StatusConflict), codes.Aborted},
+		{httpErr(http.StatusRequestEntityTooLarge), codes.ResourceExhausted},
+		{httpErr(http.StatusUnprocessableEntity), codes.FailedPrecondition},
+		{httpErr(http.StatusTooManyRequests), codes.ResourceExhausted},
+		{httpErr(http.StatusNotImplemented), codes.Unimplemented},
+		{httpErr(http.StatusServiceUnavailable), codes.Unavailable},
+		{httpErr(http.StatusGatewayTimeout), codes.DeadlineExceeded},
+		{context.Canceled, codes.Canceled},
+		{context.DeadlineExceeded, codes.DeadlineExceeded},
+		{errors.New("boom"), codes.Internal},
+	} {
+		if got := status.Code(grpcError(test.err)); got != test.want {
+			t.Errorf("%v: got code %v, want %v", test.err, got, test.want)
+		}
+	}
+}
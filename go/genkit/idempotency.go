@@ -0,0 +1,210 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/internal/base"
+)
+
+// An IdempotencyStore stores the results of flow invocations made with
+// an Idempotency-Key header, so that a repeated request with the same key
+// returns the stored result instead of running the flow again.
+// Keys are opaque strings derived from the flow name, the caller's
+// credentials and the header value. Values are opaque JSON holding the
+// result and a hash of the input that produced it.
+type IdempotencyStore interface {
+	// Get returns the value stored under key, if any.
+	Get(ctx context.Context, key string) (json.RawMessage, bool)
+	// Put stores a value under key.
+	Put(ctx context.Context, key string, value json.RawMessage)
+}
+
+// NewMemoryIdempotencyStore returns an in-memory [IdempotencyStore].
+// Entries expire after ttl, which is the window within which
+// a repeated key is deduplicated, and expired entries are deleted as new
+// ones are stored. If ttl is zero, entries never expire.
+func NewMemoryIdempotencyStore(ttl time.Duration) IdempotencyStore {
+	return &memoryIdempotencyStore{
+		ttl:     ttl,
+		entries: map[string]memoryIdempotencyEntry{},
+	}
+}
+
+type memoryIdempotencyStore struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	entries   map[string]memoryIdempotencyEntry
+	nextSweep time.Time // when Put next deletes expired entries
+}
+
+type memoryIdempotencyEntry struct {
+	result  json.RawMessage
+	expires time.Time // zero if the entry never expires
+}
+
+func (s *memoryIdempotencyStore) Get(_ context.Context, key string) (json.RawMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(s.entries, key)
+		return nil, false
+	}
+	return e.result, true
+}
+
+func (s *memoryIdempotencyStore) Put(_ context.Context, key string, result json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := memoryIdempotencyEntry{result: result}
+	if s.ttl > 0 {
+		now := time.Now()
+		e.expires = now.Add(s.ttl)
+		// Entries are otherwise deleted only when their key is read again,
+		// which may never happen. Sweeping at most once per ttl keeps Put
+		// cheap while holding the store to the entries of about two ttls.
+		if !now.Before(s.nextSweep) {
+			for k, e := range s.entries {
+				if now.After(e.expires) {
+					delete(s.entries, k)
+				}
+			}
+			s.nextSweep = now.Add(s.ttl)
+		}
+	}
+	s.entries[key] = e
+}
+
+// idempotency deduplicates flow invocations by idempotency key.
+// Completed results are kept in the store; invocations still in
+// progress are tracked in memory, so that a concurrent request with
+// the same key waits for the first one instead of running the flow again.
+type idempotency struct {
+	store    IdempotencyStore
+	mu       sync.Mutex
+	inflight map[string]*idempotentCall
+}
+
+type idempotentCall struct {
+	done      chan struct{}
+	inputHash string
+	result    json.RawMessage
+	err       error
+}
+
+// idempotentEntry is the value kept in the store for a key.
+type idempotentEntry struct {
+	InputHash string          `json:"inputHash"`
+	Result    json.RawMessage `json:"result"`
+}
+
+// errIdempotencyKeyReused is returned when a key is sent again with a
+// different input.
+var errIdempotencyKeyReused = &base.HTTPError{
+	Code: http.StatusUnprocessableEntity,
+	Err:  errors.New("Idempotency-Key was already used with a different request body"),
+}
+
+// idempotencyKey returns the key under which the result of a request
+// to the named flow with the given Authorization header and
+// Idempotency-Key is stored. Including the caller keeps one caller
+// from replaying another's result by sending the same key; it is
+// hashed so that the store never holds credentials.
+func idempotencyKey(flowName, auth, key string) string {
+	h := sha256.Sum256([]byte(auth))
+	return fmt.Sprintf("%s/%s/%s", flowName, hex.EncodeToString(h[:]), key)
+}
+
+// inputHash returns a hash of the JSON input of a request,
+// ignoring insignificant whitespace.
+func inputHash(input json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, input); err != nil {
+		buf.Reset()
+		buf.Write(input)
+	}
+	h := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(h[:])
+}
+
+func newIdempotency(store IdempotencyStore) *idempotency {
+	return &idempotency{store: store, inflight: map[string]*idempotentCall{}}
+}
+
+// do returns the result of f for key, calling f only if there is
+// neither a stored result nor an invocation in progress for key.
+// It reports whether the result was replayed rather than computed.
+// Errors are not stored, so a failed invocation may be retried.
+// If the stored or in-progress invocation for key had an input other
+// than the one with hash inHash, do returns a 422 error.
+func (i *idempotency) do(ctx context.Context, key, inHash string, f func(context.Context) (json.RawMessage, error)) (_ json.RawMessage, replayed bool, _ error) {
+	i.mu.Lock()
+	if c, ok := i.inflight[key]; ok {
+		i.mu.Unlock()
+		if c.inputHash != inHash {
+			return nil, false, errIdempotencyKeyReused
+		}
+		select {
+		case <-c.done:
+			return c.result, c.err == nil, c.err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+	c := &idempotentCall{done: make(chan struct{}), inputHash: inHash}
+	i.inflight[key] = c
+	i.mu.Unlock()
+
+	defer func() {
+		i.mu.Lock()
+		delete(i.inflight, key)
+		i.mu.Unlock()
+		close(c.done)
+	}()
+
+	if value, ok := i.store.Get(ctx, key); ok {
+		var e idempotentEntry
+		if err := json.Unmarshal(value, &e); err == nil {
+			if e.InputHash != inHash {
+				c.err = errIdempotencyKeyReused
+				return nil, false, c.err
+			}
+			c.result = e.Result
+			return e.Result, true, nil
+		}
+		// An entry that can't be read is treated as missing.
+	}
+	c.result, c.err = f(ctx)
+	if c.err == nil {
+		if value, err := json.Marshal(idempotentEntry{InputHash: inHash, Result: c.result}); err == nil {
+			i.store.Put(ctx, key, value)
+		}
+	}
+	return c.result, false, c.err
+}
@@ -0,0 +1,373 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/internal/base"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWTAuthOptions configures [JWTAuth].
+type JWTAuthOptions struct {
+	// The URL of the JSON Web Key Set (JWKS) holding the public keys
+	// that sign the tokens. Required.
+	JWKSURL string
+	// If non-empty, the required value of the "iss" claim.
+	Issuer string
+	// If non-empty, a value that the "aud" claim must contain.
+	Audience string
+	// Policy checks the claims of a caller's token against the input
+	// of the flow. If nil, any valid token is accepted.
+	Policy func(auth AuthContext, input any) error
+	// Whether a token is required. If false, calls without an
+	// Authorization header run with no auth context.
+	Required bool
+	// The client used to fetch the key set. If nil, [http.DefaultClient] is used.
+	HTTPClient *http.Client
+}
+
+// JWTAuth returns a [FlowAuth], for use with [WithFlowAuth], that accepts
+// callers presenting a JSON Web Token in an "Authorization: Bearer" header.
+// The token's signature is verified with the keys published at
+// opts.JWKSURL, which may be RSA or ECDSA keys, and its expiry, issuer
+// and audience are checked. Tokens without an expiry are rejected.
+// The token's claims become the flow's
+// [AuthContext], which the flow can read with the FlowAuth's FromContext
+// method.
+//
+// The key set is fetched when the first token is verified, and fetched
+// again when it is older than the max-age of its Cache-Control header
+// (an hour if it has none), so that keys removed from the set stop being
+// accepted, and when a token is signed with an unknown key. Fetches,
+// including failed ones, happen at most once a minute.
+func JWTAuth(opts JWTAuthOptions) (FlowAuth, error) {
+	if opts.JWKSURL == "" {
+		return nil, errors.New("JWTAuth: JWKSURL is required")
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &jwtAuth{
+		opts: opts,
+		keys: &jwks{url: opts.JWKSURL, client: client},
+	}, nil
+}
+
+var jwtAuthContextKey = base.NewContextKey[AuthContext]()
+
+// jwtAuth is a FlowAuth that verifies JSON Web Tokens.
+type jwtAuth struct {
+	opts JWTAuthOptions
+	keys *jwks
+}
+
+// ProvideAuthContext verifies the bearer token in authHeader and sets
+// its claims as the auth context.
+func (a *jwtAuth) ProvideAuthContext(ctx context.Context, authHeader string) (context.Context, error) {
+	if authHeader == "" {
+		if a.opts.Required {
+			return nil, errors.New("authorization header is required but not provided")
+		}
+		return ctx, nil
+	}
+	const bearerPrefix = "bearer "
+	if !strings.HasPrefix(strings.ToLower(authHeader), bearerPrefix) {
+		return nil, errors.New("invalid authorization header format")
+	}
+	claims, err := a.verify(ctx, authHeader[len(bearerPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("error verifying token: %w", err)
+	}
+	return a.NewContext(ctx, AuthContext(claims)), nil
+}
+
+// jwtMethods are the signing methods accepted by JWTAuth.
+// They are all asymmetric, so that the key set holds only public keys.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// verify verifies the token and returns its claims.
+func (a *jwtAuth) verify(ctx context.Context, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(jwtMethods))
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.keys.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The parser checks exp only if it is present.
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("token has no expiry or is expired")
+	}
+	if a.opts.Issuer != "" && !claims.VerifyIssuer(a.opts.Issuer, true) {
+		return nil, fmt.Errorf("token issuer is not %q", a.opts.Issuer)
+	}
+	if a.opts.Audience != "" && !claims.VerifyAudience(a.opts.Audience, true) {
+		return nil, fmt.Errorf("token audience does not include %q", a.opts.Audience)
+	}
+	return claims, nil
+}
+
+// NewContext sets the auth context on the given context.
+func (a *jwtAuth) NewContext(ctx context.Context, authContext AuthContext) context.Context {
+	if ctx == nil {
+		return nil
+	}
+	return jwtAuthContextKey.NewContext(ctx, authContext)
+}
+
+// FromContext retrieves the auth context from the given context.
+func (*jwtAuth) FromContext(ctx context.Context) AuthContext {
+	if ctx == nil {
+		return nil
+	}
+	return jwtAuthContextKey.FromContext(ctx)
+}
+
+// CheckAuthPolicy checks the auth context against the policy.
+func (a *jwtAuth) CheckAuthPolicy(ctx context.Context, input any) error {
+	authContext := a.FromContext(ctx)
+	if authContext == nil {
+		if a.opts.Required {
+			return errors.New("auth is required")
+		}
+		return nil
+	}
+	if a.opts.Policy == nil {
+		return nil
+	}
+	return a.opts.Policy(authContext, input)
+}
+
+const (
+	// jwksMinRefresh is the minimum time between fetches of a key set,
+	// whether they succeed or fail.
+	jwksMinRefresh = time.Minute
+	// jwksMaxAge is how long a key set is used before it is fetched
+	// again, unless its response sets a max-age.
+	jwksMaxAge = time.Hour
+	// jwksFetchTimeout bounds a fetch of a key set.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// jwks is a JSON Web Key Set fetched from a URL.
+type jwks struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]any // public keys by key ID
+	expires   time.Time      // when keys must be fetched again; zero if never fetched
+	attempted time.Time      // when a fetch last started; zero if never
+	err       error          // error of the last fetch, if it failed
+	fetching  chan struct{}  // closed when the fetch in progress ends; nil if none
+}
+
+// key returns the public key with the given ID. The key set is fetched
+// if it hasn't been, if it is older than its max age, or if it lacks
+// the key, but no more than once every jwksMinRefresh. While a fetch
+// fails, the keys of the last successful fetch are used.
+// An empty kid matches the only key of a set with one key.
+func (s *jwks) key(ctx context.Context, kid string) (any, error) {
+	for {
+		s.mu.Lock()
+		k := s.lookup(kid)
+		if k != nil && time.Now().Before(s.expires) {
+			s.mu.Unlock()
+			return k, nil
+		}
+		if ch := s.fetching; ch != nil {
+			// Wait for the fetch in progress rather than starting another.
+			s.mu.Unlock()
+			select {
+			case <-ch:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if !s.attempted.IsZero() && time.Since(s.attempted) < jwksMinRefresh {
+			err := s.err
+			s.mu.Unlock()
+			switch {
+			case k != nil:
+				return k, nil
+			case err != nil:
+				return nil, err
+			default:
+				return nil, fmt.Errorf("no key with ID %q", kid)
+			}
+		}
+		ch := make(chan struct{})
+		s.fetching = ch
+		s.attempted = time.Now()
+		s.mu.Unlock()
+
+		// The fetch serves other callers too, so it isn't canceled with ctx.
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksFetchTimeout)
+		keys, maxAge, err := s.fetch(fctx)
+		cancel()
+
+		s.mu.Lock()
+		if err == nil {
+			s.keys = keys
+			s.expires = time.Now().Add(maxAge)
+		}
+		s.err = err
+		s.fetching = nil
+		close(ch)
+		s.mu.Unlock()
+		// Look the key up again; the fetch just attempted
+		// keeps the next iteration from fetching.
+	}
+}
+
+// requires s.mu
+func (s *jwks) lookup(kid string) any {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k
+		}
+	}
+	return s.keys[kid]
+}
+
+// fetch fetches and parses the key set, returning its keys and how long
+// they may be used. Malformed keys and keys of unsupported types are skipped.
+func (s *jwks) fetch(ctx context.Context) (_ map[string]any, maxAge time.Duration, _ error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("fetching key set: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("fetching key set: %s", res.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return nil, 0, fmt.Errorf("decoding key set: %w", err)
+	}
+	keys := map[string]any{}
+	for _, jk := range set.Keys {
+		if jk.Use != "" && jk.Use != "sig" {
+			continue
+		}
+		// A malformed key is skipped like a key of an unsupported type,
+		// so that it doesn't make the rest of the set unusable.
+		if k, err := jk.publicKey(); err == nil && k != nil {
+			keys[jk.Kid] = k
+		}
+	}
+	return keys, cacheMaxAge(res.Header.Get("Cache-Control")), nil
+}
+
+// cacheMaxAge returns the max-age of a Cache-Control header, no less
+// than jwksMinRefresh, or jwksMaxAge if the header doesn't set one.
+func cacheMaxAge(cacheControl string) time.Duration {
+	for _, d := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		secs, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || secs < 0 {
+			break
+		}
+		return max(time.Duration(secs)*time.Second, jwksMinRefresh)
+	}
+	return jwksMaxAge
+}
+
+// A jsonWebKey is a public key in a JSON Web Key Set (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+	Crv string `json:"crv"` // EC curve
+	X   string `json:"x"`   // EC point
+	Y   string `json:"y"`
+}
+
+// publicKey returns the key as an *rsa.PublicKey or *ecdsa.PublicKey,
+// or nil if its type is not supported.
+func (k *jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64URLInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64URLInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, nil
+		}
+		x, err := base64URLInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64URLInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func base64URLInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
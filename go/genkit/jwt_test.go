@@ -0,0 +1,244 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/internal/registry"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestJWTAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				// A malformed key must not make the others unusable.
+				"kty": "RSA",
+				"kid": "bad",
+				"n":   "!",
+				"e":   "AQAB",
+			}, {
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwksSrv.Close()
+
+	auth, err := JWTAuth(JWTAuthOptions{
+		JWKSURL:  jwksSrv.URL,
+		Issuer:   "https://issuer.example.com",
+		Audience: "my-app",
+		Required: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "whoami", func(ctx context.Context, _ string, _ noStream) (string, error) {
+		sub, _ := auth.FromContext(ctx)["sub"].(string)
+		return sub, nil
+	}, WithFlowAuth(auth))
+	srv := httptest.NewServer(newFlowServeMux(r, nil))
+	defer srv.Close()
+
+	sign := func(t *testing.T, k *rsa.PrivateKey, claims jwt.MapClaims) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		tok.Header["kid"] = "k1"
+		s, err := tok.SignedString(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	claims := func(exp time.Time, aud string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "user-1",
+			"iss": "https://issuer.example.com",
+			"aud": aud,
+			"exp": exp.Unix(),
+		}
+	}
+	post := func(t *testing.T, authHeader string) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/whoami", strings.NewReader(`{"data": ""}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	later := time.Now().Add(time.Hour)
+	t.Run("valid", func(t *testing.T) {
+		status, body := post(t, "Bearer "+sign(t, key, claims(later, "my-app")))
+		if status != http.StatusOK {
+			t.Fatalf("got status %d (%s), want 200", status, body)
+		}
+		got, err := readJSON[struct{ Result string }](strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "user-1"; got.Result != want {
+			t.Errorf("got %q, want %q", got.Result, want)
+		}
+	})
+	for _, test := range []struct {
+		name       string
+		authHeader string
+	}{
+		{"missing", ""},
+		{"not bearer", "Basic dXNlcjpwYXNz"},
+		{"wrong key", "Bearer " + sign(t, otherKey, claims(later, "my-app"))},
+		{"expired", "Bearer " + sign(t, key, claims(time.Now().Add(-time.Hour), "my-app"))},
+		{"wrong audience", "Bearer " + sign(t, key, claims(later, "other-app"))},
+		{"no expiry", "Bearer " + sign(t, key, jwt.MapClaims{
+			"sub": "user-1",
+			"iss": "https://issuer.example.com",
+			"aud": "my-app",
+		})},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if status, body := post(t, test.authHeader); status != http.StatusUnauthorized {
+				t.Errorf("got status %d (%s), want 401", status, body)
+			}
+		})
+	}
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu      sync.Mutex
+		kid     = "k1"
+		failing = false
+		fetches = 0
+	)
+	jwksSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetches++
+		if failing {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwksSrv.Close()
+	s := &jwks{url: jwksSrv.URL, client: http.DefaultClient}
+	ctx := context.Background()
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetches
+	}
+	// age makes the key set look fetched d ago.
+	age := func(d time.Duration) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.expires = s.expires.Add(-d)
+		s.attempted = s.attempted.Add(-d)
+	}
+
+	if _, err := s.key(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(s.expires); d < 4*time.Minute || d > 5*time.Minute {
+		t.Errorf("key set expires in %v, want the max-age of 5m", d)
+	}
+
+	// The key is removed from the set. It is accepted until the set expires.
+	mu.Lock()
+	kid = "k2"
+	mu.Unlock()
+	if _, err := s.key(ctx, "k1"); err != nil {
+		t.Errorf("before expiry: %v", err)
+	}
+	age(10 * time.Minute)
+	if _, err := s.key(ctx, "k1"); err == nil {
+		t.Error("removed key accepted after the key set expired")
+	}
+	if n := count(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+
+	// While the endpoint is down, unknown keys don't cause a fetch
+	// per request.
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	age(10 * time.Minute)
+	for range 5 {
+		if _, err := s.key(ctx, "unknown"); err == nil {
+			t.Fatal("got nil error for an unknown key")
+		}
+	}
+	if n := count(); n != 3 {
+		t.Errorf("got %d fetches, want 3", n)
+	}
+	// The keys of the last successful fetch are still used.
+	if _, err := s.key(ctx, "k2"); err != nil {
+		t.Errorf("while failing: %v", err)
+	}
+}
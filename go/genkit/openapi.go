@@ -0,0 +1,148 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/internal"
+	"github.com/firebase/genkit/go/internal/registry"
+	"github.com/invopop/jsonschema"
+)
+
+// OpenAPISpec returns an OpenAPI 3.1 document, encoded as JSON,
+// that describes the routes served by [NewFlowServeMux] for all
+// registered flows: each flow's path and its input and output schemas.
+// The flow server also serves this document at /openapi.json.
+func OpenAPISpec() ([]byte, error) {
+	return openAPISpec(registry.Global, nil)
+}
+
+// openAPISpec returns the OpenAPI document for the named flows in r,
+// or all flows if flows is empty.
+func openAPISpec(r *registry.Registry, flows []string) ([]byte, error) {
+	m := map[string]bool{}
+	for _, f := range flows {
+		m[f] = true
+	}
+	components := map[string]any{}
+	paths := map[string]any{}
+	for _, f := range r.ListFlows() {
+		f := f.(flow)
+		if len(flows) > 0 && !m[f.Name()] {
+			continue
+		}
+		in, out := f.schemas()
+		inSchema, err := openAPISchema(in, components)
+		if err != nil {
+			return nil, fmt.Errorf("flow %q: input schema: %w", f.Name(), err)
+		}
+		outSchema, err := openAPISchema(out, components)
+		if err != nil {
+			return nil, fmt.Errorf("flow %q: output schema: %w", f.Name(), err)
+		}
+		op := map[string]any{
+			"operationId": f.Name(),
+			"requestBody": map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{
+						"schema": map[string]any{
+							"type":       "object",
+							"properties": map[string]any{"data": inSchema},
+						},
+					},
+				},
+			},
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "The result of the flow.",
+					"content": map[string]any{
+						"application/json": map[string]any{
+							"schema": map[string]any{
+								"type":       "object",
+								"properties": map[string]any{"result": outSchema},
+							},
+						},
+					},
+				},
+			},
+		}
+		if msg := f.deprecation(); msg != "" {
+			op["deprecated"] = true
+			op["description"] = "Deprecated: " + msg
+		}
+		paths["/"+f.Name()] = map[string]any{"post": op}
+	}
+	doc := map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Genkit flows",
+			"version": internal.Version,
+		},
+		"paths": paths,
+	}
+	if len(components) > 0 {
+		doc["components"] = map[string]any{"schemas": components}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// openAPISchema converts a JSON schema to an OpenAPI schema object.
+// Definitions in the schema's $defs are moved to components, and
+// references to them are rewritten to point there.
+func openAPISchema(s *jsonschema.Schema, components map[string]any) (any, error) {
+	if s == nil {
+		return map[string]any{}, nil
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if defs, ok := v["$defs"].(map[string]any); ok {
+		for name, def := range defs {
+			components[name] = rewriteRefs(def)
+		}
+		delete(v, "$defs")
+	}
+	delete(v, "$schema")
+	delete(v, "$id")
+	return rewriteRefs(v), nil
+}
+
+// rewriteRefs rewrites "#/$defs/" references in v to "#/components/schemas/".
+func rewriteRefs(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, x := range v {
+			if s, ok := x.(string); k == "$ref" && ok {
+				v[k] = strings.Replace(s, "#/$defs/", "#/components/schemas/", 1)
+			} else {
+				v[k] = rewriteRefs(x)
+			}
+		}
+	case []any:
+		for i, x := range v {
+			v[i] = rewriteRefs(x)
+		}
+	}
+	return v
+}
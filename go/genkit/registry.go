@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+	"github.com/firebase/genkit/go/internal/registry"
+)
+
+// A Registry holds a set of flows, models and tools that is independent
+// of the global one used by [DefineFlow], [ai.DefineModel], [ai.DefineTool]
+// and [Init]. Actions with the same name can be defined in different
+// registries without colliding, and each registry is served by its own
+// handler, so a multi-tenant server can keep the actions of each tenant
+// apart. Each registry also has its own trace state.
+//
+// When a model run by a flow of a registry requests a tool, the tool is
+// looked up in that registry, and then among the global tools. Models
+// and tools defined globally, such as those of plugins, are shared by
+// all registries; those defined in one registry are not visible to the
+// flows of another.
+type Registry struct {
+	reg *registry.Registry
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() (*Registry, error) {
+	r, err := registry.New()
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{reg: r}, nil
+}
+
+// DefineFlowInRegistry is like [DefineFlow], but defines the flow in r.
+func DefineFlowInRegistry[In, Out any](
+	r *Registry,
+	name string,
+	fn func(ctx context.Context, input In) (Out, error),
+	opts ...FlowOption,
+) *Flow[In, Out, struct{}] {
+	return defineFlow(r.reg, name, nonStreamingFunc(fn), opts...)
+}
+
+// DefineStreamingFlowInRegistry is like [DefineStreamingFlow], but defines the flow in r.
+func DefineStreamingFlowInRegistry[In, Out, Stream any](
+	r *Registry,
+	name string,
+	fn func(ctx context.Context, input In, callback func(context.Context, Stream) error) (Out, error),
+	opts ...FlowOption,
+) *Flow[In, Out, Stream] {
+	return defineFlow(r.reg, name, core.Func[In, Out, Stream](fn), opts...)
+}
+
+// DefineModelInRegistry is like [ai.DefineModel], but defines the model in r.
+func DefineModelInRegistry(
+	r *Registry,
+	provider, name string,
+	metadata *ai.ModelMetadata,
+	generate func(context.Context, *ai.ModelRequest, ai.ModelStreamingCallback) (*ai.ModelResponse, error),
+) ai.Model {
+	return ai.DefineModelInRegistry(r.reg, provider, name, metadata, generate)
+}
+
+// LookupModelInRegistry is like [ai.LookupModel], but looks the model up
+// in r, and then, if r doesn't have it, among the global models.
+func LookupModelInRegistry(r *Registry, provider, name string) ai.Model {
+	return ai.LookupModelInRegistry(r.reg, provider, name)
+}
+
+// DefineToolInRegistry is like [ai.DefineTool], but defines the tool in r.
+func DefineToolInRegistry[In, Out any](
+	r *Registry,
+	name, description string,
+	fn func(ctx context.Context, input In) (Out, error),
+) *ai.ToolDef[In, Out] {
+	return ai.DefineToolInRegistry(r.reg, name, description, fn)
+}
+
+// LookupToolInRegistry is like [ai.LookupTool], but looks the tool up
+// in r, and then, if r doesn't have it, among the global tools.
+// It returns nil if there is no such tool.
+func LookupToolInRegistry(r *Registry, name string) ai.Tool {
+	return ai.LookupToolInRegistry(r.reg, name)
+}
+
+// NewFlowServeMux is like the function [NewFlowServeMux], but serves the
+// flows of r. If flows is non-empty, only the named flows are served.
+func (r *Registry) NewFlowServeMux(flows []string, opts ...ServerOption) *http.ServeMux {
+	return newFlowServeMux(r.reg, flows, opts...)
+}
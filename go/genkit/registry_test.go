@@ -0,0 +1,139 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/internal/registry"
+)
+
+func TestRegistryIsolation(t *testing.T) {
+	newTenant := func(greeting string) *Registry {
+		r, err := NewRegistry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		DefineFlowInRegistry(r, "greet", func(_ context.Context, name string) (string, error) {
+			return greeting + ", " + name, nil
+		})
+		return r
+	}
+	acme := newTenant("Hello")
+	globex := newTenant("Bonjour")
+
+	for _, test := range []struct {
+		reg  *Registry
+		want string
+	}{
+		{acme, "Hello, Ada"},
+		{globex, "Bonjour, Ada"},
+	} {
+		srv := httptest.NewServer(test.reg.NewFlowServeMux(nil))
+		res, err := http.Post(srv.URL+"/greet", "application/json", strings.NewReader(`{"data": "Ada"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readJSON[struct{ Result string }](res.Body)
+		res.Body.Close()
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Result != test.want {
+			t.Errorf("got %q, want %q", got.Result, test.want)
+		}
+	}
+
+	// The flows are not visible through the global registry.
+	if a := registry.Global.LookupAction("/flow/greet"); a != nil {
+		t.Error("flow defined in a Registry is visible globally")
+	}
+}
+
+func TestRegistryModelsAndTools(t *testing.T) {
+	// Each tenant has its own model and tool with the same names.
+	// The model asks for the "secret" tool, then replies with its output.
+	newTenant := func(secret string) *Registry {
+		r, err := NewRegistry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		DefineToolInRegistry(r, "secret", "Returns the tenant's secret.", func(_ context.Context, _ struct{}) (string, error) {
+			return secret, nil
+		})
+		m := DefineModelInRegistry(r, "tenant", "model", nil, func(_ context.Context, req *ai.ModelRequest, _ ai.ModelStreamingCallback) (*ai.ModelResponse, error) {
+			last := req.Messages[len(req.Messages)-1]
+			if last.Role == ai.RoleTool {
+				out := last.Content[0].ToolResponse.Output["response"]
+				return &ai.ModelResponse{Request: req, Message: ai.NewModelTextMessage(fmt.Sprint(out))}, nil
+			}
+			return &ai.ModelResponse{Request: req, Message: &ai.Message{
+				Role:    ai.RoleModel,
+				Content: []*ai.Part{ai.NewToolRequestPart(&ai.ToolRequest{Name: "secret", Input: map[string]any{}})},
+			}}, nil
+		})
+		DefineFlowInRegistry(r, "reveal", func(ctx context.Context, _ string) (string, error) {
+			resp, err := ai.Generate(ctx, m, ai.WithTextPrompt("What is the secret?"),
+				ai.WithTools(LookupToolInRegistry(r, "secret")))
+			if err != nil {
+				return "", err
+			}
+			return resp.Text(), nil
+		})
+		return r
+	}
+	acme := newTenant("acme-secret")
+	globex := newTenant("globex-secret")
+
+	for _, test := range []struct {
+		reg  *Registry
+		want string
+	}{
+		{acme, "acme-secret"},
+		{globex, "globex-secret"},
+	} {
+		srv := httptest.NewServer(test.reg.NewFlowServeMux(nil))
+		res, err := http.Post(srv.URL+"/reveal", "application/json", strings.NewReader(`{"data": ""}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := readJSON[struct{ Result string }](res.Body)
+		res.Body.Close()
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Result != test.want {
+			t.Errorf("got %q, want %q", got.Result, test.want)
+		}
+	}
+
+	if m := ai.LookupModel("tenant", "model"); m != nil {
+		t.Error("model defined in a Registry is visible globally")
+	}
+	if tool := ai.LookupToolInRegistry(registry.Global, "secret"); tool != nil {
+		t.Error("tool defined in a Registry is visible globally")
+	}
+	if m := LookupModelInRegistry(acme, "tenant", "model"); m == nil {
+		t.Error("model not found in its Registry")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/firebase/genkit/go/core/tracing"
+)
+
+// A SecretStore provides secrets, such as API keys, by name.
+// Implementations may be backed by a service like Secret Manager.
+type SecretStore interface {
+	// LookupSecret returns the value of the named secret.
+	// It returns an error wrapping [ErrSecretNotFound] if there is no such secret.
+	LookupSecret(ctx context.Context, name string) (string, error)
+}
+
+// ErrSecretNotFound is returned by a [SecretStore] for an unknown secret.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// EnvSecretStore is a [SecretStore] that reads secrets from environment variables.
+// It is the default SecretStore.
+type EnvSecretStore struct{}
+
+// LookupSecret returns the value of the environment variable name.
+func (EnvSecretStore) LookupSecret(_ context.Context, name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s: %w", name, ErrSecretNotFound)
+	}
+	return v, nil
+}
+
+var secrets = struct {
+	mu    sync.Mutex
+	store SecretStore
+}{store: EnvSecretStore{}}
+
+// SetSecretStore sets the [SecretStore] used by [Secret].
+func SetSecretStore(s SecretStore) {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+	secrets.store = s
+}
+
+// Secret returns the value of the named secret from the current [SecretStore].
+//
+// When called from a flow or action, the value is redacted from the
+// trace: it is replaced by [tracing.Redacted] wherever it appears in the
+// input, output or error of a span in the trace that ends after the call.
+func Secret(ctx context.Context, name string) (string, error) {
+	secrets.mu.Lock()
+	store := secrets.store
+	secrets.mu.Unlock()
+	v, err := store.LookupSecret(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("genkit.Secret(%q): %w", name, err)
+	}
+	tracing.Redact(ctx, v)
+	return v, nil
+}
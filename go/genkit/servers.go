@@ -23,11 +23,16 @@
 package genkit
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -36,13 +41,16 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core"
 	"github.com/firebase/genkit/go/core/logger"
 	"github.com/firebase/genkit/go/core/tracing"
 	"github.com/firebase/genkit/go/internal"
 	"github.com/firebase/genkit/go/internal/action"
+	"github.com/firebase/genkit/go/internal/atype"
 	"github.com/firebase/genkit/go/internal/base"
 	"github.com/firebase/genkit/go/internal/registry"
+	"github.com/invopop/jsonschema"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -58,20 +66,22 @@ type runtimeFileData struct {
 type devServer struct {
 	reg             *registry.Registry
 	runtimeFilePath string
+	maxBodySize     int64                             // maximum size of a request body; zero for no limit
+	httpMiddleware  []func(http.Handler) http.Handler // wraps the runAction handlers
 }
 
-// startReflectionServer starts the Reflection API server listening at the
-// value of the environment variable GENKIT_REFLECTION_PORT for the port,
-// or ":3100" if it is empty.
-func startReflectionServer(ctx context.Context, errCh chan<- error) *http.Server {
+// startReflectionServer starts the Reflection API server listening on port.
+// If port is zero, it uses the value of the environment variable
+// GENKIT_REFLECTION_PORT for the port, or ":3100" if it is empty.
+func startReflectionServer(ctx context.Context, errCh chan<- error, port int, maxBodySize int64, mw []func(http.Handler) http.Handler, configure []func(*http.Server)) *http.Server {
 	slog.Debug("starting reflection server")
-	addr := serverAddress("", "GENKIT_REFLECTION_PORT", "127.0.0.1:3100")
-	s := &devServer{reg: registry.Global}
+	addr := serverAddress(portAddress(port), "GENKIT_REFLECTION_PORT", "127.0.0.1:3100")
+	s := &devServer{reg: registry.Global, maxBodySize: maxBodySize, httpMiddleware: mw}
 	if err := s.writeRuntimeFile(addr); err != nil {
 		slog.Error("failed to write runtime file", "error", err)
 	}
 	mux := newDevServeMux(s)
-	server := startServer(addr, mux, errCh)
+	server := startServer(addr, mux, errCh, configure...)
 	go func() {
 		<-ctx.Done()
 		if err := s.cleanupRuntimeFile(); err != nil {
@@ -158,33 +168,255 @@ func findProjectRoot() (string, error) {
 
 // startFlowServer starts a production server listening at the given address.
 // The Server has a route for each defined flow.
-// If addr is "", it uses the value of the environment variable PORT
-// for the port, and if that is empty it uses ":3400".
+// If addr is "", it uses the port set by [WithProdPort], or else the value
+// of the environment variable PORT for the port, and if that is empty it uses ":3400".
 //
 // To construct a server with additional routes, use [NewFlowServeMux].
-func startFlowServer(addr string, flows []string, errCh chan<- error) *http.Server {
+func startFlowServer(addr string, flows []string, errCh chan<- error, opts ...ServerOption) *http.Server {
 	slog.Debug("starting flow server")
+	if addr == "" {
+		addr = portAddress(newServerOptions(opts).prodPort)
+	}
 	addr = serverAddress(addr, "PORT", "127.0.0.1:3400")
-	mux := NewFlowServeMux(flows)
-	return startServer(addr, mux, errCh)
+	mux := NewFlowServeMux(flows, opts...)
+	return startServer(addr, mux, errCh, newServerOptions(opts).httpServer...)
+}
+
+// serverOptions configures the flow server.
+type serverOptions struct {
+	corsOrigins      []string                          // Origins allowed to make cross-origin requests.
+	idempotencyStore IdempotencyStore                  // Results of requests with an Idempotency-Key header.
+	devPort          int                               // Port of the development server; zero for the default.
+	prodPort         int                               // Port of the flow server; zero for the default.
+	env              string                            // Environment; empty for the value of GENKIT_ENV.
+	grpcAddr         string                            // Address of the gRPC flow server; empty for none.
+	maxBodySize      int64                             // Maximum size of a request body; zero for no limit.
+	jsonEncoding     jsonEncoding                      // How flow outputs are encoded.
+	httpMiddleware   []func(http.Handler) http.Handler // Wrap flow and runAction handlers, outermost first.
+	httpServer       []func(*http.Server)              // Configure the servers started by Init, in order.
+}
+
+// ServerOption configures the flow server started by [Init]
+// or the handler returned by [NewFlowServeMux].
+type ServerOption func(opts *serverOptions)
+
+// WithCORS allows browsers to call flows from the given origins.
+// The origin "*" allows requests from any origin.
+// Flow routes respond to CORS preflight (OPTIONS) requests
+// and add the Access-Control-Allow-* headers to their responses.
+func WithCORS(origins []string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.corsOrigins = append(opts.corsOrigins, origins...)
+	}
+}
+
+// WithIdempotency deduplicates flow requests that carry an Idempotency-Key
+// header, so that clients can safely retry non-idempotent flows.
+// The first request with a given key for a flow runs the flow and stores
+// its result in store; a repeated request returns the stored result
+// with the header "Idempotent-Replayed: true", and a concurrent request
+// waits for the first to finish. Failed runs are not stored.
+// Keys are scoped to the caller, identified by the Authorization header,
+// so callers can't see each other's results. A repeated key with a
+// different request body is rejected with a 422 (Unprocessable Entity)
+// status. Streaming requests are not deduplicated.
+func WithIdempotency(store IdempotencyStore) ServerOption {
+	return func(opts *serverOptions) {
+		opts.idempotencyStore = store
+	}
+}
+
+// WithDevPort sets the port of the development server started by [Init]
+// in the "dev" environment, overriding the GENKIT_REFLECTION_PORT
+// environment variable.
+func WithDevPort(port int) ServerOption {
+	return func(opts *serverOptions) {
+		opts.devPort = port
+	}
+}
+
+// WithProdPort sets the port of the flow server started by [Init],
+// overriding the PORT environment variable.
+// It has no effect if [Options].FlowAddr is set.
+func WithProdPort(port int) ServerOption {
+	return func(opts *serverOptions) {
+		opts.prodPort = port
+	}
+}
+
+// WithEnv sets the environment in which [Init] runs, either "dev" or "prod",
+// overriding the GENKIT_ENV environment variable.
+func WithEnv(env string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.env = env
+	}
+}
+
+// WithGRPC makes [Init] also serve flows over gRPC at addr,
+// alongside the HTTP flow server. The gRPC service is described at
+// [RegisterFlowService].
+func WithGRPC(addr string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.grpcAddr = addr
+	}
+}
+
+// WithMaxRequestBodySize limits the size of request bodies to n bytes,
+// for flow requests and for the development server's /api/runAction.
+// Larger requests are rejected with a 413 (Request Entity Too Large)
+// status, before the body is read if it declares its length, and
+// otherwise as soon as n bytes have been read.
+// A flow can set its own limit with [WithMaxInputSize].
+func WithMaxRequestBodySize(n int64) ServerOption {
+	return func(opts *serverOptions) {
+		opts.maxBodySize = n
+	}
+}
+
+// WithJSONEscapeHTML sets whether the characters <, > and & in the
+// strings of flow outputs are escaped as \u003c, \u003e and \u0026,
+// so that the JSON is safe to embed in HTML. They are escaped by default,
+// as by [json.Marshal]. Pass false to send outputs such as prompts and
+// URLs as they are.
+func WithJSONEscapeHTML(escape bool) ServerOption {
+	return func(opts *serverOptions) {
+		opts.jsonEncoding.noEscapeHTML = !escape
+	}
+}
+
+// WithJSONIndent indents the JSON responses of flows, beginning each
+// nested element on a new line with one copy of indent per level of
+// nesting, as [json.MarshalIndent] does.
+// Streamed responses are not indented, since each chunk and the result
+// must be on one line.
+func WithJSONIndent(indent string) ServerOption {
+	return func(opts *serverOptions) {
+		opts.jsonEncoding.indent = indent
+	}
+}
+
+// WithHTTPMiddleware wraps the handler of each flow route, and of the
+// development server's /api/runAction and /api/runActions, in the given
+// middleware, for example to add authentication, logging or tracing.
+// The first middleware is the outermost: it sees each request first.
+// CORS preflight requests to flow routes are answered without calling
+// the middleware, since browsers send them without credentials.
+// The option may be given more than once; the middleware of later
+// options is applied inside that of earlier ones.
+func WithHTTPMiddleware(mw ...func(http.Handler) http.Handler) ServerOption {
+	return func(opts *serverOptions) {
+		opts.httpMiddleware = append(opts.httpMiddleware, mw...)
+	}
+}
+
+// WithHTTPServer calls configure with each HTTP server that [Init]
+// starts, the flow server and in the "dev" environment the development
+// server, before it begins listening. Use it to tune fields of the
+// [http.Server] such as ReadTimeout, WriteTimeout and MaxHeaderBytes,
+// which are otherwise zero, so that there are no timeouts.
+// configure must not change the server's Addr or Handler.
+// The option may be given more than once; the functions are called
+// in order.
+func WithHTTPServer(configure func(*http.Server)) ServerOption {
+	return func(opts *serverOptions) {
+		opts.httpServer = append(opts.httpServer, configure)
+	}
+}
+
+// wrapHTTP returns h wrapped in mw, with mw[0] outermost.
+func wrapHTTP(h http.Handler, mw []func(http.Handler) http.Handler) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+func newServerOptions(opts []ServerOption) *serverOptions {
+	sopts := &serverOptions{}
+	for _, opt := range opts {
+		opt(sopts)
+	}
+	return sopts
+}
+
+// environment returns the environment set by [WithEnv],
+// or the current environment if none was set.
+func (o *serverOptions) environment() registry.Environment {
+	if o.env != "" {
+		return registry.Environment(o.env)
+	}
+	return registry.CurrentEnvironment()
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin
+// header for a request from origin, or "" if the origin is not allowed.
+func (o *serverOptions) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, allowed := range o.corsOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if allowed == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+// withCORS wraps f so that it sets CORS headers on responses to allowed origins.
+func (o *serverOptions) withCORS(f func(http.ResponseWriter, *http.Request) error) func(http.ResponseWriter, *http.Request) error {
+	if len(o.corsOrigins) == 0 {
+		return f
+	}
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if origin := o.allowOrigin(r.Header.Get("Origin")); origin != "" {
+			h := w.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			if o.idempotencyStore != nil {
+				h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key")
+			} else {
+				h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			}
+			h.Add("Vary", "Origin")
+		}
+		return f(w, r)
+	}
 }
 
 // flow is the type that all Flow[In, Out, Stream] have in common.
 type flow interface {
 	Name() string
 
+	// deprecation returns the flow's deprecation message,
+	// or "" if the flow is not deprecated.
+	deprecation() string
+
+	// maxInputSize returns the maximum size of an HTTP request body
+	// for the flow, or zero to use the server's limit.
+	maxInputSize() int64
+
+	// schemas returns the JSON schemas of the flow's input and output.
+	schemas() (input, output *jsonschema.Schema)
+
 	// runJSON uses encoding/json to unmarshal the input,
 	// calls Flow.start, then returns the marshaled result.
 	runJSON(ctx context.Context, authHeader string, input json.RawMessage, cb streamingCallback[json.RawMessage]) (json.RawMessage, error)
 }
 
-// startServer starts an HTTP server listening on the address.
-// It returns the server an
-func startServer(addr string, handler http.Handler, errCh chan<- error) *http.Server {
+// startServer starts an HTTP server listening on the address,
+// after passing it to each of the configure functions.
+// It returns the server and reports errors from serving on errCh.
+func startServer(addr string, handler http.Handler, errCh chan<- error, configure ...func(*http.Server)) *http.Server {
 	server := &http.Server{
 		Addr:    addr,
 		Handler: handler,
 	}
+	for _, c := range configure {
+		c(server)
+	}
 
 	go func() {
 		slog.Debug("server listening", "addr", addr)
@@ -236,7 +468,8 @@ func newDevServeMux(s *devServer) *http.ServeMux {
 	handle(mux, "GET /api/__health", func(w http.ResponseWriter, _ *http.Request) error {
 		return nil
 	})
-	handle(mux, "POST /api/runAction", s.handleRunAction)
+	mux.Handle("POST /api/runAction", wrapHTTP(errorHandler(s.handleRunAction, writeTextError), s.httpMiddleware))
+	mux.Handle("POST /api/runActions", wrapHTTP(errorHandler(s.handleRunActions, writeTextError), s.httpMiddleware))
 	handle(mux, "GET /api/actions", s.handleListActions)
 	handle(mux, "POST /api/notify", s.handleNotify)
 	return mux
@@ -246,14 +479,20 @@ func newDevServeMux(s *devServer) *http.ServeMux {
 // provided JSON input, and writes back the JSON-marshaled request.
 func (s *devServer) handleRunAction(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
-	var body struct {
-		Key     string          `json:"key"`
-		Input   json.RawMessage `json:"input"`
-		Context json.RawMessage `json:"context"`
-	}
+	var body runActionRequest
 	defer r.Body.Close()
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		return &base.HTTPError{Code: http.StatusBadRequest, Err: err}
+	if err := limitBody(w, r, s.bodyLimit()); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return bodyError(err)
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return bodyError(err)
+	}
+	if err := s.checkInputSize(body.Key, len(data)); err != nil {
+		return err
 	}
 	stream, err := parseBoolQueryParam(r, "stream")
 	if err != nil {
@@ -278,17 +517,136 @@ func (s *devServer) handleRunAction(w http.ResponseWriter, r *http.Request) erro
 			return nil
 		}
 	}
-	var contextMap map[string]any = nil
-	if body.Context != nil {
-		json.Unmarshal(body.Context, &contextMap)
-	}
-	resp, err := runAction(ctx, s.reg, body.Key, body.Input, callback, contextMap)
+	resp, err := runAction(ctx, s.reg, body.Key, body.Input, callback, body.contextMap())
 	if err != nil {
 		return err
 	}
 	return writeJSON(ctx, w, resp)
 }
 
+// bodyLimit returns the maximum size of a request body to /api/runAction
+// or /api/runActions: the largest of the server's limit and the limits
+// of the flows, or zero for no limit. The limit that applies to the
+// action being run is checked by [devServer.checkInputSize] once its key
+// is known.
+func (s *devServer) bodyLimit() int64 {
+	if s.maxBodySize <= 0 {
+		return 0
+	}
+	n := s.maxBodySize
+	for _, f := range s.reg.ListFlows() {
+		n = max(n, f.(flow).maxInputSize())
+	}
+	return n
+}
+
+// checkInputSize returns a 413 error if a request of size bytes to run
+// the action with the given key exceeds the limit for that action: the
+// flow's limit set by [WithMaxInputSize], if any, otherwise the server's.
+func (s *devServer) checkInputSize(key string, size int) error {
+	n := s.maxBodySize
+	for _, f := range s.reg.ListFlows() {
+		f := f.(flow)
+		if key == fmt.Sprintf("/%s/%s", atype.Flow, f.Name()) && f.maxInputSize() != 0 {
+			n = f.maxInputSize()
+			break
+		}
+	}
+	if n > 0 && int64(size) > n {
+		return &base.HTTPError{Code: http.StatusRequestEntityTooLarge, Err: fmt.Errorf("request of %d bytes for %q exceeds limit of %d bytes", size, key, n)}
+	}
+	return nil
+}
+
+// runActionRequest is the body of a request to /api/runAction,
+// and an element of the body of a request to /api/runActions.
+type runActionRequest struct {
+	Key     string          `json:"key"`
+	Input   json.RawMessage `json:"input"`
+	Context json.RawMessage `json:"context"`
+}
+
+// contextMap returns the unmarshaled context of the request, or nil if
+// it has none.
+func (r *runActionRequest) contextMap() map[string]any {
+	var contextMap map[string]any = nil
+	if r.Context != nil {
+		json.Unmarshal(r.Context, &contextMap)
+	}
+	return contextMap
+}
+
+// maxBatchConcurrency is the maximum number of actions in a request
+// to /api/runActions that run at the same time.
+const maxBatchConcurrency = 8
+
+// batchResult is an element of the response to /api/runActions.
+// Exactly one of Result and Error is set.
+type batchResult struct {
+	*runActionResponse
+	Error *batchError `json:"error,omitempty"`
+}
+
+// batchError describes the failure of an action in a batch.
+// Code is the HTTP status that /api/runAction would have responded with.
+type batchError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// handleRunActions runs each action in a JSON array of /api/runAction
+// requests, a few at a time, and writes back a JSON array of the results
+// in the same order. The failure of an action is reported in its result
+// rather than failing the whole request. Results are not streamed.
+func (s *devServer) handleRunActions(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	var raw []json.RawMessage
+	defer r.Body.Close()
+	if err := limitBody(w, r, s.bodyLimit()); err != nil {
+		return err
+	}
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return bodyError(err)
+	}
+	body := make([]runActionRequest, len(raw))
+	for i, m := range raw {
+		if err := json.Unmarshal(m, &body[i]); err != nil {
+			return bodyError(err)
+		}
+	}
+	logger.FromContext(ctx).Debug("running actions", "count", len(body))
+	results := make([]batchResult, len(body))
+	sem := make(chan struct{}, maxBatchConcurrency)
+	var wg sync.WaitGroup
+	for i, req := range body {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			err := s.checkInputSize(req.Key, len(raw[i]))
+			var resp *runActionResponse
+			if err == nil {
+				resp, err = runAction(ctx, s.reg, req.Key, req.Input, nil, req.contextMap())
+			}
+			if err != nil {
+				code := http.StatusInternalServerError
+				var herr *base.HTTPError
+				if errors.As(err, &herr) {
+					code = herr.Code
+				}
+				results[i].Error = &batchError{Code: code, Message: err.Error()}
+				return
+			}
+			results[i].runActionResponse = resp
+		}()
+	}
+	wg.Wait()
+	return writeJSON(ctx, w, results)
+}
+
 // handleNotify configures the telemetry server URL from the request.
 func (s *devServer) handleNotify(w http.ResponseWriter, r *http.Request) error {
 	var body struct {
@@ -360,17 +718,24 @@ func (s *devServer) handleListActions(w http.ResponseWriter, r *http.Request) er
 //
 // All routes take a single query parameter, "stream", which if true will stream the
 // flow's results back to the client. (Not all flows support streaming, however.)
+// The ServeMux also serves an OpenAPI description of the routes at /openapi.json;
+// see [OpenAPISpec].
 //
 // To use the returned ServeMux as part of a server with other routes, either add routes
 // to it, or install it as part of another ServeMux, like so:
 //
 //	mainMux := http.NewServeMux()
 //	mainMux.Handle("POST /flow/", http.StripPrefix("/flow/", NewFlowServeMux()))
-func NewFlowServeMux(flows []string) *http.ServeMux {
-	return newFlowServeMux(registry.Global, flows)
+func NewFlowServeMux(flows []string, opts ...ServerOption) *http.ServeMux {
+	return newFlowServeMux(registry.Global, flows, opts...)
 }
 
-func newFlowServeMux(r *registry.Registry, flows []string) *http.ServeMux {
+func newFlowServeMux(r *registry.Registry, flows []string, opts ...ServerOption) *http.ServeMux {
+	sopts := newServerOptions(opts)
+	var idem *idempotency
+	if sopts.idempotencyStore != nil {
+		idem = newIdempotency(sopts.idempotencyStore)
+	}
 	mux := http.NewServeMux()
 	m := map[string]bool{}
 	for _, f := range flows {
@@ -379,20 +744,49 @@ func newFlowServeMux(r *registry.Registry, flows []string) *http.ServeMux {
 	for _, f := range r.ListFlows() {
 		f := f.(flow)
 		if len(flows) == 0 || m[f.Name()] {
-			handle(mux, "POST /"+f.Name(), nonDurableFlowHandler(f))
+			maxBodySize := sopts.maxBodySize
+			if n := f.maxInputSize(); n != 0 {
+				maxBodySize = n
+			}
+			h := errorHandler(sopts.withCORS(nonDurableFlowHandler(f, idem, maxBodySize, sopts.jsonEncoding)), writeFlowError)
+			mux.Handle("POST /"+f.Name(), wrapHTTP(h, sopts.httpMiddleware))
+			if len(sopts.corsOrigins) > 0 {
+				handle(mux, "OPTIONS /"+f.Name(), sopts.withCORS(preflightHandler))
+			}
 		}
 	}
+	handle(mux, "GET /openapi.json", func(w http.ResponseWriter, _ *http.Request) error {
+		spec, err := openAPISpec(r, flows)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(spec)
+		return err
+	})
 	return mux
 }
 
-func nonDurableFlowHandler(f flow) func(http.ResponseWriter, *http.Request) error {
+// preflightHandler responds to a CORS preflight request.
+// The CORS headers themselves are set by [serverOptions.withCORS].
+func preflightHandler(w http.ResponseWriter, r *http.Request) error {
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// nonDurableFlowHandler returns a handler that runs f.
+// If idem is non-nil, requests are deduplicated by their Idempotency-Key header.
+// If maxBodySize is positive, larger request bodies are rejected.
+// Flow outputs are encoded as described by enc.
+func nonDurableFlowHandler(f flow, idem *idempotency, maxBodySize int64, enc jsonEncoding) func(http.ResponseWriter, *http.Request) error {
 	return func(w http.ResponseWriter, r *http.Request) error {
-		var body struct {
-			Data json.RawMessage `json:"data"`
-		}
 		defer r.Body.Close()
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			return &base.HTTPError{Code: http.StatusBadRequest, Err: err}
+		if err := limitBody(w, r, maxBodySize); err != nil {
+			return err
+		}
+		input, err := flowInput(r)
+		if err != nil {
+			return err
 		}
 		stream, err := parseBoolQueryParam(r, "stream")
 		if err != nil {
@@ -414,16 +808,190 @@ func nonDurableFlowHandler(f flow) func(http.ResponseWriter, *http.Request) erro
 				return nil
 			}
 		}
-		// TODO: telemetry
-		out, err := f.runJSON(r.Context(), r.Header.Get("Authorization"), body.Data, callback)
+		if msg := f.deprecation(); msg != "" {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("X-Genkit-Deprecation", msg)
+		}
+		run := func(ctx context.Context) (json.RawMessage, error) {
+			// TODO: telemetry
+			ctx = jsonEncodingKey.NewContext(ctx, enc)
+			return f.runJSON(ctx, r.Header.Get("Authorization"), input, callback)
+		}
+		var out json.RawMessage
+		if key := r.Header.Get("Idempotency-Key"); idem != nil && key != "" && !stream {
+			var replayed bool
+			out, replayed, err = idem.do(r.Context(), idempotencyKey(f.Name(), r.Header.Get("Authorization"), key), inputHash(input), run)
+			if replayed {
+				w.Header().Set("Idempotent-Replayed", "true")
+			}
+		} else {
+			out, err = run(r.Context())
+		}
 		if err != nil {
 			return err
 		}
-		// Responses for non-streaming, non-durable flows are passed back
+		// Responses for non-durable flows are passed back
 		// with the flow result stored in a field called "result."
-		_, err = fmt.Fprintf(w, `{"result": %s}\n`, out)
+		return enc.writeResult(w, out, stream)
+	}
+}
+
+// jsonEncoding describes how flow outputs are encoded as JSON.
+// The zero value encodes them as [json.Marshal] does.
+type jsonEncoding struct {
+	noEscapeHTML bool   // don't escape <, > and & in strings
+	indent       string // indentation of responses; empty for none
+}
+
+// jsonEncodingKey holds the encoding of the outputs of the flow
+// being run by the flow server.
+var jsonEncodingKey = base.NewContextKey[jsonEncoding]()
+
+// marshal returns the compact JSON encoding of v.
+// Indentation applies only to the whole response, in writeResult.
+func (e jsonEncoding) marshal(v any) ([]byte, error) {
+	if !e.noEscapeHTML {
+		return json.Marshal(v)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	// Remove the newline added by Encode.
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// writeResult writes the response to a flow request, which holds the
+// flow output out, already encoded as e describes, in its "result" field.
+// Unless it is indented, the response is written in the form
+// `{"result": ...}`, on one line. A streamed result is always written
+// in that form, since it is how clients such as [StreamFlow] tell the
+// result from a chunk.
+func (e jsonEncoding) writeResult(w io.Writer, out json.RawMessage, stream bool) error {
+	if e.indent == "" || stream {
+		_, err := fmt.Fprintf(w, `{"result": %s}\n`, out)
 		return err
 	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(!e.noEscapeHTML)
+	enc.SetIndent("", e.indent)
+	return enc.Encode(struct {
+		Result json.RawMessage `json:"result"`
+	}{out})
+}
+
+// maxMultipartMemory is the number of bytes of a multipart/form-data
+// request body that are held in memory; the rest is stored in temporary files.
+const maxMultipartMemory = 32 << 20
+
+// flowInput returns the JSON input to a flow from the body of r.
+// A JSON body holds the input in its "data" field.
+// A multipart/form-data body is described at [multipartFlowInput].
+func flowInput(r *http.Request) (json.RawMessage, error) {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		return multipartFlowInput(r)
+	}
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, bodyError(err)
+	}
+	return body.Data, nil
+}
+
+// limitBody limits the body of r to n bytes, if n is positive.
+// It returns a 413 error without reading the body if the request
+// declares a longer body; otherwise, reading beyond the limit fails
+// with an error that [bodyError] turns into a 413.
+func limitBody(w http.ResponseWriter, r *http.Request, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if r.ContentLength > n {
+		return &base.HTTPError{Code: http.StatusRequestEntityTooLarge, Err: fmt.Errorf("request body of %d bytes exceeds limit of %d bytes", r.ContentLength, n)}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, n)
+	return nil
+}
+
+// bodyError returns the HTTP error for an error reading or decoding
+// a request body: 413 if the body was too large, otherwise 400.
+func bodyError(err error) error {
+	var merr *http.MaxBytesError
+	if errors.As(err, &merr) {
+		return &base.HTTPError{Code: http.StatusRequestEntityTooLarge, Err: err}
+	}
+	return &base.HTTPError{Code: http.StatusBadRequest, Err: err}
+}
+
+// multipartFlowInput builds the JSON input to a flow from a
+// multipart/form-data request, so that clients can upload files.
+// The input is a JSON object. If there is a form field named "data",
+// it is parsed as a JSON object to start from. Every other form field
+// sets the object field of the same name to its string value, and every
+// uploaded file sets the object field of its form name to an [ai.Part]
+// holding the file contents as a media part with a "data:" URL.
+// So a flow whose input has a field of type *ai.Part receives the file
+// as media that can be passed directly to a model.
+func multipartFlowInput(r *http.Request) (json.RawMessage, error) {
+	if err := r.ParseMultipartForm(maxMultipartMemory); err != nil {
+		return nil, bodyError(err)
+	}
+	form := r.MultipartForm
+	defer form.RemoveAll()
+
+	input := map[string]any{}
+	if vals := form.Value["data"]; len(vals) > 0 {
+		if err := json.Unmarshal([]byte(vals[0]), &input); err != nil {
+			return nil, &base.HTTPError{Code: http.StatusBadRequest, Err: fmt.Errorf(`form field "data": %w`, err)}
+		}
+	}
+	for name, vals := range form.Value {
+		if name != "data" && len(vals) > 0 {
+			input[name] = vals[0]
+		}
+	}
+	for name, fhs := range form.File {
+		if len(fhs) == 0 {
+			continue
+		}
+		part, err := filePart(fhs[0])
+		if err != nil {
+			return nil, &base.HTTPError{Code: http.StatusBadRequest, Err: fmt.Errorf("form file %q: %w", name, err)}
+		}
+		input[name] = part
+	}
+	return json.Marshal(input)
+}
+
+// filePart returns a media part holding the contents of an uploaded file.
+func filePart(fh *multipart.FileHeader) (*ai.Part, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	contentType := fh.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+	url := "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	return ai.NewMediaPart(contentType, url), nil
+}
+
+// portAddress returns the loopback address for port, or "" if port is zero.
+func portAddress(port int) string {
+	if port == 0 {
+		return ""
+	}
+	return "127.0.0.1:" + strconv.Itoa(port)
 }
 
 // serverAddress determines a server address.
@@ -445,7 +1013,15 @@ var requestID atomic.Int64
 // If the error is an httpError, the code it contains is used as the status code;
 // otherwise a 500 status is used.
 func handle(mux *http.ServeMux, pattern string, f func(w http.ResponseWriter, r *http.Request) error) {
-	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle(pattern, errorHandler(f, writeTextError))
+}
+
+// errorHandler returns an http.Handler that calls f, and writes the
+// error f returns, if any, with writeError. Flow routes use
+// writeFlowError, to report errors in the JSON envelope described
+// at [FlowError].
+func errorHandler(f func(w http.ResponseWriter, r *http.Request) error, writeError func(http.ResponseWriter, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := requestID.Add(1)
 		// Create a logger that always outputs the requestID, and store it in the request context.
 		log := slog.Default().With("reqID", id)
@@ -462,18 +1038,23 @@ func handle(mux *http.ServeMux, pattern string, f func(w http.ResponseWriter, r
 		}()
 		err = f(w, r)
 		if err != nil {
-			// If the error is an httpError, serve the status code it contains.
-			// Otherwise, assume this is an unexpected error and serve a 500.
-			var herr *base.HTTPError
-			if errors.As(err, &herr) {
-				http.Error(w, herr.Error(), herr.Code)
-			} else {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-			}
+			writeError(w, err)
 		}
 	})
 }
 
+// writeTextError writes err as plain text. If the error is an httpError,
+// it serves the status code it contains. Otherwise, it assumes this is an
+// unexpected error and serves a 500.
+func writeTextError(w http.ResponseWriter, err error) {
+	var herr *base.HTTPError
+	if errors.As(err, &herr) {
+		http.Error(w, herr.Error(), herr.Code)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func parseBoolQueryParam(r *http.Request, name string) (bool, error) {
 	b := false
 	if s := r.FormValue(name); s != "" {
@@ -17,13 +17,21 @@ package genkit
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core"
 	"github.com/firebase/genkit/go/core/tracing"
 	"github.com/firebase/genkit/go/internal/action"
@@ -32,6 +40,12 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/invopop/jsonschema"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func inc(_ context.Context, x int, _ noStream) (int, error) {
@@ -82,6 +96,46 @@ func TestDevServer(t *testing.T) {
 		}
 		checkActionTrace(t, tc, tid, "inc")
 	})
+	t.Run("runActions", func(t *testing.T) {
+		body := `[
+			{"key": "/custom/devServer/inc", "input": 3},
+			{"key": "/custom/devServer/missing", "input": 3},
+			{"key": "/custom/devServer/dec", "input": 3}
+		]`
+		res, err := http.Post(srv.URL+"/api/runActions", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Fatalf("got status %d, wanted 200", res.StatusCode)
+		}
+		got, err := readJSON[[]struct {
+			Result    json.RawMessage
+			Telemetry *telemetry
+			Error     *batchError
+		}](res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 3 {
+			t.Fatalf("got %d results, want 3", len(got))
+		}
+		for i, want := range []string{"4", "", "2"} {
+			if g := string(got[i].Result); g != want {
+				t.Errorf("result %d: got %q, want %q", i, g, want)
+			}
+		}
+		if got[0].Telemetry == nil || len(got[0].Telemetry.TraceID) != 32 {
+			t.Errorf("result 0: got telemetry %+v, want a trace ID", got[0].Telemetry)
+		}
+		if e := got[1].Error; e == nil || e.Code != http.StatusNotFound {
+			t.Errorf("result 1: got error %+v, want status %d", e, http.StatusNotFound)
+		}
+		if got[0].Error != nil || got[2].Error != nil {
+			t.Errorf("got errors %+v and %+v for successful actions", got[0].Error, got[2].Error)
+		}
+	})
 	t.Run("list actions", func(t *testing.T) {
 		res, err := http.Get(srv.URL + "/api/actions")
 		if err != nil {
@@ -170,6 +224,756 @@ func TestProdServer(t *testing.T) {
 	t.Run("bad", func(t *testing.T) { check(t, "true", 400, 0) })
 }
 
+func TestProdServerPanic(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "panic", func(_ context.Context, _ int, _ noStream) (int, error) {
+		panic("boom")
+	})
+	srv := httptest.NewServer(newFlowServeMux(r, nil))
+	defer srv.Close()
+
+	// The server must survive and keep serving after a panic.
+	for range 2 {
+		res, err := http.Post(srv.URL+"/panic", "application/json", strings.NewReader(`{"data": 1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if g, w := res.StatusCode, http.StatusInternalServerError; g != w {
+			t.Fatalf("status: got %d, want %d", g, w)
+		}
+		if !strings.Contains(string(body), "INTERNAL: panic: boom") {
+			t.Errorf("got body %q, want it to contain the panic", body)
+		}
+	}
+}
+
+func TestProdServerFlowErrors(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "throwy", func(_ context.Context, msg string, _ noStream) (string, error) {
+		return "", errors.New(msg)
+	})
+	defineFlow(r, "lookup", func(_ context.Context, id string, _ noStream) (string, error) {
+		ferr := NewFlowError(StatusNotFound, "no user %q", id)
+		ferr.Details = map[string]any{"id": id}
+		return "", fmt.Errorf("lookup: %w", ferr)
+	})
+	srv := httptest.NewServer(newFlowServeMux(r, nil))
+	defer srv.Close()
+
+	type envelope struct {
+		Error FlowError `json:"error"`
+	}
+	for _, test := range []struct {
+		path       string
+		body       string
+		wantStatus int
+		want       FlowError
+	}{
+		{
+			path:       "/throwy",
+			body:       `{"data": "oops"}`,
+			wantStatus: http.StatusInternalServerError,
+			want:       FlowError{Status: StatusInternal, Message: "oops"},
+		},
+		{
+			path:       "/lookup",
+			body:       `{"data": "ada"}`,
+			wantStatus: http.StatusNotFound,
+			want: FlowError{
+				Status:  StatusNotFound,
+				Message: `no user "ada"`,
+				Details: map[string]any{"id": "ada"},
+			},
+		},
+		{
+			path:       "/throwy",
+			body:       `{"data": 1}`,
+			wantStatus: http.StatusBadRequest,
+			want: FlowError{
+				Status:  StatusInvalidArgument,
+				Message: "data did not match expected schema:\n- (root): Invalid type. Expected: string, given: integer",
+			},
+		},
+	} {
+		t.Run(test.path, func(t *testing.T) {
+			res, err := http.Post(srv.URL+test.path, "application/json", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != test.wantStatus {
+				t.Errorf("status: got %d, want %d", res.StatusCode, test.wantStatus)
+			}
+			if g, w := res.Header.Get("Content-Type"), "application/json"; g != w {
+				t.Errorf("Content-Type: got %q, want %q", g, w)
+			}
+			got, err := readJSON[envelope](res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, got.Error); diff != "" {
+				t.Errorf("mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	// The client returns the server's error.
+	_, err = StreamFlow[string, string, noStream](context.Background(), srv.URL+"/lookup", "ada", nil)
+	var ferr *FlowError
+	if !errors.As(err, &ferr) || ferr.Status != StatusNotFound {
+		t.Errorf("StreamFlow: got error %v, want a NOT_FOUND FlowError", err)
+	}
+}
+
+// countingReader is an endless stream of spaces that counts the bytes read from it.
+type countingReader struct{ n int64 }
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func TestMaxRequestBodySize(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "inc", func(_ context.Context, i int, _ noStream) (int, error) {
+		return i + 1, nil
+	})
+	defineFlow(r, "small", func(_ context.Context, i int, _ noStream) (int, error) {
+		return i + 1, nil
+	}, WithMaxInputSize(8))
+	mux := newFlowServeMux(r, nil, WithMaxRequestBodySize(64))
+
+	post := func(path string, body io.Reader, length int64) int {
+		req := httptest.NewRequest("POST", path, body)
+		req.ContentLength = length
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	t.Run("under limit", func(t *testing.T) {
+		body := `{"data": 1}`
+		if g, w := post("/inc", strings.NewReader(body), int64(len(body))), http.StatusOK; g != w {
+			t.Errorf("status: got %d, want %d", g, w)
+		}
+	})
+	t.Run("declared length over limit", func(t *testing.T) {
+		body := &countingReader{}
+		if g, w := post("/inc", body, 1<<20), http.StatusRequestEntityTooLarge; g != w {
+			t.Errorf("status: got %d, want %d", g, w)
+		}
+		if body.n != 0 {
+			t.Errorf("read %d bytes of the body, want 0", body.n)
+		}
+	})
+	t.Run("unknown length over limit", func(t *testing.T) {
+		body := &countingReader{}
+		if g, w := post("/inc", body, -1), http.StatusRequestEntityTooLarge; g != w {
+			t.Errorf("status: got %d, want %d", g, w)
+		}
+		// The decoder reads in chunks, but must stop soon after the limit.
+		if body.n > 64<<10 {
+			t.Errorf("read %d bytes of the body, want at most %d", body.n, 64<<10)
+		}
+	})
+	t.Run("per-flow limit", func(t *testing.T) {
+		body := `{"data": 1}`
+		if g, w := post("/small", strings.NewReader(body), int64(len(body))), http.StatusRequestEntityTooLarge; g != w {
+			t.Errorf("status: got %d, want %d", g, w)
+		}
+	})
+
+	dev := newDevServeMux(&devServer{reg: r, maxBodySize: 64})
+	postDev := func(path, body string) (int, string) {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		dev.ServeHTTP(w, req)
+		return w.Code, w.Body.String()
+	}
+	t.Run("runAction per-flow limit", func(t *testing.T) {
+		if g, _ := postDev("/api/runAction", `{"key": "/flow/inc", "input": 1}`); g != http.StatusOK {
+			t.Errorf("inc: got status %d, want 200", g)
+		}
+		if g, _ := postDev("/api/runAction", `{"key": "/flow/small", "input": 1}`); g != http.StatusRequestEntityTooLarge {
+			t.Errorf("small: got status %d, want 413", g)
+		}
+	})
+	t.Run("runActions per-flow limit", func(t *testing.T) {
+		code, body := postDev("/api/runActions", `[{"key":"/flow/inc","input":1},{"key":"/flow/small","input":1}]`)
+		if code != http.StatusOK {
+			t.Fatalf("got status %d, want 200", code)
+		}
+		got, err := readJSON[[]struct{ Error *batchError }](strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0].Error != nil {
+			t.Errorf("inc: got error %v", got[0].Error)
+		}
+		if got[1].Error == nil || got[1].Error.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("small: got error %+v, want code 413", got[1].Error)
+		}
+	})
+}
+
+func TestStreamFlowClient(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "letters", func(ctx context.Context, n int, cb func(context.Context, string) error) (int, error) {
+		for i := range n {
+			if err := cb(ctx, string(rune('a'+i))); err != nil {
+				return 0, err
+			}
+		}
+		if n > 3 {
+			return 0, errors.New("too many letters")
+		}
+		return n, nil
+	})
+	srv := httptest.NewServer(newFlowServeMux(r, nil))
+	defer srv.Close()
+
+	var chunks []string
+	collect := func(_ context.Context, s string) error {
+		chunks = append(chunks, s)
+		return nil
+	}
+	got, err := StreamFlow[int](context.Background(), srv.URL+"/letters", 3, collect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 3 {
+		t.Errorf("got result %d, want 3", got)
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(chunks, want) {
+		t.Errorf("got chunks %q, want %q", chunks, want)
+	}
+
+	chunks = nil
+	_, err = StreamFlow[int](context.Background(), srv.URL+"/letters", 4, collect)
+	if err == nil || !strings.Contains(err.Error(), "too many letters") {
+		t.Errorf("got error %v, want the flow's error", err)
+	}
+	if len(chunks) != 4 {
+		t.Errorf("got %d chunks before the error, want 4", len(chunks))
+	}
+
+	// The result is found whatever the encoding options of the server.
+	encSrv := httptest.NewServer(newFlowServeMux(r, nil, WithJSONEscapeHTML(false), WithJSONIndent("  ")))
+	defer encSrv.Close()
+	chunks = nil
+	got, err = StreamFlow[int](context.Background(), encSrv.URL+"/letters", 2, collect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 2 {
+		t.Errorf("with encoding options: got result %d, want 2", got)
+	}
+	if want := []string{"a", "b"}; !slices.Equal(chunks, want) {
+		t.Errorf("with encoding options: got chunks %q, want %q", chunks, want)
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "inc", func(_ context.Context, i int, _ noStream) (int, error) {
+		return i + 1, nil
+	})
+	// header returns middleware that appends value to the X-Middleware header.
+	header := func(value string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Middleware", value)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	deny := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Deny") != "" {
+				http.Error(w, "denied", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	opts := []ServerOption{WithHTTPMiddleware(header("outer"), deny), WithHTTPMiddleware(header("inner"))}
+
+	post := func(t *testing.T, url, body string, hdr http.Header) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = hdr
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	check := func(t *testing.T, res *http.Response, wantStatus int, wantHeader []string) {
+		t.Helper()
+		if res.StatusCode != wantStatus {
+			t.Errorf("status: got %d, want %d", res.StatusCode, wantStatus)
+		}
+		if diff := cmp.Diff(wantHeader, res.Header.Values("X-Middleware")); diff != "" {
+			t.Errorf("X-Middleware mismatch (-want, +got):\n%s", diff)
+		}
+	}
+
+	t.Run("flow", func(t *testing.T) {
+		srv := httptest.NewServer(newFlowServeMux(r, nil, opts...))
+		defer srv.Close()
+		res := post(t, srv.URL+"/inc", `{"data": 1}`, nil)
+		check(t, res, http.StatusOK, []string{"outer", "inner"})
+		res = post(t, srv.URL+"/inc", `{"data": 1}`, http.Header{"X-Deny": {"1"}})
+		check(t, res, http.StatusForbidden, []string{"outer"})
+	})
+	t.Run("runAction", func(t *testing.T) {
+		so := newServerOptions(opts)
+		srv := httptest.NewServer(newDevServeMux(&devServer{reg: r, httpMiddleware: so.httpMiddleware}))
+		defer srv.Close()
+		res := post(t, srv.URL+"/api/runAction", `{"key": "/flow/inc", "input": 1}`, nil)
+		check(t, res, http.StatusOK, []string{"outer", "inner"})
+		// Other routes are not wrapped.
+		res, err := http.Get(srv.URL + "/api/actions")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		check(t, res, http.StatusOK, nil)
+	})
+}
+
+func TestProdServerCORS(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "inc", func(_ context.Context, i int, _ noStream) (int, error) {
+		return i + 1, nil
+	})
+	srv := httptest.NewServer(newFlowServeMux(r, nil, WithCORS([]string{"http://localhost:5173"})))
+	defer srv.Close()
+
+	preflight := func(t *testing.T, origin string) *http.Response {
+		req, err := http.NewRequest(http.MethodOptions, srv.URL+"/inc", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	t.Run("allowed origin", func(t *testing.T) {
+		res := preflight(t, "http://localhost:5173")
+		if g, w := res.StatusCode, http.StatusNoContent; g != w {
+			t.Fatalf("status: got %d, want %d", g, w)
+		}
+		want := map[string]string{
+			"Access-Control-Allow-Origin":  "http://localhost:5173",
+			"Access-Control-Allow-Methods": "POST, OPTIONS",
+			"Access-Control-Allow-Headers": "Content-Type, Authorization",
+		}
+		for k, w := range want {
+			if g := res.Header.Get(k); g != w {
+				t.Errorf("%s: got %q, want %q", k, g, w)
+			}
+		}
+	})
+	t.Run("disallowed origin", func(t *testing.T) {
+		res := preflight(t, "http://evil.example")
+		if g := res.Header.Get("Access-Control-Allow-Origin"); g != "" {
+			t.Errorf("Access-Control-Allow-Origin: got %q, want empty", g)
+		}
+	})
+	t.Run("post", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/inc", strings.NewReader(`{"data": 2}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Origin", "http://localhost:5173")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if g, w := res.Header.Get("Access-Control-Allow-Origin"), "http://localhost:5173"; g != w {
+			t.Errorf("Access-Control-Allow-Origin: got %q, want %q", g, w)
+		}
+	})
+}
+
+func TestProdServerDeprecatedFlow(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "old", func(_ context.Context, i int, _ noStream) (int, error) {
+		return i, nil
+	}, WithFlowVersion("v1"), WithFlowDeprecated("use new instead"))
+	defineFlow(r, "new", func(_ context.Context, i int, _ noStream) (int, error) {
+		return i, nil
+	}, WithFlowVersion("v2"))
+	srv := httptest.NewServer(newFlowServeMux(r, nil))
+	defer srv.Close()
+
+	post := func(t *testing.T, name string) *http.Response {
+		res, err := http.Post(srv.URL+"/"+name, "application/json", strings.NewReader(`{"data": 1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Fatalf("got status %d, wanted 200", res.StatusCode)
+		}
+		return res
+	}
+
+	res := post(t, "old")
+	if g, w := res.Header.Get("Deprecation"), "true"; g != w {
+		t.Errorf("Deprecation: got %q, want %q", g, w)
+	}
+	if g, w := res.Header.Get("X-Genkit-Deprecation"), "use new instead"; g != w {
+		t.Errorf("X-Genkit-Deprecation: got %q, want %q", g, w)
+	}
+	res = post(t, "new")
+	if g := res.Header.Get("Deprecation"); g != "" {
+		t.Errorf("Deprecation: got %q, want empty", g)
+	}
+
+	md := r.LookupAction("/flow/old").Desc().Metadata
+	if md["version"] != "v1" || md["deprecated"] != "use new instead" {
+		t.Errorf("got metadata %v, want version and deprecation", md)
+	}
+}
+
+func TestProdServerIdempotency(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := 0
+	defineFlow(r, "charge", func(_ context.Context, amount int, _ noStream) (int, error) {
+		runs++
+		return amount * runs, nil
+	})
+	srv := httptest.NewServer(newFlowServeMux(r, nil, WithIdempotency(NewMemoryIdempotencyStore(time.Minute))))
+	defer srv.Close()
+
+	send := func(t *testing.T, key, auth, data string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/charge", strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Idempotency-Key", key)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res, string(body)
+	}
+	post := func(t *testing.T, key string) (*http.Response, string) {
+		res, body := send(t, key, "", `{"data": 5}`)
+		if res.StatusCode != 200 {
+			t.Fatalf("got status %d, wanted 200", res.StatusCode)
+		}
+		return res, body
+	}
+
+	res1, body1 := post(t, "k1")
+	if g := res1.Header.Get("Idempotent-Replayed"); g != "" {
+		t.Errorf("first request: Idempotent-Replayed = %q, want empty", g)
+	}
+	res2, body2 := post(t, "k1")
+	if g, w := res2.Header.Get("Idempotent-Replayed"), "true"; g != w {
+		t.Errorf("repeated request: Idempotent-Replayed = %q, want %q", g, w)
+	}
+	if body1 != body2 {
+		t.Errorf("repeated request: got %q, want %q", body2, body1)
+	}
+	if runs != 1 {
+		t.Errorf("flow ran %d times for the same key, want 1", runs)
+	}
+
+	post(t, "k2")
+	if runs != 2 {
+		t.Errorf("flow ran %d times for two keys, want 2", runs)
+	}
+
+	t.Run("other caller", func(t *testing.T) {
+		res, _ := send(t, "k1", "Bearer other", `{"data": 5}`)
+		if res.StatusCode != 200 {
+			t.Fatalf("got status %d, wanted 200", res.StatusCode)
+		}
+		if g := res.Header.Get("Idempotent-Replayed"); g != "" {
+			t.Errorf("Idempotent-Replayed = %q for another caller's key, want empty", g)
+		}
+		if runs != 3 {
+			t.Errorf("flow ran %d times, want 3", runs)
+		}
+	})
+
+	t.Run("different body", func(t *testing.T) {
+		res, body := send(t, "k1", "", `{"data": 6}`)
+		if res.StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("got status %d, wanted %d", res.StatusCode, http.StatusUnprocessableEntity)
+		}
+		if !strings.Contains(body, "different request body") {
+			t.Errorf("got body %q, want it to mention the different body", body)
+		}
+		if runs != 3 {
+			t.Errorf("flow ran %d times, want 3", runs)
+		}
+	})
+}
+
+func TestMemoryIdempotencyStoreSweep(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryIdempotencyStore(time.Millisecond).(*memoryIdempotencyStore)
+	for i := range 100 {
+		s.Put(ctx, fmt.Sprint("key", i), json.RawMessage(`1`))
+	}
+	time.Sleep(5 * time.Millisecond)
+	// Keys that are never read again must not accumulate.
+	s.Put(ctx, "last", json.RawMessage(`1`))
+	s.mu.Lock()
+	n := len(s.entries)
+	s.mu.Unlock()
+	if n != 1 {
+		t.Errorf("got %d entries after the others expired, want 1", n)
+	}
+}
+
+func TestProdServerJSONEncoding(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "link", func(_ context.Context, q string, _ noStream) (map[string]string, error) {
+		return map[string]string{"url": "https://example.com/?q=" + q + "&lang=en"}, nil
+	})
+
+	post := func(t *testing.T, opts ...ServerOption) string {
+		srv := httptest.NewServer(newFlowServeMux(r, nil, opts...))
+		defer srv.Close()
+		res, err := http.Post(srv.URL+"/link", "application/json", strings.NewReader(`{"data": "<go>"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Fatalf("got status %d, wanted 200", res.StatusCode)
+		}
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		if got := post(t); !strings.Contains(got, `\u0026`) {
+			t.Errorf("got %s, want & escaped", got)
+		}
+	})
+	t.Run("no escaping", func(t *testing.T) {
+		got := post(t, WithJSONEscapeHTML(false))
+		want := `{"result": {"url":"https://example.com/?q=<go>&lang=en"}}\n`
+		if got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+	t.Run("indent", func(t *testing.T) {
+		got := post(t, WithJSONEscapeHTML(false), WithJSONIndent("  "))
+		want := "{\n  \"result\": {\n    \"url\": \"https://example.com/?q=<go>&lang=en\"\n  }\n}\n"
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestProdServerMultipart(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type upload struct {
+		Caption string   `json:"caption"`
+		Size    int      `json:"size"`
+		Image   *ai.Part `json:"image"`
+	}
+	var got upload
+	defineFlow(r, "describe", func(_ context.Context, in upload, _ noStream) (string, error) {
+		got = in
+		return "ok", nil
+	})
+	srv := httptest.NewServer(newFlowServeMux(r, nil))
+	defer srv.Close()
+
+	image := []byte("\x89PNG\r\n\x1a\nfake image bytes")
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("data", `{"size": 3}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.WriteField("caption", "a cat"); err != nil {
+		t.Fatal(err)
+	}
+	fw, err := mw.CreateFormFile("image", "cat.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(image); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(srv.URL+"/describe", mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		t.Fatalf("got status %d, wanted 200: %s", res.StatusCode, body)
+	}
+
+	if got.Caption != "a cat" || got.Size != 3 {
+		t.Errorf("got caption %q and size %d, want %q and 3", got.Caption, got.Size, "a cat")
+	}
+	if got.Image == nil || !got.Image.IsMedia() {
+		t.Fatalf("got image %+v, want a media part", got.Image)
+	}
+	if g, w := got.Image.ContentType, "image/png"; g != w {
+		t.Errorf("content type: got %q, want %q", g, w)
+	}
+	const prefix = "data:image/png;base64,"
+	if !strings.HasPrefix(got.Image.Text, prefix) {
+		t.Fatalf("got URL %q, want prefix %q", got.Image.Text, prefix)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(got.Image.Text, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, image) {
+		t.Errorf("got image bytes %q, want %q", data, image)
+	}
+}
+
+func TestProdServerOpenAPI(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type point struct {
+		X, Y int
+	}
+	defineFlow(r, "inc", func(_ context.Context, i int, _ noStream) (int, error) {
+		return i + 1, nil
+	})
+	defineFlow(r, "origin", func(_ context.Context, name string, _ noStream) (point, error) {
+		return point{}, nil
+	})
+	srv := httptest.NewServer(newFlowServeMux(r, nil))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Fatalf("got status %d, wanted 200", res.StatusCode)
+	}
+	type schemaHolder struct {
+		Schema struct {
+			Properties map[string]map[string]any `json:"properties"`
+		} `json:"schema"`
+	}
+	type operation struct {
+		OperationID string `json:"operationId"`
+		RequestBody struct {
+			Content map[string]schemaHolder `json:"content"`
+		} `json:"requestBody"`
+		Responses map[string]struct {
+			Content map[string]schemaHolder `json:"content"`
+		} `json:"responses"`
+	}
+	spec, err := readJSON[struct {
+		OpenAPI    string                          `json:"openapi"`
+		Paths      map[string]map[string]operation `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}](res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.OpenAPI != "3.1.0" {
+		t.Errorf("openapi: got %q, want 3.1.0", spec.OpenAPI)
+	}
+
+	op, ok := spec.Paths["/inc"]["post"]
+	if !ok {
+		t.Fatalf("no POST /inc in paths %v", spec.Paths)
+	}
+	if op.OperationID != "inc" {
+		t.Errorf("operationId: got %q, want %q", op.OperationID, "inc")
+	}
+	in := op.RequestBody.Content["application/json"].Schema.Properties["data"]
+	if g, w := in["type"], "integer"; g != w {
+		t.Errorf("input type: got %v, want %v", g, w)
+	}
+	out := op.Responses["200"].Content["application/json"].Schema.Properties["result"]
+	if g, w := out["type"], "integer"; g != w {
+		t.Errorf("output type: got %v, want %v", g, w)
+	}
+
+	out = spec.Paths["/origin"]["post"].Responses["200"].Content["application/json"].Schema.Properties["result"]
+	if g, w := out["$ref"], "#/components/schemas/point"; g != w {
+		t.Errorf("struct output: got $ref %v, want %v", g, w)
+	}
+	if _, ok := spec.Components.Schemas["point"]["properties"]; !ok {
+		t.Errorf("components do not define point: %v", spec.Components.Schemas)
+	}
+}
+
 func checkActionTrace(t *testing.T, tc *tracing.TestOnlyTelemetryClient, tid, name string) {
 	td := tc.Traces[tid]
 	if td == nil {
@@ -231,3 +1035,197 @@ func readJSON[T any](r io.Reader) (T, error) {
 	}
 	return x, nil
 }
+
+func TestServerPortOptions(t *testing.T) {
+	t.Run("prod port", func(t *testing.T) {
+		t.Setenv("PORT", "1")
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+
+		errCh := make(chan error, 1)
+		s := startFlowServer("", nil, errCh, WithProdPort(port))
+		defer s.Close()
+		if want := fmt.Sprintf("127.0.0.1:%d", port); s.Addr != want {
+			t.Errorf("got address %q, want %q", s.Addr, want)
+		}
+		url := fmt.Sprintf("http://127.0.0.1:%d/openapi.json", port)
+		var res *http.Response
+		for i := 0; i < 50; i++ {
+			select {
+			case err := <-errCh:
+				t.Fatal(err)
+			default:
+			}
+			if res, err = http.Get(url); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("got status %d, want %d", res.StatusCode, http.StatusOK)
+		}
+	})
+	t.Run("http server", func(t *testing.T) {
+		errCh := make(chan error, 1)
+		s := startFlowServer("127.0.0.1:0", nil, errCh,
+			WithHTTPServer(func(s *http.Server) { s.ReadTimeout = 7 * time.Second }),
+			WithHTTPServer(func(s *http.Server) { s.MaxHeaderBytes = 4096 }))
+		defer s.Close()
+		if got, want := s.ReadTimeout, 7*time.Second; got != want {
+			t.Errorf("got ReadTimeout %v, want %v", got, want)
+		}
+		if got, want := s.MaxHeaderBytes, 4096; got != want {
+			t.Errorf("got MaxHeaderBytes %d, want %d", got, want)
+		}
+		if s.Handler == nil {
+			t.Error("server has no handler")
+		}
+	})
+	t.Run("env fallback", func(t *testing.T) {
+		t.Setenv("PORT", "4321")
+		t.Setenv("GENKIT_ENV", "dev")
+		so := newServerOptions(nil)
+		if got, want := serverAddress(portAddress(so.prodPort), "PORT", "127.0.0.1:3400"), "127.0.0.1:4321"; got != want {
+			t.Errorf("got address %q, want %q", got, want)
+		}
+		if got := so.environment(); got != registry.EnvironmentDev {
+			t.Errorf("got environment %q, want %q", got, registry.EnvironmentDev)
+		}
+		so = newServerOptions([]ServerOption{WithEnv("prod")})
+		if got := so.environment(); got != registry.EnvironmentProd {
+			t.Errorf("got environment %q, want %q", got, registry.EnvironmentProd)
+		}
+	})
+}
+
+func TestGRPCFlowService(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defineFlow(r, "inc", func(_ context.Context, i int, _ noStream) (int, error) {
+		return i + 1, nil
+	})
+	defineFlow(r, "count", func(ctx context.Context, n int, cb func(context.Context, int) error) (string, error) {
+		for i := 0; i < n; i++ {
+			if err := cb(ctx, i); err != nil {
+				return "", err
+			}
+		}
+		return "done", nil
+	})
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	registerFlowService(r, server, nil)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	t.Run("unary", func(t *testing.T) {
+		out := new(wrapperspb.BytesValue)
+		if err := conn.Invoke(ctx, "/genkit.Flows/inc", wrapperspb.Bytes([]byte("2")), out); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(out.Value), "3"; got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	})
+	t.Run("bad input", func(t *testing.T) {
+		err := conn.Invoke(ctx, "/genkit.Flows/inc", wrapperspb.Bytes([]byte("true")), new(wrapperspb.BytesValue))
+		if got, want := status.Code(err), codes.InvalidArgument; got != want {
+			t.Errorf("got code %v, want %v (err: %v)", got, want, err)
+		}
+	})
+	t.Run("streaming", func(t *testing.T) {
+		stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/genkit.Flows/countStream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.SendMsg(wrapperspb.Bytes([]byte("3"))); err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.CloseSend(); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for {
+			msg := new(wrapperspb.BytesValue)
+			if err := stream.RecvMsg(msg); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(msg.Value))
+		}
+		want := []string{"0", "1", "2", `"done"`}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("stream mismatch (-want, +got):\n%s", diff)
+		}
+	})
+}
+
+func TestValidateInput(t *testing.T) {
+	r, err := registry.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type order struct {
+		Item     string `json:"item"`
+		Quantity int    `json:"quantity"`
+	}
+	f := defineFlow(r, "order", func(_ context.Context, o order, _ noStream) (string, error) {
+		return o.Item, nil
+	})
+	srv := httptest.NewServer(newFlowServeMux(r, nil))
+	defer srv.Close()
+
+	if err := ValidateInput(f, order{Item: "pizza", Quantity: 2}); err != nil {
+		t.Errorf("valid value: %v", err)
+	}
+	if err := ValidateInput(f, json.RawMessage(`{"item": "pizza", "quantity": 2}`)); err != nil {
+		t.Errorf("valid JSON: %v", err)
+	}
+	for _, input := range []string{
+		`{"item": "pizza", "quantity": "two"}`,
+		`{"item": "pizza", "quantity": 2, "extra": true}`,
+		`"pizza"`,
+	} {
+		verr := ValidateInput(f, []byte(input))
+		if verr == nil {
+			t.Errorf("%s: ValidateInput returned nil", input)
+			continue
+		}
+		res, err := http.Post(srv.URL+"/order", "application/json", strings.NewReader(`{"data": `+input+`}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := readJSON[struct{ Error FlowError }](res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: HTTP status %d, want 400", input, res.StatusCode)
+		}
+		if got := body.Error.Message; !strings.Contains(got, verr.Error()) {
+			t.Errorf("%s: HTTP error %q does not contain ValidateInput error %q", input, got, verr)
+		}
+	}
+}
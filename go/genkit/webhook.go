@@ -0,0 +1,140 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package genkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/firebase/genkit/go/core/logger"
+)
+
+// WithCompletionWebhook configures a flow run to POST a JSON
+// [CompletionWebhookPayload] to url when the flow finishes,
+// whether it succeeds or fails. This is useful for long flows that
+// are run in the background, for example with
+//
+//	go flow.Run(ctx, input, genkit.WithCompletionWebhook(url))
+//
+// The webhook is delivered in the background after the run returns.
+// Delivery is retried with exponential backoff if the request fails
+// or the server responds with a 429 or 5xx status.
+func WithCompletionWebhook(url string) FlowRunOption {
+	return func(opts *runOptions) {
+		if opts.webhookURL != "" {
+			log.Panic("completion webhook already set in runOptions")
+		}
+		opts.webhookURL = url
+	}
+}
+
+// CompletionWebhookPayload is the body of the request sent by
+// [WithCompletionWebhook] when a flow finishes.
+type CompletionWebhookPayload struct {
+	FlowID   string `json:"flowId"`
+	FlowName string `json:"flowName"`
+	// TraceID is the ID of the trace of the flow run.
+	TraceID string `json:"traceId,omitempty"`
+	// Status is "done" if the flow succeeded and "error" if it failed.
+	Status string `json:"status"`
+	// Result is the output of the flow, if it succeeded.
+	Result any `json:"result,omitempty"`
+	// Error is the error message, if the flow failed.
+	Error string `json:"error,omitempty"`
+}
+
+// completionPayload returns the webhook payload describing the finished flow state.
+func completionPayload[In, Out any](state *flowState[In, Out]) *CompletionWebhookPayload {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	p := &CompletionWebhookPayload{
+		FlowID:   state.FlowID,
+		FlowName: state.FlowName,
+		Status:   "done",
+	}
+	if n := len(state.Executions); n > 0 {
+		if ids := state.Executions[n-1].TraceIDs; len(ids) > 0 {
+			p.TraceID = ids[len(ids)-1]
+		}
+	}
+	if r := state.Operation.Result; r != nil {
+		if r.err != nil {
+			p.Status = "error"
+			p.Error = r.Error
+		} else {
+			p.Result = r.Response
+		}
+	}
+	return p
+}
+
+// Parameters of webhook delivery; variables for testing.
+var (
+	webhookAttempts   = 5
+	webhookRetryDelay = time.Second
+	webhookClient     = &http.Client{Timeout: 30 * time.Second}
+)
+
+// deliverWebhook POSTs payload to url, retrying failed attempts.
+// Errors are logged, since there is no caller to return them to.
+func deliverWebhook(ctx context.Context, url string, payload *CompletionWebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.FromContext(ctx).Error("completion webhook", "flow", payload.FlowName, "err", err)
+		return
+	}
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := postWebhook(ctx, url, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == webhookAttempts {
+			logger.FromContext(ctx).Error("completion webhook", "flow", payload.FlowName, "attempts", attempt, "err", err)
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+	}
+}
+
+// postWebhook makes one delivery attempt, reporting whether
+// a failed attempt should be retried.
+func postWebhook(ctx context.Context, url string, body []byte) (retry bool, _ error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return false, nil
+}
@@ -57,3 +57,7 @@ type FlowStater interface {
 	CacheAt(key string) json.RawMessage
 	CacheSet(key string, val json.RawMessage)
 }
+
+// DryRunKey marks a context in which models must not call their provider.
+// It is set by genkit.WithDryRun and read by the ai package.
+var DryRunKey = NewContextKey[bool]()
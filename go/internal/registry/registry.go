@@ -15,6 +15,7 @@
 package registry
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"log/slog"
@@ -25,6 +26,7 @@ import (
 	"github.com/firebase/genkit/go/core/tracing"
 	"github.com/firebase/genkit/go/internal/action"
 	"github.com/firebase/genkit/go/internal/atype"
+	"github.com/firebase/genkit/go/internal/base"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"golang.org/x/exp/maps"
 )
@@ -52,6 +54,19 @@ type Registry struct {
 	flows   []Flow
 }
 
+var registryKey = base.NewContextKey[*Registry]()
+
+// NewContext returns a context that carries r, the registry of the flow
+// being run, in which actions looked up by name are found first.
+func NewContext(ctx context.Context, r *Registry) context.Context {
+	return registryKey.NewContext(ctx, r)
+}
+
+// FromContext returns the registry set by NewContext, or nil.
+func FromContext(ctx context.Context) *Registry {
+	return registryKey.FromContext(ctx)
+}
+
 func New() (*Registry, error) {
 	r := &Registry{
 		actions: map[string]action.Action{},
@@ -41,6 +41,19 @@ func SetDirectory(directory string) {
 	promptDirectory = directory
 }
 
+// defaultModelName is the name of the model used by prompts that don't specify one.
+var defaultModelName string
+
+// SetDefaultModel sets the name of the model used by prompts that specify
+// neither a Model nor a ModelName, when the request doesn't name one either.
+// The name is resolved when such a prompt is run, not when it is defined,
+// so it may name a model or alias defined later. Like [Config.ModelName],
+// it is either "provider/name" or an alias registered with [ai.RegisterAlias].
+// SetDefaultModel should be called before any prompts are run.
+func SetDefaultModel(name string) {
+	defaultModelName = name
+}
+
 // Prompt is a parsed dotprompt file.
 //
 // A dotprompt file consists of YAML frontmatter within --- lines,
@@ -64,6 +77,8 @@ type Prompt struct {
 	Config
 
 	// The parsed prompt template.
+	// It is parsed once, when the Prompt is created,
+	// and reused by every render.
 	Template *raymond.Template
 
 	// The original prompt template text.
@@ -80,8 +95,16 @@ type Prompt struct {
 type Config struct {
 	// The prompt variant.
 	Variant string
+	// An optional namespace, such as the name of a team or module,
+	// under which the prompt is registered, to keep its name from
+	// colliding with prompts of the same name in other namespaces.
+	// A prompt named "summarize" in namespace "support" is registered
+	// as "support/summarize"; see [LookupPrompt].
+	Namespace string
 	// The name of the model for which the prompt is input.
 	// If this is non-empty, Model should be nil.
+	// If both are unset, the prompt uses the model named in the
+	// request, or else the one set by [SetDefaultModel].
 	ModelName string
 
 	// The Model to use.
@@ -289,7 +312,9 @@ func Define(name, templateText string, cfg Config) (*Prompt, error) {
 	if err != nil {
 		return nil, err
 	}
-	p.Register()
+	if err := p.Register(); err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
@@ -297,9 +322,6 @@ func Define(name, templateText string, cfg Config) (*Prompt, error) {
 // This may be used for testing or for direct calls not using the
 // genkit action and flow mechanisms.
 func New(name, templateText string, cfg Config) (*Prompt, error) {
-	if cfg.ModelName == "" && cfg.Model == nil {
-		return nil, errors.New("dotprompt.New: config must specify either ModelName or Model")
-	}
 	if cfg.ModelName != "" && cfg.Model != nil {
 		return nil, errors.New("dotprompt.New: config must specify exactly one of ModelName and Model")
 	}
@@ -19,11 +19,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"reflect"
 	"strings"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core/tracing"
+	"github.com/firebase/genkit/go/internal/base"
 )
 
 // PromptRequest is a request to execute a dotprompt template and
@@ -35,9 +37,14 @@ type PromptRequest struct {
 	// Number of candidates to return; if 0, will be taken
 	// from the prompt config; if still 0, will use 1.
 	Candidates int `json:"candidates,omitempty"`
-	// Model configuration. If nil will be taken from the prompt config.
+	// Model configuration. If set, it is used instead of the prompt
+	// config, which if set is used instead of the model's default
+	// config. The config used is reported by [ResolutionOf].
 	Config *ai.GenerationCommonConfig `json:"config,omitempty"`
 	// Context to pass to model, if any.
+	// The context is also available to the template as the variable
+	// "context", a list of documents each with "text" and "metadata"
+	// fields, unless Variables has its own "context" field.
 	Context []any `json:"context,omitempty"`
 	// The model to use. This overrides any model specified by the prompt.
 	Model string `json:"model,omitempty"`
@@ -49,12 +56,12 @@ type PromptRequest struct {
 // Only exported fields of the struct will be used.
 func (p *Prompt) buildVariables(variables any) (map[string]any, error) {
 	if variables == nil {
-		return nil, nil
+		return p.applyVariableDefaults(nil), nil
 	}
 
 	v := reflect.Indirect(reflect.ValueOf(variables))
 	if v.Kind() == reflect.Map {
-		return variables.(map[string]any), nil
+		return p.applyVariableDefaults(variables.(map[string]any)), nil
 	}
 	if v.Kind() != reflect.Struct {
 		return nil, errors.New("dotprompt: fields not a struct or pointer to a struct or a map")
@@ -92,10 +99,83 @@ fieldLoop:
 			}
 		}
 
+		// A zero-valued field falls back to the prompt's declared default.
+		if _, ok := p.VariableDefaults[jsonName]; ok && vf.IsZero() {
+			continue
+		}
+
 		m[jsonName] = vf.Interface()
 	}
 
-	return m, nil
+	return p.applyVariableDefaults(m), nil
+}
+
+// applyVariableDefaults returns the variables with the prompt's
+// declared defaults added for any that are absent.
+// The variables map is not modified.
+func (p *Prompt) applyVariableDefaults(variables map[string]any) map[string]any {
+	if len(p.VariableDefaults) == 0 {
+		return variables
+	}
+	m := maps.Clone(p.VariableDefaults)
+	maps.Copy(m, variables)
+	return m
+}
+
+// contextDocsKey holds the [PromptRequest] context while the prompt is rendered.
+var contextDocsKey = base.NewContextKey[[]any]()
+
+// targetModelKey holds the model that a prompt is rendered for.
+var targetModelKey = base.NewContextKey[ai.Model]()
+
+// capabilityVariables returns template variables describing the
+// capabilities of m, so that templates can adapt to the target model:
+//
+//	{{#if supportsMedia}}{{media url=photo}}{{else}}{{description}}{{/if}}
+//
+// The variables are supportsMultiturn, supportsMedia, supportsTools,
+// supportsSystemRole, supportsPrefill and supportsLogProbs.
+func capabilityVariables(m ai.Model) map[string]any {
+	caps := ai.SupportedCapabilities(m)
+	return map[string]any{
+		"supportsMultiturn":  caps.Multiturn,
+		"supportsMedia":      caps.Media,
+		"supportsTools":      caps.Tools,
+		"supportsSystemRole": caps.SystemRole,
+		"supportsPrefill":    caps.Prefill,
+		"supportsLogProbs":   caps.LogProbs,
+	}
+}
+
+// contextVariable converts the [PromptRequest] context into the value
+// of the "context" template variable. Documents become maps with
+// "text" and "metadata" keys; other values are passed through unchanged.
+func contextVariable(docs []any) []any {
+	var vs []any
+	for _, d := range docs {
+		switch d := d.(type) {
+		case *ai.Document:
+			vs = append(vs, documentVariable(d))
+		case ai.Document:
+			vs = append(vs, documentVariable(&d))
+		default:
+			vs = append(vs, d)
+		}
+	}
+	return vs
+}
+
+func documentVariable(d *ai.Document) map[string]any {
+	var sb strings.Builder
+	for _, p := range d.Content {
+		if p.IsText() {
+			sb.WriteString(p.Text)
+		}
+	}
+	return map[string]any{
+		"text":     sb.String(),
+		"metadata": d.Metadata,
+	}
 }
 
 // buildRequest prepares an [ai.ModelRequest] based on the prompt,
@@ -107,6 +187,21 @@ func (p *Prompt) buildRequest(ctx context.Context, input any) (*ai.ModelRequest,
 	if err != nil {
 		return nil, err
 	}
+	if model := targetModelKey.FromContext(ctx); model != nil {
+		// Copy the variables rather than modifying the caller's map.
+		// Variables set by the caller take precedence.
+		nm := capabilityVariables(model)
+		maps.Copy(nm, m)
+		m = nm
+	}
+	if docs := contextDocsKey.FromContext(ctx); len(docs) > 0 {
+		if _, ok := m["context"]; !ok {
+			// Copy the variables rather than modifying the caller's map.
+			nm := map[string]any{"context": contextVariable(docs)}
+			maps.Copy(nm, m)
+			m = nm
+		}
+	}
 	if req.Messages, err = p.RenderMessages(m); err != nil {
 		return nil, err
 	}
@@ -145,13 +240,16 @@ func (p *Prompt) Register() error {
 		return nil
 	}
 
-	name := p.Name
-	if name == "" {
+	if p.Name == "" {
 		return errors.New("attempt to register unnamed prompt")
 	}
+	name := qualifiedName(p.Namespace, p.Name)
 	if p.Variant != "" {
 		name += "." + p.Variant
 	}
+	if ai.IsDefinedPrompt(provider, name) {
+		return fmt.Errorf("dotprompt: a prompt named %q is already registered", name)
+	}
 
 	// TODO: Undo clearing of the Version once Monaco Editor supports newer than JSON schema draft-07.
 	p.InputSchema.Version = ""
@@ -164,14 +262,37 @@ func (p *Prompt) Register() error {
 			"template": p.TemplateText,
 		},
 	}
-	p.prompt = ai.DefinePrompt("dotprompt", name, metadata, p.Config.InputSchema, p.buildRequest)
+	p.prompt = ai.DefinePrompt(provider, name, metadata, p.Config.InputSchema, p.buildRequest)
 
 	return nil
 }
 
+// provider is the provider under which prompts are registered.
+const provider = "dotprompt"
+
+// qualifiedName returns the name under which a prompt
+// in the given namespace is registered.
+func qualifiedName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// LookupPrompt returns the registered prompt with the given name
+// in the given namespace, which is empty for prompts registered
+// without a namespace. The name includes the variant, if any,
+// as in "summarize.short".
+// It returns nil if there is no such prompt.
+func LookupPrompt(namespace, name string) *ai.Prompt {
+	return ai.LookupPrompt(provider, qualifiedName(namespace, name))
+}
+
 // Generate executes a prompt. It does variable substitution and
 // passes the rendered template to the AI model specified by
 // the prompt.
+// If cb is non-nil, response chunks are passed to it as the model
+// streams them; the returned response is the complete one.
 //
 // This implements the [ai.Prompt] interface.
 func (p *Prompt) Generate(ctx context.Context, pr *PromptRequest, cb func(context.Context, *ai.ModelResponseChunk) error) (*ai.ModelResponse, error) {
@@ -179,23 +300,9 @@ func (p *Prompt) Generate(ctx context.Context, pr *PromptRequest, cb func(contex
 
 	var genReq *ai.ModelRequest
 	var err error
-	if p.prompt != nil {
-		genReq, err = p.prompt.Render(ctx, pr.Variables)
-	} else {
-		genReq, err = p.buildRequest(ctx, pr.Variables)
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	// Let some fields in pr override those in the prompt config.
-	if pr.Config != nil {
-		genReq.Config = pr.Config
-	}
 	if len(pr.Context) > 0 {
-		genReq.Context = pr.Context
+		ctx = contextDocsKey.NewContext(ctx, pr.Context)
 	}
-
 	model := p.Model
 	if model == nil {
 		modelName := p.ModelName
@@ -203,11 +310,17 @@ func (p *Prompt) Generate(ctx context.Context, pr *PromptRequest, cb func(contex
 			modelName = pr.Model
 		}
 		if modelName == "" {
-			return nil, errors.New("dotprompt execution: model not specified")
+			modelName = defaultModelName
 		}
+		if modelName == "" {
+			return nil, errors.New("dotprompt execution: model not specified and no default model set")
+		}
+		// A model name without a provider is an alias; see [ai.RegisterAlias].
 		provider, name, found := strings.Cut(modelName, "/")
 		if !found {
-			return nil, errors.New("dotprompt model not in provider/name format")
+			if provider, name, err = ai.ResolveAlias(modelName); err != nil {
+				return nil, fmt.Errorf("dotprompt model not in provider/name format: %w", err)
+			}
 		}
 
 		model = ai.LookupModel(provider, name)
@@ -216,10 +329,78 @@ func (p *Prompt) Generate(ctx context.Context, pr *PromptRequest, cb func(contex
 		}
 	}
 
-	resp, err := model.Generate(ctx, genReq, cb)
+	ctx = targetModelKey.NewContext(ctx, model)
+	if p.prompt != nil {
+		genReq, err = p.prompt.Render(ctx, pr.Variables)
+	} else {
+		genReq, err = p.buildRequest(ctx, pr.Variables)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	// Let some fields in pr override those in the prompt config.
+	// The config is used as a whole, so that a field deliberately set to
+	// zero, such as a temperature of 0, is not replaced by another value.
+	config := pr.Config
+	if config == nil {
+		config = p.GenerationConfig
+	}
+	if config == nil {
+		config = ai.DescribeModel(model).DefaultConfig
+	}
+	if config != nil {
+		genReq.Config = config
+	}
+	if len(pr.Context) > 0 {
+		genReq.Context = pr.Context
+	}
+	tracing.SetCustomMetadataAttr(ctx, "model", model.Name())
+
+	resp, err := model.Generate(ctx, genReq, cb)
+	if err != nil {
+		return nil, err
+	}
+	resp.SetCustomValue(resolutionKey, &Resolution{Model: model.Name(), Config: config})
 	return resp, nil
 }
+
+// A Resolution describes the model and config with which
+// [Prompt.Generate] generated a response, after the overrides of the
+// [PromptRequest] and the defaults were applied.
+type Resolution struct {
+	// Model is the name of the model, as "provider/name".
+	Model string `json:"model"`
+	// Config is the config of the request to the model; nil if none.
+	Config *ai.GenerationCommonConfig `json:"config,omitempty"`
+}
+
+// resolutionKey is the key of the [Resolution] in the Custom map
+// of a response.
+const resolutionKey = "dotprompt:resolution"
+
+// ResolutionOf returns the model and config used to generate resp,
+// or nil if resp was not returned by [Prompt.Generate].
+func ResolutionOf(resp *ai.ModelResponse) *Resolution {
+	custom, ok := resp.Custom.(map[string]any)
+	if !ok {
+		return nil
+	}
+	switch v := custom[resolutionKey].(type) {
+	case *Resolution:
+		return v
+	case nil:
+		return nil
+	default:
+		// The response was unmarshaled from JSON.
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		var r Resolution
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil
+		}
+		return &r
+	}
+}
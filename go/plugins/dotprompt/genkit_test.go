@@ -16,10 +16,16 @@ package dotprompt
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/tracing"
+	"github.com/firebase/genkit/go/internal/registry"
+	"github.com/google/go-cmp/cmp"
+	"github.com/invopop/jsonschema"
 )
 
 func testGenerate(ctx context.Context, req *ai.ModelRequest, cb func(context.Context, *ai.ModelResponseChunk) error) (*ai.ModelResponse, error) {
@@ -61,6 +67,142 @@ func TestExecute(t *testing.T) {
 		}
 		assertResponse(t, resp)
 	})
+	t.Run("alias", func(t *testing.T) {
+		ai.RegisterAlias("testExecuteFast", "test", "test")
+		p, err := New("TestExecute", "TestExecute", Config{ModelName: "testExecuteFast"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := p.Generate(context.Background(), &PromptRequest{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertResponse(t, resp)
+	})
+	t.Run("unknown alias", func(t *testing.T) {
+		p, err := New("TestExecute", "TestExecute", Config{ModelName: "testExecuteSlow"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.Generate(context.Background(), &PromptRequest{}, nil)
+		if err == nil || !strings.Contains(err.Error(), `unknown model alias "testExecuteSlow"`) {
+			t.Errorf("got error %v, want unknown alias error", err)
+		}
+	})
+	t.Run("default model", func(t *testing.T) {
+		p, err := New("TestExecute", "TestExecute", Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.Generate(context.Background(), &PromptRequest{}, nil)
+		if err == nil || !strings.Contains(err.Error(), "no default model") {
+			t.Errorf("got error %v, want no default model error", err)
+		}
+
+		// The default is resolved when the prompt runs, so it
+		// may be an alias registered after the prompt is created.
+		SetDefaultModel("testExecuteDefault")
+		defer SetDefaultModel("")
+		ai.RegisterAlias("testExecuteDefault", "test", "test")
+		resp, err := p.Generate(context.Background(), &PromptRequest{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertResponse(t, resp)
+	})
+}
+
+func TestResolution(t *testing.T) {
+	echoConfig := func(ctx context.Context, req *ai.ModelRequest, _ func(context.Context, *ai.ModelResponseChunk) error) (*ai.ModelResponse, error) {
+		return &ai.ModelResponse{Request: req, Message: ai.NewModelTextMessage("ok")}, nil
+	}
+	ai.DefineModel("test", "resolutionDefault", nil, echoConfig)
+	ai.DefineModel("test", "resolutionOverride", &ai.ModelMetadata{
+		DefaultConfig: &ai.GenerationCommonConfig{TopK: 40, TopP: 0.9},
+	}, echoConfig)
+	p, err := New("TestResolution", "Hello", Config{
+		ModelName:        "test/resolutionDefault",
+		GenerationConfig: &ai.GenerationCommonConfig{Temperature: 0.5, MaxOutputTokens: 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := p.Generate(context.Background(), &PromptRequest{
+		Model:  "test/resolutionOverride",
+		Config: &ai.GenerationCommonConfig{Temperature: 0.1},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Resolution{
+		Model:  "test/resolutionOverride",
+		Config: &ai.GenerationCommonConfig{Temperature: 0.1},
+	}
+	if diff := cmp.Diff(want, ResolutionOf(resp)); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want.Config, resp.Request.Config); diff != "" {
+		t.Errorf("request config mismatch (-want, +got):\n%s", diff)
+	}
+
+	// The resolution survives a round trip through JSON.
+	b, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ai.ModelResponse
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, ResolutionOf(&got)); diff != "" {
+		t.Errorf("after JSON, mismatch (-want, +got):\n%s", diff)
+	}
+
+	for _, test := range []struct {
+		name string
+		pr   *PromptRequest
+		want *ai.GenerationCommonConfig
+	}{
+		{
+			// An explicit zero must not be replaced by the prompt's value.
+			"explicit zero",
+			&PromptRequest{Config: &ai.GenerationCommonConfig{Temperature: 0}},
+			&ai.GenerationCommonConfig{Temperature: 0},
+		},
+		{
+			"prompt config",
+			&PromptRequest{},
+			&ai.GenerationCommonConfig{Temperature: 0.5, MaxOutputTokens: 100},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := p.Generate(context.Background(), test.pr, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.want, ResolutionOf(resp).Config); diff != "" {
+				t.Errorf("resolution mismatch (-want, +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.want, resp.Request.Config); diff != "" {
+				t.Errorf("request config mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("model default", func(t *testing.T) {
+		p, err := New("TestResolutionModelDefault", "Hello", Config{ModelName: "test/resolutionOverride"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := p.Generate(context.Background(), &PromptRequest{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &ai.GenerationCommonConfig{TopK: 40, TopP: 0.9}
+		if diff := cmp.Diff(want, ResolutionOf(resp).Config); diff != "" {
+			t.Errorf("resolution mismatch (-want, +got):\n%s", diff)
+		}
+	})
 }
 
 func assertResponse(t *testing.T, resp *ai.ModelResponse) {
@@ -79,3 +221,217 @@ func assertResponse(t *testing.T, resp *ai.ModelResponse) {
 		t.Errorf("fake model replied with %q, want %q", got, want)
 	}
 }
+
+func TestExecuteStreaming(t *testing.T) {
+	tc := tracing.NewTestOnlyTelemetryClient()
+	registry.Global.TracingState().WriteTelemetryImmediate(tc)
+
+	chunks := []string{"AI ", "reply ", "streamed"}
+	streamModel := ai.DefineModel("test", "stream", nil, func(ctx context.Context, req *ai.ModelRequest, cb func(context.Context, *ai.ModelResponseChunk) error) (*ai.ModelResponse, error) {
+		var text string
+		for _, c := range chunks {
+			if cb != nil {
+				if err := cb(ctx, &ai.ModelResponseChunk{Content: []*ai.Part{ai.NewTextPart(c)}}); err != nil {
+					return nil, err
+				}
+			}
+			text += c
+		}
+		return &ai.ModelResponse{Request: req, Message: ai.NewModelTextMessage(text)}, nil
+	})
+	p, err := New("TestExecuteStreaming", "Hello", Config{
+		Model:       streamModel,
+		InputSchema: &jsonschema.Schema{Type: "object"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	resp, err := p.Generate(context.Background(), &PromptRequest{Variables: map[string]any{}}, func(_ context.Context, c *ai.ModelResponseChunk) error {
+		got = append(got, c.Text())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(chunks, got); diff != "" {
+		t.Errorf("chunks mismatch (-want, +got):\n%s", diff)
+	}
+	if g, w := resp.Text(), "AI reply streamed"; g != w {
+		t.Errorf("response: got %q, want %q", g, w)
+	}
+
+	// The model span records the final response, not the chunks.
+	var output string
+	for _, td := range tc.Traces {
+		for _, sd := range td.Spans {
+			if sd.DisplayName == "test/stream" {
+				output, _ = sd.Attributes["genkit:output"].(string)
+			}
+		}
+	}
+	if !strings.Contains(output, "AI reply streamed") {
+		t.Errorf("model span output %q does not contain the final response", output)
+	}
+}
+
+func TestExecuteContextDocuments(t *testing.T) {
+	echoModel := ai.DefineModel("test", "echoContext", nil, func(ctx context.Context, req *ai.ModelRequest, _ func(context.Context, *ai.ModelResponseChunk) error) (*ai.ModelResponse, error) {
+		return &ai.ModelResponse{Request: req, Message: ai.NewModelTextMessage(req.Messages[0].Text())}, nil
+	})
+	p, err := New("TestExecuteContextDocuments",
+		"{{#each context}}- {{text}} ({{metadata.source}})\n{{/each}}Q: {{question}}",
+		Config{
+			Model:       echoModel,
+			InputSchema: &jsonschema.Schema{Type: "object"},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	vars := map[string]any{"question": "what is on the menu?"}
+	resp, err := p.Generate(context.Background(), &PromptRequest{
+		Variables: vars,
+		Context: []any{
+			ai.DocumentFromText("Pizza $10", map[string]any{"source": "menu.txt"}),
+			ai.DocumentFromText("Soup $5", map[string]any{"source": "specials.txt"}),
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- Pizza $10 (menu.txt)\n- Soup $5 (specials.txt)\nQ: what is on the menu?"
+	if got := resp.Text(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, ok := vars["context"]; ok {
+		t.Error("Generate modified the caller's variables")
+	}
+	if len(resp.Request.Context) != 2 {
+		t.Errorf("model request has %d context items, want 2", len(resp.Request.Context))
+	}
+}
+
+func TestExecuteVariableDefaults(t *testing.T) {
+	echoModel := ai.DefineModel("test", "echoDefaults", nil, func(ctx context.Context, req *ai.ModelRequest, _ func(context.Context, *ai.ModelResponseChunk) error) (*ai.ModelResponse, error) {
+		return &ai.ModelResponse{Request: req, Message: ai.NewModelTextMessage(req.Messages[0].Text())}, nil
+	})
+	p, err := Parse("TestExecuteVariableDefaults", "", []byte(`---
+input:
+  schema:
+    name: string
+    tone?: string
+  default:
+    tone: friendly
+---
+Greet {{name}} in a {{tone}} tone.`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Model = echoModel
+
+	type input struct {
+		Name string `json:"name"`
+		Tone string `json:"tone"`
+	}
+	for _, test := range []struct {
+		desc string
+		vars any
+		want string
+	}{
+		{"map omitted", map[string]any{"name": "Ada"}, "Greet Ada in a friendly tone."},
+		{"map set", map[string]any{"name": "Ada", "tone": "formal"}, "Greet Ada in a formal tone."},
+		{"struct zero", input{Name: "Ada"}, "Greet Ada in a friendly tone."},
+		{"struct set", input{Name: "Ada", Tone: "formal"}, "Greet Ada in a formal tone."},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			resp, err := p.Generate(context.Background(), &PromptRequest{Variables: test.vars}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Text(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRegisterNamespace(t *testing.T) {
+	testModel := ai.DefineModel("test", "namespaced", nil, testGenerate)
+	define := func(namespace, text string) (*Prompt, error) {
+		return Define("summarize", text, Config{
+			Model:       testModel,
+			Namespace:   namespace,
+			InputSchema: &jsonschema.Schema{Type: "object"},
+		})
+	}
+	if _, err := define("support", "Summarize the ticket."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := define("sales", "Summarize the lead."); err != nil {
+		t.Fatal(err)
+	}
+	for ns, want := range map[string]string{"support": "Summarize the ticket.", "sales": "Summarize the lead."} {
+		p := LookupPrompt(ns, "summarize")
+		if p == nil {
+			t.Fatalf("LookupPrompt(%q, %q) = nil", ns, "summarize")
+		}
+		req, err := p.Render(context.Background(), map[string]any{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := req.Messages[0].Text(); got != want {
+			t.Errorf("%s: got %q, want %q", ns, got, want)
+		}
+	}
+	if p := LookupPrompt("", "summarize"); p != nil {
+		t.Error("namespaced prompt found without namespace")
+	}
+
+	_, err := define("support", "Summarize differently.")
+	if err == nil || !strings.Contains(err.Error(), `"support/summarize" is already registered`) {
+		t.Errorf("got error %v, want collision error", err)
+	}
+}
+
+func TestExecuteCapabilityVariables(t *testing.T) {
+	echo := func(ctx context.Context, req *ai.ModelRequest, _ func(context.Context, *ai.ModelResponseChunk) error) (*ai.ModelResponse, error) {
+		return &ai.ModelResponse{Request: req, Message: ai.NewModelTextMessage(req.Messages[0].Text())}, nil
+	}
+	mediaModel := ai.DefineModel("test", "capsMedia", &ai.ModelMetadata{Supports: ai.ModelCapabilities{Media: true}}, echo)
+	textModel := ai.DefineModel("test", "capsText", nil, echo)
+	p, err := Parse("TestExecuteCapabilityVariables", "", []byte(`---
+input:
+  schema:
+    name: string
+---
+{{#if supportsMedia}}Look at the photo of {{name}}.{{else}}Read the description of {{name}}.{{/if}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		model ai.Model
+		want  string
+	}{
+		{mediaModel, "Look at the photo of Ada."},
+		{textModel, "Read the description of Ada."},
+	} {
+		t.Run(test.model.Name(), func(t *testing.T) {
+			p.Model = test.model
+			resp, err := p.Generate(context.Background(), &PromptRequest{Variables: map[string]any{"name": "Ada"}}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resp.Text(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
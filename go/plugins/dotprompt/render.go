@@ -56,6 +56,10 @@ func (p *Prompt) RenderMessages(variables map[string]any) ([]*ai.Message, error)
 		maps.Copy(nv, variables)
 		variables = nv
 	}
+	if history, ok := variables["history"].([]*ai.Message); ok {
+		variables = maps.Clone(variables)
+		variables["history"] = historyVariable(history)
+	}
 	str, err := p.Template.Exec(variables)
 	if err != nil {
 		return nil, err
@@ -63,6 +67,39 @@ func (p *Prompt) RenderMessages(variables map[string]any) ([]*ai.Message, error)
 	return p.toMessages(str)
 }
 
+// historyVariable converts a "history" variable holding messages into
+// a form that templates can render, one map per message with keys
+//   - "role": the role of the message
+//   - "text": the text of the message
+//   - "content": the parts of the message, each a map with
+//     "text" for a text part, or "url" and "contentType" for a media part.
+//
+// For example,
+//
+//	{{#each history}}{{role role}}{{text}}{{/each}}
+//
+// renders each message of the history as a message with the same role.
+func historyVariable(history []*ai.Message) []map[string]any {
+	hv := make([]map[string]any, 0, len(history))
+	for _, m := range history {
+		var content []map[string]any
+		for _, p := range m.Content {
+			switch {
+			case p.IsText():
+				content = append(content, map[string]any{"text": p.Text})
+			case p.IsMedia():
+				content = append(content, map[string]any{"url": p.Text, "contentType": p.ContentType})
+			}
+		}
+		hv = append(hv, map[string]any{
+			"role":    string(m.Role),
+			"text":    m.Text(),
+			"content": content,
+		})
+	}
+	return hv
+}
+
 const rolePrefix = "<<<dotprompt:role:"
 const roleSuffix = ">>>"
 const roleMatch = rolePrefix + "[a-z]+" + roleSuffix
@@ -165,6 +165,27 @@ func TestRenderMessages(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "render history as messages",
+			template: `{{role "system"}}You are a chef.
+{{~#each history}}{{role role}}{{text}}{{/each}}
+{{~role "user"}}{{question}}`,
+			input: map[string]any{
+				"history": []*ai.Message{
+					ai.NewUserTextMessage("What is in season?"),
+					ai.NewModelTextMessage("Asparagus."),
+					ai.NewUserTextMessage("How do I cook it?"),
+				},
+				"question": "For how long?",
+			},
+			want: []*ai.Message{
+				{Role: ai.RoleSystem, Content: []*ai.Part{ai.NewTextPart("You are a chef.")}},
+				{Role: ai.RoleUser, Content: []*ai.Part{ai.NewTextPart("What is in season?")}},
+				{Role: ai.RoleModel, Content: []*ai.Part{ai.NewTextPart("Asparagus.")}},
+				{Role: ai.RoleUser, Content: []*ai.Part{ai.NewTextPart("How do I cook it?")}},
+				{Role: ai.RoleUser, Content: []*ai.Part{ai.NewTextPart("For how long?")}},
+			},
+		},
 		{
 			name: "allow rendering JSON",
 			input: map[string]any{
@@ -226,3 +247,66 @@ func TestRenderMessages(t *testing.T) {
 		})
 	}
 }
+
+const benchTemplate = `{{role "system"}}You are a helpful assistant.
+{{role "user"}}Hello {{name}}, here is today's menu:
+{{#each items}}- {{this.title}}: {{this.description}}
+{{/each}}{{media url=image}}`
+
+var benchInput = map[string]any{
+	"name":  "Michael",
+	"image": "https://example.com/menu.png",
+	"items": []map[string]any{
+		{"title": "Soup", "description": "Tomato soup"},
+		{"title": "Salad", "description": "Green salad"},
+	},
+}
+
+func TestRenderCachedTemplate(t *testing.T) {
+	prompt, err := Parse("cached", "", []byte(benchTemplate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := prompt.Template
+	want, err := prompt.RenderMessages(benchInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		got, err := prompt.RenderMessages(benchInput)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("render %d: mismatch (-want, +got):\n%s", i, diff)
+		}
+	}
+	if prompt.Template != tmpl {
+		t.Error("rendering replaced the parsed template")
+	}
+}
+
+func BenchmarkRenderMessages(b *testing.B) {
+	b.Run("cached", func(b *testing.B) {
+		prompt, err := Parse("bench", "", []byte(benchTemplate))
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			if _, err := prompt.RenderMessages(benchInput); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("reparse", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			prompt, err := Parse("bench", "", []byte(benchTemplate))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := prompt.RenderMessages(benchInput); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
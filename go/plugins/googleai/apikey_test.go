@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package googleai
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+func TestPerRequestAPIKey(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, apiKeyOf(r))
+		mu.Unlock()
+		http.Error(w, "test", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// Set up the plugin state as Init would, without registering models.
+	state.clientOpts = []option.ClientOption{option.WithEndpoint(server.URL)}
+	client, err := genai.NewClient(ctx, append([]option.ClientOption{option.WithAPIKey("init-key")}, state.clientOpts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.gclient = client
+	defer func() {
+		state.gclient = nil
+		state.clientOpts = nil
+	}()
+
+	call := func(ctx context.Context) {
+		c, release, err := modelClient(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		req := &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("hi")}}
+		_, _ = generate(ctx, c, "gemini-1.0-pro", req, nil)
+	}
+	call(ctx)
+	call(ai.WithAPIKey(ctx, "tenant-key"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(keys) < 2 {
+		t.Fatalf("got %d requests, want at least 2", len(keys))
+	}
+	if g, w := keys[0], "init-key"; g != w {
+		t.Errorf("default call: got key %q, want %q", g, w)
+	}
+	if g, w := keys[len(keys)-1], "tenant-key"; g != w {
+		t.Errorf("per-request call: got key %q, want %q", g, w)
+	}
+}
+
+func apiKeyOf(r *http.Request) string {
+	if k := r.Header.Get("x-goog-api-key"); k != "" {
+		return k
+	}
+	return r.URL.Query().Get("key")
+}
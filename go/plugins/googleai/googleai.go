@@ -44,6 +44,9 @@ var state struct {
 	initted bool
 	// These happen to be the same.
 	gclient, pclient *genai.Client
+	// Client options other than the API key, used to create
+	// clients for per-request API keys.
+	clientOpts []option.ClientOption
 }
 
 var (
@@ -95,11 +98,11 @@ func Init(ctx context.Context, cfg *Config) (err error) {
 		}
 	}
 
-	opts := append([]option.ClientOption{
-		option.WithAPIKey(apiKey),
+	state.clientOpts = append([]option.ClientOption{
 		genai.WithClientInfo("genkit-go", internal.Version)},
 		cfg.ClientOptions...,
 	)
+	opts := append([]option.ClientOption{option.WithAPIKey(apiKey)}, state.clientOpts...)
 	client, err := genai.NewClient(ctx, opts...)
 	if err != nil {
 		return err
@@ -143,16 +146,24 @@ func DefineModel(name string, caps *ai.ModelCapabilities) (ai.Model, error) {
 
 // requires state.mu
 func defineModel(name string, caps ai.ModelCapabilities) ai.Model {
+	limits := gemini.TokenLimits[name]
 	meta := &ai.ModelMetadata{
-		Label:    labelPrefix + " - " + name,
-		Supports: caps,
+		Label:           labelPrefix + " - " + name,
+		Supports:        caps,
+		ContextWindow:   limits.ContextWindow,
+		MaxOutputTokens: limits.MaxOutputTokens,
 	}
 	return ai.DefineModel(provider, name, meta, func(
 		ctx context.Context,
 		input *ai.ModelRequest,
 		cb func(context.Context, *ai.ModelResponseChunk) error,
 	) (*ai.ModelResponse, error) {
-		return generate(ctx, state.gclient, name, input, cb)
+		client, release, err := modelClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return generate(ctx, client, name, input, cb)
 	})
 }
 
@@ -163,6 +174,25 @@ func IsDefinedModel(name string) bool {
 
 //copy:stop
 
+// modelClient returns the client to use for a model call, and a function
+// to call when the call is done.
+// If ctx carries an API key set with [ai.WithAPIKey], the client uses that
+// key instead of the one passed to [Init]. Such a client is created for
+// the call and closed by release, so that a server passing many keys,
+// such as one per user, doesn't accumulate clients and their connections.
+func modelClient(ctx context.Context) (_ *genai.Client, release func(), _ error) {
+	key := ai.APIKeyFromContext(ctx)
+	if key == "" {
+		return state.gclient, func() {}, nil
+	}
+	opts := append([]option.ClientOption{option.WithAPIKey(key)}, state.clientOpts...)
+	client, err := genai.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, func() { client.Close() }, nil
+}
+
 //copy:start vertexai.go defineEmbedder
 
 // DefineEmbedder defines an embedder with a given name.
@@ -182,11 +212,18 @@ func IsDefinedEmbedder(name string) bool {
 
 //copy:stop
 
+// embedTaskTypes maps Genkit embedding task types to Gemini task types.
+var embedTaskTypes = map[ai.EmbedTaskType]genai.TaskType{
+	ai.EmbedTaskTypeRetrievalQuery:    genai.TaskTypeRetrievalQuery,
+	ai.EmbedTaskTypeRetrievalDocument: genai.TaskTypeRetrievalDocument,
+	ai.EmbedTaskTypeClassification:    genai.TaskTypeClassification,
+}
+
 // requires state.mu
 func defineEmbedder(name string) ai.Embedder {
 	return ai.DefineEmbedder(provider, name, func(ctx context.Context, input *ai.EmbedRequest) (*ai.EmbedResponse, error) {
 		em := state.pclient.EmbeddingModel(name)
-		// TODO: set em.TaskType from EmbedRequest.Options?
+		em.TaskType = embedTaskTypes[input.TaskType]
 		batch := em.NewBatch()
 		for _, doc := range input.Documents {
 			parts, err := convertParts(doc.Content)
@@ -273,6 +310,7 @@ func generate(
 	// Streaming version.
 	iter := cs.SendMessageStream(ctx, parts...)
 	var r *ai.ModelResponse
+	var index int
 	for {
 		chunk, err := iter.Next()
 		if err == iterator.Done {
@@ -287,10 +325,12 @@ func generate(
 			tc := translateCandidate(c)
 			err := cb(ctx, &ai.ModelResponseChunk{
 				Content: tc.Message.Content,
+				Index:   index,
 			})
 			if err != nil {
 				return nil, err
 			}
+			index++
 		}
 	}
 	if r == nil {
@@ -322,6 +362,15 @@ func newModel(client *genai.Client, model string, input *ai.ModelRequest) (*gena
 			gm.SetTopP(float32(c.TopP))
 		}
 	}
+	if o := input.Output; o != nil && o.Format == ai.OutputFormatJSON {
+		// Constrain the model to produce JSON matching the schema.
+		gm.ResponseMIMEType = "application/json"
+		schema, err := convertSchema(o.Schema, o.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("output schema: %w", err)
+		}
+		gm.ResponseSchema = schema
+	}
 	for _, m := range input.Messages {
 		systemParts, err := convertParts(m.Content)
 		if err != nil {
@@ -360,18 +409,27 @@ func startChat(gm *genai.GenerativeModel, input *ai.ModelRequest) (*genai.ChatSe
 		}
 		cs.History = append(cs.History, &genai.Content{
 			Parts: parts,
-			Role:  string(m.Role),
+			Role:  convertRole(m.Role),
 		})
 	}
 	return cs, nil
 }
 
+// convertRole converts a genkit role to a Gemini role.
+// Gemini has no tool role; function responses are sent by the user.
+func convertRole(r ai.Role) string {
+	if r == ai.RoleTool {
+		return string(ai.RoleUser)
+	}
+	return string(r)
+}
+
 func convertTools(inTools []*ai.ToolDefinition) ([]*genai.Tool, error) {
 	var outTools []*genai.Tool
 	for _, t := range inTools {
 		inputSchema, err := convertSchema(t.InputSchema, t.InputSchema)
-		if err != err {
-			return nil, err
+		if err != nil {
+			return nil, fmt.Errorf("tool %q: %w", t.Name, err)
 		}
 		fd := &genai.FunctionDeclaration{
 			Name:        t.Name,
@@ -402,9 +460,9 @@ func convertSchema(originalSchema map[string]any, genkitSchema map[string]any) (
 		schema.Type = genai.TypeNumber
 	case "number":
 		schema.Type = genai.TypeNumber
-	case "int":
+	case "int", "integer":
 		schema.Type = genai.TypeInteger
-	case "bool":
+	case "bool", "boolean":
 		schema.Type = genai.TypeBoolean
 	case "object":
 		schema.Type = genai.TypeObject
@@ -517,6 +575,25 @@ func translateCandidate(cand *genai.Candidate) *ai.ModelResponse {
 func translateResponse(resp *genai.GenerateContentResponse) *ai.ModelResponse {
 	r := translateCandidate(resp.Candidates[0])
 
+	var cands []*ai.Candidate
+	for _, c := range resp.Candidates {
+		tc := translateCandidate(c)
+		cand := &ai.Candidate{
+			Index:        int(c.Index),
+			Message:      tc.Message,
+			FinishReason: tc.FinishReason,
+		}
+		for _, sr := range c.SafetyRatings {
+			cand.SafetyRatings = append(cand.SafetyRatings, &ai.SafetyRating{
+				Category:    sr.Category.String(),
+				Probability: sr.Probability.String(),
+				Blocked:     sr.Blocked,
+			})
+		}
+		cands = append(cands, cand)
+	}
+	r.SetCandidates(cands)
+
 	r.Usage = &ai.GenerationUsage{}
 	if u := resp.UsageMetadata; u != nil {
 		r.Usage.InputTokens = int(u.PromptTokenCount)
@@ -534,6 +611,10 @@ func translateResponse(resp *genai.GenerateContentResponse) *ai.ModelResponse {
 func convertParts(parts []*ai.Part) ([]genai.Part, error) {
 	res := make([]genai.Part, 0, len(parts))
 	for _, p := range parts {
+		if p.IsCitation() {
+			// Citations annotate responses; they are not model input.
+			continue
+		}
 		part, err := convertPart(p)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package googleai
+
+import (
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestTranslateResponseCandidates(t *testing.T) {
+	resp := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{
+			{
+				Index:        0,
+				Content:      &genai.Content{Role: "model", Parts: []genai.Part{genai.Text("a complete answer")}},
+				FinishReason: genai.FinishReasonStop,
+			},
+			{
+				Index:        1,
+				Content:      &genai.Content{Role: "model", Parts: []genai.Part{genai.Text("a cut")}},
+				FinishReason: genai.FinishReasonSafety,
+				SafetyRatings: []*genai.SafetyRating{{
+					Category:    genai.HarmCategoryDangerousContent,
+					Probability: genai.HarmProbabilityHigh,
+					Blocked:     true,
+				}},
+			},
+		},
+	}
+	r := translateResponse(resp)
+	if got, want := r.Text(), "a complete answer"; got != want {
+		t.Errorf("got text %q, want the first candidate %q", got, want)
+	}
+
+	cands := r.Candidates()
+	if len(cands) != 2 {
+		t.Fatalf("got %d candidates, want 2", len(cands))
+	}
+	for i, want := range []ai.FinishReason{ai.FinishReasonStop, ai.FinishReasonBlocked} {
+		if cands[i].Index != i {
+			t.Errorf("candidate %d: got index %d", i, cands[i].Index)
+		}
+		if cands[i].FinishReason != want {
+			t.Errorf("candidate %d: got finish reason %q, want %q", i, cands[i].FinishReason, want)
+		}
+	}
+	if got, want := cands[1].Message.Text(), "a cut"; got != want {
+		t.Errorf("candidate 1: got text %q, want %q", got, want)
+	}
+	if len(cands[0].SafetyRatings) != 0 {
+		t.Errorf("candidate 0: got safety ratings %v, want none", cands[0].SafetyRatings)
+	}
+	sr := cands[1].SafetyRatings
+	if len(sr) != 1 || sr[0].Category != "HarmCategoryDangerousContent" || sr[0].Probability != "HarmProbabilityHigh" || !sr[0].Blocked {
+		t.Errorf("candidate 1: got safety ratings %+v", sr)
+	}
+}
@@ -34,7 +34,10 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"google.golang.org/api/option"
 )
 
 // Config provides configuration options for the Init function.
@@ -51,6 +54,12 @@ type Config struct {
 	// The minimum level at which logs will be written.
 	// Defaults to [slog.LevelInfo].
 	LogLevel slog.Leveler
+
+	// The name of the service, recorded with each span exported to
+	// Cloud Trace. The default is "genkit".
+	ServiceName string
+	// Options for the Cloud Trace client, such as a different endpoint.
+	TraceClientOptions []option.ClientOption
 }
 
 // Init initializes all telemetry in this package.
@@ -70,12 +79,11 @@ func Init(ctx context.Context, cfg Config) (err error) {
 		return nil
 	}
 	// Add a SpanProcessor for tracing.
-	texp, err := texporter.New(texporter.WithProjectID(cfg.ProjectID))
+	texp, err := newTraceExporter(cfg)
 	if err != nil {
 		return err
 	}
-	aexp := &adjustingTraceExporter{texp}
-	core.RegisterSpanProcessor(sdktrace.NewBatchSpanProcessor(aexp))
+	core.RegisterSpanProcessor(sdktrace.NewBatchSpanProcessor(texp))
 	if err := setMeterProvider(cfg.ProjectID, cfg.MetricInterval); err != nil {
 		return err
 	}
@@ -93,14 +101,40 @@ func setMeterProvider(projectID string, interval time.Duration) error {
 	return nil
 }
 
+// newTraceExporter returns an exporter that writes spans to Cloud Trace.
+func newTraceExporter(cfg Config) (sdktrace.SpanExporter, error) {
+	texp, err := texporter.New(
+		texporter.WithProjectID(cfg.ProjectID),
+		texporter.WithTraceClientOptions(cfg.TraceClientOptions))
+	if err != nil {
+		return nil, err
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "genkit"
+	}
+	res := resource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+		semconv.CloudProviderGCP,
+		semconv.CloudAccountID(cfg.ProjectID),
+	)
+	return &adjustingTraceExporter{texp, res}, nil
+}
+
 type adjustingTraceExporter struct {
-	e sdktrace.SpanExporter
+	e   sdktrace.SpanExporter
+	res *resource.Resource // added to the resource of each span
 }
 
 func (e *adjustingTraceExporter) ExportSpans(ctx context.Context, spanData []sdktrace.ReadOnlySpan) error {
 	var adjusted []sdktrace.ReadOnlySpan
 	for _, s := range spanData {
-		adjusted = append(adjusted, adjustedSpan{s})
+		res, err := resource.Merge(s.Resource(), e.res)
+		if err != nil {
+			// The schema URLs conflict; ours has none, so this shouldn't happen.
+			res = e.res
+		}
+		adjusted = append(adjusted, adjustedSpan{s, res})
 	}
 	return e.e.ExportSpans(ctx, adjusted)
 }
@@ -111,8 +145,11 @@ func (e *adjustingTraceExporter) Shutdown(ctx context.Context) error {
 
 type adjustedSpan struct {
 	sdktrace.ReadOnlySpan
+	res *resource.Resource
 }
 
+func (s adjustedSpan) Resource() *resource.Resource { return s.res }
+
 func (s adjustedSpan) Attributes() []attribute.KeyValue {
 	// Omit input and output, which may contain PII.
 	var ts []attribute.KeyValue
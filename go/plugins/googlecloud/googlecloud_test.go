@@ -20,14 +20,22 @@ import (
 	"context"
 	"flag"
 	"log/slog"
+	"net"
 	"os"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
+	"cloud.google.com/go/trace/apiv2/tracepb"
 	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 var projectID = flag.String("project", "", "GCP project ID")
@@ -81,3 +89,83 @@ func TestGCP(t *testing.T) {
 		time.Sleep(2 * time.Second)
 	})
 }
+
+// fakeTraceServer is a stub Cloud Trace service that records the spans written to it.
+type fakeTraceServer struct {
+	tracepb.UnimplementedTraceServiceServer
+	spans chan *tracepb.Span
+}
+
+func (s *fakeTraceServer) BatchWriteSpans(_ context.Context, req *tracepb.BatchWriteSpansRequest) (*emptypb.Empty, error) {
+	for _, sp := range req.Spans {
+		s.spans <- sp
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func TestTraceExporter(t *testing.T) {
+	fake := &fakeTraceServer{spans: make(chan *tracepb.Span, 10)}
+	server := grpc.NewServer()
+	tracepb.RegisterTraceServiceServer(server, fake)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+	defer server.Stop()
+
+	exp, err := newTraceExporter(Config{
+		ProjectID:   "test-project",
+		ServiceName: "menu-service",
+		TraceClientOptions: []option.ClientOption{
+			option.WithEndpoint(lis.Addr().String()),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	ctx := context.Background()
+	_, span := tp.Tracer("genkit-test").Start(ctx, "menuFlow")
+	span.SetAttributes(
+		attribute.String("genkit:input", "secret"),
+		attribute.String("genkit:name", "menuFlow"),
+	)
+	span.End()
+	if err := tp.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var got *tracepb.Span
+	select {
+	case got = <-fake.spans:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no span written to Cloud Trace")
+	}
+	wantPrefix := "projects/test-project/traces/" + span.SpanContext().TraceID().String()
+	if !strings.HasPrefix(got.Name, wantPrefix) {
+		t.Errorf("span name %q does not start with %q", got.Name, wantPrefix)
+	}
+	if g, w := got.DisplayName.GetValue(), "menuFlow"; g != w {
+		t.Errorf("display name: got %q, want %q", g, w)
+	}
+	labels := map[string]string{}
+	for k, v := range got.Attributes.GetAttributeMap() {
+		labels[k] = v.GetStringValue().GetValue()
+	}
+	for k, w := range map[string]string{
+		"genkit:name":      "menuFlow",
+		"cloud.account.id": "test-project",
+		"cloud.provider":   "gcp",
+		"service.name":     "menu-service",
+	} {
+		if g := labels[k]; g != w {
+			t.Errorf("label %q: got %q, want %q", k, g, w)
+		}
+	}
+	if _, ok := labels["genkit:input"]; ok {
+		t.Error("span input was exported")
+	}
+}
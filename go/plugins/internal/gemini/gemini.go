@@ -35,3 +35,10 @@ var (
 		Media:      true,
 	}
 )
+
+// TokenLimits holds the token limits of Gemini models, by name.
+var TokenLimits = map[string]struct{ ContextWindow, MaxOutputTokens int }{
+	"gemini-1.0-pro":   {30720, 2048},
+	"gemini-1.5-pro":   {2097152, 8192},
+	"gemini-1.5-flash": {1048576, 8192},
+}
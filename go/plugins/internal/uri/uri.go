@@ -30,6 +30,14 @@ func Data(p *ai.Part) (string, []byte, error) {
 		return "", nil, errors.New("not a media part")
 	}
 
+	if p.MediaReader != nil {
+		data, err := p.ReadMedia()
+		if err != nil {
+			return "", nil, err
+		}
+		return p.ContentType, data, nil
+	}
+
 	uri := p.Text
 	if strings.HasPrefix(uri, "gs://") {
 		if p.ContentType == "" {
@@ -15,6 +15,7 @@
 package uri
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/firebase/genkit/go/ai"
@@ -64,6 +65,11 @@ func TestData(t *testing.T) {
 			input:   ai.NewTextPart("e"),
 			wantErr: true,
 		},
+		{
+			input:    ai.NewMediaPartReader("text/plain", strings.NewReader("f")),
+			wantType: "text/plain",
+			wantData: "f",
+		},
 	}
 
 	for i, test := range tests {
@@ -0,0 +1,189 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localvec
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// IVFOptions configures an inverted file (IVF) index, which speeds up
+// retrieval from large stores at the cost of sometimes missing a
+// close document.
+//
+// The index partitions the documents into clusters by k-means. A query
+// is compared only with the documents in the clusters whose centers are
+// closest to it, rather than with every document.
+type IVFOptions struct {
+	// Lists is the number of clusters. If zero, it is the square root
+	// of the number of documents.
+	Lists int
+	// Probes is the number of clusters searched for each query.
+	// More probes find close documents more reliably, but take longer.
+	// If zero, it is the square root of the number of clusters.
+	Probes int
+}
+
+const (
+	// ivfIterations is the number of k-means iterations.
+	ivfIterations = 10
+	// ivfSamplesPerList bounds the number of documents used to compute
+	// the clusters, as a multiple of the number of clusters.
+	ivfSamplesPerList = 64
+)
+
+// ivfIndex is an inverted file index of the documents in a docStore.
+type ivfIndex struct {
+	probes    int
+	centroids [][]float32 // unit vectors
+	lists     [][]string  // IDs of the documents closest to each centroid
+	trainedOn int         // number of documents when the centroids were computed
+}
+
+// buildIVF clusters the documents in data and returns an index of them.
+func buildIVF(data map[string]dbValue, opts IVFOptions) *ivfIndex {
+	ids := make([]string, 0, len(data))
+	for id := range data {
+		ids = append(ids, id)
+	}
+	// Sort the IDs so that the clusters don't depend on map order.
+	slices.Sort(ids)
+	n := len(ids)
+
+	nlists := opts.Lists
+	if nlists <= 0 {
+		nlists = int(math.Sqrt(float64(n)))
+	}
+	nlists = max(1, min(nlists, n))
+	probes := opts.Probes
+	if probes <= 0 {
+		probes = int(math.Ceil(math.Sqrt(float64(nlists))))
+	}
+
+	// Compute the clusters from evenly spaced documents,
+	// starting with some of those documents as the centers.
+	samples := make([][]float32, 0, min(n, nlists*ivfSamplesPerList))
+	step := max(1, n/(nlists*ivfSamplesPerList))
+	for i := 0; i < n; i += step {
+		samples = append(samples, normalize(data[ids[i]].Embedding))
+	}
+	idx := &ivfIndex{
+		probes:    min(probes, nlists),
+		centroids: make([][]float32, nlists),
+		lists:     make([][]string, nlists),
+		trainedOn: n,
+	}
+	for i := range idx.centroids {
+		idx.centroids[i] = samples[i*len(samples)/nlists]
+	}
+	for range ivfIterations {
+		sums := make([][]float64, nlists)
+		for _, v := range samples {
+			c := idx.nearestCentroid(v)
+			if sums[c] == nil {
+				sums[c] = make([]float64, len(v))
+			}
+			for j, x := range v {
+				sums[c][j] += float64(x)
+			}
+		}
+		for c, sum := range sums {
+			// A cluster with no documents keeps its center.
+			if sum != nil {
+				idx.centroids[c] = normalize64(sum)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		idx.add(id, data[id].Embedding)
+	}
+	return idx
+}
+
+// add adds a document to the cluster with the closest center.
+func (idx *ivfIndex) add(id string, embedding []float32) {
+	c := idx.nearestCentroid(normalize(embedding))
+	idx.lists[c] = append(idx.lists[c], id)
+}
+
+// candidates returns the IDs of the documents in the clusters
+// closest to the query.
+func (idx *ivfIndex) candidates(query []float32) []string {
+	q := normalize(query)
+	type scoredList struct {
+		i     int
+		score float64
+	}
+	scored := make([]scoredList, len(idx.centroids))
+	for i, c := range idx.centroids {
+		scored[i] = scoredList{i, dot(q, c)}
+	}
+	slices.SortFunc(scored, func(a, b scoredList) int {
+		return cmp.Compare(b.score, a.score)
+	})
+	var ids []string
+	for _, s := range scored[:idx.probes] {
+		ids = append(ids, idx.lists[s.i]...)
+	}
+	return ids
+}
+
+// nearestCentroid returns the index of the centroid closest to
+// the unit vector v.
+func (idx *ivfIndex) nearestCentroid(v []float32) int {
+	best, bestScore := 0, math.Inf(-1)
+	for i, c := range idx.centroids {
+		if s := dot(v, c); s > bestScore {
+			best, bestScore = i, s
+		}
+	}
+	return best
+}
+
+// normalize returns v scaled to unit length.
+// The zero vector is returned unchanged.
+func normalize(v []float32) []float32 {
+	v64 := make([]float64, len(v))
+	for i, x := range v {
+		v64[i] = float64(x)
+	}
+	return normalize64(v64)
+}
+
+func normalize64(v []float64) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	norm := math.Sqrt(sum)
+	if norm == 0 {
+		norm = 1
+	}
+	u := make([]float32, len(v))
+	for i, x := range v {
+		u[i] = float32(x / norm)
+	}
+	return u
+}
+
+func dot(a, b []float32) float64 {
+	var s float64
+	for i, x := range a {
+		s += float64(x) * float64(b[i])
+	}
+	return s
+}
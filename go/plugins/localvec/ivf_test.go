@@ -0,0 +1,145 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package localvec
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// clusteredStore returns a docStore holding n documents whose embeddings
+// are spread around the given number of random centers, as the embeddings
+// of documents on a few topics are, along with some queries drawn from
+// the same distribution.
+func clusteredStore(n, dim, clusters, nqueries int, opts *IVFOptions) (*docStore, [][]float32) {
+	rng := rand.New(rand.NewSource(1))
+	centers := make([][]float32, clusters)
+	for i := range centers {
+		centers[i] = make([]float32, dim)
+		for j := range centers[i] {
+			centers[i][j] = float32(rng.NormFloat64())
+		}
+	}
+	point := func() []float32 {
+		c := centers[rng.Intn(clusters)]
+		v := make([]float32, dim)
+		for j := range v {
+			v[j] = c[j] + 0.3*float32(rng.NormFloat64())
+		}
+		return v
+	}
+	ds := &docStore{data: map[string]dbValue{}, ivfOptions: opts}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprint(i)
+		ds.data[id] = dbValue{Doc: &ai.Document{ID: id}, Embedding: point()}
+	}
+	queries := make([][]float32, nqueries)
+	for i := range queries {
+		queries[i] = point()
+	}
+	return ds, queries
+}
+
+func TestIVFRecall(t *testing.T) {
+	const k = 10
+	exact, queries := clusteredStore(5000, 32, 50, 100, nil)
+	approx := &docStore{data: exact.data, ivfOptions: &IVFOptions{}}
+
+	found := 0
+	for _, q := range queries {
+		want := map[string]bool{}
+		for _, sd := range exact.nearest(q)[:k] {
+			want[sd.doc.ID] = true
+		}
+		got := approx.nearest(q)
+		for _, sd := range got[:min(k, len(got))] {
+			if want[sd.doc.ID] {
+				found++
+			}
+		}
+	}
+	recall := float64(found) / float64(k*len(queries))
+	if recall < 0.9 {
+		t.Errorf("recall@%d is %.2f, want at least 0.9", k, recall)
+	}
+
+	// Documents indexed after the clusters are computed are found too.
+	v := queries[0]
+	approx.data["new"] = dbValue{Doc: &ai.Document{ID: "new"}, Embedding: v}
+	approx.ivf.add("new", v)
+	if got := approx.nearest(v)[0].doc.ID; got != "new" {
+		t.Errorf("nearest document to a new document's embedding is %q, want %q", got, "new")
+	}
+}
+
+func BenchmarkNearest(b *testing.B) {
+	exact, queries := clusteredStore(20000, 128, 100, 100, nil)
+	for _, bm := range []struct {
+		name string
+		opts *IVFOptions
+	}{
+		{"exact", nil},
+		{"ivf", &IVFOptions{}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			ds := &docStore{data: exact.data, ivfOptions: bm.opts}
+			ds.nearest(queries[0]) // build the index, if any
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ds.nearest(queries[i%len(queries)])
+			}
+		})
+	}
+}
+
+// TestIVFConcurrent checks, when run with the race detector, that
+// searches may build the index while other searches and indexing run.
+func TestIVFConcurrent(t *testing.T) {
+	ds, queries := clusteredStore(2000, 16, 20, 8, &IVFOptions{})
+	ds.filename = filepath.Join(t.TempDir(), "db.json")
+	ds.embedder = ai.DefineEmbedder("fake", "ivfConcurrent", func(_ context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+		resp := &ai.EmbedResponse{}
+		for range req.Documents {
+			resp.Embeddings = append(resp.Embeddings, &ai.DocumentEmbedding{Embedding: queries[0]})
+		}
+		return resp, nil
+	})
+	var wg sync.WaitGroup
+	for _, q := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ds.nearest(q)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		req := &ai.IndexerRequest{Documents: []*ai.Document{ai.DocumentFromText("new", nil)}}
+		if err := ds.index(context.Background(), req); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+	if got := len(ds.nearest(queries[0])); got == 0 {
+		t.Error("no documents found after concurrent searches")
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"sync"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core/logger"
@@ -41,6 +42,10 @@ type Config struct {
 	Dir             string
 	Embedder        ai.Embedder
 	EmbedderOptions any
+	// If non-nil, the retriever searches an approximate index of the
+	// documents rather than comparing the query with each of them.
+	// This is faster for large stores, but may miss some close documents.
+	IVF *IVFOptions
 }
 
 // Init initializes the plugin.
@@ -53,6 +58,7 @@ func DefineIndexerAndRetriever(name string, cfg Config) (ai.Indexer, ai.Retrieve
 	if err != nil {
 		return nil, nil, err
 	}
+	ds.ivfOptions = cfg.IVF
 	return ai.DefineIndexer(provider, name, ds.index),
 		ai.DefineRetriever(provider, name, ds.retrieve),
 		nil
@@ -85,7 +91,11 @@ type docStore struct {
 	filename        string
 	embedder        ai.Embedder
 	embedderOptions any
-	data            map[string]dbValue
+	ivfOptions      *IVFOptions // nil for exact search
+
+	mu   sync.RWMutex
+	data map[string]dbValue
+	ivf  *ivfIndex // nil until the first approximate search
 }
 
 // dbValue is the type of a document stored in the database.
@@ -132,11 +142,14 @@ func (ds *docStore) index(ctx context.Context, req *ai.IndexerRequest) error {
 	ereq := &ai.EmbedRequest{
 		Documents: req.Documents,
 		Options:   ds.embedderOptions,
+		TaskType:  ai.EmbedTaskTypeRetrievalDocument,
 	}
 	eres, err := ds.embedder.Embed(ctx, ereq)
 	if err != nil {
 		return fmt.Errorf("localvec index embedding failed: %v", err)
 	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	for i, de := range eres.Embeddings {
 		id, err := docID(req.Documents[i])
 		if err != nil {
@@ -155,6 +168,9 @@ func (ds *docStore) index(ctx context.Context, req *ai.IndexerRequest) error {
 			Doc:       req.Documents[i],
 			Embedding: de.Embedding,
 		}
+		if ds.ivf != nil {
+			ds.ivf.add(id, de.Embedding)
+		}
 	}
 
 	// Update the file every time we add documents.
@@ -184,6 +200,51 @@ func (ds *docStore) index(ctx context.Context, req *ai.IndexerRequest) error {
 // The Options field should be either nil or a value of type *RetrieverOptions.
 type RetrieverOptions struct {
 	K int `json:"k,omitempty"` // number of entries to return
+	// If non-nil, select the entries by Maximal Marginal Relevance
+	// rather than by similarity alone. [WithMMR] sets it.
+	MMR *MMROptions `json:"mmr,omitempty"`
+}
+
+// MMROptions configures [Maximal Marginal Relevance] selection,
+// which trades off relevance to the query against diversity
+// among the returned documents.
+//
+// [Maximal Marginal Relevance]: https://www.cs.cmu.edu/~jgc/publication/The_Use_MMR_Diversity_Based_LTMIR_1998.pdf
+type MMROptions struct {
+	// Lambda is between 0 and 1. A value of 1 selects by relevance only,
+	// like plain top-K; a value of 0 selects by diversity only.
+	Lambda float64 `json:"lambda"`
+	// FetchK is the number of documents most similar to the query
+	// that the K results are selected from. Zero means 4*K.
+	FetchK int `json:"fetchK,omitempty"`
+}
+
+// defaultK is the number of documents retrieved when the request has no options.
+const defaultK = 3
+
+// WithMMR selects the documents retrieved from a localvec retriever by
+// Maximal Marginal Relevance with the given lambda, as described at
+// [MMROptions]. It sets the MMR field of the request's [RetrieverOptions],
+// so it must follow any [ai.WithRetrieverOpts] option.
+func WithMMR(lambda float64) ai.RetrieveOption {
+	return func(req *ai.RetrieverRequest) error {
+		if lambda < 0 || lambda > 1 {
+			return fmt.Errorf("localvec.WithMMR: lambda %g is not between 0 and 1", lambda)
+		}
+		var opts RetrieverOptions
+		switch o := req.Options.(type) {
+		case nil:
+			opts.K = defaultK
+		case *RetrieverOptions:
+			// Copy the options rather than modifying the caller's.
+			opts = *o
+		default:
+			return fmt.Errorf("localvec.WithMMR: retriever options are a %T, not a *RetrieverOptions", req.Options)
+		}
+		opts.MMR = &MMROptions{Lambda: lambda}
+		req.Options = &opts
+		return nil
+	}
 }
 
 // retrieve retrieves documents close to the argument.
@@ -193,23 +254,72 @@ func (ds *docStore) retrieve(ctx context.Context, req *ai.RetrieverRequest) (*ai
 	ereq := &ai.EmbedRequest{
 		Documents: []*ai.Document{req.Document},
 		Options:   ds.embedderOptions,
+		TaskType:  ai.EmbedTaskTypeRetrievalQuery,
 	}
 	eres, err := ds.embedder.Embed(ctx, ereq)
 	if err != nil {
 		return nil, fmt.Errorf("localvec retrieve embedding failed: %v", err)
 	}
-	vals := eres.Embeddings[0].Embedding
+	scoredDocs := ds.nearest(eres.Embeddings[0].Embedding)
+
+	k := defaultK
+	var mmr *MMROptions
+	if options, _ := req.Options.(*RetrieverOptions); options != nil {
+		k = options.K
+		mmr = options.MMR
+	}
+	k = min(k, len(scoredDocs))
+
+	if mmr != nil {
+		if mmr.Lambda < 0 || mmr.Lambda > 1 {
+			return nil, fmt.Errorf("localvec: MMR lambda %g is not between 0 and 1", mmr.Lambda)
+		}
+		fetchK := mmr.FetchK
+		if fetchK <= 0 {
+			fetchK = 4 * k
+		}
+		scoredDocs = selectMMR(scoredDocs[:min(max(fetchK, k), len(scoredDocs))], k, mmr.Lambda)
+	}
+
+	docs := make([]*ai.Document, 0, k)
+	for i := 0; i < k; i++ {
+		docs = append(docs, scoredDocs[i].doc)
+	}
+
+	resp := &ai.RetrieverResponse{
+		Documents: docs,
+	}
+	return resp, nil
+}
 
-	type scoredDoc struct {
-		score float64
-		doc   *ai.Document
+// nearest returns the documents to consider for the query, sorted by
+// descending similarity to it. The documents are all those in the store,
+// unless the store has an approximate index.
+func (ds *docStore) nearest(query []float32) []scoredDoc {
+	if ds.ivfOptions != nil {
+		ds.refreshIVF()
 	}
-	scoredDocs := make([]scoredDoc, 0, len(ds.data))
-	for _, dbv := range ds.data {
-		score := similarity(vals, dbv.Embedding)
+	ds.mu.RLock()
+	var candidates []dbValue
+	if ds.ivf == nil {
+		candidates = make([]dbValue, 0, len(ds.data))
+		for _, dbv := range ds.data {
+			candidates = append(candidates, dbv)
+		}
+	} else {
+		for _, id := range ds.ivf.candidates(query) {
+			candidates = append(candidates, ds.data[id])
+		}
+	}
+	ds.mu.RUnlock()
+
+	scoredDocs := make([]scoredDoc, 0, len(candidates))
+	for _, dbv := range candidates {
+		score := similarity(query, dbv.Embedding)
 		scoredDocs = append(scoredDocs, scoredDoc{
-			score: score,
-			doc:   dbv.Doc,
+			score:     score,
+			doc:       dbv.Doc,
+			embedding: dbv.Embedding,
 		})
 	}
 
@@ -218,22 +328,61 @@ func (ds *docStore) retrieve(ctx context.Context, req *ai.RetrieverRequest) (*ai
 		// so pass b.score first to reverse the default ordering.
 		return cmp.Compare(b.score, a.score)
 	})
+	return scoredDocs
+}
 
-	k := 3
-	if options, _ := req.Options.(*RetrieverOptions); options != nil {
-		k = options.K
+// refreshIVF builds the approximate index, or recomputes its clusters
+// when the store has doubled in size since they were computed, so that
+// they stay representative.
+func (ds *docStore) refreshIVF() {
+	stale := func() bool {
+		return len(ds.data) > 0 && (ds.ivf == nil || len(ds.data) >= 2*ds.ivf.trainedOn)
 	}
-	k = min(k, len(scoredDocs))
-
-	docs := make([]*ai.Document, 0, k)
-	for i := 0; i < k; i++ {
-		docs = append(docs, scoredDocs[i].doc)
+	ds.mu.RLock()
+	s := stale()
+	ds.mu.RUnlock()
+	if !s {
+		return
 	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	// Another search may have rebuilt the index in the meantime.
+	if stale() {
+		ds.ivf = buildIVF(ds.data, *ds.ivfOptions)
+	}
+}
 
-	resp := &ai.RetrieverResponse{
-		Documents: docs,
+// scoredDoc is a document with its similarity to the query.
+type scoredDoc struct {
+	score     float64
+	doc       *ai.Document
+	embedding []float32
+}
+
+// selectMMR greedily selects k of the candidates by Maximal Marginal
+// Relevance, in O(len(candidates)*k*k) time: each step picks the candidate that maximizes
+// lambda*relevance - (1-lambda)*(similarity to the closest selected document).
+// The candidates should be sorted by descending score, so that ties
+// are broken in favor of relevance.
+func selectMMR(candidates []scoredDoc, k int, lambda float64) []scoredDoc {
+	remaining := slices.Clone(candidates)
+	selected := make([]scoredDoc, 0, k)
+	for len(selected) < k && len(remaining) > 0 {
+		best, bestScore := 0, math.Inf(-1)
+		for i, c := range remaining {
+			redundancy := 0.0
+			for _, s := range selected {
+				redundancy = max(redundancy, similarity(c.embedding, s.embedding))
+			}
+			score := lambda*c.score - (1-lambda)*redundancy
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		selected = append(selected, remaining[best])
+		remaining = slices.Delete(remaining, best, best+1)
 	}
-	return resp, nil
+	return selected
 }
 
 // similarity computes the [cosine similarity] between two vectors.
@@ -276,9 +425,12 @@ func similarity(vals1, vals2 []float32) float64 {
 	return dot / (l1 * l2)
 }
 
-// docID returns the ID to use for a Document.
-// This is intended to be the same as the genkit Typescript computation.
+// docID returns the ID to use for a Document: its ID if it has one.
+// Otherwise, it is intended to be the same as the genkit Typescript computation.
 func docID(doc *ai.Document) (string, error) {
+	if doc.ID != "" {
+		return doc.ID, nil
+	}
 	b, err := json.Marshal(doc)
 	if err != nil {
 		return "", fmt.Errorf("localvec: error marshaling document: %v", err)
@@ -16,12 +16,15 @@ package localvec
 
 import (
 	"context"
+	"crypto/md5"
+	"fmt"
 	"math"
 	"strings"
 	"testing"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/internal/fakeembedder"
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestLocalVec(t *testing.T) {
@@ -89,6 +92,23 @@ func TestLocalVec(t *testing.T) {
 	}
 }
 
+func TestDocID(t *testing.T) {
+	// A document without an ID gets the ID of the TypeScript
+	// implementation, the MD5 hash of its JSON encoding.
+	d := ai.DocumentFromText("hello", nil)
+	got, err := docID(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%02x", md5.Sum([]byte(`{"content":[{"text":"hello"}]}`))); got != want {
+		t.Errorf("got ID %q, want %q", got, want)
+	}
+	d.ID = "doc-1"
+	if got, _ := docID(d); got != "doc-1" {
+		t.Errorf("got ID %q, want the document's ID %q", got, "doc-1")
+	}
+}
+
 func TestPersistentIndexing(t *testing.T) {
 	ctx := context.Background()
 
@@ -177,6 +197,124 @@ func TestPersistentIndexing(t *testing.T) {
 	}
 }
 
+func TestMMR(t *testing.T) {
+	ctx := context.Background()
+
+	// Three near-duplicate documents close to the query,
+	// and one less relevant but different document.
+	query := ai.DocumentFromText("query", nil)
+	a1 := ai.DocumentFromText("a1", nil)
+	a2 := ai.DocumentFromText("a2", nil)
+	a3 := ai.DocumentFromText("a3", nil)
+	b1 := ai.DocumentFromText("b1", nil)
+
+	embedder := fakeembedder.New()
+	embedder.Register(query, []float32{1, 0, 0})
+	embedder.Register(a1, []float32{1, 0.05, 0})
+	embedder.Register(a2, []float32{1, 0.06, 0})
+	embedder.Register(a3, []float32{1, 0.04, 0.01})
+	embedder.Register(b1, []float32{0.6, 0, 0.8})
+	embedAction := ai.DefineEmbedder("fake", "embedderMMR", embedder.Embed)
+	ds, err := newDocStore(t.TempDir(), "testMMR", embedAction, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.index(ctx, &ai.IndexerRequest{Documents: []*ai.Document{a1, a2, a3, b1}}); err != nil {
+		t.Fatalf("Index operation failed: %v", err)
+	}
+
+	clusters := func(opts *RetrieverOptions) map[byte]int {
+		resp, err := ds.retrieve(ctx, &ai.RetrieverRequest{Document: query, Options: opts})
+		if err != nil {
+			t.Fatalf("Retrieve operation failed: %v", err)
+		}
+		if len(resp.Documents) != 2 {
+			t.Fatalf("got %d results, expected 2", len(resp.Documents))
+		}
+		m := map[byte]int{}
+		for _, d := range resp.Documents {
+			m[d.Content[0].Text[0]]++
+		}
+		return m
+	}
+
+	if got := clusters(&RetrieverOptions{K: 2}); got['a'] != 2 {
+		t.Errorf("top-K: got clusters %v, want both results from cluster a", got)
+	}
+	if got := clusters(&RetrieverOptions{K: 2, MMR: &MMROptions{Lambda: 0.3}}); got['a'] != 1 || got['b'] != 1 {
+		t.Errorf("MMR: got clusters %v, want one result from each cluster", got)
+	}
+	// With only the two most similar documents as candidates,
+	// MMR can't reach the other cluster.
+	if got := clusters(&RetrieverOptions{K: 2, MMR: &MMROptions{Lambda: 0.3, FetchK: 2}}); got['a'] != 2 {
+		t.Errorf("MMR with FetchK 2: got clusters %v, want both results from cluster a", got)
+	}
+
+	req := &ai.RetrieverRequest{Document: query, Options: &RetrieverOptions{K: 2}}
+	if err := WithMMR(0.3)(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := clusters(req.Options.(*RetrieverOptions)); got['a'] != 1 || got['b'] != 1 {
+		t.Errorf("WithMMR: got clusters %v, want one result from each cluster", got)
+	}
+	if err := WithMMR(1.5)(req); err == nil {
+		t.Error("WithMMR(1.5) succeeded, want an error")
+	}
+	if got := clusters(&RetrieverOptions{K: 2, MMR: &MMROptions{Lambda: 1}}); got['a'] != 2 {
+		t.Errorf("MMR with lambda 1: got clusters %v, want both results from cluster a", got)
+	}
+
+	_, err = ds.retrieve(ctx, &ai.RetrieverRequest{Document: query, Options: &RetrieverOptions{K: 2, MMR: &MMROptions{Lambda: 2}}})
+	if err == nil {
+		t.Error("got nil error for lambda out of range")
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	type item struct {
+		Title string   `json:"title"`
+		Price float64  `json:"price"`
+		Tags  []string `json:"tags"`
+	}
+	want := item{Title: "Soup", Price: 4.5, Tags: []string{"vegan", "hot"}}
+	d := ai.DocumentFromText("soup", map[string]any{"item": &want})
+
+	embedder := fakeembedder.New()
+	embedder.Register(d, []float32{1, 0})
+	embedAction := ai.DefineEmbedder("fake", "embedderMetadata", embedder.Embed)
+
+	dir := t.TempDir()
+	ds, err := newDocStore(dir, "testMetadata", embedAction, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.index(ctx, &ai.IndexerRequest{Documents: []*ai.Document{d}}); err != nil {
+		t.Fatalf("Index operation failed: %v", err)
+	}
+
+	// Reopen the store so that the metadata is read back from the file.
+	ds, err = newDocStore(dir, "testMetadata", embedAction, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ds.retrieve(ctx, &ai.RetrieverRequest{Document: d, Options: &RetrieverOptions{K: 1}})
+	if err != nil {
+		t.Fatalf("Retrieve operation failed: %v", err)
+	}
+	if len(resp.Documents) != 1 {
+		t.Fatalf("got %d results, expected 1", len(resp.Documents))
+	}
+	var got item
+	if err := resp.Documents[0].DecodeMetadata("item", &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("metadata mismatch (-want, +got):\n%s", diff)
+	}
+}
+
 func TestSimilarity(t *testing.T) {
 	x := []float32{5, 23, 2, 5, 9}
 	y := []float32{3, 21, 2, 5, 14}
@@ -205,3 +343,30 @@ func TestInit(t *testing.T) {
 		t.Errorf("got %q, want %q", g, want)
 	}
 }
+
+func TestEmbedTaskTypes(t *testing.T) {
+	ctx := context.Background()
+
+	d := ai.DocumentFromText("hello", nil)
+	embedder := fakeembedder.New()
+	embedder.Register(d, []float32{1, 0})
+	var got []ai.EmbedTaskType
+	embedAction := ai.DefineEmbedder("fake", "taskTypes", func(ctx context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+		got = append(got, req.TaskType)
+		return embedder.Embed(ctx, req)
+	})
+	ds, err := newDocStore(t.TempDir(), "testTaskTypes", embedAction, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.index(ctx, &ai.IndexerRequest{Documents: []*ai.Document{d}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.retrieve(ctx, &ai.RetrieverRequest{Document: d}); err != nil {
+		t.Fatal(err)
+	}
+	want := []ai.EmbedTaskType{ai.EmbedTaskTypeRetrievalDocument, ai.EmbedTaskTypeRetrievalQuery}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
@@ -18,7 +18,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strings"
 
@@ -27,6 +29,14 @@ import (
 
 type EmbedOptions struct {
 	Model string `json:"model"`
+	// If true, scale each embedding to unit length (L2 norm 1).
+	// Ollama returns raw embeddings; normalized ones can be compared
+	// with a plain dot product.
+	Normalize bool `json:"normalize,omitempty"`
+	// TaskPrefixes maps task types to text prepended to each input with
+	// that [ai.EmbedRequest.TaskType]. Some models, like nomic-embed-text,
+	// expect inputs to start with a prefix such as "search_query: ".
+	TaskPrefixes map[ai.EmbedTaskType]string `json:"taskPrefixes,omitempty"`
 }
 
 type ollamaEmbedRequest struct {
@@ -39,6 +49,21 @@ type ollamaEmbedResponse struct {
 	Embeddings [][]float32 `json:"embeddings"`
 }
 
+// ollamaLegacyEmbedRequest and ollamaLegacyEmbedResponse are used with
+// the /api/embeddings endpoint of Ollama servers that predate /api/embed.
+// That endpoint embeds a single prompt per request.
+type ollamaLegacyEmbedRequest struct {
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+}
+
+type ollamaLegacyEmbedResponse struct {
+	Embedding []float32 `json:"embedding"`
+}
+
+// errNoBatchEmbed is returned when the server does not support /api/embed.
+var errNoBatchEmbed = errors.New("ollama server does not support /api/embed")
+
 func embed(ctx context.Context, serverAddress string, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
 	options, ok := req.Options.(*EmbedOptions)
 	if !ok && req.Options != nil {
@@ -52,38 +77,110 @@ func embed(ctx context.Context, serverAddress string, req *ai.EmbedRequest) (*ai
 		return nil, fmt.Errorf("invalid server address: address cannot be empty")
 	}
 
-	ollamaReq := newOllamaEmbedRequest(options.Model, req.Documents)
-
-	jsonData, err := json.Marshal(ollamaReq)
+	documents := req.Documents
+	if prefix := options.TaskPrefixes[req.TaskType]; prefix != "" {
+		documents = prefixDocuments(prefix, documents)
+	}
+	embeddings, err := embedBatch(ctx, serverAddress, newOllamaEmbedRequest(options.Model, documents))
+	if errors.Is(err, errNoBatchEmbed) {
+		embeddings, err = embedEach(ctx, serverAddress, options.Model, documents)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal embed request: %w", err)
+		return nil, err
+	}
+	if options.Normalize {
+		for _, e := range embeddings {
+			normalize(e)
+		}
 	}
+	return newEmbedResponse(embeddings), nil
+}
 
-	resp, err := sendEmbedRequest(ctx, serverAddress, jsonData)
-	if err != nil {
+// embedBatch embeds all the inputs of req with one call to /api/embed.
+// It returns errNoBatchEmbed if the server does not have that endpoint.
+func embedBatch(ctx context.Context, serverAddress string, req ollamaEmbedRequest) ([][]float32, error) {
+	var resp ollamaEmbedResponse
+	if err := postEmbedRequest(ctx, serverAddress+"/api/embed", req, &resp); err != nil {
+		// Ollama servers before 0.3.0 only have /api/embeddings.
+		// A 404 may also mean that the model is missing; in that case
+		// the fallback requests fail with the same status.
+		var serr *embedStatusError
+		if errors.As(err, &serr) && serr.code == http.StatusNotFound {
+			return nil, errNoBatchEmbed
+		}
 		return nil, err
 	}
-	defer resp.Body.Close()
+	return resp.Embeddings, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("ollama embed request failed with status code %d", resp.StatusCode)
+// embedEach embeds the documents one at a time with /api/embeddings,
+// for servers that do not support /api/embed.
+func embedEach(ctx context.Context, serverAddress, model string, documents []*ai.Document) ([][]float32, error) {
+	embeddings := make([][]float32, len(documents))
+	for i, doc := range documents {
+		var resp ollamaLegacyEmbedResponse
+		req := ollamaLegacyEmbedRequest{Model: model, Prompt: concatenateText(doc)}
+		if err := postEmbedRequest(ctx, serverAddress+"/api/embeddings", req, &resp); err != nil {
+			return nil, err
+		}
+		embeddings[i] = resp.Embedding
 	}
+	return embeddings, nil
+}
 
-	var ollamaResp ollamaEmbedResponse
-	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
-		return nil, fmt.Errorf("failed to decode embed response: %w", err)
+// postEmbedRequest posts req as JSON to url and decodes the response into resp.
+func postEmbedRequest(ctx context.Context, url string, req, resp any) error {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal embed request: %w", err)
 	}
+	httpResp, err := sendEmbedRequest(ctx, url, jsonData)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return &embedStatusError{code: httpResp.StatusCode}
+	}
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		return fmt.Errorf("failed to decode embed response: %w", err)
+	}
+	return nil
+}
 
-	return newEmbedResponse(ollamaResp.Embeddings), nil
+// embedStatusError reports an unsuccessful HTTP status from an embed request.
+type embedStatusError struct {
+	code int
 }
 
-func sendEmbedRequest(ctx context.Context, serverAddress string, jsonData []byte) (*http.Response, error) {
+func (e *embedStatusError) Error() string {
+	return fmt.Sprintf("ollama embed request failed with status code %d", e.code)
+}
+
+// normalize scales v in place to unit L2 norm. A zero vector is unchanged.
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := math.Sqrt(sum)
+	for i, x := range v {
+		v[i] = float32(float64(x) / norm)
+	}
+}
+
+func sendEmbedRequest(ctx context.Context, url string, jsonData []byte) (*http.Response, error) {
 	client := &http.Client{}
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", serverAddress+"/api/embed", bytes.NewBuffer(jsonData))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
+	setHeaders(httpReq, configuredHeaders())
 	return client.Do(httpReq)
 }
 
@@ -115,6 +212,15 @@ func newEmbedResponse(embeddings [][]float32) *ai.EmbedResponse {
 	return resp
 }
 
+// prefixDocuments returns text-only copies of documents that begin with prefix.
+func prefixDocuments(prefix string, documents []*ai.Document) []*ai.Document {
+	docs := make([]*ai.Document, len(documents))
+	for i, doc := range documents {
+		docs[i] = ai.DocumentFromText(prefix+concatenateText(doc), doc.Metadata)
+	}
+	return docs
+}
+
 func concatenateText(doc *ai.Document) string {
 	var builder strings.Builder
 	for _, part := range doc.Content {
@@ -142,6 +248,42 @@ func DefineEmbedder(serverAddress string, model string) ai.Embedder {
 	})
 }
 
+// EmbeddingDimension returns the length of the embeddings produced by
+// the named model, as reported by the Ollama server at serverAddress.
+func EmbeddingDimension(ctx context.Context, serverAddress, model string) (int, error) {
+	jsonData, err := json.Marshal(map[string]string{"model": model})
+	if err != nil {
+		return 0, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", serverAddress+"/api/show", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	setHeaders(httpReq, configuredHeaders())
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("ollama show request failed with status code %d", resp.StatusCode)
+	}
+	var show struct {
+		ModelInfo map[string]any `json:"model_info"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return 0, fmt.Errorf("failed to decode show response: %w", err)
+	}
+	// The dimension is stored under "<architecture>.embedding_length".
+	arch, _ := show.ModelInfo["general.architecture"].(string)
+	dim, ok := show.ModelInfo[arch+".embedding_length"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("ollama did not report the embedding length of model %q", model)
+	}
+	return int(dim), nil
+}
+
 // IsDefinedEmbedder reports whether the embedder with the given server address is defined by this plugin.
 func IsDefinedEmbedder(serverAddress string) bool {
 	isDefined := ai.IsDefinedEmbedder(provider, serverAddress)
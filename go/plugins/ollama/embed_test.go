@@ -17,6 +17,7 @@ package ollama
 import (
 	"context"
 	"encoding/json"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -64,3 +65,125 @@ func TestEmbedInvalidServerAddress(t *testing.T) {
 		t.Fatalf("expected invalid server address error, got %v", err)
 	}
 }
+
+func TestEmbedNormalize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ollamaEmbedResponse{
+			Embeddings: [][]float32{{3, 4}, {1, 2, 2}, {0, 0}},
+		})
+	}))
+	defer server.Close()
+
+	req := &ai.EmbedRequest{
+		Documents: []*ai.Document{
+			ai.DocumentFromText("a", nil),
+			ai.DocumentFromText("b", nil),
+			ai.DocumentFromText("c", nil),
+		},
+		Options: &EmbedOptions{Model: "all-minilm", Normalize: true},
+	}
+	resp, err := embed(context.Background(), server.URL, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range resp.Embeddings[:2] {
+		var sum float64
+		for _, x := range e.Embedding {
+			sum += float64(x) * float64(x)
+		}
+		if math.Abs(sum-1) > 1e-6 {
+			t.Errorf("embedding %d: squared norm is %g, want 1", i, sum)
+		}
+	}
+	if got := resp.Embeddings[0].Embedding; got[0] != 0.6 || got[1] != 0.8 {
+		t.Errorf("got %v, want [0.6 0.8]", got)
+	}
+	if got := resp.Embeddings[2].Embedding; got[0] != 0 || got[1] != 0 {
+		t.Errorf("zero vector changed to %v", got)
+	}
+}
+
+func TestEmbedLegacyEndpoint(t *testing.T) {
+	var prompts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embeddings" {
+			http.NotFound(w, r)
+			return
+		}
+		var req ollamaLegacyEmbedRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		prompts = append(prompts, req.Prompt)
+		json.NewEncoder(w).Encode(ollamaLegacyEmbedResponse{
+			Embedding: []float32{float32(len(prompts)), 0},
+		})
+	}))
+	defer server.Close()
+
+	req := &ai.EmbedRequest{
+		Documents: []*ai.Document{
+			ai.DocumentFromText("a", nil),
+			ai.DocumentFromText("b", nil),
+		},
+		Options: &EmbedOptions{Model: "all-minilm"},
+	}
+	resp, err := embed(context.Background(), server.URL, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prompts) != 2 || prompts[0] != "a" || prompts[1] != "b" {
+		t.Errorf("got prompts %q, want [a b]", prompts)
+	}
+	if len(resp.Embeddings) != 2 || resp.Embeddings[1].Embedding[0] != 2 {
+		t.Errorf("got embeddings %v", resp.Embeddings)
+	}
+}
+
+func TestEmbeddingDimension(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/show" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"model_info": {"general.architecture": "bert", "bert.embedding_length": 384}}`))
+	}))
+	defer server.Close()
+
+	got, err := EmbeddingDimension(context.Background(), server.URL, "all-minilm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 384 {
+		t.Errorf("got dimension %d, want 384", got)
+	}
+}
+
+func TestEmbedTaskPrefix(t *testing.T) {
+	var got ollamaEmbedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(ollamaEmbedResponse{Embeddings: [][]float32{{1, 0}}})
+	}))
+	defer server.Close()
+
+	req := &ai.EmbedRequest{
+		Documents: []*ai.Document{ai.DocumentFromText("what is genkit?", nil)},
+		Options: &EmbedOptions{
+			Model: "nomic-embed-text",
+			TaskPrefixes: map[ai.EmbedTaskType]string{
+				ai.EmbedTaskTypeRetrievalQuery:    "search_query: ",
+				ai.EmbedTaskTypeRetrievalDocument: "search_document: ",
+			},
+		},
+		TaskType: ai.EmbedTaskTypeRetrievalQuery,
+	}
+	if _, err := embed(context.Background(), server.URL, req); err != nil {
+		t.Fatal(err)
+	}
+	if want := "search_query: what is genkit?"; got.Input != want {
+		t.Errorf("got input %q, want %q", got.Input, want)
+	}
+}
@@ -23,30 +23,81 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
-	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/tracing"
 	"github.com/firebase/genkit/go/plugins/internal/uri"
 )
 
 const provider = "ollama"
 
-var mediaSupportedModels = []string{"llava"}
+// knownModels holds the capabilities of known Ollama models, by name without tag.
+// It is guarded by state.mu.
+var knownModels = map[string]ai.ModelCapabilities{}
+
+func init() {
+	chat := ai.ModelCapabilities{Multiturn: true, SystemRole: true}
+	media := chat
+	media.Media = true
+	tools := chat
+	tools.Tools = true
+	for _, name := range []string{"llava", "llava-llama3", "llava-phi3", "bakllava", "llama3.2-vision", "moondream", "minicpm-v"} {
+		knownModels[name] = media
+	}
+	for _, name := range []string{"llama3.1", "llama3.2", "llama3.3", "mistral", "mistral-nemo", "mixtral", "qwen2", "qwen2.5", "qwen2.5-coder", "command-r", "command-r-plus", "firefunction-v2", "hermes3", "nemotron-mini"} {
+		knownModels[name] = tools
+	}
+}
+
+// SetModelCapabilities sets the capabilities that [DefineModel] uses for
+// the named model when it is passed nil capabilities, adding to or
+// replacing the built-in table of known models.
+// The name should not have a tag: capabilities set for "llama3.1"
+// apply to "llama3.1:8b" and "llama3.1:70b".
+func SetModelCapabilities(name string, caps ai.ModelCapabilities) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	knownModels[name] = caps
+}
+
+// defaultCapabilities returns the capabilities of the named model from
+// the table of known models, or those of a plain chat model if it is unknown.
+// The caller must hold state.mu.
+func defaultCapabilities(name string) ai.ModelCapabilities {
+	if caps, ok := knownModels[name]; ok {
+		return caps
+	}
+	base, _, _ := strings.Cut(name, ":")
+	if caps, ok := knownModels[base]; ok {
+		return caps
+	}
+	return ai.ModelCapabilities{Multiturn: true, SystemRole: true}
+}
+
 var roleMapping = map[ai.Role]string{
 	ai.RoleUser:   "user",
 	ai.RoleModel:  "assistant",
 	ai.RoleSystem: "system",
+	ai.RoleTool:   "tool",
 }
 var state struct {
 	mu            sync.Mutex
 	initted       bool
 	serverAddress string
+	headers       map[string]string // added to every request
 }
 
+// DefineModel defines an Ollama model. If caps is nil, the model's
+// capabilities are taken from a table of known models, which can be
+// extended with [SetModelCapabilities].
+// The model is served by the server at model.ServerAddress, or
+// if that is empty, by the server whose address was passed to [Init].
 func DefineModel(model ModelDefinition, caps *ai.ModelCapabilities) ai.Model {
 	state.mu.Lock()
 	defer state.mu.Unlock()
@@ -57,17 +108,17 @@ func DefineModel(model ModelDefinition, caps *ai.ModelCapabilities) ai.Model {
 	if caps != nil {
 		mc = *caps
 	} else {
-		mc = ai.ModelCapabilities{
-			Multiturn:  true,
-			SystemRole: true,
-			Media:      slices.Contains(mediaSupportedModels, model.Name),
-		}
+		mc = defaultCapabilities(model.Name)
 	}
 	meta := &ai.ModelMetadata{
 		Label:    "Ollama - " + model.Name,
 		Supports: mc,
 	}
-	g := &generator{model: model, serverAddress: state.serverAddress}
+	addr := model.ServerAddress
+	if addr == "" {
+		addr = state.serverAddress
+	}
+	g := &generator{model: model, serverAddress: addr, headers: state.headers}
 	return ai.DefineModel(provider, model.Name, meta, g.generate)
 
 }
@@ -87,17 +138,22 @@ func Model(name string) ai.Model {
 type ModelDefinition struct {
 	Name string
 	Type string
+	// The address of the Ollama server that serves the model.
+	// If empty, the address in the [Config] passed to [Init] is used.
+	ServerAddress string
 }
 
 type generator struct {
 	model         ModelDefinition
 	serverAddress string
+	headers       map[string]string
 }
 
 type ollamaMessage struct {
-	Role    string   `json:"role"`
-	Content string   `json:"content"`
-	Images  []string `json:"images,omitempty"`
+	Role     string   `json:"role"`
+	Content  string   `json:"content"`
+	Images   []string `json:"images,omitempty"`
+	ToolName string   `json:"tool_name,omitempty"` // for messages with role "tool"
 }
 
 // Ollama has two API endpoints, one with a chat interface and another with a generate response interface.
@@ -108,7 +164,6 @@ TODO: Support optional, advanced parameters:
 format: the format to return a response in. Currently the only accepted value is json
 options: additional model parameters listed in the documentation for the Modelfile such as temperature
 system: system message to (overrides what is defined in the Modelfile)
-template: the prompt template to use (overrides what is defined in the Modelfile)
 context: the context parameter returned from a previous request to /generate, this can be used to keep a short conversational memory
 stream: if false the response will be returned as a single response object, rather than a stream of objects
 raw: if true no formatting will be applied to the prompt. You may choose to use the raw parameter if you are specifying a full templated prompt in your request to the API
@@ -121,11 +176,33 @@ type ollamaChatRequest struct {
 }
 
 type ollamaModelRequest struct {
-	System string   `json:"system,omitempty"`
-	Images []string `json:"images,omitempty"`
-	Model  string   `json:"model"`
-	Prompt string   `json:"prompt"`
-	Stream bool     `json:"stream"`
+	System   string   `json:"system,omitempty"`
+	Images   []string `json:"images,omitempty"`
+	Model    string   `json:"model"`
+	Prompt   string   `json:"prompt"`
+	Stream   bool     `json:"stream"`
+	Template string   `json:"template,omitempty"`
+}
+
+// GenerateConfig is the configuration for Ollama models.
+// Pass it to [ai.WithConfig] in place of [ai.GenerationCommonConfig].
+type GenerateConfig struct {
+	ai.GenerationCommonConfig
+	// Template is the prompt template to use, overriding the one
+	// defined in the Modelfile. It is only supported by models
+	// of type "generate".
+	Template string `json:"template,omitempty"`
+}
+
+// generateConfig returns the [GenerateConfig] in input, if any.
+func generateConfig(input *ai.ModelRequest) *GenerateConfig {
+	switch c := input.Config.(type) {
+	case *GenerateConfig:
+		return c
+	case GenerateConfig:
+		return &c
+	}
+	return nil
 }
 
 // TODO: Add optional parameters (images, format, options, etc.) based on your use case
@@ -136,18 +213,66 @@ type ollamaChatResponse struct {
 		Role    string `json:"role"`
 		Content string `json:"content"`
 	} `json:"message"`
+	ollamaMetrics
 }
 
 type ollamaModelResponse struct {
 	Model     string `json:"model"`
 	CreatedAt string `json:"created_at"`
 	Response  string `json:"response"`
+	ollamaMetrics
+}
+
+// ollamaMetrics holds the token counts and timings that Ollama reports
+// on the final object of a response. Durations are in nanoseconds.
+type ollamaMetrics struct {
+	Done               bool  `json:"done,omitempty"`
+	TotalDuration      int64 `json:"total_duration,omitempty"`
+	LoadDuration       int64 `json:"load_duration,omitempty"`
+	PromptEvalCount    int   `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration,omitempty"`
+	EvalCount          int   `json:"eval_count,omitempty"`
+	EvalDuration       int64 `json:"eval_duration,omitempty"`
+}
+
+// add adds the counts and durations of o to m.
+func (m *ollamaMetrics) add(o ollamaMetrics) {
+	m.Done = m.Done || o.Done
+	m.TotalDuration += o.TotalDuration
+	m.LoadDuration += o.LoadDuration
+	m.PromptEvalCount += o.PromptEvalCount
+	m.PromptEvalDuration += o.PromptEvalDuration
+	m.EvalCount += o.EvalCount
+	m.EvalDuration += o.EvalDuration
+}
+
+// usage converts m to a [ai.GenerationUsage].
+// Durations are reported in milliseconds in the Custom map.
+func (m *ollamaMetrics) usage() *ai.GenerationUsage {
+	return &ai.GenerationUsage{
+		InputTokens:  m.PromptEvalCount,
+		OutputTokens: m.EvalCount,
+		TotalTokens:  m.PromptEvalCount + m.EvalCount,
+		Custom: map[string]float64{
+			"totalDurationMs":      nanosToMillis(m.TotalDuration),
+			"loadDurationMs":       nanosToMillis(m.LoadDuration),
+			"promptEvalDurationMs": nanosToMillis(m.PromptEvalDuration),
+			"evalDurationMs":       nanosToMillis(m.EvalDuration),
+		},
+	}
+}
+
+func nanosToMillis(ns int64) float64 {
+	return float64(ns) / float64(time.Millisecond)
 }
 
 // Config provides configuration options for the Init function.
 type Config struct {
 	// Server Address of oLLama.
 	ServerAddress string
+	// Headers are added to every request to the server, for example
+	// to pass an API key to an authenticating proxy in front of it.
+	Headers map[string]string
 }
 
 // Init initializes the plugin.
@@ -163,29 +288,52 @@ func Init(ctx context.Context, cfg *Config) (err error) {
 		return errors.New("ollama: need ServerAddress")
 	}
 	state.serverAddress = cfg.ServerAddress
+	state.headers = maps.Clone(cfg.Headers)
 	state.initted = true
 	return nil
 }
 
+// setHeaders adds headers to req.
+func setHeaders(req *http.Request, headers map[string]string) {
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+}
+
+// configuredHeaders returns the headers passed to [Init].
+func configuredHeaders() map[string]string {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.headers
+}
+
 // Generate makes a request to the Ollama API and processes the response.
 func (g *generator) generate(ctx context.Context, input *ai.ModelRequest, cb func(context.Context, *ai.ModelResponseChunk) error) (*ai.ModelResponse, error) {
 
 	stream := cb != nil
 	var payload any
 	isChatModel := g.model.Type == "chat"
+	var template string
+	if c := generateConfig(input); c != nil {
+		template = c.Template
+	}
 	if !isChatModel {
 		images, err := concatImages(input, []ai.Role{ai.RoleUser, ai.RoleModel})
 		if err != nil {
 			return nil, fmt.Errorf("failed to grab image parts: %v", err)
 		}
 		payload = ollamaModelRequest{
-			Model:  g.model.Name,
-			Prompt: concatMessages(input, []ai.Role{ai.RoleUser, ai.RoleModel, ai.RoleTool}),
-			System: concatMessages(input, []ai.Role{ai.RoleSystem}),
-			Images: images,
-			Stream: stream,
+			Model:    g.model.Name,
+			Prompt:   concatMessages(input, []ai.Role{ai.RoleUser, ai.RoleModel, ai.RoleTool}),
+			System:   concatMessages(input, []ai.Role{ai.RoleSystem}),
+			Images:   images,
+			Stream:   stream,
+			Template: template,
 		}
 	} else {
+		if template != "" {
+			return nil, fmt.Errorf("ollama: model %s does not support templates; only generate models do", g.model.Name)
+		}
 		var messages []*ollamaMessage
 		// Translate all messages to ollama message format.
 		for _, m := range input.Messages {
@@ -201,7 +349,12 @@ func (g *generator) generate(ctx context.Context, input *ai.ModelRequest, cb fun
 			Stream:   stream,
 		}
 	}
+	// Requests time out after 30 seconds, unless ctx has a deadline,
+	// such as one set by ai.WithTimeout, which applies instead.
 	client := &http.Client{Timeout: 30 * time.Second}
+	if _, ok := ctx.Deadline(); ok {
+		client.Timeout = 0
+	}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
@@ -216,10 +369,12 @@ func (g *generator) generate(ctx context.Context, input *ai.ModelRequest, cb fun
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setHeaders(req, g.headers)
 	req = req.WithContext(ctx)
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %v", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 	if cb == nil {
@@ -227,7 +382,7 @@ func (g *generator) generate(ctx context.Context, input *ai.ModelRequest, cb fun
 		var err error
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read response body: %v", err)
+			return nil, fmt.Errorf("failed to read response body: %w", err)
 		}
 		if resp.StatusCode != http.StatusOK {
 			return nil, fmt.Errorf("server returned non-200 status: %d, body: %s", resp.StatusCode, body)
@@ -244,48 +399,97 @@ func (g *generator) generate(ctx context.Context, input *ai.ModelRequest, cb fun
 		}
 		return response, nil
 	} else {
-		var chunks []*ai.ModelResponseChunk
+		// The final message is accumulated as chunks arrive, rather than by
+		// keeping every chunk, so memory use grows with the length of the
+		// generated text and not with the number of chunks.
+		var acc messageAccumulator
+		var metrics ollamaMetrics
+		var timeToFirstChunk time.Duration
+		var index int
 		scanner := bufio.NewScanner(resp.Body)
-		for scanner.Scan() {
+		for first := true; scanner.Scan(); first = false {
+			if first {
+				timeToFirstChunk = time.Since(start)
+				tracing.SetCustomMetadataAttr(ctx, "ollama:timeToFirstChunkMs",
+					strconv.FormatFloat(nanosToMillis(int64(timeToFirstChunk)), 'f', -1, 64))
+			}
 			line := scanner.Text()
 			var chunk *ai.ModelResponseChunk
+			var m ollamaMetrics
 			if isChatModel {
-				chunk, err = translateChatChunk(line)
+				chunk, m, err = translateChatChunkMetrics(line)
 			} else {
-				chunk, err = translateGenerateChunk(line)
+				chunk, m, err = translateGenerateChunkMetrics(line)
 			}
 			if err != nil {
 				return nil, fmt.Errorf("failed to translate chunk: %v", err)
 			}
-			chunks = append(chunks, chunk)
+			metrics.add(m)
+			acc.add(chunk.Content)
+			chunk.Index = index
+			index++
 			cb(ctx, chunk)
 		}
 		if err := scanner.Err(); err != nil {
-			return nil, fmt.Errorf("reading response stream: %v", err)
+			return nil, fmt.Errorf("reading response stream: %w", err)
 		}
 		// Create a final response with the merged chunks
 		finalResponse := &ai.ModelResponse{
 			Request:      input,
 			FinishReason: ai.FinishReason("stop"),
 			Message: &ai.Message{
-				Role: ai.RoleModel,
+				Role:    ai.RoleModel,
+				Content: acc.parts(),
 			},
 		}
-		// Add all the merged content to the final response's candidate
-		for _, chunk := range chunks {
-			finalResponse.Message.Content = append(finalResponse.Message.Content, chunk.Content...)
-		}
+		finalResponse.Usage = metrics.usage()
+		finalResponse.Usage.Custom["timeToFirstChunkMs"] = nanosToMillis(int64(timeToFirstChunk))
 		return finalResponse, nil // Return the final merged response
 
 	}
 }
 
+// A messageAccumulator builds the content of a message from the
+// content of streamed chunks. Consecutive text parts are merged into one.
+type messageAccumulator struct {
+	content []*ai.Part
+	text    strings.Builder // text since the last non-text part
+}
+
+func (a *messageAccumulator) add(parts []*ai.Part) {
+	for _, p := range parts {
+		if p.IsText() {
+			a.text.WriteString(p.Text)
+			continue
+		}
+		a.flush()
+		a.content = append(a.content, p)
+	}
+}
+
+func (a *messageAccumulator) flush() {
+	if a.text.Len() > 0 {
+		a.content = append(a.content, ai.NewTextPart(a.text.String()))
+		a.text.Reset()
+	}
+}
+
+// parts returns the accumulated content.
+func (a *messageAccumulator) parts() []*ai.Part {
+	a.flush()
+	return a.content
+}
+
 func convertParts(role ai.Role, parts []*ai.Part) (*ollamaMessage, error) {
 	message := &ollamaMessage{
 		Role: roleMapping[role],
 	}
 	var contentBuilder strings.Builder
 	for _, part := range parts {
+		if part.IsCitation() {
+			// Citations annotate responses; they are not model input.
+			continue
+		}
 		if part.IsText() {
 			contentBuilder.WriteString(part.Text)
 		} else if part.IsMedia() {
@@ -295,6 +499,15 @@ func convertParts(role ai.Role, parts []*ai.Part) (*ollamaMessage, error) {
 			}
 			base64Encoded := base64.StdEncoding.EncodeToString(data)
 			message.Images = append(message.Images, base64Encoded)
+		} else if part.IsToolResponse() {
+			// Ollama takes a tool result as the content of
+			// a message with role "tool".
+			output, err := json.Marshal(part.ToolResponse.Output)
+			if err != nil {
+				return nil, fmt.Errorf("tool %s: %v", part.ToolResponse.Name, err)
+			}
+			contentBuilder.Write(output)
+			message.ToolName = part.ToolResponse.Name
 		} else {
 			return nil, errors.New("unknown content type")
 		}
@@ -319,6 +532,7 @@ func translateChatResponse(responseData []byte) (*ai.ModelResponse, error) {
 
 	aiPart := ai.NewTextPart(response.Message.Content)
 	modelResponse.Message.Content = append(modelResponse.Message.Content, aiPart)
+	modelResponse.Usage = response.ollamaMetrics.usage()
 
 	return modelResponse, nil
 }
@@ -340,32 +554,56 @@ func translateModelResponse(responseData []byte) (*ai.ModelResponse, error) {
 
 	aiPart := ai.NewTextPart(response.Response)
 	modelResponse.Message.Content = append(modelResponse.Message.Content, aiPart)
-	modelResponse.Usage = &ai.GenerationUsage{} // TODO: can we get any of this info?
+	modelResponse.Usage = response.ollamaMetrics.usage()
 	return modelResponse, nil
 }
 
 func translateChatChunk(input string) (*ai.ModelResponseChunk, error) {
+	chunk, _, err := translateChatChunkMetrics(input)
+	return chunk, err
+}
+
+// translateChatChunkMetrics is like translateChatChunk, but also returns
+// the metrics reported with the chunk.
+// If the chunk is the final one, its Custom field holds the
+// metrics as a *[ai.GenerationUsage].
+func translateChatChunkMetrics(input string) (*ai.ModelResponseChunk, ollamaMetrics, error) {
 	var response ollamaChatResponse
 
 	if err := json.Unmarshal([]byte(input), &response); err != nil {
-		return nil, fmt.Errorf("failed to parse response JSON: %v", err)
+		return nil, ollamaMetrics{}, fmt.Errorf("failed to parse response JSON: %v", err)
 	}
 	chunk := &ai.ModelResponseChunk{}
 	aiPart := ai.NewTextPart(response.Message.Content)
 	chunk.Content = append(chunk.Content, aiPart)
-	return chunk, nil
+	if response.Done {
+		chunk.Custom = response.ollamaMetrics.usage()
+	}
+	return chunk, response.ollamaMetrics, nil
 }
 
 func translateGenerateChunk(input string) (*ai.ModelResponseChunk, error) {
+	chunk, _, err := translateGenerateChunkMetrics(input)
+	return chunk, err
+}
+
+// translateGenerateChunkMetrics is like translateGenerateChunk, but also returns
+// the metrics reported with the chunk.
+// If the chunk is the final one, its Custom field holds the
+// metrics as a *[ai.GenerationUsage].
+func translateGenerateChunkMetrics(input string) (*ai.ModelResponseChunk, ollamaMetrics, error) {
 	var response ollamaModelResponse
 
 	if err := json.Unmarshal([]byte(input), &response); err != nil {
-		return nil, fmt.Errorf("failed to parse response JSON: %v", err)
+		return nil, ollamaMetrics{}, fmt.Errorf("failed to parse response JSON: %v", err)
 	}
 	chunk := &ai.ModelResponseChunk{}
 	aiPart := ai.NewTextPart(response.Response)
 	chunk.Content = append(chunk.Content, aiPart)
-	return chunk, nil
+	if response.Done {
+		chunk.Custom = response.ollamaMetrics.usage()
+	}
+	return chunk, response.ollamaMetrics, nil
 }
 
 // concatMessages translates a list of messages into a prompt-style format
@@ -15,9 +15,23 @@
 package ollama
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/tracing"
 )
 
 func TestConcatMessages(t *testing.T) {
@@ -114,6 +128,235 @@ func TestTranslateGenerateChunk(t *testing.T) {
 	}
 }
 
+func TestStreamingMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"model": "m", "response": "Hello, "}`)
+		fmt.Fprintln(w, `{"model": "m", "response": "world", "prompt_eval_count": 3, "prompt_eval_duration": 1000000}`)
+		fmt.Fprintln(w, `{"model": "m", "response": "", "done": true, "total_duration": 9000000, "prompt_eval_duration": 2000000, "eval_count": 5, "eval_duration": 4000000}`)
+	}))
+	defer server.Close()
+
+	tstate := tracing.NewState()
+	tc := tracing.NewTestOnlyTelemetryClient()
+	tstate.WriteTelemetryImmediate(tc)
+
+	g := &generator{model: ModelDefinition{Name: "m", Type: "generate"}, serverAddress: server.URL}
+	req := &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("hi")}}
+	var last *ai.ModelResponseChunk
+	resp, err := tracing.RunInNewSpan(context.Background(), tstate, "generate", "", true, req,
+		func(ctx context.Context, req *ai.ModelRequest) (*ai.ModelResponse, error) {
+			return g.generate(ctx, req, func(_ context.Context, c *ai.ModelResponseChunk) error {
+				last = c
+				return nil
+			})
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := resp.Text(), "Hello, world"; got != want {
+		t.Errorf("got text %q, want %q", got, want)
+	}
+	if _, ok := last.Custom.(*ai.GenerationUsage); !ok {
+		t.Errorf("final chunk Custom is %T, want *ai.GenerationUsage", last.Custom)
+	}
+	u := resp.Usage
+	if u.InputTokens != 3 || u.OutputTokens != 5 || u.TotalTokens != 8 {
+		t.Errorf("got tokens in=%d out=%d total=%d, want 3, 5, 8", u.InputTokens, u.OutputTokens, u.TotalTokens)
+	}
+	for k, want := range map[string]float64{
+		"totalDurationMs":      9,
+		"promptEvalDurationMs": 3,
+		"evalDurationMs":       4,
+	} {
+		if got := u.Custom[k]; got != want {
+			t.Errorf("%s: got %v, want %v", k, got, want)
+		}
+	}
+	if _, ok := u.Custom["timeToFirstChunkMs"]; !ok {
+		t.Error("timeToFirstChunkMs missing from usage")
+	}
+
+	var found bool
+	for _, td := range tc.Traces {
+		for _, sd := range td.Spans {
+			if _, ok := sd.Attributes["genkit:metadata:ollama:timeToFirstChunkMs"]; ok {
+				found = true
+			}
+		}
+	}
+	if !found {
+		t.Error("time to first chunk not recorded on span")
+	}
+}
+
+func TestStreamingLongResponse(t *testing.T) {
+	const n = 10000
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for range n {
+			fmt.Fprintln(w, `{"model": "m", "response": "ab"}`)
+		}
+		fmt.Fprintln(w, `{"model": "m", "response": "", "done": true}`)
+	}))
+	defer server.Close()
+
+	g := &generator{model: ModelDefinition{Name: "m", Type: "generate"}, serverAddress: server.URL}
+	req := &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("hi")}}
+	chunks := 0
+	resp, err := g.generate(context.Background(), req, func(context.Context, *ai.ModelResponseChunk) error {
+		chunks++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunks != n+1 {
+		t.Errorf("got %d chunks, want %d", chunks, n+1)
+	}
+	// The chunks' text is merged as it arrives, not kept part by part.
+	if got := len(resp.Message.Content); got != 1 {
+		t.Errorf("final message has %d parts, want 1", got)
+	}
+	if got, want := resp.Text(), strings.Repeat("ab", n); got != want {
+		t.Errorf("got text of length %d, want %d", len(got), len(want))
+	}
+}
+
+func TestMessageAccumulator(t *testing.T) {
+	var acc messageAccumulator
+	acc.add([]*ai.Part{ai.NewTextPart("a"), ai.NewTextPart("b")})
+	acc.add([]*ai.Part{ai.NewMediaPart("image/png", "data:x")})
+	acc.add([]*ai.Part{ai.NewTextPart("c")})
+	acc.add(nil)
+	acc.add([]*ai.Part{ai.NewTextPart("d")})
+	var got []string
+	for _, p := range acc.parts() {
+		got = append(got, p.Text)
+	}
+	want := []string{"ab", "data:x", "cd"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got parts %q, want %q", got, want)
+	}
+}
+
+func TestGenerateTemplate(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		fmt.Fprintln(w, `{"model": "m", "response": "ok", "done": true}`)
+	}))
+	defer server.Close()
+
+	g := &generator{model: ModelDefinition{Name: "m", Type: "generate"}, serverAddress: server.URL}
+	msgs := []*ai.Message{ai.NewUserTextMessage("hi")}
+	tests := []struct {
+		name   string
+		config any
+		want   any
+	}{
+		{"with template", &GenerateConfig{Template: "{{ .Prompt }}!"}, "{{ .Prompt }}!"},
+		{"without template", &GenerateConfig{}, nil},
+		{"common config", &ai.GenerationCommonConfig{}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := g.generate(context.Background(), &ai.ModelRequest{Messages: msgs, Config: test.config}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := body["template"]
+			if test.want == nil {
+				if ok {
+					t.Errorf("template sent as %q, want omitted", got)
+				}
+			} else if got != test.want {
+				t.Errorf("got template %v, want %q", got, test.want)
+			}
+		})
+	}
+
+	t.Run("chat model", func(t *testing.T) {
+		g := &generator{model: ModelDefinition{Name: "c", Type: "chat"}, serverAddress: server.URL}
+		_, err := g.generate(context.Background(), &ai.ModelRequest{Messages: msgs, Config: &GenerateConfig{Template: "x"}}, nil)
+		if err == nil || !strings.Contains(err.Error(), "does not support templates") {
+			t.Errorf("got error %v, want one about templates", err)
+		}
+	})
+}
+
+func TestGenerateCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"model": "m", "response": "Hello"}`)
+		w.(http.Flusher).Flush()
+		// Hang until the client goes away.
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	before := runtime.NumGoroutine()
+	g := &generator{model: ModelDefinition{Name: "m", Type: "generate"}, serverAddress: server.URL}
+	req := &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("hi")}}
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	_, err := g.generate(ctx, req, func(context.Context, *ai.ModelResponseChunk) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("generate took %v to return after cancellation", d)
+	}
+
+	// The request's goroutines should exit once the connection is closed.
+	server.CloseClientConnections()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines leaked", n-before)
+	}
+}
+
+func TestMediaPartReader(t *testing.T) {
+	data := []byte("\x89PNG fake image bytes")
+	r := &countingReader{r: bytes.NewReader(data)}
+	part := ai.NewMediaPartReader("image/png", r)
+	want := base64.StdEncoding.EncodeToString(data)
+	// Convert twice, as a tool loop would, to check that the
+	// contents are kept after the reader is consumed.
+	for i := 0; i < 2; i++ {
+		msg, err := convertParts(ai.RoleUser, []*ai.Part{ai.NewTextPart("what is this?"), part})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg.Images) != 1 || msg.Images[0] != want {
+			t.Errorf("conversion %d: got images %q, want [%q]", i, msg.Images, want)
+		}
+	}
+	if r.reads == 0 {
+		t.Error("reader was not read")
+	}
+	if b, err := json.Marshal(part); err != nil || bytes.Contains(b, []byte("PNG")) {
+		t.Errorf("marshaled part %s (err %v) contains the media contents", b, err)
+	}
+}
+
+type countingReader struct {
+	r     io.Reader
+	reads int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.reads++
+	return c.r.Read(p)
+}
+
 // Helper function to compare content
 func equalContent(a, b []*ai.Part) bool {
 	if len(a) != len(b) {
@@ -126,3 +369,122 @@ func equalContent(a, b []*ai.Part) bool {
 	}
 	return true
 }
+
+func TestDefaultCapabilities(t *testing.T) {
+	chat := ai.ModelCapabilities{Multiturn: true, SystemRole: true}
+	tests := []struct {
+		name string
+		want ai.ModelCapabilities
+	}{
+		{"llama3.1", ai.ModelCapabilities{Multiturn: true, SystemRole: true, Tools: true}},
+		{"qwen2.5:7b", ai.ModelCapabilities{Multiturn: true, SystemRole: true, Tools: true}},
+		{"llava:13b", ai.ModelCapabilities{Multiturn: true, SystemRole: true, Media: true}},
+		{"gemma2", chat},
+	}
+	for _, test := range tests {
+		if got := defaultCapabilities(test.name); got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	custom := ai.ModelCapabilities{Multiturn: true, Tools: true, Media: true}
+	SetModelCapabilities("my-model", custom)
+	defer func() {
+		state.mu.Lock()
+		delete(knownModels, "my-model")
+		state.mu.Unlock()
+	}()
+	if got := defaultCapabilities("my-model:latest"); got != custom {
+		t.Errorf("after SetModelCapabilities: got %+v, want %+v", got, custom)
+	}
+}
+
+func TestConvertToolResponse(t *testing.T) {
+	msg, err := convertParts(ai.RoleTool, []*ai.Part{ai.NewToolResponsePart(&ai.ToolResponse{
+		Name:   "weather",
+		Output: map[string]any{"response": "sunny"},
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"role":"tool","content":"{\"response\":\"sunny\"}","tool_name":"weather"}`
+	if string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestDefineModelServerAddress(t *testing.T) {
+	// newServer returns a fake Ollama server that answers with its name.
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"model": "m", "response": %q, "done": true}`+"\n", name)
+		}))
+	}
+	defaultServer := newServer("default")
+	defer defaultServer.Close()
+	gpuServer := newServer("gpu")
+	defer gpuServer.Close()
+
+	state.mu.Lock()
+	savedInitted, savedAddress := state.initted, state.serverAddress
+	state.initted = true
+	state.serverAddress = defaultServer.URL
+	state.mu.Unlock()
+	defer func() {
+		state.mu.Lock()
+		state.initted, state.serverAddress = savedInitted, savedAddress
+		state.mu.Unlock()
+	}()
+
+	for _, test := range []struct {
+		model ModelDefinition
+		want  string
+	}{
+		{ModelDefinition{Name: "small", Type: "generate"}, "default"},
+		{ModelDefinition{Name: "big", Type: "generate", ServerAddress: gpuServer.URL}, "gpu"},
+	} {
+		m := DefineModel(test.model, nil)
+		resp, err := ai.Generate(context.Background(), m, ai.WithTextPrompt("hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Text(); got != test.want {
+			t.Errorf("%s: answered by %q server, want %q", test.model.Name, got, test.want)
+		}
+	}
+}
+
+func TestConfigHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		fmt.Fprintln(w, `{"model": "m", "response": "ok", "done": true}`)
+	}))
+	defer srv.Close()
+
+	state.mu.Lock()
+	savedInitted, savedAddress, savedHeaders := state.initted, state.serverAddress, state.headers
+	state.initted = true
+	state.serverAddress = srv.URL
+	state.headers = map[string]string{"X-Api-Key": "secret", "X-Tenant": "acme"}
+	state.mu.Unlock()
+	defer func() {
+		state.mu.Lock()
+		state.initted, state.serverAddress, state.headers = savedInitted, savedAddress, savedHeaders
+		state.mu.Unlock()
+	}()
+
+	m := DefineModel(ModelDefinition{Name: "headers", Type: "generate"}, nil)
+	if _, err := ai.Generate(context.Background(), m, ai.WithTextPrompt("hi")); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{"X-Api-Key": "secret", "X-Tenant": "acme", "Content-Type": "application/json"} {
+		if g := got.Get(k); g != want {
+			t.Errorf("header %s: got %q, want %q", k, g, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// PullProgress reports the progress of pulling a model.
+type PullProgress struct {
+	// Status describes the current step, such as "pulling manifest"
+	// or "success".
+	Status string `json:"status"`
+	// Digest is the layer being downloaded, if any.
+	Digest string `json:"digest,omitempty"`
+	// Total and Completed are the size of the layer being
+	// downloaded and the number of bytes downloaded so far.
+	Total     int64 `json:"total,omitempty"`
+	Completed int64 `json:"completed,omitempty"`
+}
+
+// EnsureModel makes sure that the named model is present on the Ollama
+// server passed to [Init], pulling it if it is not. Pulling can take a
+// long time; if progress is non-nil, it is called with each progress
+// update from the server.
+func EnsureModel(ctx context.Context, name string, progress func(PullProgress)) error {
+	state.mu.Lock()
+	serverAddress := state.serverAddress
+	state.mu.Unlock()
+	if serverAddress == "" {
+		return errors.New("ollama.EnsureModel called before Init")
+	}
+	return ensureModel(ctx, serverAddress, name, progress)
+}
+
+func ensureModel(ctx context.Context, serverAddress, name string, progress func(PullProgress)) error {
+	present, err := hasModel(ctx, serverAddress, name)
+	if err != nil {
+		return err
+	}
+	if present {
+		return nil
+	}
+	return pullModel(ctx, serverAddress, name, progress)
+}
+
+// hasModel reports whether the server has the named model.
+func hasModel(ctx context.Context, serverAddress, name string) (bool, error) {
+	resp, err := postJSON(ctx, serverAddress+"/api/show", map[string]any{"model": name})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("ollama show request failed with status code %d", resp.StatusCode)
+	}
+}
+
+// pullModel pulls the named model, reporting progress from the
+// newline-separated JSON objects that the server streams.
+func pullModel(ctx context.Context, serverAddress, name string, progress func(PullProgress)) error {
+	resp, err := postJSON(ctx, serverAddress+"/api/pull", map[string]any{"model": name, "stream": true})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ollama pull of %q failed with status code %d: %s", name, resp.StatusCode, bytes.TrimSpace(body))
+	}
+	var last string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var p struct {
+			PullProgress
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(line, &p); err != nil {
+			return fmt.Errorf("ollama pull of %q: decoding progress: %w", name, err)
+		}
+		if p.Error != "" {
+			return fmt.Errorf("ollama pull of %q: %s", name, p.Error)
+		}
+		last = p.Status
+		if progress != nil {
+			progress(p.PullProgress)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("ollama pull of %q: %w", name, err)
+	}
+	if last != "success" {
+		return fmt.Errorf("ollama pull of %q ended with status %q", name, last)
+	}
+	return nil
+}
+
+// postJSON posts body, encoded as JSON, to url.
+func postJSON(ctx context.Context, url string, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setHeaders(req, configuredHeaders())
+	return http.DefaultClient.Do(req)
+}
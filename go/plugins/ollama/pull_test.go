@@ -0,0 +1,96 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEnsureModel(t *testing.T) {
+	var pulls []map[string]any
+	present := map[string]bool{"llama3": true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		name, _ := body["model"].(string)
+		switch r.URL.Path {
+		case "/api/show":
+			if !present[name] {
+				http.Error(w, fmt.Sprintf(`{"error":"model %q not found"}`, name), http.StatusNotFound)
+			}
+		case "/api/pull":
+			pulls = append(pulls, body)
+			if name == "missing" {
+				fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+				fmt.Fprintln(w, `{"error":"pull model manifest: file does not exist"}`)
+				return
+			}
+			fmt.Fprintln(w, `{"status":"pulling manifest"}`)
+			fmt.Fprintln(w, `{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":100,"completed":40}`)
+			fmt.Fprintln(w, `{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":100,"completed":100}`)
+			fmt.Fprintln(w, `{"status":"success"}`)
+			present[name] = true
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	var got []PullProgress
+	record := func(p PullProgress) { got = append(got, p) }
+	if err := ensureModel(ctx, server.URL, "gemma2", record); err != nil {
+		t.Fatal(err)
+	}
+	want := []PullProgress{
+		{Status: "pulling manifest"},
+		{Status: "pulling 6a0746a1ec1a", Digest: "sha256:6a0746a1ec1a", Total: 100, Completed: 40},
+		{Status: "pulling 6a0746a1ec1a", Digest: "sha256:6a0746a1ec1a", Total: 100, Completed: 100},
+		{Status: "success"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("progress mismatch (-want, +got):\n%s", diff)
+	}
+	wantPulls := []map[string]any{{"model": "gemma2", "stream": true}}
+	if diff := cmp.Diff(wantPulls, pulls); diff != "" {
+		t.Errorf("pull requests mismatch (-want, +got):\n%s", diff)
+	}
+
+	// Models that are present are not pulled.
+	pulls = nil
+	for _, name := range []string{"llama3", "gemma2"} {
+		if err := ensureModel(ctx, server.URL, name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pulls) != 0 {
+		t.Errorf("got %d pulls of present models, want 0", len(pulls))
+	}
+
+	err := ensureModel(ctx, server.URL, "missing", nil)
+	if err == nil || !strings.Contains(err.Error(), "file does not exist") {
+		t.Errorf("got error %v, want the pull error", err)
+	}
+}
@@ -315,6 +315,7 @@ func (ds *docStore) Retrieve(ctx context.Context, req *ai.RetrieverRequest) (*ai
 		// TODO: This is what the TypeScript code does,
 		// but it loses information for multimedia documents.
 		d := ai.DocumentFromText(text, result.Metadata)
+		d.ID = result.ID
 		docs = append(docs, d)
 	}
 
@@ -324,9 +325,12 @@ func (ds *docStore) Retrieve(ctx context.Context, req *ai.RetrieverRequest) (*ai
 	return ret, nil
 }
 
-// docID returns the ID to use for a Document.
-// This is intended to be the same as the genkit Typescript computation.
+// docID returns the ID to use for a Document: its ID if it has one.
+// Otherwise, it is intended to be the same as the genkit Typescript computation.
 func docID(doc *ai.Document) (string, error) {
+	if doc.ID != "" {
+		return doc.ID, nil
+	}
 	b, err := json.Marshal(doc)
 	if err != nil {
 		return "", fmt.Errorf("pinecone: error marshaling document: %v", err)
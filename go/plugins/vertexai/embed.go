@@ -69,6 +69,9 @@ func newPredictRequest(endpoint string, req *ai.EmbedRequest) (*aiplatformpb.Pre
 		title = options.Title
 		taskType = options.TaskType
 	}
+	if taskType == "" {
+		taskType = string(req.TaskType)
+	}
 	instances := make([]*structpb.Value, 0, len(req.Documents))
 	for _, doc := range req.Documents {
 		fields := map[string]any{
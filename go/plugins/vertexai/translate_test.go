@@ -0,0 +1,157 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vertexai
+
+import (
+	"testing"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/firebase/genkit/go/ai"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConvertTools(t *testing.T) {
+	tools := []*ai.ToolDefinition{{
+		Name:        "gablorken",
+		Description: "use when need to calculate a gablorken",
+		InputSchema: map[string]any{
+			"type":     "object",
+			"required": []any{"Value", "Over"},
+			"properties": map[string]any{
+				"Value": map[string]any{"type": "number"},
+				"Over":  map[string]any{"type": "integer"},
+				"Exact": map[string]any{"type": "boolean"},
+			},
+		},
+	}}
+	got, err := convertTools(tools)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*genai.Tool{{
+		FunctionDeclarations: []*genai.FunctionDeclaration{{
+			Name:        "gablorken",
+			Description: "use when need to calculate a gablorken",
+			Parameters: &genai.Schema{
+				Type:     genai.TypeObject,
+				Required: []string{"Value", "Over"},
+				Properties: map[string]*genai.Schema{
+					"Value": {Type: genai.TypeNumber},
+					"Over":  {Type: genai.TypeInteger},
+					"Exact": {Type: genai.TypeBoolean},
+				},
+			},
+		}},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+
+	if _, err := convertTools([]*ai.ToolDefinition{{
+		Name:        "bad",
+		InputSchema: map[string]any{"type": "nonsense"},
+	}}); err == nil {
+		t.Error("got nil, want error for invalid schema type")
+	}
+}
+
+func TestTranslateFunctionCall(t *testing.T) {
+	// A response as the Gemini API returns it when the model calls a tool.
+	resp := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{
+			FinishReason: genai.FinishReasonStop,
+			Content: &genai.Content{
+				Role: "model",
+				Parts: []genai.Part{genai.FunctionCall{
+					Name: "gablorken",
+					Args: map[string]any{"Value": 2.0, "Over": 3.0},
+				}},
+			},
+		}},
+	}
+	got := translateResponse(resp)
+	if len(got.Message.Content) != 1 {
+		t.Fatalf("got %d parts, want 1", len(got.Message.Content))
+	}
+	p := got.Message.Content[0]
+	if !p.IsToolRequest() {
+		t.Fatalf("got part kind %v, want tool request", p.Kind)
+	}
+	want := &ai.ToolRequest{Name: "gablorken", Input: map[string]any{"Value": 2.0, "Over": 3.0}}
+	if diff := cmp.Diff(want, p.ToolRequest); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+}
+
+func TestConvertToolParts(t *testing.T) {
+	req := ai.NewToolRequestPart(&ai.ToolRequest{Name: "gablorken", Input: map[string]any{"Value": 2.0}})
+	resp := ai.NewToolResponsePart(&ai.ToolResponse{Name: "gablorken", Output: map[string]any{"response": 8.0}})
+	got, err := convertParts([]*ai.Part{req, resp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []genai.Part{
+		genai.FunctionCall{Name: "gablorken", Args: map[string]any{"Value": 2.0}},
+		genai.FunctionResponse{Name: "gablorken", Response: map[string]any{"response": 8.0}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want, +got):\n%s", diff)
+	}
+	if g, w := convertRole(ai.RoleTool), "user"; g != w {
+		t.Errorf("convertRole(RoleTool) = %q, want %q", g, w)
+	}
+}
+
+func TestNewModelResponseSchema(t *testing.T) {
+	schema := map[string]any{
+		"type":     "object",
+		"required": []any{"name"},
+		"properties": map[string]any{
+			"name":  map[string]any{"type": "string"},
+			"price": map[string]any{"type": "number"},
+		},
+	}
+	msgs := []*ai.Message{ai.NewUserTextMessage("Invent a dish.")}
+
+	gm, err := newModel(&genai.Client{}, "gemini", &ai.ModelRequest{
+		Messages: msgs,
+		Output:   &ai.ModelRequestOutput{Format: ai.OutputFormatJSON, Schema: schema},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := gm.ResponseMIMEType, "application/json"; got != want {
+		t.Errorf("got ResponseMIMEType %q, want %q", got, want)
+	}
+	want := &genai.Schema{
+		Type:     genai.TypeObject,
+		Required: []string{"name"},
+		Properties: map[string]*genai.Schema{
+			"name":  {Type: genai.TypeString},
+			"price": {Type: genai.TypeNumber},
+		},
+	}
+	if diff := cmp.Diff(want, gm.ResponseSchema); diff != "" {
+		t.Errorf("ResponseSchema mismatch (-want, +got):\n%s", diff)
+	}
+
+	gm, err = newModel(&genai.Client{}, "gemini", &ai.ModelRequest{Messages: msgs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gm.ResponseMIMEType != "" || gm.ResponseSchema != nil {
+		t.Errorf("got ResponseMIMEType %q, ResponseSchema %v for text output; want neither", gm.ResponseMIMEType, gm.ResponseSchema)
+	}
+}
@@ -42,7 +42,8 @@ var menuQuestionInputSchema = jsonschema.Reflect(menuQuestionInput{})
 
 // answerOutput is an answer to a question.
 type answerOutput struct {
-	Answer string `json:"answer"`
+	Answer  string   `json:"answer"`
+	Sources []string `json:"sources,omitempty"`
 }
 
 // dataMenuQuestionInput is a question about the menu,
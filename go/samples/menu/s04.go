@@ -65,7 +65,11 @@ func setup04(ctx context.Context, indexer ai.Indexer, retriever ai.Retriever, mo
 				metadata := map[string]any{
 					"menuItem": m,
 				}
-				docs = append(docs, ai.DocumentFromText(s, metadata))
+				doc := ai.DocumentFromText(s, metadata)
+				// Give the document a stable ID, which the retriever
+				// returns, so that answers can cite it.
+				doc.ID = doc.ContentID()
+				docs = append(docs, doc)
 			}
 			if err := ai.Index(ctx, indexer, ai.WithIndexerDocs(docs...)); err != nil {
 				return nil, err
@@ -90,8 +94,14 @@ func setup04(ctx context.Context, indexer ai.Indexer, retriever ai.Retriever, mo
 			}
 
 			var menuItems []*menuItem
+			var docIDs []string
 			for _, doc := range resp.Documents {
-				menuItems = append(menuItems, doc.Metadata["menuItem"].(*menuItem))
+				item := &menuItem{}
+				if err := doc.DecodeMetadata("menuItem", item); err != nil {
+					return nil, err
+				}
+				menuItems = append(menuItems, item)
+				docIDs = append(docIDs, doc.ID)
 			}
 			questionInput := &dataMenuQuestionInput{
 				MenuData: menuItems,
@@ -105,9 +115,15 @@ func setup04(ctx context.Context, indexer ai.Indexer, retriever ai.Retriever, mo
 			if err != nil {
 				return nil, err
 			}
+			presp.AddCitations(docIDs...)
 
+			var sources []string
+			for _, c := range presp.Citations() {
+				sources = append(sources, c.DocumentID)
+			}
 			ret := &answerOutput{
-				Answer: presp.Message.Content[0].Text,
+				Answer:  presp.Message.Content[0].Text,
+				Sources: sources,
 			}
 			return ret, nil
 		},